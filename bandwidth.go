@@ -0,0 +1,121 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/putersdk"
+	"github.com/spf13/viper"
+)
+
+// bandwidthSchedule in the configuration; see the README
+type bandwidthWindowConfig struct {
+	From     string
+	To       string
+	Upload   string
+	Download string
+}
+
+var rateUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1000,
+	"kb":  1000,
+	"kib": 1024,
+	"m":   1000 * 1000,
+	"mb":  1000 * 1000,
+	"mib": 1024 * 1024,
+	"g":   1000 * 1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1024 * 1024 * 1024,
+}
+
+// Parses a rate in bytes per second, e.g. "500KB" or "2MiB/s". An empty
+// rate or 0 is unlimited.
+func parseRate(rate string) (int64, error) {
+	rate = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(rate)), "/s")
+	if rate == "" {
+		return 0, nil
+	}
+	split := strings.IndexFunc(rate, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if split < 0 {
+		split = len(rate)
+	}
+	value, err := strconv.ParseFloat(rate[:split], 64)
+	unit, known := rateUnits[strings.TrimSpace(rate[split:])]
+	if err != nil || !known || value < 0 {
+		return 0, fmt.Errorf("invalid rate %q; use e.g. 500KB or 2MiB", rate)
+	}
+	return int64(value * unit), nil
+}
+
+// Parses a time of day, e.g. "23:00", as an offset from midnight.
+func parseTimeOfDay(value string) (time.Duration, error) {
+	parsed, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q; use e.g. 23:00", value)
+	}
+	return time.Duration(parsed.Hour())*time.Hour + time.Duration(parsed.Minute())*time.Minute, nil
+}
+
+// Creates the throttle shared by every mount from uploadLimit,
+// downloadLimit and bandwidthSchedule. Returns nil if none are set.
+func createThrottle() (*putersdk.Throttle, error) {
+	limits := putersdk.BandwidthLimits{}
+	var err error
+	if limits.Upload, err = parseRate(viper.GetString("uploadLimit")); err != nil {
+		return nil, fmt.Errorf("invalid uploadLimit: %s", err)
+	}
+	if limits.Download, err = parseRate(viper.GetString("downloadLimit")); err != nil {
+		return nil, fmt.Errorf("invalid downloadLimit: %s", err)
+	}
+
+	windows := []bandwidthWindowConfig{}
+	if err := viper.UnmarshalKey("bandwidthSchedule", &windows); err != nil {
+		return nil, fmt.Errorf("invalid bandwidthSchedule: %s", err)
+	}
+	schedule := []putersdk.BandwidthWindow{}
+	for i, conf := range windows {
+		window := putersdk.BandwidthWindow{}
+		if window.From, err = parseTimeOfDay(conf.From); err != nil {
+			return nil, fmt.Errorf("invalid bandwidthSchedule[%d].from: %s", i, err)
+		}
+		if window.To, err = parseTimeOfDay(conf.To); err != nil {
+			return nil, fmt.Errorf("invalid bandwidthSchedule[%d].to: %s", i, err)
+		}
+		if window.Upload, err = parseRate(conf.Upload); err != nil {
+			return nil, fmt.Errorf("invalid bandwidthSchedule[%d].upload: %s", i, err)
+		}
+		if window.Download, err = parseRate(conf.Download); err != nil {
+			return nil, fmt.Errorf("invalid bandwidthSchedule[%d].download: %s", i, err)
+		}
+		schedule = append(schedule, window)
+	}
+
+	if limits == (putersdk.BandwidthLimits{}) && len(schedule) == 0 {
+		return nil, nil
+	}
+	return putersdk.CreateThrottle(limits, schedule), nil
+}
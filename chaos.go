@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/faoimpls"
+	"github.com/spf13/viper"
+)
+
+type chaosRuleConfig struct {
+	ErrorRate     float64
+	Errno         string
+	Latency       faoimpls.ChaosLatency
+	HangRate      float64
+	ShortReadRate float64
+	DropRate      float64
+}
+
+// testChaos in the configuration; see the README
+type chaosConfig struct {
+	Seed    int64
+	Default chaosRuleConfig
+	Methods map[string]chaosRuleConfig
+}
+
+var chaosMethods = []string{
+	"Stat", "ReadDir", "Read", "Write", "Create", "Truncate", "MkDir",
+	"Symlink", "Unlink", "Move", "ReadAll", "Fsync",
+}
+
+func (c chaosRuleConfig) rule() (faoimpls.ChaosRule, error) {
+	rule := faoimpls.ChaosRule{
+		ErrorRate:     c.ErrorRate,
+		Latency:       c.Latency,
+		HangRate:      c.HangRate,
+		ShortReadRate: c.ShortReadRate,
+		DropRate:      c.DropRate,
+	}
+	switch c.Latency.Distribution {
+	case "", "fixed", "uniform", "exponential":
+	default:
+		return rule, fmt.Errorf("unknown latency distribution %q", c.Latency.Distribution)
+	}
+	if c.Errno != "" {
+		errno, err := faoimpls.ParseErrno(c.Errno)
+		if err != nil {
+			return rule, err
+		}
+		rule.Errno = errno
+	}
+	return rule, nil
+}
+
+// Reads the ChaosFAO parameters from testChaos. Returns false if it
+// isn't set.
+func chaosParams(cfg *viper.Viper) (faoimpls.P_ChaosFAO, bool, error) {
+	params := faoimpls.P_ChaosFAO{}
+	if !cfg.IsSet("testChaos") {
+		return params, false, nil
+	}
+
+	conf := chaosConfig{}
+	if err := cfg.UnmarshalKey("testChaos", &conf); err != nil {
+		return params, false, fmt.Errorf("invalid testChaos: %s", err)
+	}
+
+	params.Seed = conf.Seed
+	if params.Seed == 0 {
+		params.Seed = time.Now().UnixNano()
+	}
+
+	var err error
+	if params.Default, err = conf.Default.rule(); err != nil {
+		return params, false, fmt.Errorf("invalid testChaos.default: %s", err)
+	}
+
+	// keys in configuration files are case-insensitive
+	params.Methods = map[string]faoimpls.ChaosRule{}
+	for key, ruleConf := range conf.Methods {
+		method := ""
+		for _, name := range chaosMethods {
+			if strings.EqualFold(key, name) {
+				method = name
+			}
+		}
+		if method == "" {
+			return params, false, fmt.Errorf("invalid testChaos: unknown method %q", key)
+		}
+		if params.Methods[method], err = ruleConf.rule(); err != nil {
+			return params, false, fmt.Errorf("invalid testChaos.methods.%s: %s", key, err)
+		}
+	}
+	return params, true, nil
+}
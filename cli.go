@@ -0,0 +1,843 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// Exit codes; anything other than these comes from a panic.
+const (
+	exitOK             = 0
+	exitFailure        = 1
+	exitUsage          = 2
+	exitNotLoggedIn    = 3
+	exitNotMounted     = 4
+	exitAlreadyMounted = 5
+)
+
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+var configFile string
+var tokenFile string
+
+// token read from --token-file, if given
+var tokenFromFile string
+
+var loginOpts loginOptions
+
+var mountAllProfiles bool
+
+var controlOpts struct {
+	json      bool
+	timeout   time.Duration
+	recursive bool
+}
+
+var rootCmd = &cobra.Command{
+	Use:   "puter-fuse",
+	Short: "Mount your Puter filesystem as a FUSE filesystem",
+	Long: `Mount your Puter filesystem as a FUSE filesystem.
+
+The auth token is taken from the first of these which is set:
+  1. the file given by --token-file
+  2. the PUTER_TOKEN environment variable
+  3. the token saved by "puter-fuse login"
+
+Saved tokens are kept in credentials.json next to the configuration
+file, encrypted with a passphrase which is prompted for or read from
+PUTER_FUSE_PASSPHRASE. Set credentialStore to "keyring" to use the
+desktop keyring (Secret Service) instead.
+
+The API URL is taken from --url, then PUTER_API_URL, then the
+configuration file, and defaults to https://api.puter.com.
+
+Exit codes:
+  0  success
+  1  general failure
+  2  invalid usage
+  3  not logged in
+  4  not mounted
+  5  already mounted`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(profileNames) > 1 && cmd.Name() != "mount" {
+			return &exitError{
+				code: exitUsage,
+				err:  fmt.Errorf("only mount accepts more than one --profile"),
+			}
+		}
+		// login and config set may create a new profile
+		createsProfile := cmd.Name() == "login" || cmd.Name() == "set"
+		if err := selectProfile(createsProfile); err != nil {
+			return err
+		}
+		return loadTokenFile()
+	},
+}
+
+var mountCmd = &cobra.Command{
+	Use:   "mount",
+	Short: "Mount Puter and serve it until interrupted",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return mount()
+	},
+}
+
+var unmountCmd = &cobra.Command{
+	Use:   "unmount [mountpoint]",
+	Short: "Unmount a mounted Puter filesystem",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		mountPoint := viper.GetString("mountPoint")
+		if len(args) > 0 {
+			mountPoint = args[0]
+		}
+		return unmount(mountPoint)
+	},
+}
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in to Puter and save the token",
+	Long: `Log in to Puter and save the token.
+
+Values which aren't given as flags are prompted for when stdin is a
+terminal. For unattended setup, either pass an existing token with
+--token-file or PUTER_TOKEN, or pipe the password in:
+
+  echo "$PASSWORD" | puter-fuse login --username me --password-stdin`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cmd.Flags().Changed("url") {
+			loginOpts.apiURL = viper.GetString("url")
+		}
+		if cmd.Flags().Changed("mountpoint") {
+			loginOpts.mountPoint = viper.GetString("mountPoint")
+		}
+		if err := configure(loginOpts); err != nil {
+			var exitErr *exitError
+			if errors.As(err, &exitErr) {
+				return err
+			}
+			return fmt.Errorf("login failed: %s", err)
+		}
+		fmt.Println("Logged in")
+		return nil
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Revoke and delete the saved token",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := logout(); err != nil {
+			return err
+		}
+		fmt.Println("Logged out")
+		return nil
+	},
+}
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show login and mount status; exits 4 if not mounted",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return status()
+	},
+}
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the local cache",
+}
+
+var cacheClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Delete everything in the cache directory",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return clearCache()
+	},
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Show or change configuration values",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show [key]",
+	Short: "Print configuration values",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) > 0 {
+			if !viper.IsSet(args[0]) {
+				return fmt.Errorf("%s is not set", args[0])
+			}
+			fmt.Println(displayConfigValue(args[0]))
+			return nil
+		}
+
+		fmt.Println("# " + configFilePath())
+		keys := viper.AllKeys()
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Printf("%s = %s\n", key, displayConfigValue(key))
+		}
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Save a configuration value",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return updateConfig(map[string]interface{}{args[0]: args[1]})
+	},
+}
+
+var controlCmd = &cobra.Command{
+	Use:   "control",
+	Short: "Inspect and control a running mount",
+	Long: `Inspect and control a running mount through its control socket.
+
+Commands act on every mount of the running process unless --profile
+is given.`,
+}
+
+var controlStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show queue, cache and pending write state",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return controlStatusCommand()
+	},
+}
+
+var controlFlushCmd = &cobra.Command{
+	Use:   "flush",
+	Short: "Wait until pending writes are sent to Puter",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return controlFlushCommand()
+	},
+}
+
+var controlInvalidateCmd = &cobra.Command{
+	Use:   "invalidate <path>",
+	Short: "Drop cached metadata and contents for a path",
+	Long: `Drop cached metadata and contents for a path, given either as a path
+under a mountpoint or as a path within the mount (e.g. /user/file).`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return controlInvalidateCommand(args[0])
+	},
+}
+
+var controlDropCachesCmd = &cobra.Command{
+	Use:   "drop-caches",
+	Short: "Drop everything cached which can be fetched again",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		result := map[string]int{}
+		err := callControl(http.MethodPost, "/v1/drop-caches",
+			&controlRequest{Profile: controlProfile()}, &result)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Invalidated %d path(s)\n", result["invalidated"])
+		return nil
+	},
+}
+
+var controlLogLevelCmd = &cobra.Command{
+	Use:   "log-level <debug|info|warn|error>",
+	Short: "Change the log level",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return callControl(http.MethodPost, "/v1/log-level",
+			&controlRequest{Level: args[0]}, nil)
+	},
+}
+
+var controlPauseCmd = &cobra.Command{
+	Use:   "pause",
+	Short: "Hold uploads in the queue until resumed",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return callControl(http.MethodPost, "/v1/uploads/pause",
+			&controlRequest{Profile: controlProfile()}, nil)
+	},
+}
+
+var controlResumeCmd = &cobra.Command{
+	Use:   "resume",
+	Short: "Resume paused uploads",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return callControl(http.MethodPost, "/v1/uploads/resume",
+			&controlRequest{Profile: controlProfile()}, nil)
+	},
+}
+
+var transfersCmd = &cobra.Command{
+	Use:   "transfers",
+	Short: "Show uploads and downloads in flight",
+	Long: `Show uploads and downloads in flight, with their progress, rate and
+estimated time remaining. Transfers can be paused, resumed or cancelled
+by their ID; cancelling one fails the filesystem call it's part of.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return transfersCommand()
+	},
+}
+
+var transfersPauseCmd = &cobra.Command{
+	Use:   "pause <id>",
+	Short: "Hold a transfer until it's resumed",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return transferActionCommand("pause", args[0])
+	},
+}
+
+var transfersResumeCmd = &cobra.Command{
+	Use:   "resume <id>",
+	Short: "Resume a paused transfer",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return transferActionCommand("resume", args[0])
+	},
+}
+
+var transfersCancelCmd = &cobra.Command{
+	Use:   "cancel <id>",
+	Short: "Stop a transfer",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return transferActionCommand("cancel", args[0])
+	},
+}
+
+var replayCmd = &cobra.Command{
+	Use:   "replay <recording>",
+	Short: "Replay a recording made with mount --record-file and report differences",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return replay(args[0])
+	},
+}
+
+var encryptionCmd = &cobra.Command{
+	Use:   "encryption",
+	Short: "Manage the key for encrypted subtrees",
+}
+
+var encryptionInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create an encryption key for the profile",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return encryptionInit()
+	},
+}
+
+var encryptionExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Print the profile's encryption key so it can be backed up",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return encryptionExport()
+	},
+}
+
+var encryptionImportReplace bool
+
+var encryptionImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Save an exported encryption key, read from stdin, for the profile",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return encryptionImport(encryptionImportReplace)
+	},
+}
+
+func init() {
+	cobra.OnInitialize(initConfig)
+
+	rootCmd.PersistentFlags().StringVar(&configFile, "config", "",
+		"configuration file (default $HOME/.config/puterfuse/config.json)")
+	rootCmd.PersistentFlags().String("url", "", "Puter API URL")
+	bindFlag("url", rootCmd.PersistentFlags().Lookup("url"))
+	rootCmd.PersistentFlags().String("mountpoint", "", "where to mount Puter (default /tmp/mnt)")
+	bindFlag("mountPoint", rootCmd.PersistentFlags().Lookup("mountpoint"))
+	rootCmd.PersistentFlags().String("cache-dir", "", "cache directory")
+	bindFlag("cacheDir", rootCmd.PersistentFlags().Lookup("cache-dir"))
+	rootCmd.PersistentFlags().StringVar(&tokenFile, "token-file", "",
+		"read the auth token from this file")
+	rootCmd.PersistentFlags().StringArrayVar(&profileNames, "profile", nil,
+		"configuration profile to use (default $PUTER_FUSE_PROFILE or \"default\")")
+
+	viper.BindEnv("url", "PUTER_API_URL")
+
+	loginCmd.Flags().StringVar(&loginOpts.username, "username", "", "Puter username")
+	loginCmd.Flags().BoolVar(&loginOpts.passwordStdin, "password-stdin", false,
+		"read the password from the first line of stdin")
+	loginCmd.Flags().StringVar(&loginOpts.authURL, "auth-url", "",
+		"authentication host (default https://puter.com)")
+
+	flags := mountCmd.Flags()
+	flags.BoolVar(&mountAllProfiles, "all-profiles", false, "mount every configured profile")
+	flags.Duration("tree-cache-ttl", 0, "how long directory listings are cached (default 5s)")
+	bindFlag("treeCacheTTL", flags.Lookup("tree-cache-ttl"))
+	flags.Duration("file-read-cache-ttl", 0, "how long file contents are cached (default 5s)")
+	bindFlag("fileReadCacheTTL", flags.Lookup("file-read-cache-ttl"))
+	flags.Duration("shutdown-timeout", 0, "how long to wait for pending writes on exit (default 30s)")
+	bindFlag("shutdownTimeout", flags.Lookup("shutdown-timeout"))
+	flags.String("local-dir", "", "serve this local directory instead of Puter, e.g. for testing")
+	bindFlag("localDir", flags.Lookup("local-dir"))
+	flags.Bool("read-only", false, "refuse all writes to the mount")
+	bindFlag("readOnly", flags.Lookup("read-only"))
+	flags.Bool("experimental-cache", false, "enable read and write-back caching of file contents")
+	bindFlag("experimental_cache", flags.Lookup("experimental-cache"))
+	flags.String("upload-limit", "", "limit uploads to this many bytes per second, e.g. 2MiB")
+	bindFlag("uploadLimit", flags.Lookup("upload-limit"))
+	flags.String("download-limit", "", "limit downloads to this many bytes per second, e.g. 10MB")
+	bindFlag("downloadLimit", flags.Lookup("download-limit"))
+	flags.String("metrics-address", "", "serve Prometheus metrics on this loopback host:port")
+	bindFlag("metricsAddress", flags.Lookup("metrics-address"))
+	flags.String("log-level", "", "debug, info, warn or error (default info)")
+	bindFlag("logLevel", flags.Lookup("log-level"))
+	flags.String("log-format", "", "text or json (default text)")
+	bindFlag("logFormat", flags.Lookup("log-format"))
+	flags.String("log-file", "", "write logs to this file instead of stdout, rotating it as it grows")
+	bindFlag("logFile", flags.Lookup("log-file"))
+	flags.String("trace-file", "", "write a trace of each filesystem request to this file")
+	bindFlag("traceFile", flags.Lookup("trace-file"))
+	flags.String("trace-format", "", "chrome or otlp (default chrome)")
+	bindFlag("traceFormat", flags.Lookup("trace-format"))
+	flags.String("record-file", "", "record every filesystem call to this file, for puter-fuse replay")
+	bindFlag("recordFile", flags.Lookup("record-file"))
+	flags.Bool("record-contents", false, "record file contents, not only their size and hash")
+	bindFlag("recordContents", flags.Lookup("record-contents"))
+
+	replayCmd.Flags().StringVar(&replayOpts.against, "against", "recorded",
+		"backend to replay against: recorded (the recorded Puter responses) or mem (an empty filesystem)")
+	replayCmd.Flags().BoolVar(&replayOpts.timing, "timing", false,
+		"make calls at their recorded times, so overlapping calls overlap again")
+	replayCmd.Flags().BoolVar(&replayOpts.experimentalCache, "experimental-cache", false,
+		"include the read and write-back caches")
+
+	controlStatusCmd.Flags().BoolVar(&controlOpts.json, "json", false, "print the raw JSON status")
+	controlFlushCmd.Flags().DurationVar(&controlOpts.timeout, "timeout", 30*time.Second,
+		"how long to wait for pending writes")
+	controlInvalidateCmd.Flags().BoolVarP(&controlOpts.recursive, "recursive", "r", false,
+		"also invalidate everything under the path")
+
+	transfersCmd.Flags().BoolVar(&transfersOpts.json, "json", false, "print the raw JSON")
+	transfersCmd.Flags().DurationVarP(&transfersOpts.watch, "watch", "w", 0,
+		"refresh at this interval until interrupted, e.g. 1s")
+
+	encryptionImportCmd.Flags().BoolVar(&encryptionImportReplace, "replace", false,
+		"replace the profile's existing key")
+
+	cacheCmd.AddCommand(cacheClearCmd)
+	transfersCmd.AddCommand(transfersPauseCmd, transfersResumeCmd, transfersCancelCmd)
+	encryptionCmd.AddCommand(encryptionInitCmd, encryptionExportCmd, encryptionImportCmd)
+	configCmd.AddCommand(configShowCmd, configSetCmd)
+	controlCmd.AddCommand(
+		controlStatusCmd, controlFlushCmd, controlInvalidateCmd, controlDropCachesCmd,
+		controlLogLevelCmd, controlPauseCmd, controlResumeCmd,
+	)
+	rootCmd.AddCommand(
+		mountCmd, unmountCmd, loginCmd, logoutCmd, statusCmd, cacheCmd, configCmd,
+		controlCmd, replayCmd, encryptionCmd, transfersCmd,
+	)
+
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return &exitError{code: exitUsage, err: err}
+	})
+}
+
+// Runs the command line and returns the process exit code.
+func execute() int {
+	err := rootCmd.Execute()
+	if err == nil {
+		return exitOK
+	}
+
+	fmt.Fprintln(os.Stderr, "error:", err)
+
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	// cobra doesn't type its argument errors
+	if strings.Contains(err.Error(), "unknown command") ||
+		strings.Contains(err.Error(), "arg(s)") {
+		return exitUsage
+	}
+	return exitFailure
+}
+
+func initConfig() {
+	userConfigDir, err := os.UserConfigDir()
+	if err != nil {
+		panic(fmt.Errorf(("error getting user config directory: %s"), err))
+	}
+
+	puterfuseConfigDir := filepath.Join(userConfigDir, "puterfuse")
+	err = os.MkdirAll(puterfuseConfigDir, 0755)
+	if err != nil {
+		panic(fmt.Errorf("error creating config directory: %s", err))
+	}
+
+	// TODO: this should go in ConfigService
+	if configFile != "" {
+		viper.SetConfigFile(configFile)
+	} else {
+		viper.SetConfigName("config")
+		viper.AddConfigPath(".")
+		viper.AddConfigPath("$HOME/.config/puterfuse")
+	}
+
+	// A missing config file is fine; commands which need values from it
+	// report what's missing.
+	if err := viper.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !errors.As(err, &notFound) && !os.IsNotExist(err) {
+			fmt.Fprintf(os.Stderr, "error reading configuration: %s\n", err)
+		}
+	}
+
+	setDefaults(viper.GetViper())
+}
+
+func loadTokenFile() error {
+	if tokenFile == "" {
+		return nil
+	}
+
+	contents, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return fmt.Errorf("error reading token file: %s", err)
+	}
+	tokenFromFile = strings.TrimSpace(string(contents))
+	if tokenFromFile == "" {
+		return &exitError{code: exitUsage, err: fmt.Errorf("token file %s is empty", tokenFile)}
+	}
+
+	return nil
+}
+
+// Returns a token given by --token-file or PUTER_TOKEN, which take
+// precedence over a saved token.
+func resolvedTokenOverride() string {
+	return firstNonEmpty(tokenFromFile, os.Getenv("PUTER_TOKEN"))
+}
+
+// Describes where the token in use comes from, without unlocking the
+// credential store.
+func tokenSource() string {
+	switch {
+	case tokenFromFile != "":
+		return "--token-file"
+	case os.Getenv("PUTER_TOKEN") != "":
+		return "PUTER_TOKEN"
+	}
+	if fileConfig, err := readConfigFile(); err == nil && fileConfig.GetString("token") != "" {
+		return "configuration file, unencrypted; run login to migrate it"
+	}
+	if store, err := openCredentialStore(); err == nil {
+		if has, _ := store.Has(tokenCredential(currentProfile())); has {
+			return "credential store"
+		}
+	}
+	return ""
+}
+
+// Returns the configuration file in use, or where one will be created.
+func configFilePath() string {
+	if used := viper.ConfigFileUsed(); used != "" {
+		if _, err := os.Stat(used); err == nil {
+			return used
+		}
+	}
+	if configFile != "" {
+		return configFile
+	}
+	userConfigDir, _ := os.UserConfigDir()
+	return filepath.Join(userConfigDir, "puterfuse", "config.json")
+}
+
+// Returns only the values in the configuration file, without defaults,
+// flags or the environment.
+func readConfigFile() (*viper.Viper, error) {
+	path := configFilePath()
+
+	fileConfig := viper.New()
+	fileConfig.SetConfigFile(path)
+	if err := fileConfig.ReadInConfig(); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("error reading %s: %s", path, err)
+	}
+	return fileConfig, nil
+}
+
+// Saves 'values' to the selected profile in the configuration file; a nil
+// value removes the key. Only values already in the file are kept
+// alongside them, so defaults and flags aren't persisted.
+func updateConfig(values map[string]interface{}) error {
+	return updateProfileConfig(currentProfile(), values)
+}
+
+func updateProfileConfig(profile string, values map[string]interface{}) error {
+	path := configFilePath()
+
+	fileConfig, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+
+	settings := fileConfig.AllSettings()
+
+	// values for a named profile go in its section
+	target := settings
+	if name := strings.ToLower(profile); name != defaultProfile {
+		profiles, _ := settings["profiles"].(map[string]interface{})
+		if profiles == nil {
+			profiles = map[string]interface{}{}
+			settings["profiles"] = profiles
+		}
+		target, _ = profiles[name].(map[string]interface{})
+		if target == nil {
+			target = map[string]interface{}{}
+			profiles[name] = target
+		}
+	}
+
+	for key, value := range values {
+		if value == nil {
+			delete(target, strings.ToLower(key))
+		} else {
+			target[strings.ToLower(key)] = value
+		}
+		viper.Set(key, value)
+	}
+
+	newConfig := viper.New()
+	newConfig.SetConfigPermissions(0600)
+	newConfig.MergeConfigMap(settings)
+	if err := newConfig.WriteConfigAs(path); err != nil {
+		return fmt.Errorf("error writing %s: %s", path, err)
+	}
+	// files written by older versions may be world-readable
+	return os.Chmod(path, 0600)
+}
+
+func displayConfigValue(key string) string {
+	value := viper.GetString(key)
+	if value != "" && (strings.Contains(key, "token") || strings.Contains(key, "password")) {
+		return "********"
+	}
+	return value
+}
+
+func resolveCacheDir(cfg *viper.Viper) (string, error) {
+	if cfg.IsSet("cacheDir") && cfg.GetString("cacheDir") != "" {
+		return cfg.GetString("cacheDir"), nil
+	}
+	if cfg.GetBool("useUserCacheDir") {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("error getting user cache directory: %s", err)
+		}
+		return filepath.Join(userCacheDir, "puterfuse"), nil
+	}
+	return "/tmp/puterfuse", nil
+}
+
+// Reports whether a FUSE filesystem is mounted at 'mountPoint'.
+func isMounted(mountPoint string) (bool, error) {
+	mountPoint, err := filepath.Abs(mountPoint)
+	if err != nil {
+		return false, err
+	}
+
+	mounts, err := os.Open("/proc/mounts")
+	if err != nil {
+		// no procfs (e.g. macOS); ask mount(8) instead
+		out, err := exec.Command("mount").Output()
+		if err != nil {
+			return false, err
+		}
+		return strings.Contains(string(out), " on "+mountPoint+" "), nil
+	}
+	defer mounts.Close()
+
+	scanner := bufio.NewScanner(mounts)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 {
+			continue
+		}
+		target := strings.ReplaceAll(fields[1], `\040`, " ")
+		if target == mountPoint && strings.HasPrefix(fields[2], "fuse") {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+func unmount(mountPoint string) error {
+	mounted, err := isMounted(mountPoint)
+	if err != nil {
+		return err
+	}
+	if !mounted {
+		return &exitError{
+			code: exitNotMounted,
+			err:  fmt.Errorf("%s is not mounted", mountPoint),
+		}
+	}
+
+	var candidates [][]string
+	if runtime.GOOS == "darwin" {
+		candidates = [][]string{{"umount", mountPoint}}
+	} else {
+		candidates = [][]string{
+			{"fusermount3", "-u", mountPoint},
+			{"fusermount", "-u", mountPoint},
+		}
+	}
+
+	for _, candidate := range candidates {
+		if _, err := exec.LookPath(candidate[0]); err != nil {
+			continue
+		}
+		out, err := exec.Command(candidate[0], candidate[1:]...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("%s: %s", candidate[0], strings.TrimSpace(string(out)))
+		}
+		fmt.Printf("Unmounted %s\n", mountPoint)
+		return nil
+	}
+
+	return fmt.Errorf("no unmount command found (tried fusermount3, fusermount)")
+}
+
+func status() error {
+	mountPoint := viper.GetString("mountPoint")
+	cacheDir, err := resolveCacheDir(viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	loggedIn := "no"
+	if source := tokenSource(); source != "" {
+		loggedIn = "yes (token from " + source + ")"
+	}
+
+	mounted, err := isMounted(mountPoint)
+	if err != nil {
+		return err
+	}
+	mountState := "not mounted"
+	if mounted {
+		mountState = "mounted"
+	}
+
+	fmt.Println("Configuration file:", configFilePath())
+	fmt.Println("Profile:", currentProfile())
+	fmt.Println("API URL:", viper.GetString("url"))
+	fmt.Println("Logged in:", loggedIn)
+	fmt.Printf("Mountpoint: %s (%s)\n", mountPoint, mountState)
+	fmt.Printf("Cache directory: %s (%d bytes)\n", cacheDir, dirSize(cacheDir))
+
+	if !mounted {
+		return &exitError{code: exitNotMounted, err: fmt.Errorf("%s is not mounted", mountPoint)}
+	}
+	return nil
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+func clearCache() error {
+	if mounted, _ := isMounted(viper.GetString("mountPoint")); mounted {
+		return &exitError{
+			code: exitAlreadyMounted,
+			err:  fmt.Errorf("the cache is in use; unmount first"),
+		}
+	}
+
+	cacheDir, err := resolveCacheDir(viper.GetViper())
+	if err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(cacheDir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(cacheDir, entry.Name())); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Cleared %s (%d entries)\n", cacheDir, len(entries))
+	return nil
+}
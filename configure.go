@@ -19,67 +19,140 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"strings"
 
 	"github.com/manifoldco/promptui"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/viper"
 )
 
-func configure() {
-	usernamePrompt := promptui.Prompt{
-		Label: "Username",
-	}
+type loginOptions struct {
+	username      string
+	passwordStdin bool
+	authURL       string
+	apiURL        string
+	mountPoint    string
+}
 
-	username, err := usernamePrompt.Run()
-	if err != nil {
-		panic(err)
-	}
+// Returns true if stdin is attached to a terminal, in which case missing
+// values may be prompted for.
+func stdinIsTerminal() bool {
+	return isatty.IsTerminal(os.Stdin.Fd())
+}
 
-	passwordPrompt := promptui.Prompt{
-		Label: "Password",
-		Mask:  '*',
+// Returns 'value' if it's set; otherwise prompts for it, or returns
+// 'def' when stdin isn't a terminal.
+func promptUnlessSet(value, label, def string) (string, error) {
+	if value != "" {
+		return value, nil
 	}
-
-	password, err := passwordPrompt.Run()
-	if err != nil {
-		panic(err)
+	if !stdinIsTerminal() {
+		return def, nil
 	}
 
-	hostPrompt := promptui.Prompt{
-		Label:   "Authentication Host",
-		Default: "https://puter.com",
+	prompt := promptui.Prompt{
+		Label:   label,
+		Default: def,
 	}
+	return prompt.Run()
+}
 
-	host, err := hostPrompt.Run()
-	if err != nil {
-		panic(err)
+func readPassword(opts loginOptions) (string, error) {
+	if opts.passwordStdin {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		return strings.TrimRight(line, "\r\n"), nil
 	}
 
-	hostAPIPrompt := promptui.Prompt{
-		Label:   "API Host",
-		Default: "https://api.puter.com",
+	if !stdinIsTerminal() {
+		return "", fmt.Errorf("no terminal; use --password-stdin")
 	}
 
-	hostAPI, err := hostAPIPrompt.Run()
-	if err != nil {
-		panic(err)
+	passwordPrompt := promptui.Prompt{
+		Label: "Password",
+		Mask:  '*',
 	}
+	return passwordPrompt.Run()
+}
 
-	mountpointPrompt := promptui.Prompt{
-		Label:   "Mountpoint",
-		Default: "/tmp/mnt",
-	}
+// Obtains a token and saves it to the configuration file. A token from
+// --token-file or PUTER_TOKEN is saved as-is; otherwise the username and
+// password are exchanged for one, prompting for anything not given.
+func configure(opts loginOptions) error {
+	var err error
 
-	mountpoint, err := mountpointPrompt.Run()
+	token := resolvedTokenOverride()
+	authURL := firstNonEmpty(viper.GetString("authUrl"), "https://puter.com")
+
+	apiURL, err := promptUnlessSet(
+		firstNonEmpty(opts.apiURL, os.Getenv("PUTER_API_URL")),
+		"API Host", firstNonEmpty(viper.GetString("url"), "https://api.puter.com"))
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	// Get token from server
+	mountPoint, err := promptUnlessSet(
+		opts.mountPoint, "Mountpoint", viper.GetString("mountPoint"))
+	if err != nil {
+		return err
+	}
+
+	if token == "" {
+		if opts.username == "" && !stdinIsTerminal() {
+			return &exitError{
+				code: exitUsage,
+				err: fmt.Errorf(
+					"no terminal; use --username with --password-stdin, --token-file or PUTER_TOKEN"),
+			}
+		}
+
+		username, err := promptUnlessSet(opts.username, "Username", "")
+		if err != nil {
+			return err
+		}
+
+		password, err := readPassword(opts)
+		if err != nil {
+			return err
+		}
+
+		authURL, err = promptUnlessSet(
+			firstNonEmpty(opts.authURL, os.Getenv("PUTER_AUTH_URL")),
+			"Authentication Host",
+			authURL)
+		if err != nil {
+			return err
+		}
+
+		token, err = requestToken(authURL, username, password)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := saveToken(currentProfile(), token); err != nil {
+		return fmt.Errorf("error saving token: %s", err)
+	}
+
+	return updateConfig(map[string]interface{}{
+		"mountPoint": mountPoint,
+		"url":        apiURL,
+		"authUrl":    authURL,
+		"token":      nil,
+	})
+}
+
+// Exchanges a username and password for an auth token.
+func requestToken(authURL, username, password string) (string, error) {
 	payload := map[string]string{
 		"username": username,
 		"password": password,
@@ -87,70 +160,52 @@ func configure() {
 
 	jsonStr, err := json.Marshal(payload)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
 	req, err := http.NewRequest(
 		"POST",
-		host+"/login",
+		authURL+"/login",
 		bytes.NewBuffer(jsonStr),
 	)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		// Print response
-		body, _ := io.ReadAll(resp.Body)
-		fmt.Println(string(body))
-
-		panic(fmt.Errorf("unexpected status: %d", resp.StatusCode))
-	}
-
-	// Save token
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		panic(err)
-	}
-
-	responseData := map[string]interface{}{}
-	err = json.Unmarshal(body, &responseData)
-	if err != nil {
-		panic(err)
-	}
-
-	configToWrite := map[string]interface{}{
-		"mountpoint": mountpoint,
-		"url":        hostAPI,
-		"token":      responseData["token"],
+		return "", err
 	}
 
-	// Write config
-	configDir, err := os.UserConfigDir()
-	if err != nil {
-		panic(err)
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("unexpected status: %d: %s", resp.StatusCode, string(body))
 	}
 
-	configFile, err := os.Create(configDir + "/puterfuse/config.json")
+	responseData := map[string]interface{}{}
+	err = json.Unmarshal(body, &responseData)
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	defer configFile.Close()
 
-	configJSON, err := json.Marshal(configToWrite)
-	if err != nil {
-		panic(err)
+	token, _ := responseData["token"].(string)
+	if token == "" {
+		return "", fmt.Errorf("login response did not include a token")
 	}
+	return token, nil
+}
 
-	_, err = configFile.Write(configJSON)
-	if err != nil {
-		panic(err)
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
 	}
+	return ""
 }
@@ -0,0 +1,563 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/debug"
+	"github.com/HeyPuter/puter-fuse/engine"
+	"github.com/HeyPuter/puter-fuse/putersdk"
+	"github.com/spf13/viper"
+)
+
+// The control API is JSON over HTTP on a unix socket only the user can
+// access. Requests which act on mounts take an optional "profile"; when
+// it's empty they act on every mount.
+//
+//	GET  /v1/status
+//	POST /v1/flush          {"profile", "timeout"}
+//	POST /v1/invalidate     {"profile", "path", "recursive"}
+//	POST /v1/drop-caches    {"profile"}
+//	POST /v1/log-level      {"level"}
+//	POST /v1/uploads/pause  {"profile"}
+//	POST /v1/uploads/resume {"profile"}
+//	GET  /v1/transfers
+//	POST /v1/transfers/pause  {"id"}
+//	POST /v1/transfers/resume {"id"}
+//	POST /v1/transfers/cancel {"id"}
+
+type controlRequest struct {
+	Profile   string `json:"profile,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Recursive bool   `json:"recursive,omitempty"`
+	Timeout   string `json:"timeout,omitempty"`
+	Level     string `json:"level,omitempty"`
+	ID        int64  `json:"id,omitempty"`
+}
+
+type mountStatus struct {
+	Profile          string                  `json:"profile"`
+	MountPoint       string                  `json:"mountPoint"`
+	ReadOnly         bool                    `json:"readOnly"`
+	Authenticated    bool                    `json:"authenticated"`
+	Queue            engine.OperationStats   `json:"queue"`
+	Caches           engine.CacheStats       `json:"caches"`
+	PendingMutations map[string]int          `json:"pendingMutations"`
+	Requests         putersdk.SchedulerStats `json:"requests"`
+}
+
+type mountTransfers struct {
+	Profile   string                `json:"profile"`
+	Transfers []engine.TransferInfo `json:"transfers"`
+}
+
+type controlStatus struct {
+	PID      int           `json:"pid"`
+	LogLevel string        `json:"logLevel"`
+	Mounts   []mountStatus `json:"mounts"`
+}
+
+type flushResult struct {
+	Profile           string   `json:"profile"`
+	PendingFiles      []string `json:"pendingFiles"`
+	PendingOperations int      `json:"pendingOperations"`
+}
+
+// Returns the control socket's path; it's shared by every profile.
+func controlSocketPath() string {
+	if socketPath := viper.GetString("controlSocket"); socketPath != "" {
+		return socketPath
+	}
+	if runtimeDir := os.Getenv("XDG_RUNTIME_DIR"); runtimeDir != "" {
+		return filepath.Join(runtimeDir, "puterfuse", "control.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("puterfuse-%d", os.Getuid()), "control.sock")
+}
+
+type controlServer struct {
+	mounts []*Mount
+	path   string
+	server *http.Server
+}
+
+// Starts serving the control API for 'mounts'. Fails if another process
+// is already serving on the socket.
+func startControlServer(socketPath string, mounts []*Mount) (*controlServer, error) {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0700); err != nil {
+		return nil, fmt.Errorf("error creating control socket directory: %s", err)
+	}
+
+	// A socket left by a process which didn't exit cleanly is removed,
+	// but not one which is still being served.
+	if conn, err := net.Dial("unix", socketPath); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s is in use by another puter-fuse process", socketPath)
+	}
+	os.Remove(socketPath)
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("error creating control socket: %s", err)
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	s := &controlServer{
+		mounts: mounts,
+		path:   socketPath,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/status", s.handle(http.MethodGet, s.status))
+	mux.HandleFunc("/v1/flush", s.handle(http.MethodPost, s.flush))
+	mux.HandleFunc("/v1/invalidate", s.handle(http.MethodPost, s.invalidate))
+	mux.HandleFunc("/v1/drop-caches", s.handle(http.MethodPost, s.dropCaches))
+	mux.HandleFunc("/v1/log-level", s.handle(http.MethodPost, s.setLogLevel))
+	mux.HandleFunc("/v1/uploads/pause", s.handle(http.MethodPost, s.pauseUploads))
+	mux.HandleFunc("/v1/uploads/resume", s.handle(http.MethodPost, s.resumeUploads))
+	mux.HandleFunc("/v1/transfers", s.handle(http.MethodGet, s.transfers))
+	mux.HandleFunc("/v1/transfers/pause", s.handle(http.MethodPost, s.transferAction((*engine.TransferService).Pause)))
+	mux.HandleFunc("/v1/transfers/resume", s.handle(http.MethodPost, s.transferAction((*engine.TransferService).Resume)))
+	mux.HandleFunc("/v1/transfers/cancel", s.handle(http.MethodPost, s.transferAction((*engine.TransferService).Cancel)))
+
+	s.server = &http.Server{Handler: mux}
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			mountLog.Error("control socket stopped: %s", err)
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *controlServer) Close() {
+	s.server.Close()
+	os.Remove(s.path)
+}
+
+// an error with the HTTP status to report it with
+type controlError struct {
+	status int
+	err    error
+}
+
+func (e *controlError) Error() string {
+	return e.err.Error()
+}
+
+func (s *controlServer) handle(
+	method string,
+	fn func(req controlRequest) (interface{}, error),
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+
+		var result interface{}
+		var err error
+		req := controlRequest{}
+
+		if r.Method != method {
+			err = &controlError{http.StatusMethodNotAllowed, fmt.Errorf("use %s", method)}
+		} else if r.Method == http.MethodPost && r.ContentLength != 0 {
+			if decodeErr := json.NewDecoder(r.Body).Decode(&req); decodeErr != nil {
+				err = &controlError{http.StatusBadRequest, decodeErr}
+			}
+		}
+		if err == nil {
+			result, err = fn(req)
+		}
+
+		if err != nil {
+			status := http.StatusInternalServerError
+			var controlErr *controlError
+			if errors.As(err, &controlErr) {
+				status = controlErr.status
+			}
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// Returns the mounts a request applies to.
+func (s *controlServer) selectMounts(profile string) ([]*Mount, error) {
+	if profile == "" {
+		return s.mounts, nil
+	}
+	for _, m := range s.mounts {
+		if strings.EqualFold(m.Profile, profile) {
+			return []*Mount{m}, nil
+		}
+	}
+	return nil, &controlError{http.StatusNotFound, fmt.Errorf("profile %q is not mounted", profile)}
+}
+
+func (m *Mount) operationService() *engine.OperationService {
+	return m.Services.Get("operation").(*engine.OperationService)
+}
+
+func (m *Mount) cacheControlService() *engine.CacheControlService {
+	return m.Services.Get("cache-control").(*engine.CacheControlService)
+}
+
+func (m *Mount) transferService() *engine.TransferService {
+	return m.Services.Get("transfer").(*engine.TransferService)
+}
+
+func (m *Mount) Status() mountStatus {
+	return mountStatus{
+		Profile:          m.Profile,
+		MountPoint:       m.MountPoint,
+		ReadOnly:         m.ReadOnlyFAO.IsReadOnly(),
+		Authenticated:    m.SDK.Authenticated(),
+		Queue:            m.operationService().Stats(),
+		Caches:           m.cacheControlService().Stats(),
+		PendingMutations: m.cacheControlService().PendingMutations(),
+		Requests:         m.SDK.Scheduler.Stats(),
+	}
+}
+
+// Waits until pending writes are sent or 'ctx' is done, and reports
+// what's still pending.
+func (m *Mount) Flush(ctx context.Context) flushResult {
+	svc_writeCache := m.Services.Get("write-cache").(*engine.WriteCacheService)
+
+	// The write cache is drained first since it feeds the batch queue.
+	svc_writeCache.Drain(ctx)
+	requests := m.operationService().Drain(ctx)
+
+	pendingFiles := []string{}
+	for path := range m.cacheControlService().PendingMutations() {
+		pendingFiles = append(pendingFiles, path)
+	}
+	return flushResult{
+		Profile:           m.Profile,
+		PendingFiles:      pendingFiles,
+		PendingOperations: len(requests),
+	}
+}
+
+func (s *controlServer) status(req controlRequest) (interface{}, error) {
+	status := controlStatus{
+		PID:      os.Getpid(),
+		LogLevel: debug.GetLevel().String(),
+		Mounts:   []mountStatus{},
+	}
+	for _, m := range s.mounts {
+		status.Mounts = append(status.Mounts, m.Status())
+	}
+	return status, nil
+}
+
+func (s *controlServer) flush(req controlRequest) (interface{}, error) {
+	mounts, err := s.selectMounts(req.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := 30 * time.Second
+	if req.Timeout != "" {
+		if timeout, err = time.ParseDuration(req.Timeout); err != nil {
+			return nil, &controlError{http.StatusBadRequest, err}
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	results := []flushResult{}
+	for _, m := range mounts {
+		results = append(results, m.Flush(ctx))
+	}
+	return results, nil
+}
+
+func (s *controlServer) invalidate(req controlRequest) (interface{}, error) {
+	mounts, err := s.selectMounts(req.Profile)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(req.Path, "/") {
+		return nil, &controlError{http.StatusBadRequest, fmt.Errorf("path must be absolute")}
+	}
+
+	invalidated := 0
+	for _, m := range mounts {
+		invalidated += m.cacheControlService().Invalidate(path.Clean(req.Path), req.Recursive)
+	}
+	return map[string]int{"invalidated": invalidated}, nil
+}
+
+func (s *controlServer) dropCaches(req controlRequest) (interface{}, error) {
+	mounts, err := s.selectMounts(req.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	invalidated := 0
+	for _, m := range mounts {
+		invalidated += m.cacheControlService().DropAll()
+	}
+	return map[string]int{"invalidated": invalidated}, nil
+}
+
+func (s *controlServer) setLogLevel(req controlRequest) (interface{}, error) {
+	level, err := debug.ParseLevel(req.Level)
+	if err != nil {
+		return nil, &controlError{http.StatusBadRequest, err}
+	}
+	debug.SetLevel(level)
+	return map[string]string{"logLevel": level.String()}, nil
+}
+
+func (s *controlServer) pauseUploads(req controlRequest) (interface{}, error) {
+	mounts, err := s.selectMounts(req.Profile)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mounts {
+		m.operationService().Pause()
+	}
+	return map[string]bool{"paused": true}, nil
+}
+
+func (s *controlServer) resumeUploads(req controlRequest) (interface{}, error) {
+	mounts, err := s.selectMounts(req.Profile)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range mounts {
+		m.operationService().Resume()
+	}
+	return map[string]bool{"paused": false}, nil
+}
+
+func (s *controlServer) transfers(req controlRequest) (interface{}, error) {
+	results := []mountTransfers{}
+	for _, m := range s.mounts {
+		results = append(results, mountTransfers{
+			Profile:   m.Profile,
+			Transfers: m.transferService().List(),
+		})
+	}
+	return results, nil
+}
+
+// Returns a handler which applies 'action' to the transfer with the
+// request's ID, in whichever mount it belongs to.
+func (s *controlServer) transferAction(
+	action func(svc *engine.TransferService, id int64) (bool, error),
+) func(req controlRequest) (interface{}, error) {
+	return func(req controlRequest) (interface{}, error) {
+		for _, m := range s.mounts {
+			found, err := action(m.transferService(), req.ID)
+			if err != nil {
+				return nil, &controlError{http.StatusConflict, err}
+			}
+			if found {
+				return map[string]int64{"id": req.ID}, nil
+			}
+		}
+		return nil, &controlError{http.StatusNotFound, fmt.Errorf("no transfer %d is in flight", req.ID)}
+	}
+}
+
+// Sends a request to the control API of the running process and decodes
+// the response into 'result'.
+func callControl(method, endpoint string, req *controlRequest, result interface{}) error {
+	socketPath := controlSocketPath()
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+
+	var body io.Reader
+	if req != nil {
+		payload, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	httpReq, err := http.NewRequest(method, "http://puter-fuse"+endpoint, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return &exitError{
+			code: exitNotMounted,
+			err:  fmt.Errorf("puter-fuse is not running (no control socket at %s)", socketPath),
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		failure := map[string]string{}
+		json.NewDecoder(resp.Body).Decode(&failure)
+		err := fmt.Errorf("%s", firstNonEmpty(failure["error"], resp.Status))
+		if resp.StatusCode == http.StatusNotFound {
+			return &exitError{code: exitNotMounted, err: err}
+		}
+		if resp.StatusCode == http.StatusBadRequest {
+			return &exitError{code: exitUsage, err: err}
+		}
+		return err
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// Returns the profile control commands act on, or an empty string for
+// every mount.
+func controlProfile() string {
+	if len(profileNames) > 0 {
+		return profileNames[0]
+	}
+	return os.Getenv("PUTER_FUSE_PROFILE")
+}
+
+func controlStatusCommand() error {
+	status := controlStatus{}
+	if err := callControl(http.MethodGet, "/v1/status", nil, &status); err != nil {
+		return err
+	}
+
+	if controlOpts.json {
+		out, _ := json.MarshalIndent(status, "", "  ")
+		fmt.Println(string(out))
+		return nil
+	}
+
+	fmt.Printf("Process: %d (log level %s)\n", status.PID, status.LogLevel)
+	profile := controlProfile()
+	for _, m := range status.Mounts {
+		if profile != "" && !strings.EqualFold(m.Profile, profile) {
+			continue
+		}
+		uploads := "running"
+		if m.Queue.Paused {
+			uploads = "paused"
+		}
+		fmt.Printf("\n%s at %s\n", m.Profile, m.MountPoint)
+		fmt.Printf("  read-only: %v, authenticated: %v\n", m.ReadOnly, m.Authenticated)
+		fmt.Printf("  uploads: %s; %d queued, %d batch(es) in flight, %d operation(s) pending\n",
+			uploads, m.Queue.Queued, m.Queue.InFlightBatches, m.Queue.Pending)
+		fmt.Printf("  tree cache: %d directories, %d nodes, %d paths\n",
+			m.Caches.Tree.Directories, m.Caches.Tree.Nodes, m.Caches.Tree.Paths)
+		fmt.Printf("  blob cache: %d blobs, %d bytes\n", m.Caches.Blob.Blobs, m.Caches.Blob.Bytes)
+		fmt.Printf("  write cache: %d files (%d pending), %d bytes in memory\n",
+			m.Caches.Write.Files, m.Caches.Write.PendingFiles, m.Caches.Write.MemoryBytes)
+		requests := []string{}
+		for _, class := range []string{"metadata", "read", "prefetch", "write-back"} {
+			requests = append(requests, fmt.Sprintf("%s %d running, %d queued",
+				class, m.Requests.Running[class], m.Requests.Queued[class]))
+		}
+		fmt.Printf("  requests: %s\n", strings.Join(requests, "; "))
+		for path, count := range m.PendingMutations {
+			fmt.Printf("    %s: %d pending\n", path, count)
+		}
+	}
+	return nil
+}
+
+func controlFlushCommand() error {
+	results := []flushResult{}
+	err := callControl(http.MethodPost, "/v1/flush", &controlRequest{
+		Profile: controlProfile(),
+		Timeout: controlOpts.timeout.String(),
+	}, &results)
+	if err != nil {
+		return err
+	}
+
+	flushed := true
+	for _, result := range results {
+		if len(result.PendingFiles) == 0 && result.PendingOperations == 0 {
+			continue
+		}
+		flushed = false
+		fmt.Printf("%s: %d operation(s) still pending\n", result.Profile, result.PendingOperations)
+		for _, path := range result.PendingFiles {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+	if !flushed {
+		return fmt.Errorf("not all pending writes were flushed within %s", controlOpts.timeout)
+	}
+	fmt.Println("All pending writes were flushed")
+	return nil
+}
+
+func controlInvalidateCommand(target string) error {
+	req := &controlRequest{
+		Profile:   controlProfile(),
+		Path:      target,
+		Recursive: controlOpts.recursive,
+	}
+
+	// Paths under a mountpoint are translated to paths within the mount.
+	status := controlStatus{}
+	if err := callControl(http.MethodGet, "/v1/status", nil, &status); err != nil {
+		return err
+	}
+	if absTarget, err := filepath.Abs(target); err == nil {
+		for _, m := range status.Mounts {
+			mountPoint, _ := filepath.Abs(m.MountPoint)
+			if absTarget != mountPoint && !strings.HasPrefix(absTarget, mountPoint+"/") {
+				continue
+			}
+			req.Profile = m.Profile
+			req.Path = "/" + strings.TrimPrefix(absTarget[len(mountPoint):], "/")
+			break
+		}
+	}
+
+	result := map[string]int{}
+	if err := callControl(http.MethodPost, "/v1/invalidate", req, &result); err != nil {
+		return err
+	}
+	fmt.Printf("Invalidated %d path(s)\n", result["invalidated"])
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/engine"
+	faopkg "github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/puterfs"
+	"github.com/HeyPuter/puter-fuse/putersdk"
+)
+
+// how long writing to the control directory's flush file waits
+const controlDirFlushTimeout = 30 * time.Second
+
+type queueReport struct {
+	engine.OperationStats
+	Operations       []putersdk.Operation `json:"operations"`
+	PendingMutations map[string]int       `json:"pendingMutations"`
+}
+
+func marshalReport(report interface{}) ([]byte, error) {
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// Returns the files in the mount's control directory, which offers part
+// of the control API to anyone who can access the mount.
+func (m *Mount) controlFiles() []puterfs.ControlFile {
+	return []puterfs.ControlFile{
+		{
+			Name: "status.json",
+			Contents: func() ([]byte, error) {
+				return marshalReport(m.Status())
+			},
+		},
+		{
+			Name: "queue.json",
+			Contents: func() ([]byte, error) {
+				report := queueReport{
+					OperationStats:   m.operationService().Stats(),
+					Operations:       []putersdk.Operation{},
+					PendingMutations: m.cacheControlService().PendingMutations(),
+				}
+				for _, req := range m.operationService().PendingRequests() {
+					report.Operations = append(report.Operations, req.Operation)
+				}
+				return marshalReport(report)
+			},
+		},
+		{
+			Name: "transfers.json",
+			Contents: func() ([]byte, error) {
+				return marshalReport(m.transferService().List())
+			},
+		},
+		{
+			Name: "cache-stats.json",
+			Contents: func() ([]byte, error) {
+				return marshalReport(m.cacheControlService().Stats())
+			},
+		},
+		{
+			Name:    "invalidate",
+			Receive: m.invalidateFromControlDir,
+		},
+		{
+			Name: "flush",
+			Receive: func([]byte) error {
+				ctx, cancel := context.WithTimeout(context.Background(), controlDirFlushTimeout)
+				defer cancel()
+
+				result := m.Flush(ctx)
+				if len(result.PendingFiles) > 0 || result.PendingOperations > 0 {
+					return faopkg.Errorf(syscall.EIO, "not all pending writes were flushed")
+				}
+				return nil
+			},
+		},
+	}
+}
+
+// Invalidates each path written, one per line, and everything under it.
+// Paths are within the mount, or under its mountpoint.
+func (m *Mount) invalidateFromControlDir(data []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		target := strings.TrimSpace(scanner.Text())
+		if target == "" {
+			continue
+		}
+		if target == m.MountPoint || strings.HasPrefix(target, m.MountPoint+"/") {
+			target = "/" + strings.TrimPrefix(target[len(m.MountPoint):], "/")
+		}
+		if !strings.HasPrefix(target, "/") {
+			return faopkg.Errorf(syscall.EINVAL, "path must be absolute: %s", target)
+		}
+		m.cacheControlService().Invalidate(path.Clean(target), true)
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,243 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/HeyPuter/puter-fuse/credstore"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/viper"
+)
+
+// Returns the name of a profile's auth token in the credential store.
+func tokenCredential(profile string) string {
+	if profile == defaultProfile {
+		return "token"
+	}
+	return "token:" + strings.ToLower(profile)
+}
+
+func credentialsFilePath() string {
+	return filepath.Join(filepath.Dir(configFilePath()), "credentials.json")
+}
+
+// Opens the credential store selected by the "credentialStore" key:
+// "file" (the default) or "keyring".
+func openCredentialStore() (credstore.Store, error) {
+	switch backend := viper.GetString("credentialStore"); backend {
+	case "", "file":
+		return credstore.CreateFileStore(credstore.P_FileStore{
+			Path:       credentialsFilePath(),
+			Passphrase: readPassphrase,
+		}), nil
+	case "keyring":
+		if err := credstore.KeyringAvailable(); err != nil {
+			return nil, err
+		}
+		return credstore.CreateKeyringStore("puter-fuse"), nil
+	default:
+		return nil, fmt.Errorf(
+			"unknown credentialStore %q; expected file or keyring", backend)
+	}
+}
+
+// Set once the mounts are up. After that the credential file is only
+// read to re-authenticate in the background, when nobody may be at the
+// terminal, so it fails rather than prompting.
+var passphrasePromptsDone atomic.Bool
+
+// Returns the passphrase for the credential file from
+// PUTER_FUSE_PASSPHRASE, or prompts for it.
+func readPassphrase() (string, error) {
+	if passphrase := os.Getenv("PUTER_FUSE_PASSPHRASE"); passphrase != "" {
+		return passphrase, nil
+	}
+	if passphrasePromptsDone.Load() {
+		return "", errors.New("the credential file is locked")
+	}
+	if !stdinIsTerminal() {
+		return "", &exitError{
+			code: exitUsage,
+			err:  errors.New("no terminal; set PUTER_FUSE_PASSPHRASE to unlock the credential file"),
+		}
+	}
+
+	prompt := promptui.Prompt{
+		Label: "Credential passphrase",
+		Mask:  '*',
+	}
+	return prompt.Run()
+}
+
+// Returns the token saved by login for a profile, or an empty string.
+func savedToken(store credstore.Store, profile string) (string, error) {
+	token, err := store.Get(tokenCredential(profile))
+	if errors.Is(err, credstore.ErrNotFound) {
+		return "", nil
+	}
+	return token, err
+}
+
+func saveToken(profile, token string) error {
+	store, err := openCredentialStore()
+	if err != nil {
+		return err
+	}
+	return store.Set(tokenCredential(profile), token)
+}
+
+// Returns the token to use for a profile according to the documented
+// precedence: --token-file, then PUTER_TOKEN, then the credential store.
+// 'fromStore' is true if it came from the store, which is then unlocked.
+func resolveToken(
+	openStore func() (credstore.Store, error), profile string,
+) (token string, fromStore bool, err error) {
+	if token := resolvedTokenOverride(); token != "" {
+		return token, false, nil
+	}
+	store, err := openStore()
+	if err != nil {
+		return "", false, err
+	}
+	if err := migrateToken(store); err != nil {
+		return "", false, err
+	}
+	token, err = savedToken(store, profile)
+	return token, token != "", err
+}
+
+// Returns a function which finds a replacement for a token Puter rejected.
+// The source the token came from is checked again, in case it was rotated
+// or `puter-fuse login` was run, then the profile's tokenRefreshCommand is
+// run if it's configured. 'store' is the credential store the token came
+// from, kept unlocked since mounting, or nil if it came from elsewhere.
+func reauthenticator(profile string, cfg *viper.Viper, store credstore.Store) func(rejected string) (string, error) {
+	return func(rejected string) (string, error) {
+		return reauthenticate(profile, cfg, store, rejected)
+	}
+}
+
+func reauthenticate(profile string, cfg *viper.Viper, store credstore.Store, rejected string) (string, error) {
+	if tokenFile != "" {
+		if err := loadTokenFile(); err == nil && tokenFromFile != rejected {
+			return tokenFromFile, nil
+		}
+	} else if store != nil && os.Getenv("PUTER_TOKEN") == "" {
+		// The store was unlocked when mounting; if it's locked again,
+		// e.g. because the file was replaced, this fails rather than
+		// prompting (see passphrasePromptsDone).
+		token, err := savedToken(store, profile)
+		if err == nil && token != "" && token != rejected {
+			return token, nil
+		}
+	}
+
+	if command := cfg.GetString("tokenRefreshCommand"); command != "" {
+		out, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			return "", fmt.Errorf("tokenRefreshCommand failed: %s", err)
+		}
+		if token := strings.TrimSpace(string(out)); token != "" {
+			return token, nil
+		}
+	}
+
+	return "", errors.New("no new token found; run `puter-fuse login`")
+}
+
+// Moves a plaintext token left in the configuration file by older
+// versions into the credential store; these predate profiles, so it
+// belongs to the default profile.
+func migrateToken(store credstore.Store) error {
+	fileConfig, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	token := fileConfig.GetString("token")
+	if token == "" {
+		return nil
+	}
+
+	if err := store.Set(tokenCredential(defaultProfile), token); err != nil {
+		return fmt.Errorf("error moving token to the credential store: %s", err)
+	}
+	if err := updateProfileConfig(defaultProfile, map[string]interface{}{"token": nil}); err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "Moved the token from the configuration file to the credential store")
+	return nil
+}
+
+// Asks Puter to invalidate the session behind 'token'.
+func revokeToken(token string) error {
+	authURL := firstNonEmpty(viper.GetString("authUrl"), "https://puter.com")
+	req, err := http.NewRequest("POST", authURL+"/logout", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func logout() error {
+	store, err := openCredentialStore()
+	if err != nil {
+		return err
+	}
+	if err := migrateToken(store); err != nil {
+		return err
+	}
+
+	profile := currentProfile()
+	if has, err := store.Has(tokenCredential(profile)); err != nil {
+		return err
+	} else if !has {
+		return &exitError{code: exitNotLoggedIn, err: errors.New("not logged in")}
+	}
+
+	token, err := store.Get(tokenCredential(profile))
+	if err != nil {
+		return err
+	}
+
+	// The local copy is deleted even if Puter can't be reached.
+	if err := revokeToken(token); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: could not revoke token: %s\n", err)
+	}
+
+	return store.Delete(tokenCredential(profile))
+}
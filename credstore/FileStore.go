@@ -0,0 +1,262 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package credstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	fileStoreVersion = 1
+	kdfIterations    = 600000
+	keySize          = 32
+)
+
+type fileStoreEntry struct {
+	Nonce []byte `json:"nonce"`
+	Data  []byte `json:"data"`
+}
+
+type fileStoreContents struct {
+	Version    int                       `json:"version"`
+	Iterations int                       `json:"iterations"`
+	Salt       []byte                    `json:"salt"`
+	Entries    map[string]fileStoreEntry `json:"entries"`
+}
+
+type P_FileStore struct {
+	Path string
+	// Called at most once, the first time a secret is read or written.
+	Passphrase func() (string, error)
+	// PBKDF2 iterations for new files; defaults to kdfIterations
+	Iterations int
+}
+
+// FileStore keeps secrets in a JSON file readable only by its owner.
+// Each secret is sealed with AES-256-GCM using a key derived from a
+// passphrase with PBKDF2; the names of secrets are not encrypted.
+type FileStore struct {
+	P_FileStore
+
+	key  []byte
+	lock sync.Mutex
+}
+
+func CreateFileStore(params P_FileStore) *FileStore {
+	return &FileStore{
+		P_FileStore: params,
+	}
+}
+
+func (s *FileStore) load() (*fileStoreContents, error) {
+	data, err := os.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		iterations := s.Iterations
+		if iterations == 0 {
+			iterations = kdfIterations
+		}
+		return &fileStoreContents{
+			Version:    fileStoreVersion,
+			Iterations: iterations,
+			Salt:       salt,
+			Entries:    map[string]fileStoreEntry{},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	contents := &fileStoreContents{}
+	if err := json.Unmarshal(data, contents); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %s", s.Path, err)
+	}
+	if contents.Version != fileStoreVersion {
+		return nil, fmt.Errorf("unsupported credential file version %d", contents.Version)
+	}
+	if contents.Entries == nil {
+		contents.Entries = map[string]fileStoreEntry{}
+	}
+	return contents, nil
+}
+
+// Writes the file atomically with 0600 permissions.
+func (s *FileStore) save(contents *fileStoreContents) error {
+	data, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.Path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".credentials-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.Path)
+}
+
+func (s *FileStore) aead(contents *fileStoreContents) (cipher.AEAD, error) {
+	if s.key == nil {
+		if s.Passphrase == nil {
+			return nil, errors.New("no passphrase for credential file")
+		}
+		passphrase, err := s.Passphrase()
+		if err != nil {
+			return nil, err
+		}
+		s.key = pbkdf2.Key(
+			[]byte(passphrase), contents.Salt, contents.Iterations, keySize, sha256.New)
+	}
+
+	block, err := aes.NewCipher(s.key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (s *FileStore) Get(name string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	contents, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	entry, exists := contents.Entries[name]
+	if !exists {
+		return "", ErrNotFound
+	}
+
+	aead, err := s.aead(contents)
+	if err != nil {
+		return "", err
+	}
+
+	// The name is authenticated so entries can't be swapped.
+	plaintext, err := aead.Open(nil, entry.Nonce, entry.Data, []byte(name))
+	if err != nil {
+		s.key = nil
+		return "", errors.New("wrong passphrase or corrupt credential file")
+	}
+	return string(plaintext), nil
+}
+
+func (s *FileStore) Set(name string, secret string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	contents, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	aead, err := s.aead(contents)
+	if err != nil {
+		return err
+	}
+
+	// Every entry must be sealed with the same key, so it's verified
+	// against an existing entry before anything is written.
+	for existingName, entry := range contents.Entries {
+		_, err := aead.Open(nil, entry.Nonce, entry.Data, []byte(existingName))
+		if err != nil {
+			s.key = nil
+			return errors.New("wrong passphrase or corrupt credential file")
+		}
+		break
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	contents.Entries[name] = fileStoreEntry{
+		Nonce: nonce,
+		Data:  aead.Seal(nil, nonce, []byte(secret), []byte(name)),
+	}
+	return s.save(contents)
+}
+
+func (s *FileStore) Delete(name string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	contents, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, exists := contents.Entries[name]; !exists {
+		return ErrNotFound
+	}
+
+	delete(contents.Entries, name)
+	if len(contents.Entries) == 0 {
+		err := os.Remove(s.Path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return s.save(contents)
+}
+
+func (s *FileStore) Has(name string) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	contents, err := s.load()
+	if err != nil {
+		return false, err
+	}
+	_, exists := contents.Entries[name]
+	return exists, nil
+}
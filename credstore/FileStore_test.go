@@ -0,0 +1,123 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package credstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A file written by an earlier version, whose key derivation must still
+// be understood.
+const existingCredentials = `{"version":1,"iterations":1000,"salt":"DwZ2j8A5vxO2bu5SN+Ag5Q==",` +
+	`"entries":{"token":{"nonce":"j9J3FJvTZwVVGKaq","data":"waaoC5YB52KaCo1CqPulW7N01w=="}}}`
+
+func TestFileStoreReadsExistingFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.json")
+	if err := os.WriteFile(path, []byte(existingCredentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := createTestFileStore(path, "hunter2").Get("token")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if secret != "abc" {
+		t.Errorf("expected 'abc', got %q", secret)
+	}
+}
+
+func createTestFileStore(path, passphrase string) *FileStore {
+	return CreateFileStore(P_FileStore{
+		Path: path,
+		Passphrase: func() (string, error) {
+			return passphrase, nil
+		},
+		Iterations: 1000,
+	})
+}
+
+func TestFileStore(t *testing.T) {
+	t.Run("secrets round-trip", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "credentials.json")
+		if err := createTestFileStore(path, "hunter2").Set("token", "abc"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		secret, err := createTestFileStore(path, "hunter2").Get("token")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if secret != "abc" {
+			t.Errorf("expected 'abc', got '%s'", secret)
+		}
+
+		stat, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stat.Mode().Perm() != 0600 {
+			t.Errorf("expected mode 0600, got %o", stat.Mode().Perm())
+		}
+	})
+
+	t.Run("wrong passphrase is rejected", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "credentials.json")
+		createTestFileStore(path, "hunter2").Set("token", "abc")
+
+		store := createTestFileStore(path, "hunter3")
+		if _, err := store.Get("token"); err == nil {
+			t.Errorf("expected an error")
+		}
+		if err := store.Set("other", "def"); err == nil {
+			t.Errorf("expected an error")
+		}
+	})
+
+	t.Run("secret is not stored in plaintext", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "credentials.json")
+		createTestFileStore(path, "hunter2").Set("token", "very-secret-token")
+
+		data, _ := os.ReadFile(path)
+		if bytes.Contains(data, []byte("very-secret-token")) {
+			t.Errorf("secret found in plaintext")
+		}
+	})
+
+	t.Run("deleting the last secret removes the file", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "credentials.json")
+		store := createTestFileStore(path, "hunter2")
+		store.Set("token", "abc")
+
+		if err := store.Delete("token"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if has, _ := store.Has("token"); has {
+			t.Errorf("expected the secret to be deleted")
+		}
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected the file to be removed")
+		}
+		if err := store.Delete("token"); err != ErrNotFound {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package credstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// KeyringStore keeps secrets in the desktop keyring through the
+// freedesktop Secret Service, using libsecret's secret-tool.
+type KeyringStore struct {
+	Service string
+}
+
+func CreateKeyringStore(service string) *KeyringStore {
+	return &KeyringStore{
+		Service: service,
+	}
+}
+
+// Returns nil if secret-tool is installed.
+func KeyringAvailable() error {
+	_, err := exec.LookPath("secret-tool")
+	if err != nil {
+		return errors.New("secret-tool not found; install libsecret-tools")
+	}
+	return nil
+}
+
+func (s *KeyringStore) attributes(name string) []string {
+	return []string{"service", s.Service, "account", name}
+}
+
+func (s *KeyringStore) run(stdin string, args ...string) (string, error) {
+	cmd := exec.Command("secret-tool", args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	if err != nil {
+		var exitErr *exec.ExitError
+		// secret-tool exits 1 without output when nothing matched
+		if errors.As(err, &exitErr) && stderr.Len() == 0 {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("secret-tool: %s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+func (s *KeyringStore) Get(name string) (string, error) {
+	args := append([]string{"lookup"}, s.attributes(name)...)
+	return s.run("", args...)
+}
+
+func (s *KeyringStore) Set(name string, secret string) error {
+	args := append(
+		[]string{"store", "--label=" + s.Service + " " + name},
+		s.attributes(name)...)
+	_, err := s.run(secret, args...)
+	return err
+}
+
+func (s *KeyringStore) Delete(name string) error {
+	args := append([]string{"clear"}, s.attributes(name)...)
+	_, err := s.run("", args...)
+	return err
+}
+
+// Has searches for the secret rather than looking it up, since a lookup
+// unlocks the keyring and may prompt for its password. A search without
+// --unlock leaves a locked keyring locked.
+func (s *KeyringStore) Has(name string) (bool, error) {
+	args := append([]string{"search", "--all"}, s.attributes(name)...)
+	cmd := exec.Command("secret-tool", args...)
+	output := &bytes.Buffer{}
+	// depending on the version, item details go to stdout or stderr
+	cmd.Stdout = output
+	cmd.Stderr = output
+
+	err := cmd.Run()
+	for _, line := range strings.Split(output.String(), "\n") {
+		// each item found starts with its D-Bus object path
+		if strings.HasPrefix(line, "[/") {
+			return true, nil
+		}
+	}
+	var exitErr *exec.ExitError
+	if err != nil && !(errors.As(err, &exitErr) && output.Len() == 0) {
+		return false, fmt.Errorf("secret-tool: %s", strings.TrimSpace(output.String()))
+	}
+	return false, nil
+}
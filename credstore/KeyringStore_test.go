@@ -0,0 +1,71 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package credstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Puts a fake secret-tool on PATH which prints 'search' for searches and
+// fails anything else, recording its arguments in the returned file.
+func fakeSecretTool(t *testing.T, search string) string {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := "#!/bin/sh\n" +
+		"echo \"$@\" >> " + calls + "\n" +
+		"[ \"$1\" = search ] || exit 2\n" +
+		"printf '%s' '" + search + "'\n"
+	if err := os.WriteFile(filepath.Join(dir, "secret-tool"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	return calls
+}
+
+func TestKeyringStoreHas(t *testing.T) {
+	tests := []struct {
+		name   string
+		search string
+		has    bool
+	}{
+		{"secret found", "[/org/freedesktop/secrets/collection/login/1]\nlabel = puter-fuse token\n", true},
+		{"nothing found", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := fakeSecretTool(t, tt.search)
+
+			has, err := CreateKeyringStore("puter-fuse").Has("token")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if has != tt.has {
+				t.Errorf("expected %v, got %v", tt.has, has)
+			}
+
+			args, _ := os.ReadFile(calls)
+			if string(args) != "search --all service puter-fuse account token\n" {
+				t.Errorf("expected only a search, got %q", args)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package credstore
+
+import "errors"
+
+var ErrNotFound = errors.New("credential not found")
+
+// Store holds secrets such as auth tokens outside of the plaintext
+// configuration file.
+type Store interface {
+	Get(name string) (string, error)
+	Set(name string, secret string) error
+	Delete(name string) error
+	// Reports whether a secret exists without needing to decrypt it.
+	Has(name string) (bool, error)
+}
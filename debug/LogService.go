@@ -20,18 +20,74 @@ package debug
 
 import (
 	"fmt"
+	"strings"
+	"sync/atomic"
 
 	"github.com/HeyPuter/puter-fuse/services"
 )
 
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (level Level) String() string {
+	if level < 0 || int(level) >= len(levelNames) {
+		return fmt.Sprintf("level(%d)", int32(level))
+	}
+	return levelNames[level]
+}
+
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", name)
+}
+
+// messages below this level are discarded, unless a prefix level
+// applies to the logger
+var minLevel atomic.Int32
+
+func init() {
+	minLevel.Store(int32(LevelInfo))
+}
+
+func SetLevel(level Level) {
+	minLevel.Store(int32(level))
+}
+
+func GetLevel() Level {
+	return Level(minLevel.Load())
+}
+
 type ILogger interface {
+	Debug(format string, args ...interface{})
 	Log(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+	With(keysAndValues ...interface{}) *Logger
 	Sub(crumbs []string) *Logger
 	S(str string) *Logger
 }
 
+// Field is a key and value attached to every message of a logger.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
 type Logger struct {
 	Crumbs []string
+	Fields []Field
 }
 
 func NewLogger(format string, args ...interface{}) *Logger {
@@ -40,40 +96,69 @@ func NewLogger(format string, args ...interface{}) *Logger {
 	}
 }
 
-func (l *Logger) log(format, col string, args ...interface{}) {
-	str := ""
-	for _, crumb := range l.Crumbs {
-		str += fmt.Sprintf("[%s] ", crumb)
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	if !Enabled(l.Crumbs, level) {
+		return
 	}
+	emit(Entry{
+		Level:   level,
+		Crumbs:  l.Crumbs,
+		Message: fmt.Sprintf(format, args...),
+		Fields:  l.Fields,
+	})
+}
 
-	// Wrap in colour <col>
-	str = fmt.Sprintf("\033[%sm%s\033[0m", col, str)
-
-	str += fmt.Sprintf(format, args...)
-
-	fmt.Println(str)
+func (l *Logger) Debug(format string, args ...interface{}) {
+	l.log(LevelDebug, format, args...)
 }
 
 func (l *Logger) Log(format string, args ...interface{}) {
-	l.log(format, "34;1", args...)
+	l.log(LevelInfo, format, args...)
+}
+
+func (l *Logger) Warn(format string, args ...interface{}) {
+	l.log(LevelWarn, format, args...)
 }
 
 func (l *Logger) Error(format string, args ...interface{}) {
-	l.log(format, "31;1", args...)
+	l.log(LevelError, format, args...)
 }
 
-func (l *Logger) Sub(crumbs []string) *Logger {
+// Enabled reports whether messages at 'level' would be written; use it
+// to skip building expensive arguments.
+func (l *Logger) Enabled(level Level) bool {
+	return Enabled(l.Crumbs, level)
+}
+
+// With returns a logger which adds the given key-value pairs to each
+// message, e.g. logger.With("path", path, "size", n).
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	fields := append([]Field{}, l.Fields...)
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key := fmt.Sprint(keysAndValues[i])
+		var value interface{} = "(missing)"
+		if i+1 < len(keysAndValues) {
+			value = keysAndValues[i+1]
+		}
+		fields = append(fields, Field{Key: key, Value: value})
+	}
 	return &Logger{
-		Crumbs: append(l.Crumbs, crumbs...),
+		Crumbs: l.Crumbs,
+		Fields: fields,
 	}
 }
 
-func (l *Logger) S(str string) *Logger {
+func (l *Logger) Sub(crumbs []string) *Logger {
 	return &Logger{
-		Crumbs: append(l.Crumbs, str),
+		Crumbs: append(append([]string{}, l.Crumbs...), crumbs...),
+		Fields: l.Fields,
 	}
 }
 
+func (l *Logger) S(str string) *Logger {
+	return l.Sub([]string{str})
+}
+
 type LogService struct {
 	Logger *Logger
 
@@ -81,16 +166,16 @@ type LogService struct {
 }
 
 func (svc *LogService) Init(services services.IServiceContainer) {
-	svc.Logger = &Logger{}
+	// mounts in one process may share a logger
+	if svc.Logger == nil {
+		svc.Logger = &Logger{}
+	}
 }
 
 func (svc *LogService) Log(msg string) {
-	svc.Logger.Log(msg)
+	svc.Logger.Log("%s", msg)
 }
 
 func (svc *LogService) GetLogger(format string, args ...interface{}) *Logger {
-	logger := &Logger{
-		Crumbs: append(svc.Logger.Crumbs, fmt.Sprintf(format, args...)),
-	}
-	return logger
+	return svc.Logger.S(fmt.Sprintf(format, args...))
 }
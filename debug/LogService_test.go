@@ -0,0 +1,185 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package debug
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Captures output for the duration of a test.
+func captureOutput(t *testing.T, opts Options) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	opts.Writer = buf
+	Configure(opts)
+	level := GetLevel()
+	t.Cleanup(func() {
+		Configure(Options{Color: true})
+		SetLevel(level)
+	})
+	return buf
+}
+
+func TestRedact(t *testing.T) {
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"bearer", "Authorization: Bearer abc.def", "Authorization: Bearer [redacted]"},
+		{"json token", `{"token": "abc", "path": "/a"}`, `{"token": "[redacted]", "path": "/a"}`},
+		{"json password", `{"password":"hunter2"}`, `{"password":"[redacted]"}`},
+		{"query", "GET /read?token=abc&path=/a", "GET /read?token=[redacted]&path=/a"},
+		{"jwt", "using eyJhbGci.eyJzdWIi.c2lnbmF0dXJl now", "using [redacted] now"},
+		{"plain", "stat /user/token.txt", "stat /user/token.txt"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Redact(tc.input); got != tc.expected {
+				t.Fatalf("expected %q; got %q", tc.expected, got)
+			}
+		})
+	}
+
+	t.Run("added secret", func(t *testing.T) {
+		AddSecret("not-a-known-pattern-1234")
+		got := Redact("token is not-a-known-pattern-1234")
+		if got != "token is [redacted]" {
+			t.Fatalf("expected the secret to be redacted; got %q", got)
+		}
+	})
+}
+
+func TestPrefixLevels(t *testing.T) {
+	levels, err := ParsePrefixLevels([]string{"putersdk=debug", "Engine/Operation=error"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	captureOutput(t, Options{PrefixLevels: levels})
+	SetLevel(LevelInfo)
+
+	testCases := []struct {
+		crumbs   []string
+		level    Level
+		expected bool
+	}{
+		{[]string{"putersdk"}, LevelDebug, true},
+		{[]string{"engine", "operation"}, LevelInfo, false},
+		{[]string{"engine", "operation"}, LevelError, true},
+		{[]string{"engine", "blob-cache"}, LevelInfo, true},
+		{[]string{"engine", "blob-cache"}, LevelDebug, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(strings.Join(tc.crumbs, "/")+"@"+tc.level.String(), func(t *testing.T) {
+			if got := Enabled(tc.crumbs, tc.level); got != tc.expected {
+				t.Fatalf("expected %v; got %v", tc.expected, got)
+			}
+		})
+	}
+
+	if _, err := ParsePrefixLevels([]string{"putersdk"}); err == nil {
+		t.Fatalf("expected an error for an entry without a level")
+	}
+}
+
+func TestJSONOutput(t *testing.T) {
+	buf := captureOutput(t, Options{Format: FormatJSON})
+
+	NewLogger("putersdk").S("batch").With(
+		"status", 200,
+		"token", "abc",
+		"body", []byte("file contents"),
+	).Log("sent %d operations", 3)
+
+	record := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("output is not JSON: %s: %q", err, buf.String())
+	}
+
+	expected := map[string]interface{}{
+		"level":  "info",
+		"logger": "putersdk/batch",
+		"msg":    "sent 3 operations",
+		"status": float64(200),
+		"token":  "[redacted]",
+		"body":   "[13 bytes]",
+	}
+	for key, value := range expected {
+		if record[key] != value {
+			t.Errorf("%s: expected %v; got %v", key, value, record[key])
+		}
+	}
+}
+
+func TestTextOutput(t *testing.T) {
+	buf := captureOutput(t, Options{})
+
+	logger := NewLogger("fao")
+	logger.Debug("not written")
+	logger.With("path", "/a b").Warn("Bearer abc")
+
+	line := buf.String()
+	if strings.Count(line, "\n") != 1 {
+		t.Fatalf("expected one line; got %q", line)
+	}
+	for _, part := range []string{"WARN ", "[fao] ", "Bearer [redacted]", `path="/a b"`} {
+		if !strings.Contains(line, part) {
+			t.Errorf("expected %q in %q", part, line)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "puter-fuse.log")
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for file, contents := range expected {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != contents {
+			t.Errorf("%s: expected %q; got %q", file, contents, data)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected at most 2 backups")
+	}
+}
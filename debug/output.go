@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package debug
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Format int
+
+const (
+	FormatText Format = iota
+	FormatJSON
+)
+
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "", "text":
+		return FormatText, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return 0, fmt.Errorf("unknown log format %q (expected text or json)", name)
+}
+
+// Entry is one log message.
+type Entry struct {
+	Time    time.Time
+	Level   Level
+	Crumbs  []string
+	Message string
+	Fields  []Field
+}
+
+// Options configures where and how messages are written.
+type Options struct {
+	Format Format
+	Writer io.Writer
+	// colour crumbs and levels; only used for text
+	Color bool
+	// levels for loggers whose crumbs start with a prefix, overriding
+	// the global level; see ParsePrefixLevels
+	PrefixLevels map[string]Level
+}
+
+type output struct {
+	Options
+	// prefixes sorted longest first
+	prefixes []string
+	lock     sync.Mutex
+}
+
+var current = &output{
+	Options: Options{
+		Writer: os.Stdout,
+		Color:  true,
+	},
+}
+var currentLock sync.RWMutex
+
+// Configure replaces the output options for every logger.
+func Configure(opts Options) {
+	if opts.Writer == nil {
+		opts.Writer = os.Stdout
+	}
+	o := &output{Options: opts}
+	for prefix := range opts.PrefixLevels {
+		o.prefixes = append(o.prefixes, prefix)
+	}
+	sort.Slice(o.prefixes, func(i, j int) bool {
+		return len(o.prefixes[i]) > len(o.prefixes[j])
+	})
+
+	currentLock.Lock()
+	current = o
+	currentLock.Unlock()
+}
+
+func getOutput() *output {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+	return current
+}
+
+// Crumbs are matched against prefixes joined by "/", ignoring case.
+func crumbPath(crumbs []string) string {
+	return strings.ToLower(strings.Join(crumbs, "/"))
+}
+
+// ParsePrefixLevels parses entries like "putersdk=debug" or
+// "engine/operation=warn".
+func ParsePrefixLevels(entries []string) (map[string]Level, error) {
+	levels := map[string]Level{}
+	for _, entry := range entries {
+		prefix, name, found := strings.Cut(entry, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid log level %q (expected prefix=level)", entry)
+		}
+		level, err := ParseLevel(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		levels[strings.ToLower(strings.TrimSpace(prefix))] = level
+	}
+	return levels, nil
+}
+
+// Enabled reports whether a logger with 'crumbs' writes messages at
+// 'level'. The longest matching prefix level applies, then the global one.
+func Enabled(crumbs []string, level Level) bool {
+	o := getOutput()
+	if len(o.prefixes) > 0 {
+		path := crumbPath(crumbs)
+		for _, prefix := range o.prefixes {
+			if strings.HasPrefix(path, prefix) {
+				return level >= o.PrefixLevels[prefix]
+			}
+		}
+	}
+	return level >= GetLevel()
+}
+
+var levelColors = []string{"90", "34;1", "33;1", "31;1"}
+
+func emit(entry Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	entry.Message = Redact(entry.Message)
+
+	o := getOutput()
+	buf := &bytes.Buffer{}
+	if o.Format == FormatJSON {
+		writeJSON(buf, entry)
+	} else {
+		writeText(buf, entry, o.Color)
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.Writer.Write(buf.Bytes())
+}
+
+func writeText(buf *bytes.Buffer, entry Entry, color bool) {
+	buf.WriteString(entry.Time.Format("2006-01-02T15:04:05.000Z07:00"))
+	buf.WriteByte(' ')
+
+	prefix := fmt.Sprintf("%-5s ", strings.ToUpper(entry.Level.String()))
+	for _, crumb := range entry.Crumbs {
+		prefix += fmt.Sprintf("[%s] ", crumb)
+	}
+	if color && int(entry.Level) < len(levelColors) {
+		prefix = fmt.Sprintf("\033[%sm%s\033[0m", levelColors[entry.Level], prefix)
+	}
+	buf.WriteString(prefix)
+	buf.WriteString(entry.Message)
+
+	for _, field := range entry.Fields {
+		value := fmt.Sprint(redactField(field))
+		if strings.ContainsAny(value, " \t\n\"=") || value == "" {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(buf, " %s=%s", field.Key, value)
+	}
+	buf.WriteByte('\n')
+}
+
+func writeJSON(buf *bytes.Buffer, entry Entry) {
+	record := map[string]interface{}{
+		"time":  entry.Time.Format(time.RFC3339Nano),
+		"level": entry.Level.String(),
+		"msg":   entry.Message,
+	}
+	if len(entry.Crumbs) > 0 {
+		record["logger"] = strings.Join(entry.Crumbs, "/")
+	}
+	for _, field := range entry.Fields {
+		value := redactField(field)
+		// fields don't replace the standard keys
+		if _, exists := record[field.Key]; exists {
+			record["field."+field.Key] = value
+			continue
+		}
+		record[field.Key] = value
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		record = map[string]interface{}{
+			"time":  record["time"],
+			"level": record["level"],
+			"msg":   entry.Message,
+			"error": fmt.Sprintf("fields could not be encoded: %s", err),
+		}
+		data, _ = json.Marshal(record)
+	}
+	buf.Write(data)
+	buf.WriteByte('\n')
+}
@@ -0,0 +1,111 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package debug
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const redacted = "[redacted]"
+
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile(`(?i)(bearer\s+)[^\s"']+`), "${1}" + redacted},
+	{regexp.MustCompile(`(?i)("(?:token|auth_token|password|authorization|secret)"\s*:\s*)"(?:[^"\\]|\\.)*"`), `${1}"` + redacted + `"`},
+	{regexp.MustCompile(`(?i)\b((?:token|auth_token|password)=)[^\s&"']+`), "${1}" + redacted},
+	// JWTs, which is what Puter's tokens are
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`), redacted},
+}
+
+// field keys whose values are never logged
+var secretKeys = map[string]bool{
+	"token":         true,
+	"auth_token":    true,
+	"password":      true,
+	"passphrase":    true,
+	"authorization": true,
+	"secret":        true,
+}
+
+var (
+	secrets     []string
+	secretsLock sync.RWMutex
+)
+
+// AddSecret makes Redact hide 'secret' wherever it appears, e.g. a token
+// which doesn't match any of the known patterns.
+func AddSecret(secret string) {
+	if len(secret) < 8 {
+		return
+	}
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	for _, s := range secrets {
+		if s == secret {
+			return
+		}
+	}
+	secrets = append(secrets, secret)
+}
+
+// Redact hides tokens and passwords in 's'.
+func Redact(s string) string {
+	secretsLock.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, redacted)
+	}
+	secretsLock.RUnlock()
+
+	for _, p := range secretPatterns {
+		s = p.pattern.ReplaceAllString(s, p.replacement)
+	}
+	return s
+}
+
+// Payload describes 'data' by its size, for logging request and file
+// contents without their bytes.
+type Payload []byte
+
+func (p Payload) String() string {
+	return fmt.Sprintf("[%d bytes]", len(p))
+}
+
+func redactField(field Field) interface{} {
+	if secretKeys[strings.ToLower(field.Key)] {
+		return redacted
+	}
+	switch value := field.Value.(type) {
+	case []byte:
+		return Payload(value).String()
+	case Payload:
+		return value.String()
+	case string:
+		return Redact(value)
+	case error:
+		return Redact(value.Error())
+	case fmt.Stringer:
+		return Redact(value.String())
+	}
+	return field.Value
+}
@@ -20,7 +20,6 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"syscall"
 
 	"github.com/hanwen/go-fuse/v2/fs"
@@ -32,7 +31,7 @@ type PuterFSDirectoryInode struct {
 }
 
 func (n *PuterFSDirectoryInode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
-	fmt.Printf("lookup(%s)\n", name)
+	exampleLog.Debug("lookup(%s)", name)
 	inode := &PuterFSFileInode{
 		Contents: []byte("hello\nworld\n"),
 	}
@@ -43,7 +42,7 @@ func (n *PuterFSDirectoryInode) Lookup(ctx context.Context, name string, out *fu
 }
 
 func (n *PuterFSDirectoryInode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	fmt.Println("readdir")
+	exampleLog.Debug("readdir")
 	return fs.NewListDirStream([]fuse.DirEntry{
 		{
 			Name: "test.txt",
@@ -0,0 +1,205 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+// Package encryption implements the format of files and names encrypted
+// by EncryptionFAO.
+//
+// A file is a header followed by chunks. The header is a magic number
+// and a random file ID. Each chunk holds up to ChunkSize bytes of
+// plaintext sealed with AES-256-GCM under a key derived for the file,
+// with a random nonce stored in front of it. The file ID, the chunk's
+// index and whether it's the last chunk are authenticated with it, so
+// chunks can't be moved, swapped between files, or cut off the end
+// without detection. Any chunk can be read or rewritten on its own.
+//
+// An empty file has no header, so files created by other layers are
+// valid empty files.
+package encryption
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+const (
+	KeySize   = 32
+	ChunkSize = 64 * 1024
+
+	idSize     = 16
+	HeaderSize = 4 + idSize
+
+	nonceSize = 12
+	tagSize   = 16
+	// bytes added to each chunk
+	ChunkOverhead = nonceSize + tagSize
+	// the size of a full chunk once sealed
+	SealedChunkSize = ChunkSize + ChunkOverhead
+)
+
+var magic = []byte("PFE\x01")
+
+var ErrCorrupt = errors.New("encrypted data is corrupt or was changed")
+
+// Keys are derived from the master key, so it's never used directly.
+type Keys struct {
+	content  []byte
+	nameMAC  []byte
+	nameSeal cipher.AEAD
+}
+
+func derive(master []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, master)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func DeriveKeys(master []byte) (*Keys, error) {
+	if len(master) != KeySize {
+		return nil, fmt.Errorf("encryption keys are %d bytes, not %d", KeySize, len(master))
+	}
+	nameSeal, err := newGCM(derive(master, "puter-fuse names"))
+	if err != nil {
+		return nil, err
+	}
+	return &Keys{
+		content:  derive(master, "puter-fuse contents"),
+		nameMAC:  derive(master, "puter-fuse name IVs"),
+		nameSeal: nameSeal,
+	}, nil
+}
+
+// GenerateKey returns a new random master key.
+func GenerateKey() []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Header identifies a file and holds its key.
+type Header struct {
+	id   []byte
+	aead cipher.AEAD
+}
+
+func (k *Keys) header(id []byte) (*Header, error) {
+	aead, err := newGCM(derive(k.content, string(id)))
+	if err != nil {
+		return nil, err
+	}
+	return &Header{id: id, aead: aead}, nil
+}
+
+// NewHeader returns the header for a new file.
+func (k *Keys) NewHeader() (*Header, error) {
+	id := make([]byte, idSize)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	return k.header(id)
+}
+
+// ParseHeader reads the header at the start of a file.
+func (k *Keys) ParseHeader(data []byte) (*Header, error) {
+	if len(data) < HeaderSize || !bytes.Equal(data[:len(magic)], magic) {
+		return nil, ErrCorrupt
+	}
+	return k.header(bytes.Clone(data[len(magic):HeaderSize]))
+}
+
+func (h *Header) Bytes() []byte {
+	return append(bytes.Clone(magic), h.id...)
+}
+
+func (h *Header) additionalData(index int64, final bool) []byte {
+	data := make([]byte, idSize+9)
+	copy(data, h.id)
+	binary.BigEndian.PutUint64(data[idSize:], uint64(index))
+	if final {
+		data[idSize+8] = 1
+	}
+	return data
+}
+
+// Seal encrypts the chunk at 'index'; 'final' is true for the last
+// chunk of the file.
+func (h *Header) Seal(index int64, final bool, plaintext []byte) []byte {
+	nonce := make([]byte, nonceSize, nonceSize+len(plaintext)+tagSize)
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	return h.aead.Seal(nonce, nonce, plaintext, h.additionalData(index, final))
+}
+
+// Open decrypts the chunk at 'index'.
+func (h *Header) Open(index int64, final bool, sealed []byte) ([]byte, error) {
+	if len(sealed) < ChunkOverhead {
+		return nil, ErrCorrupt
+	}
+	plaintext, err := h.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], h.additionalData(index, final))
+	if err != nil {
+		return nil, ErrCorrupt
+	}
+	return plaintext, nil
+}
+
+// Chunks returns the number of chunks in a file of 'plainSize' bytes;
+// files with a header have at least one, which may be empty.
+func Chunks(plainSize int64) int64 {
+	if plainSize == 0 {
+		return 1
+	}
+	return (plainSize + ChunkSize - 1) / ChunkSize
+}
+
+// PlainSize returns the size of the plaintext of an encrypted file.
+func PlainSize(sealedSize int64) int64 {
+	if sealedSize <= HeaderSize {
+		return 0
+	}
+	body := sealedSize - HeaderSize
+	chunks := (body + SealedChunkSize - 1) / SealedChunkSize
+	return max(0, body-chunks*ChunkOverhead)
+}
+
+// SealedSize returns the size of an encrypted file with 'plainSize'
+// bytes of plaintext.
+func SealedSize(plainSize int64) int64 {
+	return HeaderSize + plainSize + Chunks(plainSize)*ChunkOverhead
+}
+
+// ChunkOffset returns where the chunk at 'index' starts in the file.
+func ChunkOffset(index int64) int64 {
+	return HeaderSize + index*SealedChunkSize
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func testKeys(t *testing.T) *Keys {
+	keys, err := DeriveKeys(bytes.Repeat([]byte{7}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// Encrypts 'plaintext' as a whole file.
+func seal(t *testing.T, keys *Keys, plaintext []byte) []byte {
+	header, err := keys.NewHeader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := header.Bytes()
+	chunks := Chunks(int64(len(plaintext)))
+	for i := int64(0); i < chunks; i++ {
+		end := min((i+1)*ChunkSize, int64(len(plaintext)))
+		sealed = append(sealed, header.Seal(i, i == chunks-1, plaintext[i*ChunkSize:end])...)
+	}
+	return sealed
+}
+
+func TestSizes(t *testing.T) {
+	for _, size := range []int64{0, 1, ChunkSize - 1, ChunkSize, ChunkSize + 1, 3*ChunkSize + 17} {
+		sealed := SealedSize(size)
+		if got := PlainSize(sealed); got != size {
+			t.Errorf("PlainSize(SealedSize(%d)) = %d", size, got)
+		}
+		if got := int64(len(seal(t, testKeys(t), make([]byte, size)))); got != sealed {
+			t.Errorf("expected %d bytes for %d of plaintext, got %d", sealed, size, got)
+		}
+	}
+	if PlainSize(0) != 0 {
+		t.Errorf("expected an empty file to be empty")
+	}
+}
+
+func TestReader(t *testing.T) {
+	keys := testKeys(t)
+	plaintext := make([]byte, 2*ChunkSize+100)
+	for i := range plaintext {
+		plaintext[i] = byte(i)
+	}
+	sealed := seal(t, keys, plaintext)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		corrupt bool
+	}{
+		{"whole file", sealed, false},
+		{"empty file", []byte{}, false},
+		{"cut at a chunk", sealed[:ChunkOffset(2)], true},
+		{"cut in a chunk", sealed[:len(sealed)-10], true},
+		{"changed byte", append(bytes.Clone(sealed[:100]), append([]byte{sealed[100] ^ 1}, sealed[101:]...)...), true},
+		{"chunks swapped", append(append(bytes.Clone(sealed[:ChunkOffset(0)]), sealed[ChunkOffset(1):ChunkOffset(2)]...), append(bytes.Clone(sealed[ChunkOffset(0):ChunkOffset(1)]), sealed[ChunkOffset(2):]...)...), true},
+		{"other key", seal(t, func() *Keys { k, _ := DeriveKeys(make([]byte, KeySize)); return k }(), plaintext), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := io.ReadAll(keys.NewReader(bytes.NewReader(tt.data)))
+			if tt.corrupt {
+				if !errors.Is(err, ErrCorrupt) {
+					t.Errorf("expected ErrCorrupt, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tt.data) > 0 && !bytes.Equal(got, plaintext) {
+				t.Errorf("plaintext doesn't match")
+			}
+		})
+	}
+}
+
+func TestNames(t *testing.T) {
+	keys := testKeys(t)
+	encrypted := keys.EncryptName("notes.txt")
+	if encrypted == "notes.txt" || keys.EncryptName("notes.txt") != encrypted {
+		t.Errorf("expected names to be encrypted deterministically")
+	}
+	if name, err := keys.DecryptName(encrypted); err != nil || name != "notes.txt" {
+		t.Errorf("expected %q, got %q, %v", "notes.txt", name, err)
+	}
+	if _, err := keys.DecryptName("notes.txt"); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("expected a plaintext name not to decrypt, got %v", err)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+)
+
+// Names are encrypted deterministically so a node can be found by its
+// name. The nonce is a MAC of the name, which is checked again when the
+// name is decrypted.
+
+func (k *Keys) nameNonce(name string) []byte {
+	mac := hmac.New(sha256.New, k.nameMAC)
+	mac.Write([]byte(name))
+	return mac.Sum(nil)[:nonceSize]
+}
+
+func (k *Keys) EncryptName(name string) string {
+	nonce := k.nameNonce(name)
+	sealed := k.nameSeal.Seal(nonce, nonce, []byte(name), nil)
+	return base64.RawURLEncoding.EncodeToString(sealed)
+}
+
+func (k *Keys) DecryptName(encrypted string) (string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(encrypted)
+	if err != nil || len(sealed) < ChunkOverhead {
+		return "", ErrCorrupt
+	}
+	plaintext, err := k.nameSeal.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", ErrCorrupt
+	}
+	name := string(plaintext)
+	if !hmac.Equal(k.nameNonce(name), sealed[:nonceSize]) {
+		return "", ErrCorrupt
+	}
+	return name, nil
+}
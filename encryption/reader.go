@@ -0,0 +1,94 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package encryption
+
+import (
+	"bufio"
+	"io"
+)
+
+// Reader decrypts a whole encrypted file as it's read.
+type Reader struct {
+	keys   *Keys
+	src    *bufio.Reader
+	header *Header
+	index  int64
+	buf    []byte
+	done   bool
+	err    error
+}
+
+func (k *Keys) NewReader(src io.Reader) *Reader {
+	return &Reader{keys: k, src: bufio.NewReaderSize(src, SealedChunkSize+1)}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Decrypts the next chunk into r.buf.
+func (r *Reader) next() error {
+	if r.done {
+		return io.EOF
+	}
+
+	if r.header == nil {
+		data := make([]byte, HeaderSize)
+		n, err := io.ReadFull(r.src, data)
+		if n == 0 && err == io.EOF {
+			// an empty file
+			return io.EOF
+		}
+		if err != nil {
+			return ErrCorrupt
+		}
+		if r.header, err = r.keys.ParseHeader(data); err != nil {
+			return err
+		}
+	}
+
+	sealed := make([]byte, SealedChunkSize)
+	n, err := io.ReadFull(r.src, sealed)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	final := n < SealedChunkSize
+	if !final {
+		if _, err := r.src.Peek(1); err == io.EOF {
+			final = true
+		}
+	}
+
+	plaintext, err := r.header.Open(r.index, final, sealed[:n])
+	if err != nil {
+		return err
+	}
+	r.index++
+	r.buf = plaintext
+	r.done = final
+	return nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/HeyPuter/puter-fuse/credstore"
+	"github.com/HeyPuter/puter-fuse/encryption"
+)
+
+// Returns the name of a profile's encryption key in the credential store.
+func encryptionKeyCredential(profile string) string {
+	if profile == defaultProfile {
+		return "encryption-key"
+	}
+	return "encryption-key:" + strings.ToLower(profile)
+}
+
+func decodeEncryptionKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != encryption.KeySize {
+		return nil, fmt.Errorf("an encryption key is %d bytes of base64", encryption.KeySize)
+	}
+	return key, nil
+}
+
+// Returns the keys for a profile's encrypted subtrees.
+func loadEncryptionKeys(store credstore.Store, profile string) (*encryption.Keys, error) {
+	encoded, err := store.Get(encryptionKeyCredential(profile))
+	if errors.Is(err, credstore.ErrNotFound) {
+		return nil, &exitError{
+			code: exitUsage,
+			err:  fmt.Errorf("profile %q has no encryption key; run `puter-fuse encryption init` or `puter-fuse encryption import`", profile),
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := decodeEncryptionKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return encryption.DeriveKeys(key)
+}
+
+func saveEncryptionKey(profile string, key []byte, replace bool) error {
+	store, err := openCredentialStore()
+	if err != nil {
+		return err
+	}
+	name := encryptionKeyCredential(profile)
+	if exists, err := store.Has(name); err != nil {
+		return err
+	} else if exists && !replace {
+		return &exitError{
+			code: exitUsage,
+			err:  fmt.Errorf("profile %q already has an encryption key; files encrypted with it can't be read without it", profile),
+		}
+	}
+	return store.Set(name, base64.StdEncoding.EncodeToString(key))
+}
+
+func encryptionInit() error {
+	profile := currentProfile()
+	if err := saveEncryptionKey(profile, encryption.GenerateKey(), false); err != nil {
+		return err
+	}
+	fmt.Printf("Created an encryption key for profile %q.\n", profile)
+	fmt.Println("Keep a copy from `puter-fuse encryption export`; encrypted files can't be read without it.")
+	return nil
+}
+
+func encryptionExport() error {
+	store, err := openCredentialStore()
+	if err != nil {
+		return err
+	}
+	encoded, err := store.Get(encryptionKeyCredential(currentProfile()))
+	if errors.Is(err, credstore.ErrNotFound) {
+		return fmt.Errorf("profile %q has no encryption key", currentProfile())
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Println(encoded)
+	return nil
+}
+
+// Reads a key exported on another machine from stdin.
+func encryptionImport(replace bool) error {
+	if stdinIsTerminal() {
+		fmt.Fprint(os.Stderr, "Encryption key: ")
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		return fmt.Errorf("error reading the key: %s", err)
+	}
+	key, err := decodeEncryptionKey(line)
+	if err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+	if err := saveEncryptionKey(currentProfile(), key, replace); err != nil {
+		return err
+	}
+	fmt.Printf("Saved the encryption key for profile %q.\n", currentProfile())
+	return nil
+}
@@ -21,12 +21,12 @@ package engine
 import (
 	"crypto/sha1"
 	"encoding/hex"
-	"fmt"
 	"io"
 	"path/filepath"
 	"sync"
 
 	"github.com/HeyPuter/puter-fuse/lang"
+	"github.com/HeyPuter/puter-fuse/putersdk"
 	"github.com/HeyPuter/puter-fuse/services"
 	"github.com/google/uuid"
 	"github.com/spf13/afero"
@@ -48,10 +48,8 @@ func (ref *BLOBCacheReference) Release() {
 	ref.entry.ReferencesLock.Lock()
 	defer ref.entry.ReferencesLock.Unlock()
 
-	fmt.Println("looking for ref in", []*BLOBCacheReference{ref}, ref.entry.References)
 	for i, r := range ref.entry.References {
 		if r == ref {
-			fmt.Println("found ref in", ref, r, ref.entry.References)
 			ref.entry.References = append(
 				ref.entry.References[:i],
 				ref.entry.References[i+1:]...,
@@ -60,10 +58,7 @@ func (ref *BLOBCacheReference) Release() {
 		}
 	}
 
-	fmt.Println("ref count is", len(ref.entry.References))
-
 	if len(ref.entry.References) == 0 {
-		fmt.Println("ref not found in", ref.entry.References)
 		close(ref.entry.AwaitRelease)
 	}
 }
@@ -79,6 +74,7 @@ func (ref *BLOBCacheReference) AwaitRemovedFromFS() <-chan struct{} {
 type BLOBCacheEntry struct {
 	Uid                string
 	Hash               string
+	Size               int64
 	ReferencesLock     sync.RWMutex
 	References         []*BLOBCacheReference
 	AwaitRelease       chan struct{}
@@ -86,6 +82,8 @@ type BLOBCacheEntry struct {
 	AwaitRemovedFromFS chan struct{}
 }
 
+var blobLog = logger.S("blob-cache")
+
 type BLOBCacheService struct {
 	ConfigService IConfig
 	KnownBlobs    lang.IMap[string, *BLOBCacheEntry]
@@ -106,6 +104,26 @@ func CreateBLOBCacheService(fs afero.Fs) *BLOBCacheService {
 func (svc *BLOBCacheService) Store(
 	reader io.Reader,
 ) *BLOBCacheReference {
+	ref, _ := svc.store(reader)
+	return ref
+}
+
+// Like Store, but fails if 'reader' or the cache file does rather than
+// caching whatever was read before the error.
+func (svc *BLOBCacheService) TryStore(
+	reader io.Reader,
+) (*BLOBCacheReference, error) {
+	ref, err := svc.store(reader)
+	if err != nil {
+		ref.Release()
+		return nil, err
+	}
+	return ref, nil
+}
+
+func (svc *BLOBCacheService) store(
+	reader io.Reader,
+) (*BLOBCacheReference, error) {
 	// {
 	// 	maybeRef := svc.Hold(hash)
 	// 	if maybeRef != nil {
@@ -129,11 +147,23 @@ func (svc *BLOBCacheService) Store(
 
 	hasher := sha1.New()
 	reader = io.TeeReader(reader, hasher)
-	svc.storeFile(tmpid, reader)
+	size, err := svc.storeFile(tmpid, reader)
 
 	// TODO: see if we can remove encode to hex (i.e. is []byte "comparable"?)
 	hash := hex.EncodeToString(hasher.Sum(nil))
 	entry.Hash = hash
+	entry.Size = size
+
+	// If this blob is already cached, hold the existing entry instead;
+	// replacing it would delete the file out from under its references.
+	if existingRef := svc.Hold(hash); existingRef != nil {
+		svc.deleteFile(tmpid)
+		return existingRef, err
+	}
+	if existing, ok := svc.KnownBlobs.Get(hash); ok {
+		// the existing entry is being released; wait until it's gone
+		<-existing.AwaitRemovedFromFS
+	}
 
 	svc.Filesystem.Rename(
 		filepath.Join(
@@ -151,25 +181,49 @@ func (svc *BLOBCacheService) Store(
 	go func() {
 		<-entry.AwaitRelease
 		svc.KnownBlobs.Del(hash)
+		CacheEvictions.With("blob").Inc()
 		close(entry.AwaitForgotten)
 		svc.deleteFile(hash)
 		close(entry.AwaitRemovedFromFS)
 	}()
 
-	return ref
+	return ref, err
+}
+
+type BLOBCacheStats struct {
+	Blobs int   `json:"blobs"`
+	Bytes int64 `json:"bytes"`
+}
+
+func (svc *BLOBCacheService) Stats() BLOBCacheStats {
+	stats := BLOBCacheStats{}
+	for _, entry := range svc.KnownBlobs.Values() {
+		stats.Blobs++
+		stats.Bytes += entry.Size
+	}
+	return stats
 }
 
 func (svc *BLOBCacheService) GetBytes(
 	hash string, offset int64,
 	buffer []byte,
 ) (int, bool, error) {
-	fmt.Printf("GETTING BYTES FOR %s; OFFSET %d; SIZE %d\n", hash, offset, len(buffer))
-	reader := svc.Get(hash, offset, int64(len(buffer)))
-	if reader == nil {
+	blobLog.Debug("reading %d bytes of %s at %d", len(buffer), hash, offset)
+	ref := svc.Hold(hash)
+	if ref == nil {
 		return 0, false, nil
 	}
+	defer ref.Release()
+
+	file := svc.getFile(hash)
+	if file == nil {
+		return 0, false, nil
+	}
+	if closer, ok := file.(io.Closer); ok {
+		defer closer.Close()
+	}
 
-	n, err := reader.Read(buffer)
+	n, err := file.ReadAt(buffer, offset)
 	if err != nil && err != io.EOF {
 		return 0, false, err
 	}
@@ -177,6 +231,15 @@ func (svc *BLOBCacheService) GetBytes(
 	return n, true, nil
 }
 
+// Returns the size of a cached blob, and false if it isn't cached.
+func (svc *BLOBCacheService) GetSize(hash string) (int64, bool) {
+	entry, ok := svc.KnownBlobs.Get(hash)
+	if !ok {
+		return 0, false
+	}
+	return entry.Size, true
+}
+
 func (svc *BLOBCacheService) Get(
 	hash string, offset, size int64,
 ) io.Reader {
@@ -186,6 +249,10 @@ func (svc *BLOBCacheService) Get(
 	}
 
 	atReader := svc.getFile(hash)
+	if atReader == nil {
+		maybeRef.Release()
+		return nil
+	}
 
 	var reader io.Reader
 	reader = io.NewSectionReader(atReader, offset, size)
@@ -193,13 +260,24 @@ func (svc *BLOBCacheService) Get(
 
 	go func() {
 		<-reader.(*lang.SignalReader).Done
-		fmt.Println("DONE SIGNAL IS WORKING")
+		if closer, ok := atReader.(io.Closer); ok {
+			closer.Close()
+		}
 		maybeRef.Release()
 	}()
 
 	return reader
 }
 
+// Returns an upload which streams a cached blob from its file. 'ref'
+// must be held until the upload is finished.
+func (svc *BLOBCacheService) Upload(ref *BLOBCacheReference) (putersdk.Upload, error) {
+	return putersdk.FileUpload(svc.Filesystem, filepath.Join(
+		svc.ConfigService.GetString("cacheDir"),
+		ref.GetHash(),
+	))
+}
+
 func (svc *BLOBCacheService) Hold(
 	hash string,
 ) *BLOBCacheReference {
@@ -227,7 +305,7 @@ func (svc *BLOBCacheService) Hold(
 func (svc *BLOBCacheService) storeFile(
 	hash string,
 	reader io.Reader,
-) error {
+) (int64, error) {
 	path := filepath.Join(
 		svc.ConfigService.GetString("cacheDir"),
 		hash,
@@ -235,12 +313,11 @@ func (svc *BLOBCacheService) storeFile(
 
 	file, err := svc.Filesystem.Create(path)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	defer file.Close()
-	_, err = io.Copy(file, reader)
-	return err
+	return io.Copy(file, reader)
 }
 
 func (svc *BLOBCacheService) deleteFile(
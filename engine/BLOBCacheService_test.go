@@ -21,6 +21,7 @@ package engine
 import (
 	"bytes"
 	"fmt"
+	"strconv"
 	"testing"
 
 	"github.com/spf13/afero"
@@ -34,6 +35,11 @@ func (c *MockConfig) GetString(key string) string {
 	return c.params[key]
 }
 
+func (c *MockConfig) GetInt(key string) int {
+	n, _ := strconv.Atoi(c.params[key])
+	return n
+}
+
 func TestBLOBCacheService(t *testing.T) {
 	memfs := afero.NewMemMapFs()
 	config := &MockConfig{
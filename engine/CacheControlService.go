@@ -0,0 +1,144 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package engine
+
+import (
+	"strings"
+
+	"github.com/HeyPuter/puter-fuse/services"
+)
+
+// CacheControlService inspects and invalidates the caches of one mount.
+type CacheControlService struct {
+	associationService *AssociationService
+	virtualTreeService *VirtualTreeService
+	blobCacheService   *BLOBCacheService
+	writeCacheService  *WriteCacheService
+}
+
+func CreateCacheControlService() *CacheControlService {
+	return &CacheControlService{}
+}
+
+func (svc *CacheControlService) Init(services services.IServiceContainer) {
+	svc.associationService = services.Get("association").(*AssociationService)
+	svc.virtualTreeService = services.Get("virtual-tree").(*VirtualTreeService)
+	svc.blobCacheService = services.Get("blob-cache").(*BLOBCacheService)
+	svc.writeCacheService = services.Get("write-cache").(*WriteCacheService)
+}
+
+func pathMatches(path, target string, recursive bool) bool {
+	if path == target {
+		return true
+	}
+	if !recursive {
+		return false
+	}
+	return target == "/" || strings.HasPrefix(path, target+"/")
+}
+
+// Forgets cached metadata and contents for 'path', and everything under
+// it if 'recursive' is set, so they're fetched again on next access.
+// Files with writes that haven't been acknowledged keep their cached
+// contents. Returns the number of paths invalidated.
+func (svc *CacheControlService) Invalidate(path string, recursive bool) int {
+	pending := svc.writeCacheService.PendingMutations()
+
+	// Paths stay associated with their local UIDs since the tree cache
+	// relies on parents being known.
+	invalidated := map[string]bool{}
+	for _, p := range svc.associationService.PathToLocalUID.Keys() {
+		if !pathMatches(p, path, recursive) {
+			continue
+		}
+		localUID, exists := svc.associationService.PathToLocalUID.Get(p)
+		if !exists {
+			continue
+		}
+		svc.associationService.LocalUIDToNodeInfo.Del(localUID)
+		svc.virtualTreeService.ExpireReaddir(localUID)
+		CacheEvictions.With("tree").Inc()
+		invalidated[p] = true
+	}
+
+	for _, p := range svc.associationService.PathToBaseHash.Keys() {
+		if !pathMatches(p, path, recursive) {
+			continue
+		}
+		localUID, _ := svc.associationService.PathToLocalUID.Get(p)
+		if pending[localUID] > 0 {
+			continue
+		}
+		svc.associationService.PathToBaseHash.Del(p)
+		invalidated[p] = true
+	}
+
+	if pathMatches("/", path, recursive) {
+		svc.virtualTreeService.ExpireReaddir(ROOT_UUID)
+	}
+
+	return len(invalidated)
+}
+
+// Forgets everything cached which can be fetched again.
+func (svc *CacheControlService) DropAll() int {
+	return svc.Invalidate("/", true)
+}
+
+// Returns the number of unacknowledged operations for each file with
+// pending writes, by path.
+func (svc *CacheControlService) PendingMutations() map[string]int {
+	byLocalUID := svc.writeCacheService.PendingMutations()
+
+	byPath := map[string]int{}
+	if len(byLocalUID) == 0 {
+		return byPath
+	}
+	for _, path := range svc.associationService.PathToLocalUID.Keys() {
+		localUID, _ := svc.associationService.PathToLocalUID.Get(path)
+		if count, exists := byLocalUID[localUID]; exists {
+			byPath[path] = count
+		}
+	}
+	return byPath
+}
+
+type TreeCacheStats struct {
+	Directories int `json:"directories"`
+	Nodes       int `json:"nodes"`
+	Paths       int `json:"paths"`
+}
+
+type CacheStats struct {
+	Tree  TreeCacheStats  `json:"tree"`
+	Blob  BLOBCacheStats  `json:"blob"`
+	Write WriteCacheStats `json:"write"`
+}
+
+func (svc *CacheControlService) Stats() CacheStats {
+	return CacheStats{
+		Tree: TreeCacheStats{
+			Directories: len(svc.virtualTreeService.Directories.Keys()),
+			Nodes:       svc.associationService.LocalUIDToNodeInfo.Len(),
+			Paths:       len(svc.associationService.PathToLocalUID.Keys()),
+		},
+		Blob:  svc.blobCacheService.Stats(),
+		Write: svc.writeCacheService.Stats(),
+	}
+}
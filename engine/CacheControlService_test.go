@@ -0,0 +1,114 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package engine
+
+import (
+	"testing"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/putersdk"
+)
+
+func createTestCacheControlService() (*CacheControlService, *AssociationService) {
+	associationService := CreateAssociationService()
+	virtualTreeService := CreateVirtualTreeService()
+	virtualTreeService.Directories.Set(ROOT_UUID, CreateVirtualDirectoryEntry())
+	writeCacheService := createTestWriteCacheService("0")
+
+	svc := CreateCacheControlService()
+	svc.associationService = associationService
+	svc.virtualTreeService = virtualTreeService
+	svc.blobCacheService = writeCacheService.BLOBCacheService
+	svc.writeCacheService = writeCacheService
+
+	// /a, /a/b, /a/b/c and /ab, all with cached metadata and contents
+	for _, path := range []string{"/a", "/a/b", "/a/b/c", "/ab"} {
+		localUID := "uid:" + path
+		associationService.PathToLocalUID.Set(path, localUID)
+		associationService.PathToBaseHash.Set(path, "hash:"+path)
+		associationService.LocalUIDToNodeInfo.Set(localUID, fao.NodeInfo{
+			CloudItem: putersdk.CloudItem{LocalUID: localUID},
+		}, time.Hour)
+	}
+
+	return svc, associationService
+}
+
+func TestCacheControlServiceInvalidate(t *testing.T) {
+	testCases := []struct {
+		name        string
+		path        string
+		recursive   bool
+		invalidated []string
+	}{
+		{"single path", "/a", false, []string{"/a"}},
+		{"subtree", "/a", true, []string{"/a", "/a/b", "/a/b/c"}},
+		{"everything", "/", true, []string{"/a", "/a/b", "/a/b/c", "/ab"}},
+		{"unknown path", "/nope", true, []string{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			svc, associationService := createTestCacheControlService()
+
+			n := svc.Invalidate(tc.path, tc.recursive)
+			if n != len(tc.invalidated) {
+				t.Fatalf("expected %d paths invalidated; got %d", len(tc.invalidated), n)
+			}
+
+			expected := map[string]bool{}
+			for _, path := range tc.invalidated {
+				expected[path] = true
+			}
+			for _, path := range []string{"/a", "/a/b", "/a/b/c", "/ab"} {
+				_, hasContents := associationService.PathToBaseHash.Get(path)
+				hasNodeInfo := associationService.LocalUIDToNodeInfo.Get("uid:"+path) != nil
+				if hasContents == expected[path] || hasNodeInfo == expected[path] {
+					t.Fatalf("%s: expected invalidated=%v", path, expected[path])
+				}
+				if !associationService.PathToLocalUID.Has(path) {
+					t.Fatalf("%s: path association was removed", path)
+				}
+			}
+		})
+	}
+}
+
+func TestCacheControlServiceKeepsPendingWrites(t *testing.T) {
+	svc, associationService := createTestCacheControlService()
+
+	ref := svc.writeCacheService.ApplyMutation("uid:/a/b", &WriteMutation{
+		Data: []byte("data"),
+	})
+
+	svc.DropAll()
+
+	if _, exists := associationService.PathToBaseHash.Get("/a/b"); !exists {
+		t.Fatalf("contents of a file with pending writes were dropped")
+	}
+	if pending := svc.PendingMutations(); pending["/a/b"] != 1 {
+		t.Fatalf("expected one pending mutation for /a/b; got %v", pending)
+	}
+
+	ref.Release()
+	if pending := svc.PendingMutations(); len(pending) != 0 {
+		t.Fatalf("expected no pending mutations; got %v", pending)
+	}
+}
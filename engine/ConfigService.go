@@ -28,6 +28,7 @@ import (
 // of viper are being used, in case we ever swap it out.
 type IConfig interface {
 	GetString(key string) string
+	GetInt(key string) int
 }
 
 type ConfigService struct {
@@ -35,8 +36,11 @@ type ConfigService struct {
 }
 
 func (svc *ConfigService) Init(services services.IServiceContainer) {
-	// store viper here so we can use a de-coupled interface
-	svc.IConfig = viper.GetViper()
+	// store viper here so we can use a de-coupled interface; a profile's
+	// configuration may be provided instead
+	if svc.IConfig == nil {
+		svc.IConfig = viper.GetViper()
+	}
 
 	// TODO: config is loaded in main.go right now for simplicity,
 	// but it should be loaded here instead.
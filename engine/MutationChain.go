@@ -0,0 +1,468 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package engine
+
+import (
+	"io"
+	"sync"
+
+	"github.com/HeyPuter/puter-fuse/streamutil"
+)
+
+// MutationEntry wraps a mutation in the chain with a count of delegate
+// operations which have not yet been acknowledged. Several references
+// can point to one entry when writes are merged.
+type MutationEntry struct {
+	Mutation
+	pending int
+	// set when the delegate rejected any operation for this entry
+	failed bool
+}
+
+// MutationReference is held by whoever is delegating a mutation; it
+// must be released once the delegate has acknowledged the mutation.
+type MutationReference struct {
+	chain    *MutationChain
+	entry    *MutationEntry
+	released bool
+}
+
+func (ref *MutationReference) Release() {
+	ref.chain.lock.Lock()
+	defer ref.chain.lock.Unlock()
+
+	if ref.released {
+		return
+	}
+	ref.released = true
+	ref.entry.pending--
+	if ref.chain.dead && ref.entry.pending == 0 {
+		ref.chain.releaseMutation(ref.entry.Mutation)
+	}
+	ref.chain.getCond().Broadcast()
+}
+
+// Fail is called instead of Release when the delegate rejected the
+// mutation. The error is kept on the chain until it's reported.
+func (ref *MutationReference) Fail(err error) {
+	ref.chain.lock.Lock()
+	defer ref.chain.lock.Unlock()
+
+	if ref.released {
+		return
+	}
+	ref.released = true
+	ref.entry.pending--
+	ref.entry.failed = true
+	if ref.chain.dead && ref.entry.pending == 0 {
+		ref.chain.releaseMutation(ref.entry.Mutation)
+	}
+	if ref.chain.err == nil {
+		ref.chain.err = err
+	}
+	ref.chain.getCond().Broadcast()
+}
+
+// MutationChain is the "branch" described in the devlog: a base blob
+// (held in Releasables) and the mutations performed on top of it.
+type MutationChain struct {
+	Releasables []Releasable
+	Mutations   []interface{}
+
+	// bytes of write data held in memory by this chain
+	memSize int64
+	// set once the chain is removed from WriteCacheService
+	dead bool
+	// first error reported by the delegate which hasn't been taken yet
+	err error
+	// set while the chain is spilled or rebased outside the lock
+	spilling bool
+	rebasing bool
+
+	lock sync.RWMutex
+	cond *sync.Cond
+}
+
+// The caller must hold the write lock.
+func (chain *MutationChain) getCond() *sync.Cond {
+	if chain.cond == nil {
+		chain.cond = sync.NewCond(&chain.lock)
+	}
+	return chain.cond
+}
+
+// The caller must hold the lock.
+func (chain *MutationChain) hasPending() bool {
+	for _, item := range chain.Mutations {
+		if entry, isEntry := item.(*MutationEntry); isEntry && entry.pending > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if any mutation is waiting on the delegate.
+func (chain *MutationChain) Pending() bool {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	return chain.hasPending()
+}
+
+// Returns the number of delegate operations not yet acknowledged.
+func (chain *MutationChain) PendingCount() int {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	count := 0
+	for _, item := range chain.Mutations {
+		if entry, isEntry := item.(*MutationEntry); isEntry {
+			count += entry.pending
+		}
+	}
+	return count
+}
+
+// Returns the bytes of write data the chain holds in memory.
+func (chain *MutationChain) MemSize() int64 {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	return chain.memSize
+}
+
+// Blocks until every mutation in the chain has been acknowledged or
+// rejected by the delegate, then returns and clears the chain's error.
+func (chain *MutationChain) Await() error {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	for chain.hasPending() {
+		chain.getCond().Wait()
+	}
+
+	err := chain.err
+	chain.err = nil
+	return err
+}
+
+// Returns and clears the chain's error without waiting.
+func (chain *MutationChain) TakeError() error {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	err := chain.err
+	chain.err = nil
+	return err
+}
+
+func unwrapMutation(item interface{}) (Mutation, bool) {
+	switch item := item.(type) {
+	case *MutationEntry:
+		return item.Mutation, true
+	case Mutation:
+		return item, true
+	}
+	return nil, false
+}
+
+func (chain *MutationChain) ApplyToBuffer(buffer []byte, offset int64) {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	for _, mut := range chain.Mutations {
+		switch mut := mut.(type) {
+		case Mutation:
+			mut.ApplyToBuffer(buffer, offset)
+		}
+	}
+}
+
+// Returns the size of the file after every mutation in the chain is
+// applied to a file of size 'baseSize'.
+func (chain *MutationChain) Size(baseSize uint64) uint64 {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	size := baseSize
+	for _, mut := range chain.Mutations {
+		switch mut := mut.(type) {
+		case Mutation:
+			size = mut.ApplyToSize(size)
+		}
+	}
+	return size
+}
+
+// Returns true if the most recent mutation in the chain removed the file.
+func (chain *MutationChain) IsRemoved() bool {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	if len(chain.Mutations) == 0 {
+		return false
+	}
+	mut, _ := unwrapMutation(chain.Mutations[len(chain.Mutations)-1])
+	_, removed := mut.(*RemoveMutation)
+	return removed
+}
+
+// Returns a reader which will emit the contents of inStream with every
+// mutation in the chain applied. 'baseSize' must be the size of inStream.
+func (chain *MutationChain) Apply(inStream io.ReadCloser, baseSize uint64) (io.ReadCloser, error) {
+	chain.lock.RLock()
+	defer chain.lock.RUnlock()
+
+	return applyMutations(chain.Mutations, inStream, baseSize)
+}
+
+func applyMutations(
+	items []interface{}, inStream io.ReadCloser, baseSize uint64,
+) (io.ReadCloser, error) {
+	stream := inStream
+	size := baseSize
+	for _, item := range items {
+		mut, ok := unwrapMutation(item)
+		if !ok {
+			continue
+		}
+
+		// ReplaceReader can't write past the end of its source, so the
+		// stream is zero-padded first when a mutation extends the file.
+		newSize := mut.ApplyToSize(size)
+		if newSize > size {
+			stream = streamutil.NewTruncateReader(stream, newSize)
+		}
+
+		var err error
+		stream, err = mut.Apply(stream)
+		if err != nil {
+			return nil, err
+		}
+		size = newSize
+	}
+
+	return stream, nil
+}
+
+// Adds a mutation to the end of the chain. A write which overlaps or is
+// adjacent to the previous write is merged into it, so long as that write
+// hasn't been acknowledged yet. Returns nil if the chain is dead.
+func (chain *MutationChain) Append(mut Mutation) *MutationReference {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	if chain.dead {
+		return nil
+	}
+
+	if n := len(chain.Mutations); n > 0 {
+		last, isEntry := chain.Mutations[n-1].(*MutationEntry)
+		if isEntry && last.pending > 0 {
+			lastWrite, lastIsWrite := last.Mutation.(*WriteMutation)
+			write, isWrite := mut.(*WriteMutation)
+			if lastIsWrite && isWrite && lastWrite.Touches(write) {
+				merged := lastWrite.Merge(write)
+				chain.memSize += int64(len(merged.Data) - len(lastWrite.Data))
+				last.Mutation = merged
+				last.pending++
+				return &MutationReference{chain: chain, entry: last}
+			}
+		}
+	}
+
+	if write, isWrite := mut.(*WriteMutation); isWrite {
+		chain.memSize += int64(len(write.Data))
+	}
+
+	entry := &MutationEntry{Mutation: mut, pending: 1}
+	chain.Mutations = append(chain.Mutations, entry)
+	return &MutationReference{chain: chain, entry: entry}
+}
+
+// Returns the number of mutations at the start of the chain which have
+// been accepted by the delegate. The caller must hold the lock.
+func (chain *MutationChain) acknowledgedPrefix() int {
+	for i, item := range chain.Mutations {
+		entry, isEntry := item.(*MutationEntry)
+		if isEntry && (entry.pending > 0 || entry.failed) {
+			return i
+		}
+	}
+	return len(chain.Mutations)
+}
+
+// RetriedMutation is a mutation to delegate again; 'Ref' must be
+// settled once the delegate has acknowledged or rejected it.
+type RetriedMutation struct {
+	Mutation Mutation
+	Ref      *MutationReference
+}
+
+// Marks every mutation from the first one the delegate rejected onwards
+// as pending again, and returns them to be delegated again in order.
+// The later ones are included since the delegate applied them before
+// the mutations they follow. Returns nil if none were rejected.
+func (chain *MutationChain) Retry() []RetriedMutation {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	first := -1
+	for i, item := range chain.Mutations {
+		if entry, isEntry := item.(*MutationEntry); isEntry && entry.failed {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+
+	retried := []RetriedMutation{}
+	for _, item := range chain.Mutations[first:] {
+		entry, isEntry := item.(*MutationEntry)
+		if !isEntry {
+			continue
+		}
+		entry.failed = false
+		entry.pending++
+		retried = append(retried, RetriedMutation{
+			Mutation: entry.Mutation,
+			Ref:      &MutationReference{chain: chain, entry: entry},
+		})
+	}
+	return retried
+}
+
+// Drops the mutations the delegate rejected and the error it reported.
+func (chain *MutationChain) Discard() {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	kept := []interface{}{}
+	for _, item := range chain.Mutations {
+		entry, isEntry := item.(*MutationEntry)
+		if !isEntry || !entry.failed || entry.pending > 0 {
+			kept = append(kept, item)
+			continue
+		}
+		if releasable, ok := entry.Mutation.(Releasable); ok {
+			releasable.Release()
+		}
+		if write, ok := entry.Mutation.(*WriteMutation); ok {
+			chain.memSize -= int64(len(write.Data))
+		}
+	}
+	chain.Mutations = kept
+	chain.err = nil
+}
+
+// Marks the chain dead and releases its base and every mutation which
+// isn't waiting on the delegate; the rest are released once they're
+// settled.
+func (chain *MutationChain) remove() {
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	chain.dead = true
+	for _, releasable := range chain.Releasables {
+		releasable.Release()
+	}
+	chain.Releasables = nil
+	for _, item := range chain.Mutations {
+		entry, isEntry := item.(*MutationEntry)
+		if isEntry && entry.pending > 0 {
+			continue
+		}
+		if mut, ok := unwrapMutation(item); ok {
+			chain.releaseMutation(mut)
+		}
+	}
+	chain.err = nil
+	chain.getCond().Broadcast()
+}
+
+// The caller must hold the write lock.
+func (chain *MutationChain) releaseMutation(mut Mutation) {
+	if releasable, ok := mut.(Releasable); ok {
+		releasable.Release()
+	}
+	if write, ok := mut.(*WriteMutation); ok {
+		chain.memSize -= int64(len(write.Data))
+	}
+}
+
+// Returns the entries in the chain. The caller must hold the lock.
+func (chain *MutationChain) entries() map[*MutationEntry]bool {
+	entries := map[*MutationEntry]bool{}
+	for _, item := range chain.Mutations {
+		if entry, isEntry := item.(*MutationEntry); isEntry {
+			entries[entry] = true
+		}
+	}
+	return entries
+}
+
+// Moves every in-memory write in the chain to the BLOB cache if it holds
+// more than 'spillSize' bytes of them. The writes are stored without the
+// lock held, then swapped in if they weren't merged into or dropped
+// meanwhile.
+func (chain *MutationChain) spill(blobCacheService *BLOBCacheService, spillSize int64) {
+	type candidate struct {
+		entry *MutationEntry
+		write *WriteMutation
+	}
+
+	chain.lock.Lock()
+	if chain.spilling || chain.memSize <= spillSize {
+		chain.lock.Unlock()
+		return
+	}
+	chain.spilling = true
+	candidates := []candidate{}
+	for _, item := range chain.Mutations {
+		entry, isEntry := item.(*MutationEntry)
+		if !isEntry {
+			continue
+		}
+		if write, isWrite := entry.Mutation.(*WriteMutation); isWrite {
+			candidates = append(candidates, candidate{entry, write})
+		}
+	}
+	chain.lock.Unlock()
+
+	spilled := make([]*SpilledWriteMutation, len(candidates))
+	for i, c := range candidates {
+		spilled[i] = CreateSpilledWriteMutation(blobCacheService, c.write)
+	}
+
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+
+	chain.spilling = false
+	entries := chain.entries()
+	for i, c := range candidates {
+		if c.entry.Mutation != c.write || !entries[c.entry] {
+			spilled[i].Release()
+			continue
+		}
+		c.entry.Mutation = spilled[i]
+		chain.memSize -= int64(len(c.write.Data))
+	}
+}
@@ -0,0 +1,374 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func createTestWriteCacheService(spillSize string) *WriteCacheService {
+	config := &MockConfig{
+		params: map[string]string{
+			"cacheDir":            "/",
+			"writeCacheSpillSize": spillSize,
+		},
+	}
+
+	blobCacheService := CreateBLOBCacheService(afero.NewMemMapFs())
+	blobCacheService.ConfigService = config
+
+	svc := CreateWriteCacheService()
+	svc.ConfigService = config
+	svc.BLOBCacheService = blobCacheService
+	return svc
+}
+
+func readChain(svc *WriteCacheService, localUID string, base []byte) []byte {
+	size, _ := svc.GetSize(localUID, uint64(len(base)))
+	buffer := make([]byte, size)
+	copy(buffer, base)
+	svc.ApplyToBuffer(localUID, buffer, 0)
+	return buffer
+}
+
+func TestMutationChainCompaction(t *testing.T) {
+	t.Run("sequential writes are merged", func(t *testing.T) {
+		svc := createTestWriteCacheService("0")
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0})
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("CD"), Offset: 2})
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("X"), Offset: 1})
+
+		chain, _ := svc.CachedOperations.Get("a")
+		if len(chain.Mutations) != 1 {
+			t.Errorf("expected 1 mutation, got %d", len(chain.Mutations))
+		}
+		if out := readChain(svc, "a", nil); string(out) != "AXCD" {
+			t.Errorf("expected 'AXCD', got '%s'", out)
+		}
+	})
+
+	t.Run("disjoint writes are not merged", func(t *testing.T) {
+		svc := createTestWriteCacheService("0")
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0})
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("CD"), Offset: 3})
+
+		chain, _ := svc.CachedOperations.Get("a")
+		if len(chain.Mutations) != 2 {
+			t.Errorf("expected 2 mutations, got %d", len(chain.Mutations))
+		}
+	})
+
+	t.Run("writes are not merged into acknowledged writes", func(t *testing.T) {
+		svc := createTestWriteCacheService("0")
+		ref := svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0})
+		ref.Release()
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("CD"), Offset: 2})
+
+		chain, _ := svc.CachedOperations.Get("a")
+		if len(chain.Mutations) != 2 {
+			t.Errorf("expected 2 mutations, got %d", len(chain.Mutations))
+		}
+	})
+}
+
+func TestMutationChainRebase(t *testing.T) {
+	t.Run("acknowledged prefix is folded into base", func(t *testing.T) {
+		svc := createTestWriteCacheService("0")
+		baseRef := svc.BLOBCacheService.Store(bytes.NewReader([]byte("0123456789")))
+
+		ref1 := svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0})
+		svc.ApplyMutation("a", &TruncateMutation{Size: 4})
+		ref1.Release()
+
+		var newHash string
+		err := svc.Rebase("a", baseRef.GetHash(), func(hash string) {
+			newHash = hash
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		chain, _ := svc.CachedOperations.Get("a")
+		if len(chain.Mutations) != 1 {
+			t.Errorf("expected 1 pending mutation, got %d", len(chain.Mutations))
+		}
+
+		size, _ := svc.BLOBCacheService.GetSize(newHash)
+		data, _ := io.ReadAll(svc.BLOBCacheService.Get(newHash, 0, size))
+		if string(data) != "AB23456789" {
+			t.Errorf("expected 'AB23456789', got '%s'", data)
+		}
+	})
+
+	t.Run("fully acknowledged chain without base is removed", func(t *testing.T) {
+		svc := createTestWriteCacheService("0")
+		ref := svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0})
+		ref.Release()
+
+		svc.Rebase("a", "", func(string) {
+			t.Errorf("commit should not be called without a base")
+		})
+
+		if svc.CachedOperations.Has("a") {
+			t.Errorf("expected chain to be removed")
+		}
+
+		// a new mutation must not be lost to the removed chain
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("CD"), Offset: 0})
+		if out := readChain(svc, "a", nil); string(out) != "CD" {
+			t.Errorf("expected 'CD', got '%s'", out)
+		}
+	})
+}
+
+// A write which counts how often it's released.
+type releaseCountingMutation struct {
+	*WriteMutation
+	released int
+}
+
+func (mut *releaseCountingMutation) Release() {
+	mut.released++
+}
+
+func TestMutationChainRemove(t *testing.T) {
+	svc := createTestWriteCacheService("0")
+	failed := &releaseCountingMutation{WriteMutation: &WriteMutation{Data: []byte("AB"), Offset: 0}}
+	pending := &releaseCountingMutation{WriteMutation: &WriteMutation{Data: []byte("CD"), Offset: 4}}
+	svc.ApplyMutation("a", failed).Fail(errors.New("upload failed"))
+	ref := svc.ApplyMutation("a", pending)
+
+	svc.Remove("a")
+
+	if svc.CachedOperations.Has("a") {
+		t.Errorf("expected chain to be removed")
+	}
+	if failed.released != 1 {
+		t.Errorf("expected settled mutation to be released once, got %d", failed.released)
+	}
+	if pending.released != 0 {
+		t.Errorf("expected pending mutation to be kept until it's settled")
+	}
+
+	ref.Release()
+	if pending.released != 1 {
+		t.Errorf("expected pending mutation to be released once settled, got %d", pending.released)
+	}
+	if err := svc.TakeError("a"); err != nil {
+		t.Errorf("expected no error for a removed file, got %v", err)
+	}
+}
+
+func TestMutationChainSpill(t *testing.T) {
+	svc := createTestWriteCacheService("4")
+	svc.ApplyMutation("a", &WriteMutation{Data: []byte("ABC"), Offset: 0})
+	svc.ApplyMutation("a", &WriteMutation{Data: []byte("XYZ"), Offset: 6})
+
+	chain, _ := svc.CachedOperations.Get("a")
+	if chain.memSize != 0 {
+		t.Errorf("expected chain to be spilled, %d bytes in memory", chain.memSize)
+	}
+	for _, item := range chain.Mutations {
+		mut, _ := unwrapMutation(item)
+		if _, ok := mut.(*SpilledWriteMutation); !ok {
+			t.Errorf("expected spilled write, got %T", mut)
+		}
+	}
+
+	if out := readChain(svc, "a", []byte("0123456789")); string(out) != "ABC345XYZ9" {
+		t.Errorf("expected 'ABC345XYZ9', got '%s'", out)
+	}
+}
+
+func TestMutationChainAwait(t *testing.T) {
+	t.Run("await blocks until mutations are acknowledged", func(t *testing.T) {
+		svc := createTestWriteCacheService("0")
+		ref := svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0})
+
+		done := make(chan error)
+		go func() {
+			done <- svc.Await("a")
+		}()
+
+		select {
+		case <-done:
+			t.Fatalf("await returned before the mutation was acknowledged")
+		case <-time.After(20 * time.Millisecond):
+		}
+
+		ref.Release()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("failed mutation is reported once", func(t *testing.T) {
+		svc := createTestWriteCacheService("0")
+		ref := svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0})
+		ref.Fail(errors.New("upload failed"))
+		svc.Rebase("a", "", func(string) {})
+
+		if err := svc.Await("a"); err == nil {
+			t.Errorf("expected an error")
+		}
+		if err := svc.TakeError("a"); err != nil {
+			t.Errorf("expected the error to be cleared, got %v", err)
+		}
+	})
+}
+
+func TestMutationChainRetry(t *testing.T) {
+	t.Run("failed mutations stay applied", func(t *testing.T) {
+		svc := createTestWriteCacheService("0")
+		baseRef := svc.BLOBCacheService.Store(bytes.NewReader([]byte("0123")))
+		ref1 := svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0})
+		ref1.Fail(errors.New("upload failed"))
+		ref2 := svc.ApplyMutation("a", &TruncateMutation{Size: 3})
+		ref2.Release()
+
+		svc.Rebase("a", baseRef.GetHash(), func(string) {
+			t.Errorf("nothing should be folded past a failed mutation")
+		})
+
+		if out := readChain(svc, "a", []byte("0123")); string(out) != "AB2" {
+			t.Errorf("expected 'AB2', got '%s'", out)
+		}
+	})
+
+	t.Run("retry resends from the first failed mutation", func(t *testing.T) {
+		svc := createTestWriteCacheService("0")
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0}).Release()
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("CD"), Offset: 4}).Fail(errors.New("upload failed"))
+		svc.ApplyMutation("a", &TruncateMutation{Size: 5}).Release()
+		svc.TakeError("a")
+
+		retried := svc.Retry("a")
+		if len(retried) != 2 {
+			t.Fatalf("expected 2 mutations to be retried, got %d", len(retried))
+		}
+		if _, ok := retried[1].Mutation.(*TruncateMutation); !ok {
+			t.Errorf("expected the truncate to be retried after the write, got %T", retried[1].Mutation)
+		}
+		if svc.Retry("a") != nil {
+			t.Errorf("expected mutations being retried not to be retried again")
+		}
+
+		for _, item := range retried {
+			item.Ref.Release()
+		}
+		svc.Rebase("a", "", func(string) {})
+		if svc.CachedOperations.Has("a") {
+			t.Errorf("expected the chain to be removed once the retry succeeded")
+		}
+	})
+
+	t.Run("discard drops failed mutations", func(t *testing.T) {
+		svc := createTestWriteCacheService("0")
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0}).Fail(errors.New("upload failed"))
+
+		svc.Discard("a")
+
+		if err := svc.TakeError("a"); err != nil {
+			t.Errorf("expected the error to be discarded, got %v", err)
+		}
+		if out := readChain(svc, "a", []byte("0123")); string(out) != "0123" {
+			t.Errorf("expected '0123', got '%s'", out)
+		}
+	})
+}
+
+// Blocks the first file created until 'release' is closed.
+type blockingFs struct {
+	afero.Fs
+	blocked chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (fs *blockingFs) Create(name string) (afero.File, error) {
+	fs.once.Do(func() {
+		close(fs.blocked)
+		<-fs.release
+	})
+	return fs.Fs.Create(name)
+}
+
+func TestMutationChainIOOutsideLock(t *testing.T) {
+	create := func() (*WriteCacheService, *blockingFs) {
+		svc := createTestWriteCacheService("4")
+		fs := &blockingFs{
+			Fs:      afero.NewMemMapFs(),
+			blocked: make(chan struct{}),
+			release: make(chan struct{}),
+		}
+		svc.BLOBCacheService.Filesystem = fs
+		return svc, fs
+	}
+
+	// Fails if reading the chain waits for the blocked store.
+	expectReadable := func(t *testing.T, svc *WriteCacheService, expected string) {
+		done := make(chan []byte)
+		go func() {
+			done <- readChain(svc, "a", []byte("0123456789"))
+		}()
+		select {
+		case out := <-done:
+			if string(out) != expected {
+				t.Errorf("expected '%s', got '%s'", expected, out)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("reading the chain waited for the BLOB cache")
+		}
+	}
+
+	t.Run("spill", func(t *testing.T) {
+		svc, fs := create()
+		go svc.ApplyMutation("a", &WriteMutation{Data: []byte("ABCDE"), Offset: 0})
+		<-fs.blocked
+
+		expectReadable(t, svc, "ABCDE56789")
+		close(fs.release)
+	})
+
+	t.Run("rebase", func(t *testing.T) {
+		svc, fs := create()
+		close(fs.release)
+		baseRef := svc.BLOBCacheService.Store(bytes.NewReader([]byte("0123456789")))
+		fs.once, fs.blocked, fs.release = sync.Once{}, make(chan struct{}), make(chan struct{})
+
+		svc.ApplyMutation("a", &WriteMutation{Data: []byte("AB"), Offset: 0}).Release()
+		rebased := make(chan struct{})
+		go func() {
+			svc.Rebase("a", baseRef.GetHash(), func(string) {})
+			close(rebased)
+		}()
+		<-fs.blocked
+
+		expectReadable(t, svc, "AB23456789")
+		close(fs.release)
+		<-rebased
+	})
+}
@@ -19,14 +19,24 @@
 package engine
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/HeyPuter/puter-fuse/putersdk"
 	"github.com/HeyPuter/puter-fuse/services"
+	"github.com/HeyPuter/puter-fuse/trace"
 	"github.com/google/uuid"
 )
 
+var operationLog = logger.S("operation")
+
 type OperationResponse struct {
 	Data map[string]interface{}
 }
@@ -34,7 +44,29 @@ type OperationResponse struct {
 type OperationRequest struct {
 	Operation putersdk.Operation
 	Resolve   chan<- OperationResponse
-	blob      []byte
+	upload    *putersdk.Upload
+
+	// set once the request is handed to send, after which it's only
+	// resolved by the batch
+	sent atomic.Bool
+	// called once the request is resolved by the batch; may be nil
+	resolved func()
+
+	// span covers the whole operation; waitSpan ends when it's batched
+	span     *trace.Span
+	waitSpan *trace.Span
+}
+
+// Returns the contents the operation writes, or nil.
+func (req *OperationRequest) Upload() *putersdk.Upload {
+	return req.upload
+}
+
+func (req *OperationRequest) resolve(data map[string]interface{}) {
+	req.Resolve <- OperationResponse{Data: data}
+	if req.resolved != nil {
+		req.resolved()
+	}
 }
 
 type OperationRequestPromise struct {
@@ -46,44 +78,165 @@ type OperationService struct {
 	OperationRequestQueue chan *OperationRequest
 	QueueReadyQueue       chan struct{}
 
+	// A read-only mount sends no operations, so no batches are sent
+	// and operations are refused.
+	ReadOnly bool
+
+	// how long a request may wait to be sent before it fails; 20s if
+	// unset
+	timeout time.Duration
+
+	// requests which haven't been resolved yet
+	pending     map[string]*OperationRequest
+	pendingLock sync.Mutex
+
+	batchQueue      chan *OperationRequest
+	inFlightBatches atomic.Int32
+	// when a batch last finished sending, in Unix nanoseconds
+	lastSent atomic.Int64
+
+	// while paused, batches are held rather than sent
+	paused    bool
+	resumed   chan struct{}
+	pauseLock sync.Mutex
+
 	services services.IServiceContainer
 }
 
 type I_Batcher_EnqueueOperationRequest interface {
 	EnqueueOperationRequest(
+		ctx context.Context,
 		operation putersdk.Operation,
 		blob []byte,
 	) OperationRequestPromise
 }
 
+type I_Batcher_EnqueueUpload interface {
+	EnqueueUpload(
+		ctx context.Context,
+		operation putersdk.Operation,
+		upload putersdk.Upload,
+		resolved func(),
+	) OperationRequestPromise
+}
+
 func (svc_op *OperationService) EnqueueOperationRequest(
+	ctx context.Context,
 	operation putersdk.Operation,
 	blob []byte,
 ) OperationRequestPromise {
-	resolve := make(chan OperationResponse)
+	if blob == nil {
+		return svc_op.enqueue(ctx, operation, nil, nil)
+	}
+	upload := putersdk.BytesUpload(blob)
+	return svc_op.enqueue(ctx, operation, &upload, nil)
+}
+
+// Enqueues an operation whose contents are streamed from 'upload' when
+// it's sent, rather than held in memory. The upload must stay readable
+// until 'resolved' is called, once the batch has resolved the operation;
+// that may be after the promise has timed out.
+func (svc_op *OperationService) EnqueueUpload(
+	ctx context.Context,
+	operation putersdk.Operation,
+	upload putersdk.Upload,
+	resolved func(),
+) OperationRequestPromise {
+	return svc_op.enqueue(ctx, operation, &upload, resolved)
+}
+
+func (svc_op *OperationService) enqueue(
+	ctx context.Context,
+	operation putersdk.Operation,
+	upload *putersdk.Upload,
+	resolved func(),
+) OperationRequestPromise {
+	if svc_op.ReadOnly {
+		if resolved != nil {
+			resolved()
+		}
+		refused := make(chan OperationResponse, 1)
+		refused <- OperationResponse{
+			Data: map[string]interface{}{
+				"error":   true,
+				"code":    "read_only",
+				"message": "the mount is read-only",
+			},
+		}
+		return OperationRequestPromise{Await: refused}
+	}
+
+	// buffered so a batch that completes after the timeout doesn't block
+	resolve := make(chan OperationResponse, 1)
 	await := make(chan OperationResponse)
-	svc_op.OperationRequestQueue <- &OperationRequest{
+	opName, _ := operation["op"].(string)
+	ctx, span := trace.Start(ctx, "operation."+opName)
+	_, waitSpan := trace.Start(ctx, "operation.wait")
+	req := &OperationRequest{
 		Operation: operation,
-		blob:      blob,
+		upload:    upload,
 		Resolve:   resolve,
+		resolved:  resolved,
+		span:      span,
+		waitSpan:  waitSpan,
 	}
+
+	// make a uuid for this timeout
+	uuid := uuid.New().String()
+
+	svc_op.pendingLock.Lock()
+	svc_op.pending[uuid] = req
+	svc_op.pendingLock.Unlock()
+
+	svc_op.OperationRequestQueue <- req
+	operationQueueDepth.With().Add(1)
 	go func() {
-		// make a uuid for this timeout
-		uuid := uuid.New().String()
+		defer func() {
+			svc_op.pendingLock.Lock()
+			delete(svc_op.pending, uuid)
+			svc_op.pendingLock.Unlock()
+		}()
+
 		// log operation so the debugger can find it
-		fmt.Printf("Operation: %s %s\n", uuid, operation)
-		select {
-		case res := <-resolve:
-			fmt.Printf("RESOLVED uuid: %s\n", uuid)
-			await <- res
-		case <-time.After(20 * time.Second):
-			// Print the uuid
-			fmt.Printf("TIMEOUT uuid: %s\n", uuid)
-			panic("oof time")
-			await <- OperationResponse{
-				Data: map[string]interface{}{
-					"error": "internal timeout",
-				},
+		log := operationLog.With("uuid", uuid)
+		log.With("operation", operation).Debug("enqueued")
+		for {
+			select {
+			case res := <-resolve:
+				log.Debug("resolved")
+				span.End()
+				await <- res
+				return
+			case <-time.After(svc_op.timeout):
+				// Once it's being sent, the batch resolves it; giving
+				// up would let its caller drop what it uploads.
+				if req.sent.Load() {
+					continue
+				}
+				// Behind a batch which is still being sent, however
+				// long a throttled or paused upload takes, it's only
+				// waiting its turn.
+				if svc_op.sending() {
+					continue
+				}
+				// Operations are held, not lost, while requests are
+				// paused for re-authentication or by the user.
+				if svc_op.SDK != nil && !svc_op.SDK.Authenticated() {
+					continue
+				}
+				if svc_op.Paused() {
+					continue
+				}
+				log.Warn("timed out")
+				waitSpan.End()
+				span.SetError(errors.New("internal timeout"))
+				span.End()
+				await <- OperationResponse{
+					Data: map[string]interface{}{
+						"error": "internal timeout",
+					},
+				}
+				return
 			}
 		}
 	}()
@@ -92,16 +245,123 @@ func (svc_op *OperationService) EnqueueOperationRequest(
 	}
 }
 
+// Returns whether a batch is being sent, or finished too recently for
+// the next to have been picked up.
+func (svc_op *OperationService) sending() bool {
+	if svc_op.inFlightBatches.Load() > 0 {
+		return true
+	}
+	return time.Since(time.Unix(0, svc_op.lastSent.Load())) < svc_op.timeout
+}
+
+// Returns requests which have been enqueued but not yet resolved.
+func (svc_op *OperationService) PendingRequests() []*OperationRequest {
+	svc_op.pendingLock.Lock()
+	defer svc_op.pendingLock.Unlock()
+
+	requests := make([]*OperationRequest, 0, len(svc_op.pending))
+	for _, req := range svc_op.pending {
+		requests = append(requests, req)
+	}
+	return requests
+}
+
+type OperationStats struct {
+	Queued          int  `json:"queued"`
+	InFlightBatches int  `json:"inFlightBatches"`
+	Pending         int  `json:"pending"`
+	Paused          bool `json:"paused"`
+}
+
+func (svc_op *OperationService) Stats() OperationStats {
+	svc_op.pendingLock.Lock()
+	pending := len(svc_op.pending)
+	svc_op.pendingLock.Unlock()
+
+	return OperationStats{
+		Queued:          len(svc_op.OperationRequestQueue) + len(svc_op.batchQueue),
+		InFlightBatches: int(svc_op.inFlightBatches.Load()),
+		Pending:         pending,
+		Paused:          svc_op.Paused(),
+	}
+}
+
+// Holds batches in the queue until Resume is called. A batch already
+// being sent is not interrupted.
+func (svc_op *OperationService) Pause() {
+	svc_op.pauseLock.Lock()
+	defer svc_op.pauseLock.Unlock()
+
+	if !svc_op.paused {
+		svc_op.paused = true
+		svc_op.resumed = make(chan struct{})
+	}
+}
+
+func (svc_op *OperationService) Resume() {
+	svc_op.pauseLock.Lock()
+	defer svc_op.pauseLock.Unlock()
+
+	if svc_op.paused {
+		svc_op.paused = false
+		close(svc_op.resumed)
+	}
+}
+
+func (svc_op *OperationService) Paused() bool {
+	svc_op.pauseLock.Lock()
+	defer svc_op.pauseLock.Unlock()
+
+	return svc_op.paused
+}
+
+func (svc_op *OperationService) awaitResumed() {
+	svc_op.pauseLock.Lock()
+	if !svc_op.paused {
+		svc_op.pauseLock.Unlock()
+		return
+	}
+	resumed := svc_op.resumed
+	svc_op.pauseLock.Unlock()
+
+	<-resumed
+}
+
+// Blocks until every enqueued request is resolved or 'ctx' is done.
+// Returns the requests which were still pending.
+func (svc_op *OperationService) Drain(ctx context.Context) []*OperationRequest {
+	for {
+		pending := svc_op.PendingRequests()
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return pending
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
 func (svc_op *OperationService) Init(services services.IServiceContainer) {
 	svc_op.services = services
+	svc_op.pending = map[string]*OperationRequest{}
+	if svc_op.timeout == 0 {
+		svc_op.timeout = 20 * time.Second
+	}
 
 	svc_op.OperationRequestQueue = make(chan *OperationRequest, 100)
 
 	batchQueue := make(chan *OperationRequest, 100)
+	svc_op.batchQueue = batchQueue
+
+	if svc_op.ReadOnly {
+		return
+	}
 
 	go func() {
 		for val := range svc_op.OperationRequestQueue {
-			fmt.Println("[GO] <== OP Req Queue -> Batch Queue")
 			batchQueue <- val
 			if len(batchQueue) == 100 {
 				svc_op.QueueReadyQueue <- struct{}{}
@@ -120,13 +380,9 @@ func (svc_op *OperationService) Init(services services.IServiceContainer) {
 				continue
 			}
 
-			fmt.Printf("len(batchQueue): %d\n", len(batchQueue))
-
-			operations := []putersdk.Operation{}
-			blobs := [][]byte{}
-			resolves := []chan<- OperationResponse{}
+			svc_op.awaitResumed()
 
-			fmt.Println("Umm")
+			requests := []*OperationRequest{}
 
 			MAX_BATCH := 100
 			amountToGet := min(MAX_BATCH, len(batchQueue))
@@ -135,18 +391,16 @@ func (svc_op *OperationService) Init(services services.IServiceContainer) {
 				var req *OperationRequest
 
 				req = <-batchQueue
+				operationQueueDepth.With().Add(-1)
 
 				if req == nil {
 					break
 				}
 
-				operations = append(operations, req.Operation)
-				resolves = append(resolves, req.Resolve)
-				if req.blob != nil {
-					blobs = append(blobs, req.blob)
-				}
+				req.waitSpan.End()
+				req.sent.Store(true)
+				requests = append(requests, req)
 			}
-			fmt.Println("Why?")
 
 			// The commented-out line below was a mistake!
 			// This was force of habit from dealing with queues
@@ -157,28 +411,169 @@ func (svc_op *OperationService) Init(services services.IServiceContainer) {
 
 			// batchQueue = make(chan *OperationRequest, 100)
 
-			// send the batch to the server
-			fmt.Println("BATCH")
-			batchResponse, err := svc_op.SDK.Batch(operations, blobs)
+			svc_op.send(requests)
+		}
+	}()
+}
 
-			if err != nil {
-				// TODO: batch error handling
-				fmt.Printf("error: %s\n", err)
-				continue
-			}
+// Sends queued requests to Puter and resolves them. Requests are
+// coalesced first; runs of operations which can be batched are sent
+// together, and the rest on their own, in the order they were queued.
+func (svc_op *OperationService) send(requests []*OperationRequest) {
+	ops, settled := coalesceOperations(requests)
+	for _, s := range settled {
+		s.req.resolve(s.data)
+	}
+	if coalesced := len(requests) - len(ops); coalesced > 0 {
+		operationLog.Debug("coalesced %d operations into %d", len(requests), len(ops))
+		operationsCoalesced.With().Add(uint64(coalesced))
+	}
+	if len(ops) == 0 {
+		return
+	}
 
-			// Print the batch response
-			fmt.Printf("batchResponse: %s\n", batchResponse)
+	svc_op.inFlightBatches.Add(1)
+	defer func() {
+		svc_op.lastSent.Store(time.Now().UnixNano())
+		svc_op.inFlightBatches.Add(-1)
+	}()
 
-			for i := 0; i < len(resolves); i++ {
-				if i >= len(batchResponse.Results) {
-					panic(fmt.Errorf("batch response length mismatch"))
-				}
-				result := batchResponse.Results[i]
-				resolves[i] <- OperationResponse{
-					Data: result,
-				}
+	// A batch serves operations from many traces, so it gets a trace of
+	// its own which links back to each of them.
+	ctx, batchSpan := trace.StartRoot(
+		context.Background(), "operation.batch",
+		"operations", len(ops),
+	)
+	defer batchSpan.End()
+	// uploads give way to reads
+	ctx = putersdk.WithClass(ctx, putersdk.ClassWriteBack)
+	for _, req := range requests {
+		batchSpan.AddLink(req.span)
+		req.span.SetAttr("batch", trace.RequestID(ctx))
+	}
+
+	for i := 0; i < len(ops); {
+		if !isBatchable(ops[i].Operation) {
+			svc_op.sendOne(ctx, ops[i])
+			i++
+			continue
+		}
+		end := i
+		for end < len(ops) && isBatchable(ops[end].Operation) {
+			end++
+		}
+		batchSpan.SetError(svc_op.sendBatch(ctx, ops[i:end]))
+		i = end
+	}
+}
+
+// Calls 'send' until it doesn't fail for want of authentication.
+func (svc_op *OperationService) whileUnauthenticated(count int, send func() error) error {
+	err := send()
+	for errors.Is(err, putersdk.ErrUnauthenticated) {
+		operationLog.Warn("holding %d operations until authenticated", count)
+		svc_op.SDK.WaitAuthenticated()
+		err = send()
+	}
+	return err
+}
+
+func (svc_op *OperationService) sendBatch(ctx context.Context, ops []*coalescedOperation) error {
+	operations := []putersdk.Operation{}
+	uploads := []putersdk.Upload{}
+	for _, op := range ops {
+		operation := op.Operation
+		if _, marked := operation[OperationCreates]; marked {
+			operation = copyOperation(operation)
+			delete(operation, OperationCreates)
+		}
+		operations = append(operations, operation)
+		if op.upload != nil {
+			upload := *op.upload
+			upload.Path = path.Join(operationString(operation, "path"), operationString(operation, "name"))
+			uploads = append(uploads, upload)
+		}
+	}
+
+	// send the batch to the server
+	operationLog.Debug("sending a batch of %d operations", len(operations))
+	batchSize.With().Observe(float64(len(operations)))
+	batchStart := time.Now()
+
+	var batchResponse *putersdk.BatchResoponse
+	err := svc_op.whileUnauthenticated(len(operations), func() (err error) {
+		batchResponse, err = svc_op.SDK.BatchUploads(ctx, operations, uploads)
+		return err
+	})
+	batchDuration.With().ObserveSince(batchStart)
+
+	if err != nil {
+		// Every operation in the batch failed; waiters must
+		// still be resolved so callers can report the error.
+		operationLog.Error("batch of %d operations failed: %s", len(operations), err)
+		batchErrors.With().Inc()
+		for _, op := range ops {
+			op.resolve(operationErrorData(err))
+		}
+		return err
+	}
+
+	for i, op := range ops {
+		if i >= len(batchResponse.Results) {
+			panic(fmt.Errorf("batch response length mismatch"))
+		}
+		op.resolve(batchResponse.Results[i])
+	}
+	return nil
+}
+
+// Sends an operation which can't be batched.
+func (svc_op *OperationService) sendOne(ctx context.Context, op *coalescedOperation) {
+	operation := op.Operation
+	data := map[string]interface{}{}
+	err := svc_op.whileUnauthenticated(1, func() error {
+		switch operation["op"] {
+		case "delete":
+			return svc_op.SDK.Delete(ctx, operationString(operation, "path"))
+		case "move":
+			item, err := svc_op.SDK.Move(
+				ctx,
+				operationString(operation, "source"),
+				operationString(operation, "parent"),
+				operationString(operation, "name"),
+			)
+			if err == nil {
+				itemJSON, _ := json.Marshal(item)
+				json.Unmarshal(itemJSON, &data)
 			}
+			return err
 		}
-	}()
+		return fmt.Errorf("unknown operation %v", operation["op"])
+	})
+	if err != nil {
+		operationLog.Error("%v operation failed: %s", operation["op"], err)
+		data = operationErrorData(err)
+	}
+	op.resolve(data)
+}
+
+func (op *coalescedOperation) resolve(data map[string]interface{}) {
+	for _, req := range op.requests {
+		req.resolve(data)
+	}
+}
+
+// The result of an operation which failed to send.
+func operationErrorData(err error) map[string]interface{} {
+	data := map[string]interface{}{
+		"error":   true,
+		"message": err.Error(),
+	}
+	switch {
+	case putersdk.IsStatus(err, http.StatusNotFound):
+		data["code"] = "subject_does_not_exist"
+	case putersdk.IsStatus(err, http.StatusForbidden):
+		data["code"] = "forbidden"
+	}
+	return data
 }
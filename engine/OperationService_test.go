@@ -0,0 +1,104 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package engine
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/putersdk"
+)
+
+func TestOperationServiceTimeout(t *testing.T) {
+	// a batch which takes longer than the timeout to send
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		time.Sleep(600 * time.Millisecond)
+		w.Write([]byte(`{"results": [{"path": "/a/x"}]}`))
+	}))
+	defer server.Close()
+
+	sdk := &putersdk.PuterSDK{Url: server.URL, PuterAuthToken: "token"}
+	sdk.Init()
+	svc := &OperationService{SDK: sdk, timeout: 300 * time.Millisecond}
+	svc.Init(nil)
+
+	resolved := make(chan struct{})
+	promise := svc.EnqueueUpload(
+		context.Background(),
+		write("/a", "x", "").Operation,
+		putersdk.BytesUpload([]byte("data")),
+		func() { close(resolved) },
+	)
+
+	select {
+	case resp := <-promise.Await:
+		if resp.Data["error"] != nil || resp.Data["path"] != "/a/x" {
+			t.Errorf("expected the batch's result, got %v", resp.Data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the write wasn't resolved")
+	}
+	select {
+	case <-resolved:
+	default:
+		t.Errorf("expected the upload to be released once resolved")
+	}
+}
+
+func TestOperationServiceQueuedBehindSlowBatch(t *testing.T) {
+	// the first batch takes longer than the timeout to send
+	var batches atomic.Int32
+	arrived := make(chan struct{}, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		arrived <- struct{}{}
+		if batches.Add(1) == 1 {
+			time.Sleep(800 * time.Millisecond)
+		}
+		w.Write([]byte(`{"results": [{"path": "/a/x"}]}`))
+	}))
+	defer server.Close()
+
+	sdk := &putersdk.PuterSDK{Url: server.URL, PuterAuthToken: "token"}
+	sdk.Init()
+	svc := &OperationService{SDK: sdk, timeout: 300 * time.Millisecond}
+	svc.Init(nil)
+
+	ctx := context.Background()
+	first := svc.EnqueueOperationRequest(ctx, write("/a", "x", "").Operation, []byte("1"))
+	<-arrived
+	second := svc.EnqueueOperationRequest(ctx, write("/a", "x", "").Operation, []byte("2"))
+
+	for name, promise := range map[string]OperationRequestPromise{"first": first, "second": second} {
+		select {
+		case resp := <-promise.Await:
+			if resp.Data["error"] != nil {
+				t.Errorf("expected the %s write to be sent, got %v", name, resp.Data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the %s write wasn't resolved", name)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/putersdk"
+	"github.com/HeyPuter/puter-fuse/services"
+)
+
+var transferLog = logger.S("transfer")
+
+var ErrTransferCancelled = errors.New("transfer was cancelled")
+
+// how often a transfer's rate is recalculated
+const transferRateWindow = time.Second
+
+// IDs are unique across mounts, so a transfer can be found by its ID
+// alone.
+var lastTransferID atomic.Int64
+
+type TransferState string
+
+const (
+	TransferRunning   TransferState = "running"
+	TransferPaused    TransferState = "paused"
+	TransferCancelled TransferState = "cancelled"
+)
+
+type TransferInfo struct {
+	ID        int64                      `json:"id"`
+	Direction putersdk.TransferDirection `json:"direction"`
+	Path      string                     `json:"path"`
+	State     TransferState              `json:"state"`
+	Done      int64                      `json:"done"`
+	// -1 if not known
+	Total   int64     `json:"total"`
+	Started time.Time `json:"started"`
+	// bytes per second
+	Rate float64 `json:"rate"`
+	// seconds; -1 if not known
+	ETA float64 `json:"eta"`
+}
+
+// TransferService tracks the uploads and downloads of one mount while
+// they're in flight. A paused transfer's reads block until it's resumed,
+// and a cancelled one's fail, which fails the request it's part of.
+type TransferService struct {
+	lock      sync.Mutex
+	transfers map[int64]*transfer
+}
+
+func CreateTransferService() *TransferService {
+	return &TransferService{
+		transfers: map[int64]*transfer{},
+	}
+}
+
+func (svc *TransferService) Init(services services.IServiceContainer) {}
+
+type transfer struct {
+	svc *TransferService
+	id  int64
+
+	direction putersdk.TransferDirection
+	path      string
+	total     int64
+	started   time.Time
+
+	lock      sync.Mutex
+	resumed   *sync.Cond
+	state     TransferState
+	finished  bool
+	done      int64
+	rate      float64
+	window    time.Time
+	windowEnd int64
+}
+
+func (svc *TransferService) Start(direction putersdk.TransferDirection, path string, size int64) putersdk.Transfer {
+	t := &transfer{
+		svc:       svc,
+		id:        lastTransferID.Add(1),
+		direction: direction,
+		path:      path,
+		total:     size,
+		started:   time.Now(),
+		state:     TransferRunning,
+	}
+	t.resumed = sync.NewCond(&t.lock)
+	t.window = t.started
+
+	svc.lock.Lock()
+	svc.transfers[t.id] = t
+	svc.lock.Unlock()
+	return t
+}
+
+func (t *transfer) Reader(body io.ReadCloser) io.ReadCloser {
+	// a retried upload starts over
+	t.lock.Lock()
+	t.done = 0
+	t.rate = 0
+	t.window = time.Now()
+	t.windowEnd = 0
+	t.lock.Unlock()
+	return &transferReader{ReadCloser: body, transfer: t}
+}
+
+func (t *transfer) Finish(err error) {
+	t.svc.lock.Lock()
+	delete(t.svc.transfers, t.id)
+	t.svc.lock.Unlock()
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	// a read still waiting on a pause has nowhere to go
+	t.finished = true
+	t.resumed.Broadcast()
+	if err != nil && t.state != TransferCancelled {
+		transferLog.Warn("%s of %s failed: %s", t.direction, t.path, err)
+	}
+}
+
+// Waits while the transfer is paused, unless it's finished; fails if
+// it's cancelled.
+func (t *transfer) await() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for t.state == TransferPaused && !t.finished {
+		t.resumed.Wait()
+	}
+	if t.state == TransferCancelled {
+		return ErrTransferCancelled
+	}
+	return nil
+}
+
+func (t *transfer) add(n int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.done += int64(n)
+
+	now := time.Now()
+	elapsed := now.Sub(t.window)
+	if elapsed < transferRateWindow {
+		return
+	}
+	rate := float64(t.done-t.windowEnd) / elapsed.Seconds()
+	if t.rate == 0 {
+		t.rate = rate
+	} else {
+		t.rate = (t.rate + rate) / 2
+	}
+	t.window = now
+	t.windowEnd = t.done
+}
+
+func (t *transfer) setState(state TransferState) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.state == TransferCancelled {
+		return fmt.Errorf("transfer %d was cancelled", t.id)
+	}
+	if state == TransferRunning && t.state == TransferPaused {
+		// the time spent paused doesn't count against the rate
+		t.window = time.Now()
+		t.windowEnd = t.done
+	}
+	t.state = state
+	t.resumed.Broadcast()
+	return nil
+}
+
+func (t *transfer) info() TransferInfo {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	info := TransferInfo{
+		ID:        t.id,
+		Direction: t.direction,
+		Path:      t.path,
+		State:     t.state,
+		Done:      t.done,
+		Total:     t.total,
+		Started:   t.started,
+		Rate:      t.rate,
+		ETA:       -1,
+	}
+	// until a window has passed, the rate so far
+	if info.Rate == 0 && t.done > 0 {
+		info.Rate = float64(t.done) / time.Since(t.window).Seconds()
+	}
+	if t.total >= 0 && info.Rate > 0 && t.state == TransferRunning {
+		info.ETA = float64(max(t.total-t.done, 0)) / info.Rate
+	}
+	return info
+}
+
+type transferReader struct {
+	io.ReadCloser
+	transfer *transfer
+}
+
+func (r *transferReader) Read(p []byte) (int, error) {
+	if err := r.transfer.await(); err != nil {
+		return 0, err
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.transfer.add(n)
+	return n, err
+}
+
+// Returns the transfers in flight, oldest first.
+func (svc *TransferService) List() []TransferInfo {
+	svc.lock.Lock()
+	transfers := make([]*transfer, 0, len(svc.transfers))
+	for _, t := range svc.transfers {
+		transfers = append(transfers, t)
+	}
+	svc.lock.Unlock()
+
+	infos := make([]TransferInfo, len(transfers))
+	for i, t := range transfers {
+		infos[i] = t.info()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// Returns false if there's no transfer in flight with the ID.
+func (svc *TransferService) setState(id int64, state TransferState) (bool, error) {
+	svc.lock.Lock()
+	t, exists := svc.transfers[id]
+	svc.lock.Unlock()
+	if !exists {
+		return false, nil
+	}
+	return true, t.setState(state)
+}
+
+func (svc *TransferService) Pause(id int64) (bool, error) {
+	return svc.setState(id, TransferPaused)
+}
+
+func (svc *TransferService) Resume(id int64) (bool, error) {
+	return svc.setState(id, TransferRunning)
+}
+
+// Cancels a transfer; the request it's part of fails.
+func (svc *TransferService) Cancel(id int64) (bool, error) {
+	return svc.setState(id, TransferCancelled)
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package engine
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/putersdk"
+)
+
+func TestTransferService(t *testing.T) {
+	t.Run("progress is reported until the transfer finishes", func(t *testing.T) {
+		svc := CreateTransferService()
+		transfer := svc.Start(putersdk.TransferUpload, "/a", 10)
+		reader := transfer.Reader(io.NopCloser(strings.NewReader("0123456789")))
+
+		reader.Read(make([]byte, 4))
+		infos := svc.List()
+		if len(infos) != 1 {
+			t.Fatalf("expected 1 transfer, got %d", len(infos))
+		}
+		if infos[0].Done != 4 || infos[0].Total != 10 || infos[0].Path != "/a" {
+			t.Errorf("unexpected transfer: %+v", infos[0])
+		}
+		if infos[0].Rate <= 0 || infos[0].ETA < 0 {
+			t.Errorf("expected a rate and ETA, got %f and %f", infos[0].Rate, infos[0].ETA)
+		}
+
+		transfer.Finish(nil)
+		if len(svc.List()) != 0 {
+			t.Errorf("expected the finished transfer to be forgotten")
+		}
+	})
+
+	t.Run("a retried transfer starts over", func(t *testing.T) {
+		svc := CreateTransferService()
+		transfer := svc.Start(putersdk.TransferUpload, "/a", 4)
+		io.ReadAll(transfer.Reader(io.NopCloser(strings.NewReader("0123"))))
+		transfer.Reader(io.NopCloser(strings.NewReader("0123")))
+
+		if done := svc.List()[0].Done; done != 0 {
+			t.Errorf("expected 0 bytes done, got %d", done)
+		}
+	})
+
+	t.Run("paused transfers block until resumed", func(t *testing.T) {
+		svc := CreateTransferService()
+		transfer := svc.Start(putersdk.TransferDownload, "/a", -1)
+		reader := transfer.Reader(io.NopCloser(strings.NewReader("data")))
+		id := svc.List()[0].ID
+
+		if found, err := svc.Pause(id); !found || err != nil {
+			t.Fatalf("expected to pause the transfer, got %v, %v", found, err)
+		}
+		if eta := svc.List()[0].ETA; eta != -1 {
+			t.Errorf("expected no ETA, got %f", eta)
+		}
+
+		read := make(chan error)
+		go func() {
+			_, err := io.ReadAll(reader)
+			read <- err
+		}()
+		select {
+		case <-read:
+			t.Fatalf("expected the read to block")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		svc.Resume(id)
+		if err := <-read; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("cancelled transfers fail", func(t *testing.T) {
+		svc := CreateTransferService()
+		transfer := svc.Start(putersdk.TransferUpload, "/a", 4)
+		reader := transfer.Reader(io.NopCloser(strings.NewReader("data")))
+		id := svc.List()[0].ID
+
+		svc.Pause(id)
+		read := make(chan error)
+		go func() {
+			_, err := reader.Read(make([]byte, 4))
+			read <- err
+		}()
+		svc.Cancel(id)
+
+		if err := <-read; !errors.Is(err, ErrTransferCancelled) {
+			t.Errorf("expected the transfer to be cancelled, got %v", err)
+		}
+		if _, err := svc.Resume(id); err == nil {
+			t.Errorf("expected a cancelled transfer not to resume")
+		}
+	})
+
+	t.Run("unknown transfers aren't found", func(t *testing.T) {
+		svc := CreateTransferService()
+		if found, _ := svc.Cancel(12345); found {
+			t.Errorf("expected no transfer to be found")
+		}
+	})
+}
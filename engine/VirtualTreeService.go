@@ -19,7 +19,6 @@
 package engine
 
 import (
-	"fmt"
 	"time"
 
 	"github.com/HeyPuter/puter-fuse/lang"
@@ -111,7 +110,7 @@ func (svc *VirtualTreeService) RegisterFile(uid string) string {
 }
 
 func (svc *VirtualTreeService) Link(parentUID, childUID, name string) {
-	fmt.Println("linking", parentUID, childUID, name)
+	logger.S("virtual-tree").Debug("linking %s to %s as %s", childUID, parentUID, name)
 	entry, _ := svc.Directories.Get(parentUID)
 	entry.MemberUIDToName.Set(childUID, name)
 	entry.MemberNameToUID.Set(name, childUID)
@@ -129,6 +128,15 @@ func (svc *VirtualTreeService) UpdateLastReaddir(uid string) {
 	entry.LastReaddir = time.Now()
 }
 
+// Makes the next readdir of a directory go to the delegate.
+func (svc *VirtualTreeService) ExpireReaddir(uid string) {
+	entry, exists := svc.Directories.Get(uid)
+	if !exists {
+		return
+	}
+	entry.LastReaddir = time.Time{}
+}
+
 // func (svc *VirtualTreeService) GetNodesFromEntry(entry *VirtualDirectoryEntry) []fao.NodeInfo {
 // 	nodes := []fao.NodeInfo{}
 // 	for _, fileUid := range entry.Files.Values() {
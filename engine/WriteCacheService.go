@@ -19,7 +19,10 @@
 package engine
 
 import (
+	"bytes"
+	"context"
 	"io"
+	"time"
 
 	"github.com/HeyPuter/puter-fuse/lang"
 	"github.com/HeyPuter/puter-fuse/services"
@@ -29,20 +32,9 @@ import (
 type Mutation interface {
 	Apply(inStream io.ReadCloser) (outStream io.ReadCloser, err error)
 	ApplyToBuffer(buffer []byte, offset int64)
-}
-
-type MutationChain struct {
-	Releasables []Releasable
-	Mutations   []interface{}
-}
-
-func (chain *MutationChain) ApplyToBuffer(buffer []byte, offset int64) {
-	for _, mut := range chain.Mutations {
-		switch mut := mut.(type) {
-		case Mutation:
-			mut.ApplyToBuffer(buffer, offset)
-		}
-	}
+	// Returns the size of the file after this mutation is applied to a
+	// file of size 'size'.
+	ApplyToSize(size uint64) uint64
 }
 
 type WriteMutation struct {
@@ -50,11 +42,6 @@ type WriteMutation struct {
 	Offset int64
 }
 
-type MutationReference struct{}
-
-func (ref *MutationReference) Release() {
-}
-
 // Returns a reader which will emit the contents of inStream, replacing the
 // bytes at 'Offset' with the buffer 'Data'.
 func (mut *WriteMutation) Apply(inStream io.ReadCloser) (outStream io.ReadCloser, err error) {
@@ -102,14 +89,164 @@ func (mut *WriteMutation) ApplyToBuffer(buffer []byte, offset int64) {
 	copy(buffer[iBufferStart:iBufferEnd], mut.Data[iDataStart:iDataEnd])
 }
 
+func (mut *WriteMutation) ApplyToSize(size uint64) uint64 {
+	end := uint64(mut.Offset) + uint64(len(mut.Data))
+	if end > size {
+		return end
+	}
+	return size
+}
+
+// Returns true if 'other' overlaps or is adjacent to this write.
+func (mut *WriteMutation) Touches(other *WriteMutation) bool {
+	return other.Offset <= mut.Offset+int64(len(mut.Data)) &&
+		mut.Offset <= other.Offset+int64(len(other.Data))
+}
+
+// Returns a single write equivalent to this write followed by 'other'.
+// Neither write is modified; readers may still be streaming their data.
+func (mut *WriteMutation) Merge(other *WriteMutation) *WriteMutation {
+	// Sequential writes are by far the most common case, and appending
+	// lets the slice grow in amortized constant time.
+	if other.Offset == mut.Offset+int64(len(mut.Data)) {
+		return &WriteMutation{
+			Data:   append(mut.Data[:len(mut.Data):len(mut.Data)], other.Data...),
+			Offset: mut.Offset,
+		}
+	}
+
+	start := min(mut.Offset, other.Offset)
+	end := max(mut.Offset+int64(len(mut.Data)), other.Offset+int64(len(other.Data)))
+
+	data := make([]byte, end-start)
+	copy(data[mut.Offset-start:], mut.Data)
+	copy(data[other.Offset-start:], other.Data)
+
+	return &WriteMutation{
+		Data:   data,
+		Offset: start,
+	}
+}
+
+// SpilledWriteMutation is a WriteMutation whose data has been moved to
+// the BLOB cache to keep the size of a mutation chain in memory bounded.
+type SpilledWriteMutation struct {
+	Offset int64
+	Length int64
+
+	ref              *BLOBCacheReference
+	blobCacheService *BLOBCacheService
+}
+
+func CreateSpilledWriteMutation(
+	blobCacheService *BLOBCacheService, write *WriteMutation,
+) *SpilledWriteMutation {
+	return &SpilledWriteMutation{
+		Offset:           write.Offset,
+		Length:           int64(len(write.Data)),
+		ref:              blobCacheService.Store(bytes.NewReader(write.Data)),
+		blobCacheService: blobCacheService,
+	}
+}
+
+// Reads the data back from the BLOB cache.
+func (mut *SpilledWriteMutation) Data() ([]byte, error) {
+	data := make([]byte, mut.Length)
+	_, _, err := mut.blobCacheService.GetBytes(mut.ref.GetHash(), 0, data)
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func (mut *SpilledWriteMutation) Apply(inStream io.ReadCloser) (outStream io.ReadCloser, err error) {
+	data, err := mut.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	return streamutil.NewReplaceReader(
+		inStream, data, uint64(mut.Offset),
+	), nil
+}
+
+func (mut *SpilledWriteMutation) ApplyToBuffer(buffer []byte, offset int64) {
+	start := max(mut.Offset, offset)
+	end := min(mut.Offset+mut.Length, offset+int64(len(buffer)))
+	if start >= end {
+		return
+	}
+
+	mut.blobCacheService.GetBytes(
+		mut.ref.GetHash(), start-mut.Offset,
+		buffer[start-offset:end-offset],
+	)
+}
+
+func (mut *SpilledWriteMutation) ApplyToSize(size uint64) uint64 {
+	end := uint64(mut.Offset) + uint64(mut.Length)
+	if end > size {
+		return end
+	}
+	return size
+}
+
+func (mut *SpilledWriteMutation) Release() {
+	mut.ref.Release()
+}
+
 type TruncateMutation struct {
 	Size uint64
 }
 
+// Returns a reader which will emit exactly 'Size' bytes; bytes past the
+// end of inStream are zero-filled.
+func (mut *TruncateMutation) Apply(inStream io.ReadCloser) (outStream io.ReadCloser, err error) {
+	return streamutil.NewTruncateReader(inStream, mut.Size), nil
+}
+
+// Zeroes every byte in the buffer at or past 'Size'. Writes which come
+// later in the chain will fill these bytes back in if needed.
+func (mut *TruncateMutation) ApplyToBuffer(buffer []byte, offset int64) {
+	iBufferStart := int64(mut.Size) - offset
+	if iBufferStart >= int64(len(buffer)) {
+		return
+	}
+	if iBufferStart < 0 {
+		iBufferStart = 0
+	}
+
+	for i := iBufferStart; i < int64(len(buffer)); i++ {
+		buffer[i] = 0
+	}
+}
+
+func (mut *TruncateMutation) ApplyToSize(size uint64) uint64 {
+	return mut.Size
+}
+
 type RemoveMutation struct{}
 
+// Returns an empty reader; the contents of inStream are discarded.
+func (mut *RemoveMutation) Apply(inStream io.ReadCloser) (outStream io.ReadCloser, err error) {
+	return streamutil.NewTruncateReader(inStream, 0), nil
+}
+
+func (mut *RemoveMutation) ApplyToBuffer(buffer []byte, offset int64) {
+	for i := range buffer {
+		buffer[i] = 0
+	}
+}
+
+func (mut *RemoveMutation) ApplyToSize(size uint64) uint64 {
+	return 0
+}
+
 type WriteCacheService struct {
 	CachedOperations lang.IMap[string, *MutationChain]
+
+	ConfigService    IConfig
+	BLOBCacheService *BLOBCacheService
 }
 
 func CreateWriteCacheService() *WriteCacheService {
@@ -119,25 +256,293 @@ func CreateWriteCacheService() *WriteCacheService {
 }
 
 func (svc *WriteCacheService) Init(services services.IServiceContainer) {
+	svc.ConfigService = services.Get("config").(*ConfigService)
+	svc.BLOBCacheService = services.Get("blob-cache").(*BLOBCacheService)
 }
 
 func (svc *WriteCacheService) ApplyToBuffer(localUID string, buffer []byte, offset int64) {
-	chain, _, _ := svc.CachedOperations.
-		GetWithFactory(localUID, func() (*MutationChain, bool, error) {
-			chain := &MutationChain{}
-			return chain, false, nil
-		})
+	chain, exists := svc.CachedOperations.Get(localUID)
+	if !exists {
+		CacheMisses.With("write").Inc()
+		return
+	}
+	CacheHits.With("write").Inc()
 
 	chain.ApplyToBuffer(buffer, offset)
 }
 
+// Returns the size of the file after pending mutations are applied to
+// a file of size 'baseSize', and whether the file has been removed.
+// If there are no pending mutations 'baseSize' is returned as-is.
+func (svc *WriteCacheService) GetSize(localUID string, baseSize uint64) (uint64, bool) {
+	chain, exists := svc.CachedOperations.Get(localUID)
+	if !exists {
+		return baseSize, false
+	}
+
+	return chain.Size(baseSize), chain.IsRemoved()
+}
+
+// Returns a reader with pending mutations applied to inStream.
+func (svc *WriteCacheService) ApplyToStream(
+	localUID string, inStream io.ReadCloser, baseSize uint64,
+) (io.ReadCloser, error) {
+	chain, exists := svc.CachedOperations.Get(localUID)
+	if !exists {
+		return inStream, nil
+	}
+
+	return chain.Apply(inStream, baseSize)
+}
+
 func (svc *WriteCacheService) ApplyMutation(localUID string, mut Mutation) *MutationReference {
-	chain, _, _ := svc.CachedOperations.
-		GetWithFactory(localUID, func() (*MutationChain, bool, error) {
-			chain := &MutationChain{}
-			return chain, false, nil
-		})
-
-	chain.Mutations = append(chain.Mutations, mut)
-	return &MutationReference{}
+	for {
+		chain, _, _ := svc.CachedOperations.
+			GetWithFactory(localUID, func() (*MutationChain, bool, error) {
+				chain := &MutationChain{}
+				return chain, true, nil
+			})
+
+		ref := chain.Append(mut)
+		if ref == nil {
+			// the chain was removed by Rebase; try again with a new one
+			continue
+		}
+
+		svc.maybeSpill(chain)
+		return ref
+	}
+}
+
+func (svc *WriteCacheService) maybeSpill(chain *MutationChain) {
+	if svc.ConfigService == nil || svc.BLOBCacheService == nil {
+		return
+	}
+
+	spillSize := int64(svc.ConfigService.GetInt("writeCacheSpillSize"))
+	if spillSize <= 0 {
+		return
+	}
+
+	chain.spill(svc.BLOBCacheService, spillSize)
+}
+
+// Folds mutations which the delegate has acknowledged into a new base
+// blob, then drops them from the chain. 'baseHash' is the blob the chain
+// currently applies to; if it's empty or no longer cached the delegate
+// is assumed to be up-to-date and the mutations are simply dropped.
+// 'commit' is called with the new base hash before any mutations are
+// removed from the chain, so readers always see one or the other. A
+// mutation the delegate rejected, and everything after it, is kept until
+// it's retried or discarded.
+//
+// The new base is written without the chain's lock held, so reads and
+// writes of the file aren't held up by it.
+func (svc *WriteCacheService) Rebase(
+	localUID string, baseHash string, commit func(newHash string),
+) error {
+	chain, exists := svc.CachedOperations.Get(localUID)
+	if !exists {
+		return nil
+	}
+
+	chain.lock.Lock()
+	k := chain.acknowledgedPrefix()
+	if k == 0 || chain.rebasing {
+		chain.lock.Unlock()
+		return nil
+	}
+	chain.rebasing = true
+	// Acknowledged entries stay in the chain until they're dropped
+	// below, but their writes may be spilled meanwhile; the mutations
+	// as they are now still write the same data.
+	prefix := []interface{}{}
+	for _, item := range chain.Mutations[:k] {
+		if mut, ok := unwrapMutation(item); ok {
+			prefix = append(prefix, mut)
+		}
+	}
+	chain.lock.Unlock()
+
+	newRef, err := svc.fold(prefix, baseHash)
+
+	chain.lock.Lock()
+	defer chain.lock.Unlock()
+	chain.rebasing = false
+	if err != nil {
+		return err
+	}
+
+	if newRef != nil {
+		commit(newRef.GetHash())
+
+		for _, releasable := range chain.Releasables {
+			releasable.Release()
+		}
+		chain.Releasables = []Releasable{newRef}
+	}
+
+	for _, item := range chain.Mutations[:k] {
+		if mut, ok := unwrapMutation(item); ok {
+			if releasable, ok := mut.(Releasable); ok {
+				releasable.Release()
+			}
+			if write, ok := mut.(*WriteMutation); ok {
+				chain.memSize -= int64(len(write.Data))
+			}
+		}
+	}
+
+	chain.Mutations = append([]interface{}{}, chain.Mutations[k:]...)
+
+	// a chain holding an error is kept until the error is reported
+	if len(chain.Mutations) == 0 && len(chain.Releasables) == 0 && chain.err == nil {
+		chain.dead = true
+		svc.CachedOperations.Del(localUID)
+		CacheEvictions.With("write").Inc()
+	}
+
+	return nil
+}
+
+// Stores the base blob with 'prefix' applied, or returns nil if the base
+// isn't cached.
+func (svc *WriteCacheService) fold(prefix []interface{}, baseHash string) (*BLOBCacheReference, error) {
+	if baseHash == "" || svc.BLOBCacheService == nil {
+		return nil, nil
+	}
+	size, ok := svc.BLOBCacheService.GetSize(baseHash)
+	if !ok {
+		return nil, nil
+	}
+	reader := svc.BLOBCacheService.Get(baseHash, 0, size)
+	if reader == nil {
+		return nil, nil
+	}
+
+	stream, err := applyMutations(prefix, io.NopCloser(reader), uint64(size))
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	return svc.BLOBCacheService.Store(stream), nil
+}
+
+// Marks the mutations for a file from the first one the delegate
+// rejected onwards as pending again; see MutationChain.Retry.
+func (svc *WriteCacheService) Retry(localUID string) []RetriedMutation {
+	chain, exists := svc.CachedOperations.Get(localUID)
+	if !exists {
+		return nil
+	}
+	return chain.Retry()
+}
+
+// Drops the mutations the delegate rejected for a file which has been
+// discarded, e.g. because it was deleted.
+func (svc *WriteCacheService) Discard(localUID string) {
+	chain, exists := svc.CachedOperations.Get(localUID)
+	if !exists {
+		return
+	}
+	chain.Discard()
+}
+
+// Drops the chain of a file which the delegate has removed.
+func (svc *WriteCacheService) Remove(localUID string) {
+	chain, exists := svc.CachedOperations.Get(localUID)
+	if !exists {
+		return
+	}
+	svc.CachedOperations.Del(localUID)
+	chain.remove()
+	CacheEvictions.With("write").Inc()
+}
+
+// Blocks until the delegate has acknowledged every mutation for the
+// file, returning the first error it reported since the last call.
+func (svc *WriteCacheService) Await(localUID string) error {
+	chain, exists := svc.CachedOperations.Get(localUID)
+	if !exists {
+		return nil
+	}
+	return chain.Await()
+}
+
+// Returns the first error the delegate reported for the file since the
+// last call, without waiting for pending mutations.
+func (svc *WriteCacheService) TakeError(localUID string) error {
+	chain, exists := svc.CachedOperations.Get(localUID)
+	if !exists {
+		return nil
+	}
+	return chain.TakeError()
+}
+
+// Returns the local UIDs of files with mutations the delegate hasn't
+// acknowledged yet.
+func (svc *WriteCacheService) PendingFiles() []string {
+	localUIDs := []string{}
+	for _, localUID := range svc.CachedOperations.Keys() {
+		chain, exists := svc.CachedOperations.Get(localUID)
+		if exists && chain.Pending() {
+			localUIDs = append(localUIDs, localUID)
+		}
+	}
+	return localUIDs
+}
+
+// Returns the number of unacknowledged delegate operations for each file
+// which has any, by local UID.
+func (svc *WriteCacheService) PendingMutations() map[string]int {
+	counts := map[string]int{}
+	for _, localUID := range svc.CachedOperations.Keys() {
+		chain, exists := svc.CachedOperations.Get(localUID)
+		if !exists {
+			continue
+		}
+		if count := chain.PendingCount(); count > 0 {
+			counts[localUID] = count
+		}
+	}
+	return counts
+}
+
+type WriteCacheStats struct {
+	Files        int   `json:"files"`
+	PendingFiles int   `json:"pendingFiles"`
+	MemoryBytes  int64 `json:"memoryBytes"`
+}
+
+func (svc *WriteCacheService) Stats() WriteCacheStats {
+	stats := WriteCacheStats{}
+	for _, localUID := range svc.CachedOperations.Keys() {
+		chain, exists := svc.CachedOperations.Get(localUID)
+		if !exists {
+			continue
+		}
+		stats.Files++
+		if chain.Pending() {
+			stats.PendingFiles++
+		}
+		stats.MemoryBytes += chain.MemSize()
+	}
+	return stats
+}
+
+// Blocks until no file has pending mutations or 'ctx' is done. Returns
+// the local UIDs of files which still had pending mutations.
+func (svc *WriteCacheService) Drain(ctx context.Context) []string {
+	for {
+		pending := svc.PendingFiles()
+		if len(pending) == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return pending
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
 }
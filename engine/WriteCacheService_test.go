@@ -19,7 +19,12 @@
 package engine_test
 
 import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/HeyPuter/puter-fuse/engine"
 )
@@ -159,3 +164,160 @@ func TestWriteMutation(t *testing.T) {
 
 	})
 }
+
+func TestTruncateMutation(t *testing.T) {
+	t.Run("TruncateMutation->ApplyToBuffer", func(t *testing.T) {
+		type testCase struct {
+			readOffset int64
+			size       uint64
+			expected   []byte
+		}
+
+		// test source: 0123456789
+		testCases := map[string]testCase{
+			"before read":  {readOffset: 4, size: 2, expected: []byte{0, 0, 0, 0}},
+			"within read":  {readOffset: 4, size: 6, expected: []byte{'4', '5', 0, 0}},
+			"after read":   {readOffset: 0, size: 6, expected: []byte("0123")},
+			"at read edge": {readOffset: 2, size: 6, expected: []byte("2345")},
+		}
+
+		for label, tc := range testCases {
+			t.Run(label, func(t *testing.T) {
+				mut := &engine.TruncateMutation{Size: tc.size}
+				buffer := []byte("0123456789")[tc.readOffset : tc.readOffset+4]
+				mut.ApplyToBuffer(buffer, tc.readOffset)
+				if !bytes.Equal(buffer, tc.expected) {
+					t.Errorf("Expected %q, got %q", tc.expected, buffer)
+				}
+			})
+		}
+	})
+}
+
+func TestMutationChain(t *testing.T) {
+	type testCase struct {
+		mutations    []engine.Mutation
+		expectedSize uint64
+		expected     string
+	}
+
+	// test source: 0123456789
+	testCases := map[string]testCase{
+		"append": {
+			mutations: []engine.Mutation{
+				&engine.WriteMutation{Data: []byte("AB"), Offset: 10},
+			},
+			expectedSize: 12,
+			expected:     "0123456789AB",
+		},
+		"write past end": {
+			mutations: []engine.Mutation{
+				&engine.WriteMutation{Data: []byte("AB"), Offset: 12},
+			},
+			expectedSize: 14,
+			expected:     "0123456789\x00\x00AB",
+		},
+		"shrink": {
+			mutations: []engine.Mutation{
+				&engine.TruncateMutation{Size: 4},
+			},
+			expectedSize: 4,
+			expected:     "0123",
+		},
+		"shrink then grow": {
+			mutations: []engine.Mutation{
+				&engine.TruncateMutation{Size: 2},
+				&engine.TruncateMutation{Size: 5},
+			},
+			expectedSize: 5,
+			expected:     "01\x00\x00\x00",
+		},
+		"write then shrink": {
+			mutations: []engine.Mutation{
+				&engine.WriteMutation{Data: []byte("ABCD"), Offset: 2},
+				&engine.TruncateMutation{Size: 4},
+			},
+			expectedSize: 4,
+			expected:     "01AB",
+		},
+		"shrink then write": {
+			mutations: []engine.Mutation{
+				&engine.TruncateMutation{Size: 2},
+				&engine.WriteMutation{Data: []byte("AB"), Offset: 4},
+			},
+			expectedSize: 6,
+			expected:     "01\x00\x00AB",
+		},
+		"remove": {
+			mutations: []engine.Mutation{
+				&engine.WriteMutation{Data: []byte("AB"), Offset: 0},
+				&engine.RemoveMutation{},
+			},
+			expectedSize: 0,
+			expected:     "",
+		},
+	}
+
+	for label, tc := range testCases {
+		chain := &engine.MutationChain{}
+		for _, mut := range tc.mutations {
+			chain.Mutations = append(chain.Mutations, mut)
+		}
+
+		t.Run(label+" (size)", func(t *testing.T) {
+			size := chain.Size(10)
+			if size != tc.expectedSize {
+				t.Errorf("Expected %d, got %d", tc.expectedSize, size)
+			}
+		})
+
+		t.Run(label+" (buffer)", func(t *testing.T) {
+			buffer := make([]byte, 16)
+			copy(buffer, "0123456789")
+			chain.ApplyToBuffer(buffer, 0)
+			if string(buffer[:tc.expectedSize]) != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, buffer[:tc.expectedSize])
+			}
+		})
+
+		t.Run(label+" (stream)", func(t *testing.T) {
+			reader, err := chain.Apply(io.NopCloser(strings.NewReader("0123456789")), 10)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			out, err := io.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(out) != tc.expected {
+				t.Errorf("Expected %q, got %q", tc.expected, out)
+			}
+		})
+	}
+}
+
+func TestWriteCacheServiceDrain(t *testing.T) {
+	t.Run("drain returns once mutations are acknowledged", func(t *testing.T) {
+		svc := engine.CreateWriteCacheService()
+		ref := svc.ApplyMutation("a", &engine.WriteMutation{Data: []byte("ab")})
+		go ref.Release()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if pending := svc.Drain(ctx); len(pending) != 0 {
+			t.Errorf("expected nothing pending, got %v", pending)
+		}
+	})
+
+	t.Run("drain reports files pending at the deadline", func(t *testing.T) {
+		svc := engine.CreateWriteCacheService()
+		svc.ApplyMutation("a", &engine.WriteMutation{Data: []byte("ab")})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		pending := svc.Drain(ctx)
+		if len(pending) != 1 || pending[0] != "a" {
+			t.Errorf("expected [a], got %v", pending)
+		}
+	})
+}
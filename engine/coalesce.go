@@ -0,0 +1,220 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package engine
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/HeyPuter/puter-fuse/putersdk"
+	"github.com/google/uuid"
+)
+
+// OperationCreates marks a write which creates its file, so a delete
+// queued with it can cancel both. It's removed before the operation is
+// sent.
+const OperationCreates = "puter-fuse:creates"
+
+// A coalescedOperation is sent in place of one or more requests, which
+// are all resolved with its result.
+type coalescedOperation struct {
+	Operation putersdk.Operation
+	upload    *putersdk.Upload
+	requests  []*OperationRequest
+}
+
+// A request resolved without sending anything.
+type settledRequest struct {
+	req  *OperationRequest
+	data map[string]interface{}
+}
+
+// Operations which can be sent in a batch; others have endpoints of
+// their own.
+func isBatchable(operation putersdk.Operation) bool {
+	switch operation["op"] {
+	case "move", "delete":
+		return false
+	}
+	return true
+}
+
+func operationString(operation putersdk.Operation, key string) string {
+	value, _ := operation[key].(string)
+	return value
+}
+
+// Returns the paths an operation reads or changes. Operations which
+// aren't understood are taken to touch everything.
+func operationPaths(operation putersdk.Operation) []string {
+	switch operation["op"] {
+	case "write":
+		return []string{filepath.Join(operationString(operation, "path"), operationString(operation, "name"))}
+	case "mkdir":
+		return []string{filepath.Join(operationString(operation, "parent"), operationString(operation, "path"))}
+	case "delete":
+		return []string{operationString(operation, "path")}
+	case "move":
+		return []string{
+			operationString(operation, "source"),
+			filepath.Join(operationString(operation, "parent"), operationString(operation, "name")),
+		}
+	}
+	return []string{"/"}
+}
+
+// Returns whether one path is, or is inside, the other.
+func pathsOverlap(a, b string) bool {
+	under := func(path, dir string) bool {
+		return path == dir || dir == "/" || strings.HasPrefix(path, dir+"/")
+	}
+	return under(a, b) || under(b, a)
+}
+
+// Returns the index of the last operation touching 'path', or -1.
+func lastTouching(ops []*coalescedOperation, path string) int {
+	for i := len(ops) - 1; i >= 0; i-- {
+		for _, opPath := range operationPaths(ops[i].Operation) {
+			if pathsOverlap(opPath, path) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Returns the index of the last operation touching 'path' if it's a
+// write to exactly 'path', or -1.
+func lastWrite(ops []*coalescedOperation, path string) int {
+	i := lastTouching(ops, path)
+	if i < 0 || ops[i].Operation["op"] != "write" || operationPaths(ops[i].Operation)[0] != path {
+		return -1
+	}
+	return i
+}
+
+func copyOperation(operation putersdk.Operation) putersdk.Operation {
+	copied := putersdk.Operation{}
+	for key, value := range operation {
+		copied[key] = value
+	}
+	return copied
+}
+
+// Combines queued requests so fewer operations are sent, without
+// changing the outcome:
+//   - a write replaces an earlier write to the same file
+//   - a delete cancels a write which created the file, and replaces
+//     other writes to it
+//   - a move of a file which was just created writes it to its new
+//     name instead
+//
+// Requests are only combined when nothing queued between them touches
+// the same paths, so the remaining operations can be sent in order.
+func coalesceOperations(requests []*OperationRequest) ([]*coalescedOperation, []settledRequest) {
+	ops := []*coalescedOperation{}
+	settled := []settledRequest{}
+
+	for _, req := range requests {
+		operation := req.Operation
+		switch operation["op"] {
+		case "write":
+			path := operationPaths(operation)[0]
+			overwrite, _ := operation["overwrite"].(bool)
+			if i := lastWrite(ops, path); i >= 0 && overwrite {
+				merged := copyOperation(operation)
+				if ops[i].Operation[OperationCreates] == true {
+					merged[OperationCreates] = true
+				}
+				ops[i].Operation = merged
+				ops[i].upload = req.upload
+				ops[i].requests = append(ops[i].requests, req)
+				continue
+			}
+		case "delete":
+			path := operationString(operation, "path")
+			i := lastWrite(ops, path)
+			if i < 0 {
+				break
+			}
+			write := ops[i]
+			if write.Operation[OperationCreates] != true {
+				// the file existed before, so it must still be deleted
+				write.Operation = operation
+				write.upload = nil
+				write.requests = append(write.requests, req)
+				continue
+			}
+			ops = append(ops[:i], ops[i+1:]...)
+			item := createdItem(write)
+			for _, writeReq := range write.requests {
+				settled = append(settled, settledRequest{req: writeReq, data: item})
+			}
+			settled = append(settled, settledRequest{req: req, data: map[string]interface{}{}})
+			continue
+		case "move":
+			source := operationString(operation, "source")
+			dest := operationPaths(operation)[1]
+			i := lastWrite(ops, source)
+			if i < 0 || lastTouching(ops, dest) > i {
+				break
+			}
+			if ops[i].Operation[OperationCreates] != true {
+				// the source exists on Puter already, so it must still
+				// be moved away
+				break
+			}
+			moved := copyOperation(ops[i].Operation)
+			moved["path"] = operation["parent"]
+			moved["name"] = operation["name"]
+			ops[i].Operation = moved
+			ops[i].requests = append(ops[i].requests, req)
+			continue
+		}
+
+		ops = append(ops, &coalescedOperation{
+			Operation: operation,
+			upload:    req.upload,
+			requests:  []*OperationRequest{req},
+		})
+	}
+	return ops, settled
+}
+
+// The result for a file which was created and deleted before it was
+// sent; it never had a UID, so it's given one.
+func createdItem(write *coalescedOperation) map[string]interface{} {
+	uid := uuid.NewString()
+	return map[string]interface{}{
+		"uid":    uid,
+		"id":     uid,
+		"path":   operationPaths(write.Operation)[0],
+		"name":   operationString(write.Operation, "name"),
+		"is_dir": false,
+		"size":   write.size(),
+	}
+}
+
+// Returns the size of the contents the operation writes.
+func (op *coalescedOperation) size() int64 {
+	if op.upload == nil {
+		return 0
+	}
+	return op.upload.Size
+}
@@ -0,0 +1,223 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package engine
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/putersdk"
+)
+
+func write(dir, name, contents string) *OperationRequest {
+	upload := putersdk.BytesUpload([]byte(contents))
+	return &OperationRequest{
+		Operation: putersdk.Operation{"op": "write", "path": dir, "name": name, "overwrite": true},
+		upload:    &upload,
+	}
+}
+
+func create(dir, name string) *OperationRequest {
+	req := write(dir, name, "")
+	req.Operation[OperationCreates] = true
+	return req
+}
+
+func mkdir(parent, name string) *OperationRequest {
+	return &OperationRequest{Operation: putersdk.Operation{"op": "mkdir", "parent": parent, "path": name}}
+}
+
+func remove(path string) *OperationRequest {
+	return &OperationRequest{Operation: putersdk.Operation{"op": "delete", "path": path}}
+}
+
+func move(source, parent, name string) *OperationRequest {
+	return &OperationRequest{Operation: putersdk.Operation{"op": "move", "source": source, "parent": parent, "name": name}}
+}
+
+// Describes an operation as e.g. "write /a/x=data".
+func describeOperation(op *coalescedOperation) string {
+	switch op.Operation["op"] {
+	case "write":
+		reader, _ := op.upload.Open()
+		contents, _ := io.ReadAll(reader)
+		return fmt.Sprintf("write %s=%s", operationPaths(op.Operation)[0], contents)
+	case "move":
+		paths := operationPaths(op.Operation)
+		return fmt.Sprintf("move %s %s", paths[0], paths[1])
+	}
+	return fmt.Sprintf("%v %s", op.Operation["op"], operationPaths(op.Operation)[0])
+}
+
+func TestCoalesceOperations(t *testing.T) {
+	tests := []struct {
+		name     string
+		requests []*OperationRequest
+		expected []string
+		settled  int
+	}{
+		{
+			"repeated writes send the last",
+			[]*OperationRequest{write("/a", "x", "1"), write("/a", "y", "y"), write("/a", "x", "2")},
+			[]string{"write /a/x=2", "write /a/y=y"},
+			0,
+		},
+		{
+			"create and delete cancel out",
+			[]*OperationRequest{create("/a", "x"), write("/a", "x", "data"), remove("/a/x")},
+			[]string{},
+			3,
+		},
+		{
+			"delete replaces writes to an existing file",
+			[]*OperationRequest{write("/a", "x", "data"), remove("/a/x")},
+			[]string{"delete /a/x"},
+			0,
+		},
+		{
+			"create, write and rename write the final name",
+			[]*OperationRequest{create("/a", "x.tmp"), write("/a", "x.tmp", "data"), move("/a/x.tmp", "/a", "x")},
+			[]string{"write /a/x=data"},
+			0,
+		},
+		{
+			"rename of a file created earlier is kept",
+			[]*OperationRequest{write("/a", "x.tmp", "data"), move("/a/x.tmp", "/a", "x")},
+			[]string{"write /a/x.tmp=data", "move /a/x.tmp /a/x"},
+			0,
+		},
+		{
+			"rename into a directory made after the write is kept",
+			[]*OperationRequest{write("/a", "x.tmp", "data"), mkdir("/a", "b"), move("/a/x.tmp", "/a/b", "x")},
+			[]string{"write /a/x.tmp=data", "mkdir /a/b", "move /a/x.tmp /a/b/x"},
+			0,
+		},
+		{
+			"writes around a rename of their directory are kept",
+			[]*OperationRequest{write("/a", "x", "1"), move("/a", "/", "b"), write("/a", "x", "2")},
+			[]string{"write /a/x=1", "move /a /b", "write /a/x=2"},
+			0,
+		},
+		{
+			"rename of a file not written is kept",
+			[]*OperationRequest{move("/a/x", "/a", "y"), remove("/a/z")},
+			[]string{"move /a/x /a/y", "delete /a/z"},
+			0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops, settled := coalesceOperations(tt.requests)
+
+			got := []string{}
+			resolved := len(settled)
+			for _, op := range ops {
+				got = append(got, describeOperation(op))
+				resolved += len(op.requests)
+			}
+			if strings.Join(got, "; ") != strings.Join(tt.expected, "; ") {
+				t.Errorf("expected [%s], got [%s]", strings.Join(tt.expected, "; "), strings.Join(got, "; "))
+			}
+			if len(settled) != tt.settled {
+				t.Errorf("expected %d settled requests, got %d", tt.settled, len(settled))
+			}
+			if resolved != len(tt.requests) {
+				t.Errorf("expected all %d requests to be resolved, got %d", len(tt.requests), resolved)
+			}
+		})
+	}
+}
+
+func TestOperationServiceCoalesces(t *testing.T) {
+	var lock sync.Mutex
+	batches := [][]map[string]interface{}{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/batch" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		reader := multipart.NewReader(r.Body, params["boundary"])
+		operations := []map[string]interface{}{}
+		results := []map[string]interface{}{}
+		for {
+			part, err := reader.NextPart()
+			if err != nil {
+				break
+			}
+			if part.FormName() != "operation" {
+				continue
+			}
+			operation := map[string]interface{}{}
+			json.NewDecoder(part).Decode(&operation)
+			operations = append(operations, operation)
+			results = append(results, map[string]interface{}{
+				"path": fmt.Sprintf("%s/%s", operation["path"], operation["name"]),
+			})
+		}
+		lock.Lock()
+		batches = append(batches, operations)
+		lock.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+	}))
+	defer server.Close()
+
+	sdk := &putersdk.PuterSDK{Url: server.URL, PuterAuthToken: "token"}
+	sdk.Init()
+	svc := &OperationService{SDK: sdk}
+	svc.Init(nil)
+
+	// enqueued together so they're sent in one batch
+	ctx := context.Background()
+	svc.Pause()
+	writePromise := svc.EnqueueOperationRequest(ctx, create("/a", "x.tmp").Operation, []byte("data"))
+	time.Sleep(10 * time.Millisecond)
+	movePromise := svc.EnqueueOperationRequest(ctx, move("/a/x.tmp", "/a", "x").Operation, nil)
+	time.Sleep(10 * time.Millisecond)
+	svc.Resume()
+
+	for name, promise := range map[string]OperationRequestPromise{"write": writePromise, "move": movePromise} {
+		select {
+		case resp := <-promise.Await:
+			if resp.Data["error"] != nil || resp.Data["path"] != "/a/x" {
+				t.Errorf("expected the %s to resolve with /a/x, got %v", name, resp.Data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("the %s wasn't resolved", name)
+		}
+	}
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(batches) != 1 || len(batches[0]) != 1 || batches[0][0]["name"] != "x" {
+		t.Errorf("expected one write to /a/x, got %v", batches)
+	}
+}
@@ -0,0 +1,46 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package engine
+
+import "github.com/HeyPuter/puter-fuse/metrics"
+
+// Cache counters are labelled with the cache: "tree", "blob" or "write".
+var (
+	CacheHits = metrics.NewCounterVec("puterfuse_cache_hits_total",
+		"Lookups answered from a cache.", "cache")
+	CacheMisses = metrics.NewCounterVec("puterfuse_cache_misses_total",
+		"Lookups a cache couldn't answer.", "cache")
+	CacheEvictions = metrics.NewCounterVec("puterfuse_cache_evictions_total",
+		"Entries removed from a cache by expiry, release or invalidation.", "cache")
+)
+
+var (
+	operationQueueDepth = metrics.NewGaugeVec("puterfuse_operation_queue_depth",
+		"Operations waiting to be sent in a batch.")
+	batchSize = metrics.NewHistogramVec("puterfuse_batch_size",
+		"Operations per batch sent to Puter.",
+		[]float64{1, 2, 5, 10, 20, 50, 100})
+	batchDuration = metrics.NewHistogramVec("puterfuse_batch_duration_seconds",
+		"Time to send a batch and receive its results.",
+		metrics.DurationBuckets)
+	batchErrors = metrics.NewCounterVec("puterfuse_batch_errors_total",
+		"Batches which failed as a whole.")
+	operationsCoalesced = metrics.NewCounterVec("puterfuse_operations_coalesced_total",
+		"Operations combined with others in the queue rather than sent.")
+)
@@ -21,19 +21,22 @@
 package fao
 
 import (
+	"context"
 	"io"
 )
 
 type FAO interface {
-	Stat(path string) (NodeInfo, bool, error)
-	ReadDir(path string) ([]NodeInfo, error)
-	Read(path string, dest []byte, off int64) (int, error)
-	Write(path string, src []byte, off int64) (int, error)
-	Create(path string, name string) (NodeInfo, error)
-	Truncate(path string, size uint64) error
-	MkDir(path string, name string) (NodeInfo, error)
-	Symlink(parent string, name string, target string) (NodeInfo, error)
-	Unlink(path string) error
-	Move(source string, parent string, name string) error
-	ReadAll(path string) (io.ReadCloser, error)
+	Stat(ctx context.Context, path string) (NodeInfo, bool, error)
+	ReadDir(ctx context.Context, path string) ([]NodeInfo, error)
+	Read(ctx context.Context, path string, dest []byte, off int64) (int, error)
+	Write(ctx context.Context, path string, src []byte, off int64) (int, error)
+	Create(ctx context.Context, path string, name string) (NodeInfo, error)
+	Truncate(ctx context.Context, path string, size uint64) error
+	MkDir(ctx context.Context, path string, name string) (NodeInfo, error)
+	Symlink(ctx context.Context, parent string, name string, target string) (NodeInfo, error)
+	Unlink(ctx context.Context, path string) error
+	Move(ctx context.Context, source string, parent string, name string) error
+	ReadAll(ctx context.Context, path string) (io.ReadCloser, error)
+	Fsync(ctx context.Context, path string) error
+	TakeError(ctx context.Context, path string) error
 }
@@ -21,6 +21,7 @@
 package fao
 
 import (
+	"context"
 	"io"
 )
 
@@ -36,38 +37,44 @@ func CreateProxyFAO(params P_CreateProxyFAO) *ProxyFAO {
 	return &ProxyFAO{params}
 }
 
-func (p *ProxyFAO) Stat(path string) (NodeInfo, bool, error) {
-	return p.Delegate.Stat(path)
+func (p *ProxyFAO) Stat(ctx context.Context, path string) (NodeInfo, bool, error) {
+	return p.Delegate.Stat(ctx, path)
 }
-func (p *ProxyFAO) ReadDir(path string) ([]NodeInfo, error) {
-	return p.Delegate.ReadDir(path)
+func (p *ProxyFAO) ReadDir(ctx context.Context, path string) ([]NodeInfo, error) {
+	return p.Delegate.ReadDir(ctx, path)
 }
-func (p *ProxyFAO) Read(path string, dest []byte, off int64) (int, error) {
-	return p.Delegate.Read(path, dest, off)
+func (p *ProxyFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	return p.Delegate.Read(ctx, path, dest, off)
 }
-func (p *ProxyFAO) Write(path string, src []byte, off int64) (int, error) {
-	return p.Delegate.Write(path, src, off)
+func (p *ProxyFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	return p.Delegate.Write(ctx, path, src, off)
 }
-func (p *ProxyFAO) Create(path string, name string) (NodeInfo, error) {
-	return p.Delegate.Create(path, name)
+func (p *ProxyFAO) Create(ctx context.Context, path string, name string) (NodeInfo, error) {
+	return p.Delegate.Create(ctx, path, name)
 }
-func (p *ProxyFAO) Truncate(path string, size uint64) error {
-	return p.Delegate.Truncate(path, size)
+func (p *ProxyFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	return p.Delegate.Truncate(ctx, path, size)
 }
-func (p *ProxyFAO) MkDir(path string, name string) (NodeInfo, error) {
-	return p.Delegate.MkDir(path, name)
+func (p *ProxyFAO) MkDir(ctx context.Context, path string, name string) (NodeInfo, error) {
+	return p.Delegate.MkDir(ctx, path, name)
 }
-func (p *ProxyFAO) Symlink(parent string, name string, target string) (NodeInfo, error) {
-	return p.Delegate.Symlink(parent, name, target)
+func (p *ProxyFAO) Symlink(ctx context.Context, parent string, name string, target string) (NodeInfo, error) {
+	return p.Delegate.Symlink(ctx, parent, name, target)
 }
-func (p *ProxyFAO) Unlink(path string) error {
-	return p.Delegate.Unlink(path)
+func (p *ProxyFAO) Unlink(ctx context.Context, path string) error {
+	return p.Delegate.Unlink(ctx, path)
 }
-func (p *ProxyFAO) Move(source string, parent string, name string) error {
-	return p.Delegate.Move(source, parent, name)
+func (p *ProxyFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	return p.Delegate.Move(ctx, source, parent, name)
 }
-func (p *ProxyFAO) ReadAll(path string) (io.ReadCloser, error) {
-	return p.Delegate.ReadAll(path)
+func (p *ProxyFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	return p.Delegate.ReadAll(ctx, path)
+}
+func (p *ProxyFAO) Fsync(ctx context.Context, path string) error {
+	return p.Delegate.Fsync(ctx, path)
+}
+func (p *ProxyFAO) TakeError(ctx context.Context, path string) error {
+	return p.Delegate.TakeError(ctx, path)
 }
 
 func (p *ProxyFAO) SetDelegate(delegate FAO) {
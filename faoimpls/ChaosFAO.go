@@ -0,0 +1,332 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+)
+
+// ChaosLatency is a distribution of delays added to a call.
+//   - "fixed": always Mean
+//   - "uniform": between Min and Max
+//   - "exponential": Min plus an exponential delay averaging Mean,
+//     capped at Max if it's set
+type ChaosLatency struct {
+	Distribution string
+	Min          time.Duration
+	Max          time.Duration
+	Mean         time.Duration
+}
+
+// ChaosRule is the faults injected into calls to one method. Rates are
+// probabilities from 0 to 1.
+type ChaosRule struct {
+	ErrorRate float64
+	// returned for injected errors; EIO if unset
+	Errno   syscall.Errno
+	Latency ChaosLatency
+	// hang until the caller's context is cancelled
+	HangRate float64
+	// Read returns fewer bytes than it could; ReadAll's stream fails
+	// part way through
+	ShortReadRate float64
+	// Write reports success without writing anything
+	DropRate float64
+}
+
+type P_ChaosFAO struct {
+	Seed int64
+	// applies to methods which aren't in Methods
+	Default ChaosRule
+	Methods map[string]ChaosRule
+}
+
+// ChaosFAO injects errors, latency, short reads, dropped writes and hangs
+// into the layer it wraps. Faults are drawn from an RNG seeded with
+// Seed, so a run with the same seed and the same sequence of calls
+// sees the same faults.
+type ChaosFAO struct {
+	fao.ProxyFAO
+	P_ChaosFAO
+
+	rng  *rand.Rand
+	lock sync.Mutex
+}
+
+func CreateChaosFAO(delegate fao.FAO, params P_ChaosFAO) *ChaosFAO {
+	return &ChaosFAO{
+		ProxyFAO: fao.ProxyFAO{
+			P_CreateProxyFAO: fao.P_CreateProxyFAO{
+				Delegate: delegate,
+			},
+		},
+		P_ChaosFAO: params,
+		rng:        rand.New(rand.NewSource(params.Seed)),
+	}
+}
+
+// chaosOutcome is what happens to one call.
+type chaosOutcome struct {
+	delay     time.Duration
+	hang      bool
+	fail      bool
+	shortRead bool
+	drop      bool
+	// where a short read is cut off, as a fraction of its length
+	cut float64
+}
+
+func (r ChaosRule) errno() syscall.Errno {
+	if r.Errno == 0 {
+		return syscall.EIO
+	}
+	return r.Errno
+}
+
+// Returns a value in [0, 1) made from exactly one value of the source.
+// rand's Float64 and ExpFloat64 sometimes draw again.
+func (f *ChaosFAO) uniform() float64 {
+	return float64(f.rng.Int63()>>10) / (1 << 53)
+}
+
+// Every call draws the same number of values, so one call's outcome
+// doesn't change the outcomes of the calls after it.
+func (f *ChaosFAO) draw(rule ChaosRule) chaosOutcome {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	latency := rule.Latency
+	outcome := chaosOutcome{}
+	u := f.uniform()
+	switch latency.Distribution {
+	case "fixed":
+		outcome.delay = latency.Mean
+	case "uniform":
+		span := float64(latency.Max - latency.Min)
+		outcome.delay = latency.Min + time.Duration(u*span)
+	case "exponential":
+		// by inversion, so it takes one value like the others
+		exp := -math.Log(1 - u)
+		outcome.delay = latency.Min + time.Duration(exp*float64(latency.Mean))
+		if latency.Max > 0 && outcome.delay > latency.Max {
+			outcome.delay = latency.Max
+		}
+	}
+	outcome.hang = f.uniform() < rule.HangRate
+	outcome.fail = f.uniform() < rule.ErrorRate
+	outcome.shortRead = f.uniform() < rule.ShortReadRate
+	outcome.drop = f.uniform() < rule.DropRate
+	outcome.cut = f.uniform()
+	return outcome
+}
+
+// Applies the latency, hang and error drawn for a call. The outcome is
+// returned for the faults which only some methods have.
+func (f *ChaosFAO) inject(ctx context.Context, method string) (chaosOutcome, error) {
+	rule, ok := f.Methods[method]
+	if !ok {
+		rule = f.Default
+	}
+	outcome := f.draw(rule)
+
+	if outcome.delay > 0 {
+		select {
+		case <-time.After(outcome.delay):
+		case <-ctx.Done():
+			return outcome, fao.Errorf(syscall.EINTR, "chaos: %s cancelled: %s", method, ctx.Err())
+		}
+	}
+	if outcome.hang {
+		logger.S("chaos").Debug("%s hangs until cancelled", method)
+		<-ctx.Done()
+		return outcome, fao.Errorf(syscall.EINTR, "chaos: %s hung until cancelled: %s", method, ctx.Err())
+	}
+	if outcome.fail {
+		logger.S("chaos").Debug("%s fails with %s", method, rule.errno())
+		return outcome, fao.Errorf(rule.errno(), "chaos: injected %s error", method)
+	}
+	return outcome, nil
+}
+
+func (f *ChaosFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	if _, err := f.inject(ctx, "Stat"); err != nil {
+		return fao.NodeInfo{}, false, err
+	}
+	return f.Delegate.Stat(ctx, path)
+}
+
+func (f *ChaosFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	if _, err := f.inject(ctx, "ReadDir"); err != nil {
+		return nil, err
+	}
+	return f.Delegate.ReadDir(ctx, path)
+}
+
+func (f *ChaosFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	outcome, err := f.inject(ctx, "Read")
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.Delegate.Read(ctx, path, dest, off)
+	if err == nil && outcome.shortRead && n > 1 {
+		n = 1 + int(outcome.cut*float64(n-1))
+		logger.S("chaos").Debug("short read of %d bytes from %s", n, path)
+	}
+	return n, err
+}
+
+func (f *ChaosFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	outcome, err := f.inject(ctx, "Write")
+	if err != nil {
+		return 0, err
+	}
+	if outcome.drop {
+		logger.S("chaos").Debug("dropped write of %d bytes to %s", len(src), path)
+		return len(src), nil
+	}
+	return f.Delegate.Write(ctx, path, src, off)
+}
+
+func (f *ChaosFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	if _, err := f.inject(ctx, "Create"); err != nil {
+		return fao.NodeInfo{}, err
+	}
+	return f.Delegate.Create(ctx, path, name)
+}
+
+func (f *ChaosFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	if _, err := f.inject(ctx, "Truncate"); err != nil {
+		return err
+	}
+	return f.Delegate.Truncate(ctx, path, size)
+}
+
+func (f *ChaosFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	if _, err := f.inject(ctx, "MkDir"); err != nil {
+		return fao.NodeInfo{}, err
+	}
+	return f.Delegate.MkDir(ctx, path, name)
+}
+
+func (f *ChaosFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
+	if _, err := f.inject(ctx, "Symlink"); err != nil {
+		return fao.NodeInfo{}, err
+	}
+	return f.Delegate.Symlink(ctx, parent, name, target)
+}
+
+func (f *ChaosFAO) Unlink(ctx context.Context, path string) error {
+	if _, err := f.inject(ctx, "Unlink"); err != nil {
+		return err
+	}
+	return f.Delegate.Unlink(ctx, path)
+}
+
+func (f *ChaosFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	if _, err := f.inject(ctx, "Move"); err != nil {
+		return err
+	}
+	return f.Delegate.Move(ctx, source, parent, name)
+}
+
+// A short read fails the stream with io.ErrUnexpectedEOF part way
+// through, as a dropped connection would.
+func (f *ChaosFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	outcome, err := f.inject(ctx, "ReadAll")
+	if err != nil {
+		return nil, err
+	}
+	reader, err := f.Delegate.ReadAll(ctx, path)
+	if err != nil || !outcome.shortRead {
+		return reader, err
+	}
+
+	data, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		return nil, err
+	}
+	cut := int(outcome.cut * float64(len(data)))
+	logger.S("chaos").Debug("stream of %s cut off after %d of %d bytes", path, cut, len(data))
+	return io.NopCloser(io.MultiReader(
+		bytes.NewReader(data[:cut]),
+		&errReader{err: io.ErrUnexpectedEOF},
+	)), nil
+}
+
+func (f *ChaosFAO) Fsync(ctx context.Context, path string) error {
+	if _, err := f.inject(ctx, "Fsync"); err != nil {
+		return err
+	}
+	return f.Delegate.Fsync(ctx, path)
+}
+
+// Nothing is sent, so no faults are injected.
+func (f *ChaosFAO) TakeError(ctx context.Context, path string) error {
+	return f.Delegate.TakeError(ctx, path)
+}
+
+type errReader struct {
+	err error
+}
+
+func (r *errReader) Read(p []byte) (int, error) {
+	return 0, r.err
+}
+
+var errnoNames = map[string]syscall.Errno{
+	"EACCES":       syscall.EACCES,
+	"EAGAIN":       syscall.EAGAIN,
+	"EBUSY":        syscall.EBUSY,
+	"ECONNRESET":   syscall.ECONNRESET,
+	"EEXIST":       syscall.EEXIST,
+	"EHOSTUNREACH": syscall.EHOSTUNREACH,
+	"EINTR":        syscall.EINTR,
+	"EIO":          syscall.EIO,
+	"ENETUNREACH":  syscall.ENETUNREACH,
+	"ENOENT":       syscall.ENOENT,
+	"ENOSPC":       syscall.ENOSPC,
+	"ENOTCONN":     syscall.ENOTCONN,
+	"EPERM":        syscall.EPERM,
+	"EROFS":        syscall.EROFS,
+	"ETIMEDOUT":    syscall.ETIMEDOUT,
+}
+
+// Parses an errno given by name, such as "ETIMEDOUT", or by number.
+func ParseErrno(s string) (syscall.Errno, error) {
+	if errno, ok := errnoNames[strings.ToUpper(s)]; ok {
+		return errno, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return syscall.Errno(n), nil
+	}
+	return 0, fmt.Errorf("unknown errno %q", s)
+}
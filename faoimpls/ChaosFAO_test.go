@@ -0,0 +1,167 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+)
+
+func createChaosTestFAO(t *testing.T, params P_ChaosFAO) *ChaosFAO {
+	ctx := context.Background()
+	mem := CreateMemFAO()
+	mem.Create(ctx, "/", "file")
+	mem.Write(ctx, "/file", []byte("0123456789"), 0)
+	return CreateChaosFAO(mem, params)
+}
+
+func TestChaosFAO(t *testing.T) {
+	testCases := []struct {
+		name  string
+		rule  ChaosRule
+		check func(t *testing.T, f *ChaosFAO)
+	}{
+		{"error with errno", ChaosRule{ErrorRate: 1, Errno: syscall.ETIMEDOUT},
+			func(t *testing.T, f *ChaosFAO) {
+				_, _, err := f.Stat(context.Background(), "/file")
+				var faoErr *fao.FAOError
+				if !errors.As(err, &faoErr) || faoErr.Errno != syscall.ETIMEDOUT {
+					t.Fatalf("expected ETIMEDOUT; got %v", err)
+				}
+			}},
+		{"short read", ChaosRule{ShortReadRate: 1},
+			func(t *testing.T, f *ChaosFAO) {
+				n, err := f.Read(context.Background(), "/file", make([]byte, 10), 0)
+				if err != nil || n < 1 || n >= 10 {
+					t.Fatalf("expected a short read; got %d, %v", n, err)
+				}
+			}},
+		{"cut stream", ChaosRule{ShortReadRate: 1},
+			func(t *testing.T, f *ChaosFAO) {
+				reader, err := f.ReadAll(context.Background(), "/file")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := io.ReadAll(reader); err != io.ErrUnexpectedEOF {
+					t.Fatalf("expected io.ErrUnexpectedEOF; got %v", err)
+				}
+			}},
+		{"dropped write", ChaosRule{DropRate: 1},
+			func(t *testing.T, f *ChaosFAO) {
+				n, err := f.Write(context.Background(), "/file", []byte("abc"), 0)
+				if err != nil || n != 3 {
+					t.Fatalf("expected the write to report success; got %d, %v", n, err)
+				}
+				dest := make([]byte, 3)
+				f.Delegate.Read(context.Background(), "/file", dest, 0)
+				if string(dest) != "012" {
+					t.Fatalf("expected the write to be dropped; got %q", dest)
+				}
+			}},
+		{"hang until cancelled", ChaosRule{HangRate: 1},
+			func(t *testing.T, f *ChaosFAO) {
+				ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+				defer cancel()
+				_, err := f.ReadDir(ctx, "/")
+				if ctx.Err() == nil {
+					t.Fatalf("expected ReadDir to wait for the context")
+				}
+				var faoErr *fao.FAOError
+				if !errors.As(err, &faoErr) || faoErr.Errno != syscall.EINTR {
+					t.Fatalf("expected EINTR; got %v", err)
+				}
+			}},
+		{"fixed latency", ChaosRule{Latency: ChaosLatency{Distribution: "fixed", Mean: 20 * time.Millisecond}},
+			func(t *testing.T, f *ChaosFAO) {
+				start := time.Now()
+				f.Stat(context.Background(), "/file")
+				if time.Since(start) < 20*time.Millisecond {
+					t.Fatalf("expected Stat to be delayed")
+				}
+			}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.check(t, createChaosTestFAO(t, P_ChaosFAO{Seed: 1, Default: tc.rule}))
+		})
+	}
+
+	t.Run("per-method rules", func(t *testing.T) {
+		f := createChaosTestFAO(t, P_ChaosFAO{
+			Seed:    1,
+			Methods: map[string]ChaosRule{"Read": {ErrorRate: 1}},
+		})
+		if _, _, err := f.Stat(context.Background(), "/file"); err != nil {
+			t.Fatalf("expected Stat to succeed; got %v", err)
+		}
+		if _, err := f.Read(context.Background(), "/file", make([]byte, 1), 0); err == nil {
+			t.Fatalf("expected Read to fail")
+		}
+	})
+
+	t.Run("same seed, same faults", func(t *testing.T) {
+		failures := func() []bool {
+			f := createChaosTestFAO(t, P_ChaosFAO{Seed: 42, Default: ChaosRule{ErrorRate: 0.5}})
+			results := []bool{}
+			for i := 0; i < 32; i++ {
+				_, _, err := f.Stat(context.Background(), "/file")
+				results = append(results, err != nil)
+			}
+			return results
+		}
+		first, second := failures(), failures()
+		for i := range first {
+			if first[i] != second[i] {
+				t.Fatalf("call %d differs between runs", i)
+			}
+		}
+	})
+	t.Run("latency doesn't change later faults", func(t *testing.T) {
+		failures := func(latency ChaosLatency) []bool {
+			f := createChaosTestFAO(t, P_ChaosFAO{
+				Seed: 42,
+				Methods: map[string]ChaosRule{
+					"Stat": {Latency: latency},
+					"Read": {ErrorRate: 0.5},
+				},
+			})
+			results := []bool{}
+			for i := 0; i < 256; i++ {
+				f.Stat(context.Background(), "/file")
+				_, err := f.Read(context.Background(), "/file", make([]byte, 1), 0)
+				results = append(results, err != nil)
+			}
+			return results
+		}
+		fixed := failures(ChaosLatency{})
+		exponential := failures(ChaosLatency{Distribution: "exponential", Mean: time.Nanosecond})
+		for i := range fixed {
+			if fixed[i] != exponential[i] {
+				t.Fatalf("read %d differs with exponential latency", i)
+			}
+		}
+	})
+}
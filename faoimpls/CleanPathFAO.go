@@ -19,6 +19,7 @@
 package faoimpls
 
 import (
+	"context"
 	"io"
 	"path/filepath"
 
@@ -29,58 +30,68 @@ type CleanPathFAO struct {
 	fao.ProxyFAO
 }
 
-func (f *CleanPathFAO) Stat(path string) (fao.NodeInfo, bool, error) {
+func (f *CleanPathFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
 	path = filepath.Clean(path)
-	return f.Delegate.Stat(path)
+	return f.Delegate.Stat(ctx, path)
 }
 
-func (f *CleanPathFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
+func (f *CleanPathFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
 	path = filepath.Clean(path)
-	return f.Delegate.ReadDir(path)
+	return f.Delegate.ReadDir(ctx, path)
 }
 
-func (f *CleanPathFAO) Read(path string, dest []byte, off int64) (int, error) {
+func (f *CleanPathFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
 	path = filepath.Clean(path)
-	return f.Delegate.Read(path, dest, off)
+	return f.Delegate.Read(ctx, path, dest, off)
 }
 
-func (f *CleanPathFAO) Write(path string, src []byte, off int64) (int, error) {
+func (f *CleanPathFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
 	path = filepath.Clean(path)
-	return f.Delegate.Write(path, src, off)
+	return f.Delegate.Write(ctx, path, src, off)
 }
 
-func (f *CleanPathFAO) Create(path string, name string) (fao.NodeInfo, error) {
+func (f *CleanPathFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
 	path = filepath.Clean(path)
-	return f.Delegate.Create(path, name)
+	return f.Delegate.Create(ctx, path, name)
 }
 
-func (f *CleanPathFAO) Truncate(path string, size uint64) error {
+func (f *CleanPathFAO) Truncate(ctx context.Context, path string, size uint64) error {
 	path = filepath.Clean(path)
-	return f.Delegate.Truncate(path, size)
+	return f.Delegate.Truncate(ctx, path, size)
 }
 
-func (f *CleanPathFAO) MkDir(path string, name string) (fao.NodeInfo, error) {
+func (f *CleanPathFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
 	path = filepath.Clean(path)
-	return f.Delegate.MkDir(path, name)
+	return f.Delegate.MkDir(ctx, path, name)
 }
 
-func (f *CleanPathFAO) Symlink(parent string, name string, target string) (fao.NodeInfo, error) {
+func (f *CleanPathFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
 	parent = filepath.Clean(parent)
-	return f.Delegate.Symlink(parent, name, target)
+	return f.Delegate.Symlink(ctx, parent, name, target)
 }
 
-func (f *CleanPathFAO) Unlink(path string) error {
+func (f *CleanPathFAO) Unlink(ctx context.Context, path string) error {
 	path = filepath.Clean(path)
-	return f.Delegate.Unlink(path)
+	return f.Delegate.Unlink(ctx, path)
 }
 
-func (f *CleanPathFAO) Move(source string, parent string, name string) error {
+func (f *CleanPathFAO) Move(ctx context.Context, source string, parent string, name string) error {
 	source = filepath.Clean(source)
 	parent = filepath.Clean(parent)
-	return f.Delegate.Move(source, parent, name)
+	return f.Delegate.Move(ctx, source, parent, name)
 }
 
-func (f *CleanPathFAO) ReadAll(path string) (io.ReadCloser, error) {
+func (f *CleanPathFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
 	path = filepath.Clean(path)
-	return f.Delegate.ReadAll(path)
+	return f.Delegate.ReadAll(ctx, path)
+}
+
+func (f *CleanPathFAO) Fsync(ctx context.Context, path string) error {
+	path = filepath.Clean(path)
+	return f.Delegate.Fsync(ctx, path)
+}
+
+func (f *CleanPathFAO) TakeError(ctx context.Context, path string) error {
+	path = filepath.Clean(path)
+	return f.Delegate.TakeError(ctx, path)
 }
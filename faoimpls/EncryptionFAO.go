@@ -0,0 +1,392 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/HeyPuter/puter-fuse/encryption"
+	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/lang"
+	"github.com/HeyPuter/puter-fuse/streamutil"
+)
+
+type P_EncryptionFAO struct {
+	Keys *encryption.Keys
+	// the encrypted subtrees; everything else is passed through
+	Roots []string
+	// also encrypt the names of nodes in the subtrees, except the roots
+	EncryptNames bool
+}
+
+// EncryptionFAO encrypts the contents of files in some subtrees, and
+// optionally their names, so the delegate only sees ciphertext. See the
+// encryption package for the format. Sizes are reported as the sizes of
+// the plaintext. Symlink targets aren't encrypted.
+type EncryptionFAO struct {
+	fao.ProxyFAO
+	P_EncryptionFAO
+
+	// a write reads and rewrites the chunks it touches, so writes to
+	// the same file are serialised
+	writeLocks *lang.CacheStampedeMap[string]
+}
+
+func CreateEncryptionFAO(delegate fao.FAO, params P_EncryptionFAO) *EncryptionFAO {
+	ins := &EncryptionFAO{P_EncryptionFAO: params}
+	ins.writeLocks = lang.CreateCacheStampedeMap[string]()
+	ins.Delegate = delegate
+	return ins
+}
+
+// Returns the encrypted subtree 'path' is in, if any.
+func (f *EncryptionFAO) root(path string) (string, bool) {
+	for _, root := range f.Roots {
+		if under(path, root) {
+			return root, true
+		}
+	}
+	return "", false
+}
+
+func (f *EncryptionFAO) encrypted(path string) bool {
+	_, ok := f.root(path)
+	return ok
+}
+
+// Returns the delegate's path for 'path'.
+func (f *EncryptionFAO) cipherPath(path string) string {
+	root, ok := f.root(path)
+	if !ok || !f.EncryptNames || path == root {
+		return path
+	}
+	cipherPath := root
+	for _, name := range strings.Split(strings.TrimPrefix(path, root+"/"), "/") {
+		cipherPath = filepath.Join(cipherPath, f.Keys.EncryptName(name))
+	}
+	return cipherPath
+}
+
+// Returns the delegate's parent and name for a new node.
+func (f *EncryptionFAO) cipherChild(parent, name string) (string, string) {
+	cipherPath := f.cipherPath(filepath.Join(parent, name))
+	return filepath.Dir(cipherPath), filepath.Base(cipherPath)
+}
+
+// Describes a node of the delegate by its plaintext.
+func (f *EncryptionFAO) plainNode(node fao.NodeInfo, path string) fao.NodeInfo {
+	node.Path = path
+	node.Name = filepath.Base(path)
+	if f.encrypted(path) && !bool(node.IsDir) && !bool(node.IsSymlink) {
+		node.Size = uint64(encryption.PlainSize(int64(node.Size)))
+	}
+	return node
+}
+
+func corrupt(path string, err error) error {
+	return fao.Errorf(syscall.EIO, "cannot decrypt %s: %s", path, err)
+}
+
+func (f *EncryptionFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	node, exists, err := f.Delegate.Stat(ctx, f.cipherPath(path))
+	if err != nil || !exists {
+		return node, exists, err
+	}
+	return f.plainNode(node, path), true, nil
+}
+
+func (f *EncryptionFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	nodes, err := f.Delegate.ReadDir(ctx, f.cipherPath(path))
+	if err != nil {
+		return nil, err
+	}
+
+	// only names below a root are encrypted
+	encryptedNames := f.EncryptNames && f.encrypted(path)
+
+	plainNodes := make([]fao.NodeInfo, 0, len(nodes))
+	for _, node := range nodes {
+		name := node.Name
+		if encryptedNames {
+			name, err = f.Keys.DecryptName(node.Name)
+			if err != nil {
+				logger.S("encryption").Debug("skipping %s in %s: %s", node.Name, path, err)
+				continue
+			}
+		}
+		plainNodes = append(plainNodes, f.plainNode(node, filepath.Join(path, name)))
+	}
+	return plainNodes, nil
+}
+
+// Reads the header of an encrypted file; it's nil for an empty file.
+func (f *EncryptionFAO) readHeader(ctx context.Context, path string) (*encryption.Header, error) {
+	data := make([]byte, encryption.HeaderSize)
+	n, err := f.Delegate.Read(ctx, f.cipherPath(path), data, 0)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	header, err := f.Keys.ParseHeader(data[:n])
+	if err != nil {
+		return nil, corrupt(path, err)
+	}
+	return header, nil
+}
+
+// Decrypts the chunks in 'sealed', starting with the one at 'first'.
+func openChunks(header *encryption.Header, first int64, sealed []byte, endsFile bool) ([]byte, error) {
+	plaintext := []byte{}
+	for index := first; len(sealed) > 0; index++ {
+		size := min(len(sealed), encryption.SealedChunkSize)
+		chunk, err := header.Open(index, endsFile && size == len(sealed), sealed[:size])
+		if err != nil {
+			return nil, err
+		}
+		plaintext = append(plaintext, chunk...)
+		sealed = sealed[size:]
+	}
+	return plaintext, nil
+}
+
+func (f *EncryptionFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	if !f.encrypted(path) {
+		return f.Delegate.Read(ctx, path, dest, off)
+	}
+	if len(dest) == 0 {
+		return 0, nil
+	}
+
+	cipherPath := f.cipherPath(path)
+	header, err := f.readHeader(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	if header == nil {
+		return 0, nil
+	}
+
+	first := off / encryption.ChunkSize
+	last := (off + int64(len(dest)) - 1) / encryption.ChunkSize
+	size := encryption.ChunkOffset(last+1) - encryption.ChunkOffset(first)
+
+	// One more byte shows whether the last chunk ends the file, which
+	// is authenticated so a file can't be cut short.
+	sealed := make([]byte, size+1)
+	n, err := f.Delegate.Read(ctx, cipherPath, sealed, encryption.ChunkOffset(first))
+	if err != nil {
+		return 0, err
+	}
+	endsFile := int64(n) <= size
+	plaintext, err := openChunks(header, first, sealed[:min(int64(n), size)], endsFile)
+	if err != nil {
+		return 0, corrupt(path, err)
+	}
+
+	skip := off - first*encryption.ChunkSize
+	if skip >= int64(len(plaintext)) {
+		return 0, nil
+	}
+	return copy(dest, plaintext[skip:]), nil
+}
+
+// Writes 'data' at 'off' and resizes the file to 'size', or extends it
+// to fit 'data' if 'size' is -1, sealing every chunk which changes.
+func (f *EncryptionFAO) update(ctx context.Context, path string, data []byte, off int64, size int64) error {
+	defer f.writeLocks.Lock(path).Unlock()
+
+	cipherPath := f.cipherPath(path)
+	node, exists, err := f.Delegate.Stat(ctx, cipherPath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fao.Errorf(syscall.ENOENT, "node %s does not exist", path)
+	}
+
+	sealedSize := int64(node.Size)
+	oldSize := encryption.PlainSize(sealedSize)
+	if size < 0 {
+		size = max(oldSize, off+int64(len(data)))
+	}
+	oldChunks := int64(0)
+	var header *encryption.Header
+	if sealedSize > 0 {
+		oldChunks = encryption.Chunks(oldSize)
+		if header, err = f.readHeader(ctx, path); err != nil {
+			return err
+		}
+		if header == nil {
+			return corrupt(path, encryption.ErrCorrupt)
+		}
+	} else if header, err = f.Keys.NewHeader(); err != nil {
+		return err
+	}
+
+	// Chunks from the first written to the last written are sealed
+	// again, and so is the last chunk if it's no longer last.
+	newLast := encryption.Chunks(size) - 1
+	first, last := newLast+1, int64(-1)
+	if len(data) > 0 {
+		first = off / encryption.ChunkSize
+		last = (off + int64(len(data)) - 1) / encryption.ChunkSize
+	}
+	switch {
+	case oldChunks == 0:
+		first, last = 0, newLast
+	case size > oldSize:
+		first, last = min(first, oldChunks-1), newLast
+	case size < oldSize:
+		first, last = min(first, newLast), newLast
+	}
+	last = min(last, newLast)
+	if first > last {
+		return nil
+	}
+
+	// the plaintext of the chunks being sealed, as it was
+	plaintext := []byte{}
+	if first < oldChunks {
+		readLast := min(last, oldChunks-1)
+		sealed := make([]byte, encryption.ChunkOffset(readLast+1)-encryption.ChunkOffset(first))
+		n, err := f.Delegate.Read(ctx, cipherPath, sealed, encryption.ChunkOffset(first))
+		if err != nil {
+			return err
+		}
+		plaintext, err = openChunks(header, first, sealed[:n], readLast == oldChunks-1)
+		if err != nil {
+			return corrupt(path, err)
+		}
+	}
+
+	start := first * encryption.ChunkSize
+	end := min((last+1)*encryption.ChunkSize, size)
+	region := make([]byte, end-start)
+	copy(region, plaintext)
+	if len(data) > 0 {
+		copy(region[off-start:], data)
+	}
+
+	sealed := []byte{}
+	if oldChunks == 0 {
+		sealed = header.Bytes()
+	}
+	for index := first; index <= last; index++ {
+		chunkStart := (index - first) * encryption.ChunkSize
+		chunkEnd := min(chunkStart+encryption.ChunkSize, int64(len(region)))
+		sealed = append(sealed, header.Seal(index, index == newLast, region[chunkStart:chunkEnd])...)
+	}
+
+	writeOff := encryption.ChunkOffset(first)
+	if oldChunks == 0 {
+		writeOff = 0
+	}
+	if _, err := f.Delegate.Write(ctx, cipherPath, sealed, writeOff); err != nil {
+		return err
+	}
+	if size < oldSize {
+		return f.Delegate.Truncate(ctx, cipherPath, uint64(encryption.SealedSize(size)))
+	}
+	return nil
+}
+
+func (f *EncryptionFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	if !f.encrypted(path) {
+		return f.Delegate.Write(ctx, path, src, off)
+	}
+	if len(src) == 0 {
+		return 0, nil
+	}
+	if err := f.update(ctx, path, src, off, -1); err != nil {
+		return 0, err
+	}
+	return len(src), nil
+}
+
+func (f *EncryptionFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	parent, cipherName := f.cipherChild(path, name)
+	node, err := f.Delegate.Create(ctx, parent, cipherName)
+	if err != nil {
+		return node, err
+	}
+	return f.plainNode(node, filepath.Join(path, name)), nil
+}
+
+func (f *EncryptionFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	if !f.encrypted(path) || size == 0 {
+		// an empty file has no header
+		return f.Delegate.Truncate(ctx, f.cipherPath(path), size)
+	}
+	return f.update(ctx, path, nil, int64(size), int64(size))
+}
+
+func (f *EncryptionFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	parent, cipherName := f.cipherChild(path, name)
+	node, err := f.Delegate.MkDir(ctx, parent, cipherName)
+	if err != nil {
+		return node, err
+	}
+	return f.plainNode(node, filepath.Join(path, name)), nil
+}
+
+func (f *EncryptionFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
+	cipherParent, cipherName := f.cipherChild(parent, name)
+	node, err := f.Delegate.Symlink(ctx, cipherParent, cipherName, target)
+	if err != nil {
+		return node, err
+	}
+	return f.plainNode(node, filepath.Join(parent, name)), nil
+}
+
+func (f *EncryptionFAO) Unlink(ctx context.Context, path string) error {
+	return f.Delegate.Unlink(ctx, f.cipherPath(path))
+}
+
+// Nodes can't be moved in or out of an encrypted subtree, since they'd
+// have to be encrypted or decrypted; EXDEV makes `mv` copy them instead.
+func (f *EncryptionFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	dest := filepath.Join(parent, name)
+	if f.encrypted(source) != f.encrypted(dest) {
+		return fao.Errorf(syscall.EXDEV, "cannot move %s to %s across an encrypted subtree", source, dest)
+	}
+	cipherParent, cipherName := f.cipherChild(parent, name)
+	return f.Delegate.Move(ctx, f.cipherPath(source), cipherParent, cipherName)
+}
+
+func (f *EncryptionFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	reader, err := f.Delegate.ReadAll(ctx, f.cipherPath(path))
+	if err != nil || !f.encrypted(path) {
+		return reader, err
+	}
+	return streamutil.NewReaderReadCloser(f.Keys.NewReader(reader), reader), nil
+}
+
+func (f *EncryptionFAO) Fsync(ctx context.Context, path string) error {
+	return f.Delegate.Fsync(ctx, f.cipherPath(path))
+}
+
+func (f *EncryptionFAO) TakeError(ctx context.Context, path string) error {
+	return f.Delegate.TakeError(ctx, f.cipherPath(path))
+}
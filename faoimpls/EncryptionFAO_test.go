@@ -0,0 +1,289 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/encryption"
+	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/spf13/afero"
+)
+
+func TestEncryptionFAO(t *testing.T) {
+	ctx := context.Background()
+
+	create := func(t *testing.T, encryptNames bool) (*EncryptionFAO, fao.FAO) {
+		delegate, err := CreateLocalDirFAO(afero.NewMemMapFs(), "/remote")
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys, err := encryption.DeriveKeys(bytes.Repeat([]byte{1}, encryption.KeySize))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := CreateEncryptionFAO(delegate, P_EncryptionFAO{
+			Keys:         keys,
+			Roots:        []string{"/secret"},
+			EncryptNames: encryptNames,
+		})
+		if _, err := f.MkDir(ctx, "/", "secret"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.MkDir(ctx, "/", "plain"); err != nil {
+			t.Fatal(err)
+		}
+		return f, delegate
+	}
+
+	pattern := func(size int, seed byte) []byte {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i) ^ seed
+		}
+		return data
+	}
+
+	readAll := func(t *testing.T, f fao.FAO, path string) []byte {
+		reader, err := f.ReadAll(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	t.Run("offset writes and ranged reads", func(t *testing.T) {
+		f, delegate := create(t, false)
+		if _, err := f.Create(ctx, "/secret", "file"); err != nil {
+			t.Fatal(err)
+		}
+
+		// the expected plaintext is built up alongside
+		expected := []byte{}
+		writes := []struct {
+			off  int64
+			data []byte
+		}{
+			{0, pattern(100, 1)},
+			{90, pattern(2*encryption.ChunkSize, 2)},
+			{encryption.ChunkSize - 5, pattern(10, 3)},
+			{3*encryption.ChunkSize + 50, pattern(20, 4)},
+		}
+		for _, w := range writes {
+			if _, err := f.Write(ctx, "/secret/file", w.data, w.off); err != nil {
+				t.Fatal(err)
+			}
+			if end := w.off + int64(len(w.data)); end > int64(len(expected)) {
+				expected = append(expected, make([]byte, end-int64(len(expected)))...)
+			}
+			copy(expected[w.off:], w.data)
+		}
+
+		node, _, err := f.Stat(ctx, "/secret/file")
+		if err != nil || node.Size != uint64(len(expected)) {
+			t.Fatalf("expected a size of %d, got %d, %v", len(expected), node.Size, err)
+		}
+		sealed := readAll(t, delegate, "/secret/file")
+		if bytes.Contains(sealed, pattern(100, 1)[:32]) {
+			t.Errorf("expected the delegate to hold ciphertext")
+		}
+		if !bytes.Equal(readAll(t, f, "/secret/file"), expected) {
+			t.Errorf("expected ReadAll to return the plaintext")
+		}
+
+		for _, r := range []struct{ off, size int64 }{
+			{0, 10}, {encryption.ChunkSize - 3, 6}, {95, 2 * encryption.ChunkSize}, {int64(len(expected)) - 5, 100},
+		} {
+			dest := make([]byte, r.size)
+			n, err := f.Read(ctx, "/secret/file", dest, r.off)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := expected[r.off:min(r.off+r.size, int64(len(expected)))]; !bytes.Equal(dest[:n], want) {
+				t.Errorf("read of %d bytes at %d doesn't match", r.size, r.off)
+			}
+		}
+	})
+
+	t.Run("truncate", func(t *testing.T) {
+		f, _ := create(t, false)
+		if _, err := f.Create(ctx, "/secret", "file"); err != nil {
+			t.Fatal(err)
+		}
+		data := pattern(2*encryption.ChunkSize+10, 5)
+		if _, err := f.Write(ctx, "/secret/file", data, 0); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, size := range []int{encryption.ChunkSize + 3, encryption.ChunkSize, 3 * encryption.ChunkSize, 0, 7} {
+			if err := f.Truncate(ctx, "/secret/file", uint64(size)); err != nil {
+				t.Fatal(err)
+			}
+			if len(data) > size {
+				data = data[:size]
+			} else {
+				data = append(data, make([]byte, size-len(data))...)
+			}
+			if got := readAll(t, f, "/secret/file"); !bytes.Equal(got, data) {
+				t.Errorf("after truncating to %d, expected %d bytes, got %d", size, len(data), len(got))
+			}
+		}
+	})
+
+	t.Run("cut short", func(t *testing.T) {
+		f, delegate := create(t, false)
+		if _, err := f.Create(ctx, "/secret", "file"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(ctx, "/secret/file", pattern(2*encryption.ChunkSize, 6), 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := delegate.Truncate(ctx, "/secret/file", uint64(encryption.ChunkOffset(1))); err != nil {
+			t.Fatal(err)
+		}
+
+		dest := make([]byte, 100)
+		_, err := f.Read(ctx, "/secret/file", dest, 0)
+		var faoErr *fao.FAOError
+		if !errors.As(err, &faoErr) || faoErr.Errno != syscall.EIO {
+			t.Errorf("expected EIO, got %v", err)
+		}
+	})
+
+	t.Run("names", func(t *testing.T) {
+		f, delegate := create(t, true)
+		if _, err := f.MkDir(ctx, "/secret", "dir"); err != nil {
+			t.Fatal(err)
+		}
+		node, err := f.Create(ctx, "/secret/dir", "notes.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if node.Path != "/secret/dir/notes.txt" {
+			t.Errorf("expected the plaintext path, got %s", node.Path)
+		}
+		if _, err := f.Create(ctx, "/plain", "notes.txt"); err != nil {
+			t.Fatal(err)
+		}
+
+		names, err := delegate.ReadDir(ctx, "/secret")
+		if err != nil || len(names) != 1 || names[0].Name == "dir" {
+			t.Errorf("expected the delegate to hold an encrypted name, got %v, %v", names, err)
+		}
+		if _, exists, _ := delegate.Stat(ctx, "/plain/notes.txt"); !exists {
+			t.Errorf("expected names outside the subtree to be left alone")
+		}
+
+		nodes, err := f.ReadDir(ctx, "/secret/dir")
+		if err != nil || len(nodes) != 1 || nodes[0].Name != "notes.txt" || nodes[0].Path != "/secret/dir/notes.txt" {
+			t.Errorf("expected notes.txt, got %v, %v", nodes, err)
+		}
+
+		if err := f.Move(ctx, "/secret/dir/notes.txt", "/secret", "moved.txt"); err != nil {
+			t.Fatal(err)
+		}
+		if _, exists, _ := f.Stat(ctx, "/secret/moved.txt"); !exists {
+			t.Errorf("expected the file to be moved")
+		}
+	})
+
+	t.Run("moves across the subtree", func(t *testing.T) {
+		f, _ := create(t, false)
+		if _, err := f.Create(ctx, "/plain", "file"); err != nil {
+			t.Fatal(err)
+		}
+		err := f.Move(ctx, "/plain/file", "/secret", "file")
+		var faoErr *fao.FAOError
+		if !errors.As(err, &faoErr) || faoErr.Errno != syscall.EXDEV {
+			t.Errorf("expected EXDEV, got %v", err)
+		}
+	})
+
+	t.Run("writes to different files aren't serialised", func(t *testing.T) {
+		f, delegate := create(t, false)
+		blocking := &blockingWriteFAO{
+			path:    "/secret/slow",
+			blocked: make(chan struct{}),
+			release: make(chan struct{}),
+		}
+		blocking.Delegate = delegate
+		f.Delegate = blocking
+		for _, name := range []string{"slow", "fast"} {
+			if _, err := f.Create(ctx, "/secret", name); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		slow := make(chan error)
+		go func() {
+			_, err := f.Write(ctx, "/secret/slow", []byte("slow"), 0)
+			slow <- err
+		}()
+		<-blocking.blocked
+
+		fast := make(chan error)
+		go func() {
+			_, err := f.Write(ctx, "/secret/fast", []byte("fast"), 0)
+			fast <- err
+		}()
+		select {
+		case err := <-fast:
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("the write waited for a write to another file")
+		}
+
+		close(blocking.release)
+		if err := <-slow; err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+// Holds the first write to one path, closing 'blocked', until 'release'
+// is closed.
+type blockingWriteFAO struct {
+	fao.ProxyFAO
+	path    string
+	blocked chan struct{}
+	release chan struct{}
+	once    sync.Once
+}
+
+func (f *blockingWriteFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	if path == f.path {
+		f.once.Do(func() { close(f.blocked) })
+		<-f.release
+	}
+	return f.Delegate.Write(ctx, path, src, off)
+}
@@ -19,7 +19,7 @@
 package faoimpls
 
 import (
-	"fmt"
+	"context"
 	"time"
 
 	"github.com/HeyPuter/puter-fuse/engine"
@@ -70,24 +70,26 @@ func (f *FileReadCacheFAO) tryGetCache(path string, dest []byte, offset int64) (
 	return n, exists, nil
 }
 
-func (f *FileReadCacheFAO) Read(path string, dest []byte, offset int64) (int, error) {
-	fmt.Println("READ CACHE FAO ACCESSED")
+func (f *FileReadCacheFAO) Read(ctx context.Context, path string, dest []byte, offset int64) (int, error) {
 	n, cacheHit, err := f.tryGetCache(path, dest, offset)
 	if err != nil {
 		return 0, err
 	}
 	if cacheHit {
-		fmt.Println("Read file cache hit")
+		logger.S("read-cache").Debug("hit %s", path)
+		engine.CacheHits.With("blob").Inc()
 		return n, nil
 	}
 
-	fmt.Println("Read file cache miss")
+	logger.S("read-cache").Debug("miss %s", path)
+	engine.CacheMisses.With("blob").Inc()
 
-	reader, err := f.Delegate.ReadAll(path)
+	reader, err := f.Delegate.ReadAll(ctx, path)
 	if err != nil {
 		return 0, err
 	}
 	cacheRef := f.blobCacheService.Store(reader)
+	reader.Close()
 	f.associationService.PathToBaseHash.Set(path, cacheRef.GetHash())
 
 	// For now, a naive TTL eviction policy
@@ -96,6 +98,6 @@ func (f *FileReadCacheFAO) Read(path string, dest []byte, offset int64) (int, er
 	// 	cacheRef.Release()
 	// }()
 
-	f.blobCacheService.GetBytes(cacheRef.GetHash(), offset, dest)
-	return len(dest), nil
+	n, _, err = f.blobCacheService.GetBytes(cacheRef.GetHash(), offset, dest)
+	return n, err
 }
@@ -19,10 +19,14 @@
 package faoimpls
 
 import (
-	"fmt"
+	"context"
+	"io"
+	"sync"
+	"syscall"
 
 	"github.com/HeyPuter/puter-fuse/engine"
 	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/putersdk"
 	"github.com/HeyPuter/puter-fuse/services"
 	"github.com/google/uuid"
 )
@@ -32,6 +36,10 @@ type FileWriteCacheFAO struct {
 	associationService *engine.AssociationService
 	blobCacheService   *engine.BLOBCacheService
 	writeCacheService  *engine.WriteCacheService
+
+	// last delegate operation started for each local UID
+	tails     map[string]chan struct{}
+	tailsLock sync.Mutex
 }
 
 func CreateFileWriteCacheFAO(delegate fao.FAO, services services.IServiceContainer) *FileWriteCacheFAO {
@@ -39,11 +47,12 @@ func CreateFileWriteCacheFAO(delegate fao.FAO, services services.IServiceContain
 	ins.associationService = services.Get("association").(*engine.AssociationService)
 	ins.blobCacheService = services.Get("blob-cache").(*engine.BLOBCacheService)
 	ins.writeCacheService = services.Get("write-cache").(*engine.WriteCacheService)
+	ins.tails = map[string]chan struct{}{}
 	ins.Delegate = delegate
 	return ins
 }
 
-func (f *FileWriteCacheFAO) getOrCreateCachedRead(path string) (string, error) {
+func (f *FileWriteCacheFAO) getOrCreateCachedRead(ctx context.Context, path string) (string, error) {
 	// Determine if we have a cached read to write against
 	baseHash, exists := f.associationService.PathToBaseHash.Get(path)
 	if exists {
@@ -51,57 +60,294 @@ func (f *FileWriteCacheFAO) getOrCreateCachedRead(path string) (string, error) {
 	}
 
 	// If not, create a new one
-	reader, err := f.Delegate.ReadAll(path)
+	reader, err := f.Delegate.ReadAll(ctx, path)
 	if err != nil {
 		return "", err
 	}
 	cacheRef := f.blobCacheService.Store(reader)
+	reader.Close()
 	f.associationService.PathToBaseHash.Set(path, cacheRef.GetHash())
 	return cacheRef.GetHash(), nil
 }
 
-func (f *FileWriteCacheFAO) Read(path string, dest []byte, offset int64) (int, error) {
-	f.Delegate.Read(path, dest, offset)
-
+func (f *FileWriteCacheFAO) getLocalUID(path string) string {
+	// it's okay to ignore 'err' here since only the factory can
+	// return an error (and it invariably returns nil)
 	localUID, _, _ := f.associationService.PathToLocalUID.
 		GetWithFactory(path, func() (string, bool, error) {
 			return uuid.NewString(), true, nil
 		})
+	return localUID
+}
+
+// Folds acknowledged mutations into the cached read of the file, if
+// there is one, so the mutation chain doesn't grow without bound.
+func (f *FileWriteCacheFAO) rebase(path, localUID string) {
+	baseHash, _ := f.associationService.PathToBaseHash.Get(path)
+	err := f.writeCacheService.Rebase(localUID, baseHash, func(newHash string) {
+		if newHash == "" {
+			f.associationService.PathToBaseHash.Del(path)
+			return
+		}
+		f.associationService.PathToBaseHash.Set(path, newHash)
+	})
+	if err != nil {
+		logger.S("write-cache").Error("error rebasing %s: %s", path, err)
+	}
+}
+
+// Runs 'fn' in the background after every delegate operation previously
+// started for the same file, so the delegate sees writes in order. Its
+// requests to Puter are sent as write-back.
+func (f *FileWriteCacheFAO) delegateInOrder(ctx context.Context, localUID string, fn func(ctx context.Context)) {
+	ctx = putersdk.WithClass(ctx, putersdk.ClassWriteBack)
+
+	f.tailsLock.Lock()
+	previous := f.tails[localUID]
+	done := make(chan struct{})
+	f.tails[localUID] = done
+	f.tailsLock.Unlock()
+
+	go func() {
+		if previous != nil {
+			<-previous
+		}
+		fn(ctx)
+		close(done)
+
+		f.tailsLock.Lock()
+		if f.tails[localUID] == done {
+			delete(f.tails, localUID)
+		}
+		f.tailsLock.Unlock()
+	}()
+}
+
+// Sends a mutation from the write cache to the delegate.
+func (f *FileWriteCacheFAO) delegateMutation(ctx context.Context, path string, mut engine.Mutation) error {
+	switch mut := mut.(type) {
+	case *engine.WriteMutation:
+		_, err := f.Delegate.Write(ctx, path, mut.Data, mut.Offset)
+		return err
+	case *engine.SpilledWriteMutation:
+		data, err := mut.Data()
+		if err != nil {
+			return err
+		}
+		_, err = f.Delegate.Write(ctx, path, data, mut.Offset)
+		return err
+	case *engine.TruncateMutation:
+		return f.Delegate.Truncate(ctx, path, mut.Size)
+	}
+	return nil
+}
+
+// Delegates again the mutations for a file from the first one the
+// delegate rejected; until they're accepted, they stay applied locally.
+func (f *FileWriteCacheFAO) retry(ctx context.Context, path, localUID string) {
+	retried := f.writeCacheService.Retry(localUID)
+	if len(retried) == 0 {
+		return
+	}
 
-	fmt.Println(localUID)
+	f.delegateInOrder(ctx, localUID, func(ctx context.Context) {
+		for _, item := range retried {
+			settle(item.Ref, f.delegateMutation(ctx, path, item.Mutation))
+		}
+		f.rebase(path, localUID)
+	})
+}
+
+func settle(ref *engine.MutationReference, err error) {
+	if err != nil {
+		ref.Fail(err)
+		return
+	}
+	ref.Release()
+}
+
+func (f *FileWriteCacheFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	nodeInfo, exists, err := f.Delegate.Stat(ctx, path)
+	if err != nil || !exists || bool(nodeInfo.IsDir) {
+		return nodeInfo, exists, err
+	}
+
+	size, removed := f.writeCacheService.GetSize(f.getLocalUID(path), nodeInfo.Size)
+	if removed {
+		return fao.NodeInfo{}, false, nil
+	}
+	nodeInfo.Size = size
+
+	return nodeInfo, true, nil
+}
+
+func (f *FileWriteCacheFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	nodeInfos, err := f.Delegate.ReadDir(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]fao.NodeInfo, 0, len(nodeInfos))
+	for _, nodeInfo := range nodeInfos {
+		if !nodeInfo.IsDir {
+			size, removed := f.writeCacheService.GetSize(nodeInfo.LocalUID, nodeInfo.Size)
+			if removed {
+				continue
+			}
+			nodeInfo.Size = size
+		}
+		result = append(result, nodeInfo)
+	}
+
+	return result, nil
+}
+
+func (f *FileWriteCacheFAO) Read(ctx context.Context, path string, dest []byte, offset int64) (int, error) {
+	n, err := f.Delegate.Read(ctx, path, dest, offset)
+	if err != nil {
+		return 0, err
+	}
+
+	// Anything the delegate didn't fill is past the end of the base
+	// file; it must read as zeroes unless a mutation says otherwise.
+	for i := n; i < len(dest); i++ {
+		dest[i] = 0
+	}
+
+	localUID := f.getLocalUID(path)
 
 	f.writeCacheService.ApplyToBuffer(localUID, dest, offset)
 
-	return len(dest), nil
+	// Since the delegate stopped at EOF, offset+n is a lower bound of the
+	// base size which is exact whenever the read was short.
+	size, removed := f.writeCacheService.GetSize(localUID, uint64(offset)+uint64(n))
+	if removed || size <= uint64(offset) {
+		return 0, nil
+	}
+
+	return int(min(uint64(len(dest)), size-uint64(offset))), nil
 }
 
-func (f *FileWriteCacheFAO) Write(path string, data []byte, offset int64) (int, error) {
+func (f *FileWriteCacheFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	localUID := f.getLocalUID(path)
+
+	stat, exists, err := f.Delegate.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fao.Errorf(syscall.ENOENT, "node %s does not exist", path)
+	}
+
+	reader, err := f.Delegate.ReadAll(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.writeCacheService.ApplyToStream(localUID, reader, stat.Size)
+}
+
+func (f *FileWriteCacheFAO) Write(ctx context.Context, path string, data []byte, offset int64) (int, error) {
 	// Get a cached read to write against
-	// baseHash, err := f.getOrCreateCachedRead(path)
+	// baseHash, err := f.getOrCreateCachedRead(ctx, path)
 	// if err != nil {
 	// 	return 0, err
 	// }
 
-	// Create a write mutation
+	// Create a write mutation; the data is copied since the caller
+	// is free to reuse its buffer once Write returns.
 	mut := &engine.WriteMutation{
-		Data:   data,
+		Data:   append([]byte{}, data...),
 		Offset: offset,
 	}
 
-	// it's okay to ignore 'err' here since only the factory can
-	// return an error (and it invariably returns nil)
-	localUID, _, _ := f.associationService.PathToLocalUID.
-		GetWithFactory(path, func() (string, bool, error) {
-			return uuid.NewString(), true, nil
-		})
+	localUID := f.getLocalUID(path)
+
+	// A failed upload is reported on the next write, as it would be
+	// for a local filesystem with delayed allocation, and retried on
+	// the one after.
+	if err := f.writeCacheService.TakeError(localUID); err != nil {
+		return 0, err
+	}
+	f.retry(ctx, path, localUID)
 
 	// Apply the mutation
 	ref := f.writeCacheService.ApplyMutation(localUID, mut)
 
-	go func() {
-		f.Delegate.Write(path, data, offset)
-		ref.Release()
-	}()
+	f.delegateInOrder(ctx, localUID, func(ctx context.Context) {
+		_, err := f.Delegate.Write(ctx, path, mut.Data, offset)
+		settle(ref, err)
+		f.rebase(path, localUID)
+	})
 
 	return len(data), nil
 }
+
+func (f *FileWriteCacheFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	mut := &engine.TruncateMutation{
+		Size: size,
+	}
+
+	localUID := f.getLocalUID(path)
+
+	if err := f.writeCacheService.TakeError(localUID); err != nil {
+		return err
+	}
+	f.retry(ctx, path, localUID)
+
+	ref := f.writeCacheService.ApplyMutation(localUID, mut)
+
+	f.delegateInOrder(ctx, localUID, func(ctx context.Context) {
+		err := f.Delegate.Truncate(ctx, path, size)
+		settle(ref, err)
+		f.rebase(path, localUID)
+	})
+
+	return nil
+}
+
+// Waits until every mutation for the file has been acknowledged by the
+// delegate, reporting the first one that failed. Mutations which failed
+// before, and were already reported, are retried first.
+func (f *FileWriteCacheFAO) Fsync(ctx context.Context, path string) error {
+	localUID, exists := f.associationService.PathToLocalUID.Get(path)
+	if exists {
+		if err := f.writeCacheService.TakeError(localUID); err != nil {
+			return err
+		}
+		f.retry(ctx, path, localUID)
+		if err := f.writeCacheService.Await(localUID); err != nil {
+			return err
+		}
+	}
+	return f.Delegate.Fsync(ctx, path)
+}
+
+// Reports, once, a write to the file which the delegate rejected,
+// without waiting for those still being sent.
+func (f *FileWriteCacheFAO) TakeError(ctx context.Context, path string) error {
+	localUID, exists := f.associationService.PathToLocalUID.Get(path)
+	if exists {
+		if err := f.writeCacheService.TakeError(localUID); err != nil {
+			return err
+		}
+	}
+	return f.Delegate.TakeError(ctx, path)
+}
+
+func (f *FileWriteCacheFAO) Unlink(ctx context.Context, path string) error {
+	localUID, exists := f.associationService.PathToLocalUID.Get(path)
+
+	err := f.Delegate.Unlink(ctx, path)
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		// the delegate has already removed the file, so neither its
+		// base nor its mutations matter any more
+		f.writeCacheService.Remove(localUID)
+	}
+
+	return nil
+}
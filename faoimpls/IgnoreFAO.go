@@ -0,0 +1,343 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/ignore"
+)
+
+// IgnoreFAO keeps nodes matching the ignore patterns in a local overlay
+// instead of the delegate, so temporary files from editors, desktops and
+// git are shown in the mount but never uploaded. The overlay mirrors the
+// delegate's paths; directories are created in it as needed to hold
+// ignored nodes.
+//
+// Renaming a node across the boundary (e.g. `.git/index.lock` to
+// `.git/index`) copies it to the other side.
+type IgnoreFAO struct {
+	fao.ProxyFAO
+	Overlay fao.FAO
+	Matcher *ignore.Matcher
+}
+
+func CreateIgnoreFAO(delegate fao.FAO, overlay fao.FAO, matcher *ignore.Matcher) *IgnoreFAO {
+	ins := &IgnoreFAO{
+		Overlay: overlay,
+		Matcher: matcher,
+	}
+	ins.Delegate = delegate
+	return ins
+}
+
+// Returns whether 'path' could be ignored when its type isn't known.
+func (f *IgnoreFAO) mayBeIgnored(path string) bool {
+	return f.Matcher.Match(path, false) || f.Matcher.Match(path, true)
+}
+
+// Returns whether 'path' is in the overlay.
+func (f *IgnoreFAO) isLocal(ctx context.Context, path string) bool {
+	if !f.mayBeIgnored(path) {
+		return false
+	}
+	_, exists, err := f.Overlay.Stat(ctx, path)
+	return err == nil && exists
+}
+
+func (f *IgnoreFAO) target(ctx context.Context, path string) fao.FAO {
+	if f.isLocal(ctx, path) {
+		return f.Overlay
+	}
+	return f.Delegate
+}
+
+// Creates 'dir' and its parents in the overlay.
+func (f *IgnoreFAO) ensureDir(ctx context.Context, dir string) error {
+	current := "/"
+	for _, name := range strings.Split(strings.Trim(dir, "/"), "/") {
+		if name == "" {
+			continue
+		}
+		next := filepath.Join(current, name)
+		_, exists, err := f.Overlay.Stat(ctx, next)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			_, err := f.Overlay.MkDir(ctx, current, name)
+			if err != nil && !isErrno(err, syscall.EEXIST) {
+				return err
+			}
+		}
+		current = next
+	}
+	return nil
+}
+
+func isErrno(err error, errno syscall.Errno) bool {
+	var faoErr *fao.FAOError
+	return errors.As(err, &faoErr) && faoErr.Errno == errno
+}
+
+func (f *IgnoreFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	if f.mayBeIgnored(path) {
+		node, exists, err := f.Overlay.Stat(ctx, path)
+		if err == nil && exists {
+			return node, true, nil
+		}
+	}
+	return f.Delegate.Stat(ctx, path)
+}
+
+func (f *IgnoreFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	if f.isLocal(ctx, path) {
+		return f.Overlay.ReadDir(ctx, path)
+	}
+
+	nodes, err := f.Delegate.ReadDir(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// the overlay only has this directory if something in it is ignored
+	if _, exists, err := f.Overlay.Stat(ctx, path); err != nil || !exists {
+		return nodes, nil
+	}
+	localNodes, err := f.Overlay.ReadDir(ctx, path)
+	if err != nil {
+		return nodes, nil
+	}
+
+	indices := map[string]int{}
+	for i, node := range nodes {
+		indices[node.Name] = i
+	}
+	for _, node := range localNodes {
+		// directories made to hold ignored nodes are already listed
+		if !f.Matcher.Match(node.Path, bool(node.IsDir)) {
+			continue
+		}
+		if i, exists := indices[node.Name]; exists {
+			nodes[i] = node
+			continue
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+func (f *IgnoreFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	return f.target(ctx, path).Read(ctx, path, dest, off)
+}
+
+func (f *IgnoreFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	return f.target(ctx, path).Write(ctx, path, src, off)
+}
+
+func (f *IgnoreFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	if !f.Matcher.Match(filepath.Join(path, name), false) {
+		return f.Delegate.Create(ctx, path, name)
+	}
+	if err := f.ensureDir(ctx, path); err != nil {
+		return fao.NodeInfo{}, err
+	}
+	return f.Overlay.Create(ctx, path, name)
+}
+
+func (f *IgnoreFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	return f.target(ctx, path).Truncate(ctx, path, size)
+}
+
+func (f *IgnoreFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	if !f.Matcher.Match(filepath.Join(path, name), true) {
+		return f.Delegate.MkDir(ctx, path, name)
+	}
+	if err := f.ensureDir(ctx, path); err != nil {
+		return fao.NodeInfo{}, err
+	}
+	return f.Overlay.MkDir(ctx, path, name)
+}
+
+func (f *IgnoreFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
+	if !f.Matcher.Match(filepath.Join(parent, name), false) {
+		return f.Delegate.Symlink(ctx, parent, name, target)
+	}
+	if err := f.ensureDir(ctx, parent); err != nil {
+		return fao.NodeInfo{}, err
+	}
+	return f.Overlay.Symlink(ctx, parent, name, target)
+}
+
+func (f *IgnoreFAO) Unlink(ctx context.Context, path string) error {
+	if f.isLocal(ctx, path) {
+		return f.Overlay.Unlink(ctx, path)
+	}
+	if err := f.Delegate.Unlink(ctx, path); err != nil {
+		return err
+	}
+
+	// ignored nodes in a deleted directory go with it
+	if _, exists, err := f.Overlay.Stat(ctx, path); err == nil && exists {
+		return f.Overlay.Unlink(ctx, path)
+	}
+	return nil
+}
+
+func (f *IgnoreFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	node, exists, err := f.Stat(ctx, source)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fao.Errorf(syscall.ENOENT, "node %s does not exist", source)
+	}
+
+	dest := filepath.Join(parent, name)
+	local := f.isLocal(ctx, source)
+	ignored := f.Matcher.Match(dest, bool(node.IsDir))
+
+	switch {
+	case local && ignored:
+		if err := f.ensureDir(ctx, parent); err != nil {
+			return err
+		}
+		return f.Overlay.Move(ctx, source, parent, name)
+	case !local && !ignored:
+		if err := f.Delegate.Move(ctx, source, parent, name); err != nil {
+			return err
+		}
+		// ignored nodes in a moved directory go with it
+		if _, exists, err := f.Overlay.Stat(ctx, source); err != nil || !exists {
+			return nil
+		}
+		if err := f.ensureDir(ctx, parent); err != nil {
+			return err
+		}
+		return f.Overlay.Move(ctx, source, parent, name)
+	case local:
+		return f.transfer(ctx, f.Overlay, f.Delegate, node, parent, name)
+	default:
+		return f.transfer(ctx, f.Delegate, f.Overlay, node, parent, name)
+	}
+}
+
+// Moves 'node' between the overlay and the delegate. Each node in a
+// directory is moved on its own, so those that stay on the same side
+// are renamed rather than copied.
+func (f *IgnoreFAO) transfer(
+	ctx context.Context, from fao.FAO, to fao.FAO,
+	node fao.NodeInfo, parent string, name string,
+) error {
+	if to == f.Overlay {
+		if err := f.ensureDir(ctx, parent); err != nil {
+			return err
+		}
+	}
+	dest := filepath.Join(parent, name)
+	existing, exists, err := to.Stat(ctx, dest)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case bool(node.IsSymlink):
+		if exists {
+			if err := to.Unlink(ctx, dest); err != nil {
+				return err
+			}
+		}
+		if _, err := to.Symlink(ctx, parent, name, node.SymlinkPath); err != nil {
+			return err
+		}
+	case bool(node.IsDir):
+		if !exists {
+			if _, err := to.MkDir(ctx, parent, name); err != nil {
+				return err
+			}
+		}
+		children, err := f.ReadDir(ctx, node.Path)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			if err := f.Move(ctx, child.Path, dest, child.Name); err != nil {
+				return err
+			}
+		}
+		return f.Unlink(ctx, node.Path)
+	default:
+		if exists && !bool(existing.IsDir) {
+			err = to.Truncate(ctx, dest, 0)
+		} else {
+			_, err = to.Create(ctx, parent, name)
+		}
+		if err != nil {
+			return err
+		}
+		if err := copyContents(ctx, from, to, node.Path, dest); err != nil {
+			return err
+		}
+	}
+	return from.Unlink(ctx, node.Path)
+}
+
+func copyContents(ctx context.Context, from fao.FAO, to fao.FAO, source string, dest string) error {
+	reader, err := from.ReadAll(ctx, source)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	buf := make([]byte, 1024*1024)
+	var off int64
+	for {
+		n, err := io.ReadFull(reader, buf)
+		if n > 0 {
+			if _, err := to.Write(ctx, dest, buf[:n], off); err != nil {
+				return err
+			}
+			off += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (f *IgnoreFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	return f.target(ctx, path).ReadAll(ctx, path)
+}
+
+func (f *IgnoreFAO) Fsync(ctx context.Context, path string) error {
+	return f.target(ctx, path).Fsync(ctx, path)
+}
+
+func (f *IgnoreFAO) TakeError(ctx context.Context, path string) error {
+	return f.target(ctx, path).TakeError(ctx, path)
+}
@@ -0,0 +1,187 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"context"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/ignore"
+	"github.com/spf13/afero"
+)
+
+func TestIgnoreFAO(t *testing.T) {
+	ctx := context.Background()
+
+	create := func(t *testing.T) (*IgnoreFAO, fao.FAO, fao.FAO) {
+		fs := afero.NewMemMapFs()
+		delegate, err := CreateLocalDirFAO(fs, "/remote")
+		if err != nil {
+			t.Fatal(err)
+		}
+		overlay, err := CreateLocalDirFAO(fs, "/overlay")
+		if err != nil {
+			t.Fatal(err)
+		}
+		matcher, err := ignore.Compile([]string{".*.swp", "*~", "**/.git/*.lock", "tmp/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := delegate.MkDir(ctx, "/", "dir"); err != nil {
+			t.Fatal(err)
+		}
+		return CreateIgnoreFAO(delegate, overlay, matcher), delegate, overlay
+	}
+
+	write := func(t *testing.T, f fao.FAO, parent, name, contents string) {
+		if _, err := f.Create(ctx, parent, name); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(ctx, parent+"/"+name, []byte(contents), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exists := func(t *testing.T, f fao.FAO, path string) bool {
+		_, exists, err := f.Stat(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return exists
+	}
+
+	read := func(t *testing.T, f fao.FAO, path string) string {
+		reader, err := f.ReadAll(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		data, _ := io.ReadAll(reader)
+		return string(data)
+	}
+
+	names := func(t *testing.T, f fao.FAO, path string) []string {
+		nodes, err := f.ReadDir(ctx, path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		for _, node := range nodes {
+			names = append(names, node.Name)
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	t.Run("ignored files stay in the overlay", func(t *testing.T) {
+		f, delegate, overlay := create(t)
+		write(t, f, "/dir", ".notes.swp", "swap")
+		write(t, f, "/dir", "notes", "text")
+
+		if exists(t, delegate, "/dir/.notes.swp") || !exists(t, overlay, "/dir/.notes.swp") {
+			t.Errorf("expected the swap file only in the overlay")
+		}
+		if !exists(t, delegate, "/dir/notes") || exists(t, overlay, "/dir/notes") {
+			t.Errorf("expected the file only in the delegate")
+		}
+		if got := read(t, f, "/dir/.notes.swp"); got != "swap" {
+			t.Errorf("expected %q, got %q", "swap", got)
+		}
+		if got := names(t, f, "/dir"); len(got) != 2 || got[0] != ".notes.swp" || got[1] != "notes" {
+			t.Errorf("expected both files to be listed, got %v", got)
+		}
+		if got := names(t, f, "/"); len(got) != 1 || got[0] != "dir" {
+			t.Errorf("expected directories holding ignored files to be listed once, got %v", got)
+		}
+	})
+
+	t.Run("renaming out of the overlay uploads the file", func(t *testing.T) {
+		f, delegate, overlay := create(t)
+		if _, err := f.MkDir(ctx, "/dir", ".git"); err != nil {
+			t.Fatal(err)
+		}
+		write(t, f, "/dir/.git", "index.lock", "index")
+		if err := f.Move(ctx, "/dir/.git/index.lock", "/dir/.git", "index"); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := read(t, delegate, "/dir/.git/index"); got != "index" {
+			t.Errorf("expected %q in the delegate, got %q", "index", got)
+		}
+		if exists(t, overlay, "/dir/.git/index.lock") || exists(t, f, "/dir/.git/index.lock") {
+			t.Errorf("expected the lock file to be gone")
+		}
+	})
+
+	t.Run("renaming into the overlay removes the file from the delegate", func(t *testing.T) {
+		f, delegate, overlay := create(t)
+		write(t, f, "/dir", "notes", "text")
+		if err := f.Move(ctx, "/dir/notes", "/dir", "notes~"); err != nil {
+			t.Fatal(err)
+		}
+
+		if exists(t, delegate, "/dir/notes") || exists(t, delegate, "/dir/notes~") {
+			t.Errorf("expected the file to be gone from the delegate")
+		}
+		if got := read(t, overlay, "/dir/notes~"); got != "text" {
+			t.Errorf("expected %q in the overlay, got %q", "text", got)
+		}
+	})
+
+	t.Run("ignored files move and are deleted with their directory", func(t *testing.T) {
+		f, delegate, overlay := create(t)
+		write(t, f, "/dir", "notes", "text")
+		write(t, f, "/dir", "notes~", "backup")
+		if err := f.Move(ctx, "/dir", "/", "moved"); err != nil {
+			t.Fatal(err)
+		}
+
+		if !exists(t, delegate, "/moved/notes") || exists(t, delegate, "/moved/notes~") {
+			t.Errorf("expected only the file in the delegate")
+		}
+		if got := read(t, f, "/moved/notes~"); got != "backup" {
+			t.Errorf("expected %q, got %q", "backup", got)
+		}
+
+		if err := f.Unlink(ctx, "/moved"); err != nil {
+			t.Fatal(err)
+		}
+		if exists(t, overlay, "/moved") || exists(t, f, "/moved/notes~") {
+			t.Errorf("expected the ignored file to be deleted")
+		}
+	})
+
+	t.Run("ignored directories keep their contents local", func(t *testing.T) {
+		f, delegate, _ := create(t)
+		if _, err := f.MkDir(ctx, "/dir", "tmp"); err != nil {
+			t.Fatal(err)
+		}
+		write(t, f, "/dir/tmp", "scratch", "data")
+
+		if exists(t, delegate, "/dir/tmp") {
+			t.Errorf("expected the directory to stay local")
+		}
+		if got := names(t, f, "/dir/tmp"); len(got) != 1 || got[0] != "scratch" {
+			t.Errorf("expected the local directory's contents, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,378 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/HeyPuter/puter-fuse/engine"
+	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/putersdk"
+	"github.com/google/uuid"
+	"github.com/spf13/afero"
+)
+
+// The sidecar index of UIDs, kept at the root of the directory
+const LocalDirIndexName = ".puter-fuse-uids.json"
+
+// LocalDirFAO serves a directory of an afero.Fs. Each node keeps the same
+// UID for as long as it exists, across moves and restarts; UIDs are kept
+// in a sidecar index since afero has no extended attributes. Changes
+// made to the directory by other programs are picked up, and new nodes
+// are given UIDs when they're first seen.
+type LocalDirFAO struct {
+	fao.BaseFAO
+	// rooted at the directory
+	Fs afero.Fs
+
+	base afero.Fs
+	root string
+
+	// path -> UID
+	uids  map[string]string
+	dirty bool
+	lock  sync.Mutex
+}
+
+func CreateLocalDirFAO(base afero.Fs, root string) (*LocalDirFAO, error) {
+	if err := base.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+	f := &LocalDirFAO{
+		Fs:   afero.NewBasePathFs(base, root),
+		base: base,
+		root: root,
+		uids: map[string]string{},
+	}
+
+	data, err := afero.ReadFile(f.Fs, LocalDirIndexName)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &f.uids); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// Saves the index if UIDs were added since it was last saved.
+func (f *LocalDirFAO) flush() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if f.dirty {
+		f.saveIndex()
+	}
+}
+
+// Must be called with the lock held.
+func (f *LocalDirFAO) saveIndex() {
+	f.dirty = false
+	data, err := json.Marshal(f.uids)
+	if err != nil {
+		return
+	}
+	tmp := LocalDirIndexName + ".tmp"
+	if err := afero.WriteFile(f.Fs, tmp, data, 0644); err != nil {
+		logger.S("local-dir").Error("error saving UIDs: %s", err)
+		return
+	}
+	f.Fs.Rename(tmp, LocalDirIndexName)
+}
+
+func (f *LocalDirFAO) uid(path string) string {
+	if path == "/" {
+		return engine.ROOT_UUID
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	uid, exists := f.uids[path]
+	if !exists {
+		uid = uuid.NewString()
+		f.uids[path] = uid
+		f.dirty = true
+	}
+	return uid
+}
+
+func under(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
+
+// Moves the UIDs of 'source' and everything under it to 'dest', or
+// forgets them if 'dest' is "". Anything 'dest' replaced is forgotten.
+func (f *LocalDirFAO) moveUIDs(source, dest string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	moved := map[string]string{}
+	for path, uid := range f.uids {
+		if dest != "" && under(path, dest) {
+			delete(f.uids, path)
+			continue
+		}
+		if !under(path, source) {
+			continue
+		}
+		delete(f.uids, path)
+		if dest != "" {
+			moved[dest+strings.TrimPrefix(path, source)] = uid
+		}
+	}
+	for path, uid := range moved {
+		f.uids[path] = uid
+	}
+	f.saveIndex()
+}
+
+func isIndex(path string) bool {
+	name := strings.TrimPrefix(path, "/")
+	return name == LocalDirIndexName || name == LocalDirIndexName+".tmp"
+}
+
+// Returns an FAOError with the errno of an error from afero.
+func localError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var errno syscall.Errno
+	switch {
+	case errors.As(err, &errno):
+	case errors.Is(err, fs.ErrNotExist):
+		errno = syscall.ENOENT
+	case errors.Is(err, fs.ErrExist):
+		errno = syscall.EEXIST
+	case errors.Is(err, fs.ErrPermission):
+		errno = syscall.EACCES
+	default:
+		errno = syscall.EIO
+	}
+	return &fao.FAOError{Errno: errno, From: err}
+}
+
+func (f *LocalDirFAO) lstat(path string) (os.FileInfo, error) {
+	if lstater, ok := f.Fs.(afero.Lstater); ok {
+		info, _, err := lstater.LstatIfPossible(path)
+		return info, err
+	}
+	return f.Fs.Stat(path)
+}
+
+func (f *LocalDirFAO) nodeInfo(path string, info os.FileInfo) fao.NodeInfo {
+	uid := f.uid(path)
+	modified := float64(info.ModTime().Unix())
+	nodeInfo := fao.NodeInfo{
+		CloudItem: putersdk.CloudItem{
+			Path:      path,
+			Name:      filepath.Base(path),
+			Id:        uid,
+			RemoteUID: uid,
+			IsDir:     putersdk.PuterIntBool(info.IsDir()),
+			Modified:  modified,
+			Created:   modified,
+			Accessed:  modified,
+		},
+	}
+	if !info.IsDir() {
+		nodeInfo.Size = uint64(info.Size())
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		nodeInfo.IsSymlink = true
+		if reader, ok := f.Fs.(afero.LinkReader); ok {
+			nodeInfo.SymlinkPath, _ = reader.ReadlinkIfPossible(path)
+		}
+	}
+	return nodeInfo
+}
+
+func (f *LocalDirFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	defer f.flush()
+	if isIndex(path) {
+		return fao.NodeInfo{}, false, nil
+	}
+	info, err := f.lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fao.NodeInfo{}, false, nil
+	}
+	if err != nil {
+		return fao.NodeInfo{}, false, localError(err)
+	}
+	return f.nodeInfo(path, info), true, nil
+}
+
+func (f *LocalDirFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	defer f.flush()
+	infos, err := afero.ReadDir(f.Fs, path)
+	if err != nil {
+		return nil, localError(err)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+
+	nodes := []fao.NodeInfo{}
+	for _, info := range infos {
+		childPath := filepath.Join(path, info.Name())
+		if isIndex(childPath) {
+			continue
+		}
+		nodes = append(nodes, f.nodeInfo(childPath, info))
+	}
+	return nodes, nil
+}
+
+func (f *LocalDirFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	file, err := f.Fs.Open(path)
+	if err != nil {
+		return 0, localError(err)
+	}
+	defer file.Close()
+
+	n, err := file.ReadAt(dest, off)
+	if err == io.EOF {
+		err = nil
+	}
+	return n, localError(err)
+}
+
+func (f *LocalDirFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	file, err := f.Fs.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return 0, localError(err)
+	}
+	defer file.Close()
+
+	n, err := file.WriteAt(src, off)
+	return n, localError(err)
+}
+
+func (f *LocalDirFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	defer f.flush()
+	childPath := filepath.Join(path, name)
+	file, err := f.Fs.OpenFile(childPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fao.NodeInfo{}, localError(err)
+	}
+	file.Close()
+
+	info, err := f.lstat(childPath)
+	if err != nil {
+		return fao.NodeInfo{}, localError(err)
+	}
+	return f.nodeInfo(childPath, info), nil
+}
+
+func (f *LocalDirFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	file, err := f.Fs.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return localError(err)
+	}
+	defer file.Close()
+	return localError(file.Truncate(int64(size)))
+}
+
+func (f *LocalDirFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	defer f.flush()
+	childPath := filepath.Join(path, name)
+	if err := f.Fs.Mkdir(childPath, 0755); err != nil {
+		return fao.NodeInfo{}, localError(err)
+	}
+
+	info, err := f.lstat(childPath)
+	if err != nil {
+		return fao.NodeInfo{}, localError(err)
+	}
+	return f.nodeInfo(childPath, info), nil
+}
+
+func (f *LocalDirFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
+	defer f.flush()
+	// The target is used as given; f.Fs would make it absolute.
+	linker, ok := f.base.(afero.Linker)
+	if !ok {
+		return fao.NodeInfo{}, fao.Errorf(syscall.ENOTSUP, "symlinks aren't supported here")
+	}
+	childPath := filepath.Join(parent, name)
+	if err := linker.SymlinkIfPossible(target, filepath.Join(f.root, childPath)); err != nil {
+		return fao.NodeInfo{}, localError(err)
+	}
+
+	info, err := f.lstat(childPath)
+	if err != nil {
+		return fao.NodeInfo{}, localError(err)
+	}
+	return f.nodeInfo(childPath, info), nil
+}
+
+// Like Puter, directories are deleted with everything in them.
+func (f *LocalDirFAO) Unlink(ctx context.Context, path string) error {
+	if _, err := f.lstat(path); err != nil {
+		return localError(err)
+	}
+	if err := f.Fs.RemoveAll(path); err != nil {
+		return localError(err)
+	}
+	f.moveUIDs(path, "")
+	return nil
+}
+
+func (f *LocalDirFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	dest := filepath.Join(parent, name)
+	if err := f.Fs.Rename(source, dest); err != nil {
+		return localError(err)
+	}
+	f.moveUIDs(source, dest)
+	return nil
+}
+
+func (f *LocalDirFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	info, err := f.lstat(path)
+	if err != nil {
+		return nil, localError(err)
+	}
+	if info.IsDir() {
+		return nil, fao.Errorf(syscall.EISDIR, "node %s is a directory", path)
+	}
+	file, err := f.Fs.Open(path)
+	if err != nil {
+		return nil, localError(err)
+	}
+	return file, nil
+}
+
+// Writes go straight to the directory, so there is nothing to wait for.
+func (f *LocalDirFAO) Fsync(ctx context.Context, path string) error {
+	_, err := f.lstat(path)
+	return localError(err)
+}
+
+func (f *LocalDirFAO) TakeError(ctx context.Context, path string) error {
+	return nil
+}
@@ -0,0 +1,131 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/spf13/afero"
+)
+
+func TestLocalDirFAO(t *testing.T) {
+	ctx := context.Background()
+
+	create := func(t *testing.T, fs afero.Fs) *LocalDirFAO {
+		f, err := CreateLocalDirFAO(fs, "/root")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return f
+	}
+
+	t.Run("write and read", func(t *testing.T) {
+		f := create(t, afero.NewMemMapFs())
+		if _, err := f.MkDir(ctx, "/", "dir"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Create(ctx, "/dir", "file"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write(ctx, "/dir/file", []byte("contents"), 0); err != nil {
+			t.Fatal(err)
+		}
+
+		dest := make([]byte, 16)
+		n, err := f.Read(ctx, "/dir/file", dest, 3)
+		if err != nil || string(dest[:n]) != "tents" {
+			t.Fatalf("expected %q; got %q, %v", "tents", dest[:n], err)
+		}
+
+		nodes, err := f.ReadDir(ctx, "/dir")
+		if err != nil || len(nodes) != 1 || nodes[0].Size != 8 || nodes[0].Path != "/dir/file" {
+			t.Fatalf("unexpected listing: %+v, %v", nodes, err)
+		}
+	})
+
+	t.Run("index is hidden", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		f := create(t, fs)
+		f.MkDir(ctx, "/", "dir")
+
+		if exists, _ := afero.Exists(fs, "/root/"+LocalDirIndexName); !exists {
+			t.Fatalf("expected the index to be saved")
+		}
+		nodes, _ := f.ReadDir(ctx, "/")
+		if len(nodes) != 1 || nodes[0].Name != "dir" {
+			t.Fatalf("expected only dir; got %+v", nodes)
+		}
+		if _, exists, _ := f.Stat(ctx, "/"+LocalDirIndexName); exists {
+			t.Fatalf("expected the index not to exist")
+		}
+	})
+
+	t.Run("stable UIDs", func(t *testing.T) {
+		fs := afero.NewMemMapFs()
+		f := create(t, fs)
+		f.MkDir(ctx, "/", "a")
+		file, _ := f.Create(ctx, "/a", "file")
+
+		if err := f.Move(ctx, "/a", "/", "b"); err != nil {
+			t.Fatal(err)
+		}
+		moved, exists, _ := f.Stat(ctx, "/b/file")
+		if !exists || moved.RemoteUID != file.RemoteUID {
+			t.Fatalf("expected UID %s after the move; got %s", file.RemoteUID, moved.RemoteUID)
+		}
+
+		reopened, _, _ := create(t, fs).Stat(ctx, "/b/file")
+		if reopened.RemoteUID != file.RemoteUID {
+			t.Fatalf("expected UID %s after reopening; got %s", file.RemoteUID, reopened.RemoteUID)
+		}
+	})
+
+	errorCases := []struct {
+		name  string
+		call  func(f *LocalDirFAO) error
+		errno syscall.Errno
+	}{
+		{"read missing", func(f *LocalDirFAO) error {
+			_, err := f.Read(ctx, "/missing", make([]byte, 1), 0)
+			return err
+		}, syscall.ENOENT},
+		{"create existing", func(f *LocalDirFAO) error {
+			f.Create(ctx, "/", "file")
+			_, err := f.Create(ctx, "/", "file")
+			return err
+		}, syscall.EEXIST},
+		{"unlink missing", func(f *LocalDirFAO) error {
+			return f.Unlink(ctx, "/missing")
+		}, syscall.ENOENT},
+	}
+
+	for _, tc := range errorCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.call(create(t, afero.NewMemMapFs()))
+			var faoErr *fao.FAOError
+			if !errors.As(err, &faoErr) || faoErr.Errno != tc.errno {
+				t.Fatalf("expected %s; got %v", tc.errno, err)
+			}
+		})
+	}
+}
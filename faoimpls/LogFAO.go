@@ -19,6 +19,7 @@
 package faoimpls
 
 import (
+	"context"
 	"io"
 
 	"github.com/HeyPuter/puter-fuse/debug"
@@ -44,70 +45,81 @@ func CreateLogFAO(delegate fao.FAO, logger debug.ILogger) *LogFAO {
 }
 
 // Implementing the Stat method with logging.
-func (f *LogFAO) Stat(path string) (fao.NodeInfo, bool, error) {
-	f.Log.S("LogFAO").Log("Stat called with path: %s", path)
-	return f.Delegate.Stat(path)
+func (f *LogFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	f.Log.S("LogFAO").Debug("Stat called with path: %s", path)
+	return f.Delegate.Stat(ctx, path)
 }
 
 // Implementing the ReadDir method with logging.
-func (f *LogFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
-	f.Log.S("LogFAO").Log("ReadDir called with path: %s", path)
-	return f.Delegate.ReadDir(path)
+func (f *LogFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	f.Log.S("LogFAO").Debug("ReadDir called with path: %s", path)
+	return f.Delegate.ReadDir(ctx, path)
 }
 
 // You would continue to implement the remaining methods in a similar fashion,
 // logging the method name and parameters before delegating the operation to the Delegate.
 
 // Example for Read method
-func (f *LogFAO) Read(path string, dest []byte, off int64) (int, error) {
-	f.Log.S("LogFAO").Log("Read called with path: %s, off: %d", path, off)
-	return f.Delegate.Read(path, dest, off)
+func (f *LogFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	f.Log.S("LogFAO").Debug("Read called with path: %s, off: %d", path, off)
+	return f.Delegate.Read(ctx, path, dest, off)
 }
 
 // Implementing the Write method with logging.
-func (f *LogFAO) Write(path string, src []byte, off int64) (int, error) {
-	f.Log.S("LogFAO").Log("Write called with path: %s, off: %d", path, off)
-	return f.Delegate.Write(path, src, off)
+func (f *LogFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	f.Log.S("LogFAO").Debug("Write called with path: %s, off: %d", path, off)
+	return f.Delegate.Write(ctx, path, src, off)
 }
 
 // Implementing the Truncate method with logging.
-func (f *LogFAO) Truncate(path string, size uint64) error {
-	f.Log.S("LogFAO").Log("Truncate called with path: %s, size: %d", path, size)
-	return f.Delegate.Truncate(path, size)
+func (f *LogFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	f.Log.S("LogFAO").Debug("Truncate called with path: %s, size: %d", path, size)
+	return f.Delegate.Truncate(ctx, path, size)
 }
 
 // Implementing the Create method with logging.
-func (f *LogFAO) Create(path string, name string) (fao.NodeInfo, error) {
-	f.Log.S("LogFAO").Log("Create called with path: %s, name: %s", path, name)
-	return f.Delegate.Create(path, name)
+func (f *LogFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	f.Log.S("LogFAO").Debug("Create called with path: %s, name: %s", path, name)
+	return f.Delegate.Create(ctx, path, name)
 }
 
 // Implementing the MkDir method with logging.
-func (f *LogFAO) MkDir(path string, name string) (fao.NodeInfo, error) {
-	f.Log.S("LogFAO").Log("MkDir called with path: %s, name: %s", path, name)
-	return f.Delegate.MkDir(path, name)
+func (f *LogFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	f.Log.S("LogFAO").Debug("MkDir called with path: %s, name: %s", path, name)
+	return f.Delegate.MkDir(ctx, path, name)
 }
 
 // Implementing the Symlink method with logging.
-func (f *LogFAO) Symlink(parent, name, target string) (fao.NodeInfo, error) {
-	f.Log.S("LogFAO").Log("Symlink called with parent: %s, name: %s, target: %s", parent, name, target)
-	return f.Delegate.Symlink(parent, name, target)
+func (f *LogFAO) Symlink(ctx context.Context, parent, name, target string) (fao.NodeInfo, error) {
+	f.Log.S("LogFAO").Debug("Symlink called with parent: %s, name: %s, target: %s", parent, name, target)
+	return f.Delegate.Symlink(ctx, parent, name, target)
 }
 
 // Implementing the Unlink method with logging.
-func (f *LogFAO) Unlink(path string) error {
-	f.Log.S("LogFAO").Log("Unlink called with path: %s", path)
-	return f.Delegate.Unlink(path)
+func (f *LogFAO) Unlink(ctx context.Context, path string) error {
+	f.Log.S("LogFAO").Debug("Unlink called with path: %s", path)
+	return f.Delegate.Unlink(ctx, path)
 }
 
 // Implementing the Move method with logging.
-func (f *LogFAO) Move(source, parent, name string) error {
-	f.Log.S("LogFAO").Log("Move called with source: %s, parent: %s, name: %s", source, parent, name)
-	return f.Delegate.Move(source, parent, name)
+func (f *LogFAO) Move(ctx context.Context, source, parent, name string) error {
+	f.Log.S("LogFAO").Debug("Move called with source: %s, parent: %s, name: %s", source, parent, name)
+	return f.Delegate.Move(ctx, source, parent, name)
 }
 
 // Implementing the ReadAll method with logging.
-func (f *LogFAO) ReadAll(path string) (io.ReadCloser, error) {
-	f.Log.S("LogFAO").Log("ReadAll called with path: %s", path)
-	return f.Delegate.ReadAll(path)
+func (f *LogFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	f.Log.S("LogFAO").Debug("ReadAll called with path: %s", path)
+	return f.Delegate.ReadAll(ctx, path)
+}
+
+// Implementing the Fsync method with logging.
+func (f *LogFAO) Fsync(ctx context.Context, path string) error {
+	f.Log.S("LogFAO").Debug("Fsync called with path: %s", path)
+	return f.Delegate.Fsync(ctx, path)
+}
+
+func (f *LogFAO) TakeError(ctx context.Context, path string) error {
+	f.Log.S("LogFAO").Debug("TakeError called with path: %s", path)
+	return f.Delegate.TakeError(ctx, path)
 }
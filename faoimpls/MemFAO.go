@@ -19,7 +19,7 @@
 package faoimpls
 
 import (
-	"fmt"
+	"context"
 	"io"
 	"path/filepath"
 	"strings"
@@ -87,8 +87,8 @@ func (f *MemFAO) resolvePath(path string) (*node, bool) {
 	return current, true
 }
 
-func (f *MemFAO) Stat(path string) (fao.NodeInfo, bool, error) {
-	fmt.Printf("statting %s\n", path)
+func (f *MemFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	logger.S("mem").Debug("stat %s", path)
 	n, ok := f.resolvePath(path)
 	if !ok {
 		return fao.NodeInfo{
@@ -98,7 +98,7 @@ func (f *MemFAO) Stat(path string) (fao.NodeInfo, bool, error) {
 	return n.NodeInfo, true, nil
 }
 
-func (f *MemFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
+func (f *MemFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
 	n, ok := f.resolvePath(path)
 	if !ok {
 		return nil, nil
@@ -110,9 +110,8 @@ func (f *MemFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
 	return nodes, nil
 }
 
-func (f *MemFAO) Read(path string, dest []byte, off int64) (int, error) {
+func (f *MemFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
 	n, ok := f.resolvePath(path)
-	fmt.Println("file node?", n)
 	if !ok {
 		return 0, nil
 	}
@@ -126,9 +125,8 @@ func (f *MemFAO) Read(path string, dest []byte, off int64) (int, error) {
 	return nBytes, nil
 }
 
-func (f *MemFAO) Write(path string, src []byte, off int64) (int, error) {
+func (f *MemFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
 	n, ok := f.resolvePath(path)
-	fmt.Println("file node?", n)
 	if !ok {
 		return 0, nil
 	}
@@ -146,9 +144,8 @@ func (f *MemFAO) Write(path string, src []byte, off int64) (int, error) {
 	return nBytes, nil
 }
 
-func (f *MemFAO) Create(path string, name string) (fao.NodeInfo, error) {
+func (f *MemFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
 	n, ok := f.resolvePath(path)
-	fmt.Println(n)
 
 	// TODO: errors here need to map to filesystem error numbers
 	if !ok {
@@ -176,7 +173,7 @@ func (f *MemFAO) Create(path string, name string) (fao.NodeInfo, error) {
 	return newNode.NodeInfo, nil
 }
 
-func (f *MemFAO) MkDir(parent, path string) (fao.NodeInfo, error) {
+func (f *MemFAO) MkDir(ctx context.Context, parent, path string) (fao.NodeInfo, error) {
 	n, ok := f.resolvePath(parent)
 	if !ok {
 		return fao.NodeInfo{
@@ -201,7 +198,7 @@ func (f *MemFAO) MkDir(parent, path string) (fao.NodeInfo, error) {
 	return newNode.NodeInfo, nil
 }
 
-func (f *MemFAO) Truncate(path string, size uint64) error {
+func (f *MemFAO) Truncate(ctx context.Context, path string, size uint64) error {
 	n, ok := f.resolvePath(path)
 	if !ok {
 		return nil
@@ -211,11 +208,14 @@ func (f *MemFAO) Truncate(path string, size uint64) error {
 	}
 	if size < uint64(len(n.Data)) {
 		n.Data = n.Data[:size]
+	} else {
+		n.Data = append(n.Data, make([]byte, size-uint64(len(n.Data)))...)
 	}
+	n.Size = uint64(len(n.Data))
 	return nil
 }
 
-func (f *MemFAO) Symlink(parent, name, target string) (fao.NodeInfo, error) {
+func (f *MemFAO) Symlink(ctx context.Context, parent, name, target string) (fao.NodeInfo, error) {
 	n, ok := f.resolvePath(parent)
 	if !ok {
 		return fao.NodeInfo{
@@ -239,7 +239,7 @@ func (f *MemFAO) Symlink(parent, name, target string) (fao.NodeInfo, error) {
 	return newNode.NodeInfo, nil
 }
 
-func (f *MemFAO) Unlink(path string) error {
+func (f *MemFAO) Unlink(ctx context.Context, path string) error {
 	parent := filepath.Dir(path)
 	name := filepath.Base(path)
 
@@ -250,12 +250,12 @@ func (f *MemFAO) Unlink(path string) error {
 	if _, ok := n.Nodes.Get(name); !ok {
 		return fao.Errorf(syscall.ENOENT, "node %s does not exist", name)
 	}
-	fmt.Printf("deleting %s from %s\n", name, parent)
+	logger.S("mem").Debug("deleting %s from %s", name, parent)
 	n.Nodes.Del(name)
 	return nil
 }
 
-func (f *MemFAO) Move(source, parent, name string) error {
+func (f *MemFAO) Move(ctx context.Context, source, parent, name string) error {
 	sourceParent := filepath.Dir(source)
 	sourceParentNode, ok := f.resolvePath(sourceParent)
 	if !ok {
@@ -275,7 +275,7 @@ func (f *MemFAO) Move(source, parent, name string) error {
 	return nil
 }
 
-func (f *MemFAO) ReadAll(path string) (io.ReadCloser, error) {
+func (f *MemFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
 	n, ok := f.resolvePath(path)
 	if !ok {
 		return nil, fao.Errorf(syscall.ENOENT, "node %s does not exist", path)
@@ -285,3 +285,14 @@ func (f *MemFAO) ReadAll(path string) (io.ReadCloser, error) {
 	}
 	return io.NopCloser(strings.NewReader(string(n.Data))), nil
 }
+
+func (f *MemFAO) Fsync(ctx context.Context, path string) error {
+	if _, ok := f.resolvePath(path); !ok {
+		return fao.Errorf(syscall.ENOENT, "node %s does not exist", path)
+	}
+	return nil
+}
+
+func (f *MemFAO) TakeError(ctx context.Context, path string) error {
+	return nil
+}
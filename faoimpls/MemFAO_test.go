@@ -18,7 +18,10 @@
  */
 package faoimpls
 
-import "testing"
+import (
+	"context"
+	"testing"
+)
 
 func TestMemFAO(t *testing.T) {
 	t.Run("clear-box test for MemFAO->resolvePath", func(t *testing.T) {
@@ -39,10 +42,11 @@ func TestMemFAO(t *testing.T) {
 	})
 
 	t.Run("MemFAO->write and read", func(t *testing.T) {
+		ctx := context.Background()
 		fao := CreateMemFAO()
 
 		// Create a file
-		nodeInfo, err := fao.Create("/", "test-file")
+		nodeInfo, err := fao.Create(ctx, "/", "test-file")
 		if err != nil {
 			t.Errorf("Expected nil, got %v", err)
 		}
@@ -51,7 +55,7 @@ func TestMemFAO(t *testing.T) {
 		}
 
 		// Write to the file
-		n, err := fao.Write("/test-file", []byte("test"), 0)
+		n, err := fao.Write(ctx, "/test-file", []byte("test"), 0)
 		if err != nil {
 			t.Errorf("Expected nil, got %v", err)
 		}
@@ -61,7 +65,7 @@ func TestMemFAO(t *testing.T) {
 
 		// Read the file
 		dest := make([]byte, 4)
-		n, err = fao.Read("/test-file", dest, 0)
+		n, err = fao.Read(ctx, "/test-file", dest, 0)
 		if err != nil {
 			t.Errorf("Expected nil, got %v", err)
 		}
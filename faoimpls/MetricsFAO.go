@@ -0,0 +1,155 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/metrics"
+)
+
+var (
+	faoCalls = metrics.NewCounterVec("puterfuse_fao_calls_total",
+		"FAO method calls, by layer.", "layer", "method")
+	faoErrors = metrics.NewCounterVec("puterfuse_fao_errors_total",
+		"FAO method calls which returned an error, by layer.", "layer", "method")
+	faoDuration = metrics.NewHistogramVec("puterfuse_fao_duration_seconds",
+		"Time spent in FAO methods, including the layers below.",
+		metrics.DurationBuckets, "layer", "method")
+)
+
+// MetricsFAO counts calls, errors and latency for the layer it wraps.
+type MetricsFAO struct {
+	fao.ProxyFAO
+	Layer string
+}
+
+func CreateMetricsFAO(delegate fao.FAO, layer string) *MetricsFAO {
+	return &MetricsFAO{
+		ProxyFAO: fao.ProxyFAO{
+			P_CreateProxyFAO: fao.P_CreateProxyFAO{
+				Delegate: delegate,
+			},
+		},
+		Layer: layer,
+	}
+}
+
+func (f *MetricsFAO) observe(method string, start time.Time, err error) {
+	faoCalls.With(f.Layer, method).Inc()
+	faoDuration.With(f.Layer, method).ObserveSince(start)
+	if err != nil {
+		faoErrors.With(f.Layer, method).Inc()
+	}
+}
+
+func (f *MetricsFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	start := time.Now()
+	nodeInfo, exists, err := f.Delegate.Stat(ctx, path)
+	f.observe("Stat", start, err)
+	return nodeInfo, exists, err
+}
+
+func (f *MetricsFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	start := time.Now()
+	nodeInfos, err := f.Delegate.ReadDir(ctx, path)
+	f.observe("ReadDir", start, err)
+	return nodeInfos, err
+}
+
+func (f *MetricsFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.Delegate.Read(ctx, path, dest, off)
+	f.observe("Read", start, err)
+	return n, err
+}
+
+func (f *MetricsFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.Delegate.Write(ctx, path, src, off)
+	f.observe("Write", start, err)
+	return n, err
+}
+
+func (f *MetricsFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	start := time.Now()
+	nodeInfo, err := f.Delegate.Create(ctx, path, name)
+	f.observe("Create", start, err)
+	return nodeInfo, err
+}
+
+func (f *MetricsFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	start := time.Now()
+	err := f.Delegate.Truncate(ctx, path, size)
+	f.observe("Truncate", start, err)
+	return err
+}
+
+func (f *MetricsFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	start := time.Now()
+	nodeInfo, err := f.Delegate.MkDir(ctx, path, name)
+	f.observe("MkDir", start, err)
+	return nodeInfo, err
+}
+
+func (f *MetricsFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
+	start := time.Now()
+	nodeInfo, err := f.Delegate.Symlink(ctx, parent, name, target)
+	f.observe("Symlink", start, err)
+	return nodeInfo, err
+}
+
+func (f *MetricsFAO) Unlink(ctx context.Context, path string) error {
+	start := time.Now()
+	err := f.Delegate.Unlink(ctx, path)
+	f.observe("Unlink", start, err)
+	return err
+}
+
+func (f *MetricsFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	start := time.Now()
+	err := f.Delegate.Move(ctx, source, parent, name)
+	f.observe("Move", start, err)
+	return err
+}
+
+// Only the time to open the stream is measured.
+func (f *MetricsFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	start := time.Now()
+	reader, err := f.Delegate.ReadAll(ctx, path)
+	f.observe("ReadAll", start, err)
+	return reader, err
+}
+
+func (f *MetricsFAO) Fsync(ctx context.Context, path string) error {
+	start := time.Now()
+	err := f.Delegate.Fsync(ctx, path)
+	f.observe("Fsync", start, err)
+	return err
+}
+
+func (f *MetricsFAO) TakeError(ctx context.Context, path string) error {
+	start := time.Now()
+	err := f.Delegate.TakeError(ctx, path)
+	f.observe("TakeError", start, err)
+	return err
+}
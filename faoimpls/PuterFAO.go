@@ -19,10 +19,14 @@
 package faoimpls
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"path/filepath"
+	"syscall"
 
 	"github.com/HeyPuter/puter-fuse/debug"
 	"github.com/HeyPuter/puter-fuse/engine"
@@ -35,17 +39,29 @@ import (
 type P_PuterFAO struct {
 	SDK     *putersdk.PuterSDK
 	ReadFAO fao.FAO
+
+	// optional; new contents of a file are staged here and streamed
+	// from it rather than held in memory
+	BLOBCache *engine.BLOBCacheService
 }
 
 type IC_PuterFAO interface {
 	engine.I_Batcher_EnqueueOperationRequest
+	engine.I_Batcher_EnqueueUpload
 }
 
 type D_PuterFAO struct {
 	EnqueueOperationRequest func(
+		ctx context.Context,
 		operation putersdk.Operation,
 		blob []byte,
 	) engine.OperationRequestPromise
+	EnqueueUpload func(
+		ctx context.Context,
+		operation putersdk.Operation,
+		upload putersdk.Upload,
+		resolved func(),
+	) engine.OperationRequestPromise
 }
 
 type PuterFAO struct {
@@ -69,19 +85,22 @@ func CreatePuterFAO(
 	return fao
 }
 
-func (f *PuterFAO) Stat(path string) (fao.NodeInfo, bool, error) {
-	item, err := f.SDK.Stat(path)
-	if err != nil {
+func (f *PuterFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	item, err := f.SDK.Stat(ctx, path)
+	if putersdk.IsStatus(err, http.StatusNotFound) {
 		return fao.NodeInfo{}, false, nil
 	}
+	if err != nil {
+		return fao.NodeInfo{}, false, sdkError(err)
+	}
 
 	return fao.NodeInfo{CloudItem: item}, true, nil
 }
 
-func (f *PuterFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
-	items, err := f.SDK.Readdir(debug.NewLogger("PuterFAO"), path)
+func (f *PuterFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	items, err := f.SDK.Readdir(ctx, debug.NewLogger("PuterFAO"), path)
 	if err != nil {
-		return nil, err
+		return nil, sdkError(err)
 	}
 
 	nodeInfos := make([]fao.NodeInfo, len(items))
@@ -92,69 +111,109 @@ func (f *PuterFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
 	return nodeInfos, nil
 }
 
-func (f *PuterFAO) Read(path string, dest []byte, off int64) (int, error) {
-	data, err := f.SDK.Read(path)
+func (f *PuterFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	data, err := f.SDK.Read(ctx, path)
 	if err != nil {
-		return 0, err
+		return 0, sdkError(err)
 	}
 
-	copy(dest, data[off:])
-	return len(data) - int(off), nil
+	if off >= int64(len(data)) {
+		return 0, nil
+	}
+
+	return copy(dest, data[off:]), nil
 }
 
-func (f *PuterFAO) Write(path string, src []byte, off int64) (int, error) {
-	parent := filepath.Dir(path)
-	name := filepath.Base(path)
+// Replaces the contents of the file at 'path' with 'contents', which
+// are read from 'download'. The download is closed once they've been
+// read, before they're sent, so it doesn't hold a request slot the
+// upload may be waiting for.
+func (f *PuterFAO) writeContents(ctx context.Context, path string, contents io.Reader, download io.Closer) error {
+	operation := putersdk.Operation{
+		"op":        "write",
+		"path":      filepath.Dir(path),
+		"name":      filepath.Base(path),
+		"overwrite": true,
+	}
 
-	fileContentsReader, err := f.ReadFAO.ReadAll(path)
+	if f.BLOBCache == nil || f.EnqueueUpload == nil {
+		data, err := io.ReadAll(contents)
+		download.Close()
+		if err != nil {
+			return err
+		}
+		return operationError(<-f.EnqueueOperationRequest(ctx, operation, data).Await)
+	}
+
+	ref, err := f.BLOBCache.TryStore(contents)
+	download.Close()
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	fileContents, err := io.ReadAll(fileContentsReader)
+	upload, err := f.BLOBCache.Upload(ref)
 	if err != nil {
-		return 0, nil
+		ref.Release()
+		return err
+	}
+	// The blob is read when the batch is sent, which can be after the
+	// promise times out, so it's held until the batch resolves it.
+	return operationError(<-f.EnqueueUpload(ctx, operation, upload, ref.Release).Await)
+}
+
+func (f *PuterFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	stat, exists, err := f.ReadFAO.Stat(ctx, path)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, fao.Errorf(syscall.ENOENT, "node %s does not exist", path)
 	}
-	fileContentsReader.Close()
 
-	if int64(len(fileContents)) < off+int64(len(src)) {
-		newData := make([]byte, off+int64(len(src)))
-		copy(newData, fileContents)
-		fileContents = newData
+	reader, err := f.ReadFAO.ReadAll(ctx, path)
+	if err != nil {
+		return 0, err
 	}
-	copy(fileContents[off:], src)
 
-	<-f.EnqueueOperationRequest(
-		putersdk.Operation{
-			"op":        "write",
-			"path":      parent,
-			"name":      name,
-			"overwrite": true,
-		},
-		fileContents,
-	).Await
+	// The file is extended first, so the write never starts past the
+	// end of what it's patching.
+	extend := &engine.TruncateMutation{Size: max(stat.Size, uint64(off)+uint64(len(src)))}
+	contents, _ := extend.Apply(reader)
+	write := &engine.WriteMutation{Data: src, Offset: off}
+	contents, _ = write.Apply(contents)
+
+	if err := f.writeContents(ctx, path, contents, reader); err != nil {
+		return 0, err
+	}
 
 	return len(src), nil
 }
 
-func (f *PuterFAO) Create(path string, name string) (fao.NodeInfo, error) {
+func (f *PuterFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
 	empty := make([]byte, 0)
 	resp := <-f.EnqueueOperationRequest(
+		ctx,
 		putersdk.Operation{
-			"op":        "write",
-			"path":      path,
-			"name":      name,
-			"overwrite": true,
+			"op":                    "write",
+			"path":                  path,
+			"name":                  name,
+			"overwrite":             true,
+			engine.OperationCreates: true,
 		},
 		empty,
 	).Await
 
 	// random trace uuid
 	uid := uuid.New().String()
+	log := logger.S("puter").With("trace", uid)
 
-	{
+	if log.Enabled(debug.LevelDebug) {
 		jsonBytes, _ := json.Marshal(resp)
-		fmt.Println("resp is: ", uid, string(jsonBytes))
+		log.Debug("create response: %s", jsonBytes)
+	}
+
+	if err := operationError(resp); err != nil {
+		return fao.NodeInfo{}, err
 	}
 
 	cloudItem := &putersdk.CloudItem{}
@@ -167,60 +226,44 @@ func (f *PuterFAO) Create(path string, name string) (fao.NodeInfo, error) {
 
 	// assert that node is not a directory
 	if node.IsDir {
-		fmt.Println("Path is: ", uid, path)
-		fmt.Println("Name is: ", uid, name)
-		fmt.Printf("Node is: %s %+v\n", uid, node)
+		log.With("path", path, "name", name).Error("node is %+v", node)
 		panic("created node is a directory")
 	}
 
 	if node.Path == "" {
-		fmt.Println("Path is: ", uid, path)
-		fmt.Println("Name is: ", uid, name)
-		fmt.Printf("Node is: %s %+v\n", uid, node)
+		log.With("path", path, "name", name).Error("node is %+v", node)
 		panic("created node is missing path")
 	}
 
 	return node, nil
 }
 
-func (f *PuterFAO) Truncate(path string, size uint64) error {
-	fileContentsReader, err := f.ReadFAO.ReadAll(path)
+func (f *PuterFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	stat, exists, err := f.ReadFAO.Stat(ctx, path)
 	if err != nil {
 		return err
 	}
-
-	fileContents, err := io.ReadAll(fileContentsReader)
-	if err != nil {
-		return err
+	if !exists {
+		return fao.Errorf(syscall.ENOENT, "node %s does not exist", path)
 	}
-	fileContentsReader.Close()
-
-	if uint64(len(fileContents)) == size {
+	if stat.Size == size {
 		return nil
 	}
 
-	newData := make([]byte, size)
-	copy(newData, fileContents)
-	fileContents = newData
+	reader, err := f.ReadFAO.ReadAll(ctx, path)
+	if err != nil {
+		return err
+	}
 
-	parent := filepath.Dir(path)
-	name := filepath.Base(path)
+	truncate := &engine.TruncateMutation{Size: size}
+	contents, _ := truncate.Apply(reader)
 
-	<-f.EnqueueOperationRequest(
-		putersdk.Operation{
-			"op":        "write",
-			"path":      parent,
-			"name":      name,
-			"overwrite": true,
-		},
-		fileContents,
-	).Await
-
-	return nil
+	return f.writeContents(ctx, path, contents, reader)
 }
 
-func (f *PuterFAO) MkDir(parent string, path string) (fao.NodeInfo, error) {
+func (f *PuterFAO) MkDir(ctx context.Context, parent string, path string) (fao.NodeInfo, error) {
 	resp := <-f.EnqueueOperationRequest(
+		ctx,
 		putersdk.Operation{
 			"op":     "mkdir",
 			"parent": parent,
@@ -229,6 +272,10 @@ func (f *PuterFAO) MkDir(parent string, path string) (fao.NodeInfo, error) {
 		nil,
 	).Await
 
+	if err := operationError(resp); err != nil {
+		return fao.NodeInfo{}, err
+	}
+
 	cloudItem := &putersdk.CloudItem{}
 	err := localutil.ReJSON(resp.Data, cloudItem)
 	if err != nil {
@@ -238,26 +285,105 @@ func (f *PuterFAO) MkDir(parent string, path string) (fao.NodeInfo, error) {
 	return fao.NodeInfo{CloudItem: *cloudItem}, nil
 }
 
-func (f *PuterFAO) Symlink(parent string, name string, target string) (fao.NodeInfo, error) {
-	cloudItem, err := f.SDK.Symlink(filepath.Join(parent, name), target)
+func (f *PuterFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
+	cloudItem, err := f.SDK.Symlink(ctx, filepath.Join(parent, name), target)
 	if err != nil {
-		return fao.NodeInfo{}, err
+		return fao.NodeInfo{}, sdkError(err)
 	}
 
 	nodeInfo := fao.NodeInfo{CloudItem: *cloudItem}
 	return nodeInfo, nil
 }
 
-func (f *PuterFAO) Unlink(path string) error {
-	return f.SDK.Delete(path)
+// Deletes and moves are queued with writes so they're sent in order,
+// and so the operation service can coalesce them.
+func (f *PuterFAO) Unlink(ctx context.Context, path string) error {
+	resp := <-f.EnqueueOperationRequest(
+		ctx,
+		putersdk.Operation{
+			"op":   "delete",
+			"path": path,
+		},
+		nil,
+	).Await
+
+	return operationError(resp)
 }
 
-func (f *PuterFAO) Move(source string, parent string, name string) error {
-	fmt.Println("performing a move operation")
-	_, err := f.SDK.Move(source, parent, name)
+func (f *PuterFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	logger.S("puter").Debug("moving %s to %s/%s", source, parent, name)
+	resp := <-f.EnqueueOperationRequest(
+		ctx,
+		putersdk.Operation{
+			"op":     "move",
+			"source": source,
+			"parent": parent,
+			"name":   name,
+		},
+		nil,
+	).Await
+
+	return operationError(resp)
+}
+
+func (f *PuterFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	// the body is read after the request that asked for it has ended
+	reader, err := f.SDK.ReadStream(context.WithoutCancel(ctx), path)
+	if err != nil {
+		return nil, sdkError(err)
+	}
+	return reader, nil
+}
+
+// Operations are awaited before PuterFAO returns, so by the time Fsync
+// can be called there is nothing left to wait for.
+func (f *PuterFAO) Fsync(ctx context.Context, path string) error {
+	return nil
+}
+
+// Failed operations are reported by the calls which sent them.
+func (f *PuterFAO) TakeError(ctx context.Context, path string) error {
+	return nil
+}
+
+// Maps errors from putersdk to errnos for the filesystem.
+func sdkError(err error) error {
+	switch {
+	case errors.Is(err, putersdk.ErrUnauthenticated):
+		return fao.Errorf(syscall.EACCES, "%s", err)
+	case putersdk.IsStatus(err, http.StatusNotFound):
+		return fao.Errorf(syscall.ENOENT, "%s", err)
+	}
 	return err
 }
 
-func (f *PuterFAO) ReadAll(path string) (io.ReadCloser, error) {
-	return f.SDK.ReadStream(path)
+// Returns an error if a batch operation failed; Puter reports these in
+// place of the operation's result.
+func operationError(resp engine.OperationResponse) error {
+	errorValue, hasError := resp.Data["error"]
+	if !hasError || errorValue == nil || errorValue == false {
+		return nil
+	}
+
+	message, _ := resp.Data["message"].(string)
+	if message == "" {
+		message = fmt.Sprintf("%v", errorValue)
+	}
+
+	errno := syscall.EIO
+	code, _ := resp.Data["code"].(string)
+	switch code {
+	case "storage_limit_reached":
+		errno = syscall.ENOSPC
+	case "subject_does_not_exist", "dest_does_not_exist":
+		errno = syscall.ENOENT
+	case "item_with_same_name_exists":
+		errno = syscall.EEXIST
+	case "forbidden", "access_denied":
+		errno = syscall.EACCES
+	case "read_only":
+		errno = syscall.EROFS
+	}
+
+	return fao.Errorf(errno, "batch operation failed: %s", message)
 }
@@ -0,0 +1,177 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/engine"
+	"github.com/HeyPuter/puter-fuse/putersdk"
+	"github.com/spf13/afero"
+)
+
+type cacheDirConfig string
+
+func (c cacheDirConfig) GetString(key string) string { return string(c) }
+func (c cacheDirConfig) GetInt(key string) int       { return 0 }
+
+// Reads as an endless run of 'x'.
+type repeatReader struct{}
+
+func (repeatReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+func TestPuterFAOWriteStreams(t *testing.T) {
+	const size = 64 << 20
+
+	var uploaded int64
+	var head []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stat":
+			json.NewEncoder(w).Encode(map[string]interface{}{"path": "/big", "size": size})
+		case "/read":
+			io.CopyN(w, repeatReader{}, size)
+		case "/batch":
+			_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			reader := multipart.NewReader(r.Body, params["boundary"])
+			for {
+				part, err := reader.NextPart()
+				if err != nil {
+					break
+				}
+				if part.FormName() != "file" {
+					continue
+				}
+				head = make([]byte, 5)
+				io.ReadFull(part, head)
+				n, _ := io.Copy(io.Discard, part)
+				uploaded = int64(len(head)) + n
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"results": []map[string]interface{}{{"path": "/big"}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	sdk := &putersdk.PuterSDK{Url: server.URL, PuterAuthToken: "token"}
+	sdk.Init()
+	operations := &engine.OperationService{SDK: sdk}
+	operations.Init(nil)
+	blobCache := engine.CreateBLOBCacheService(afero.NewOsFs())
+	blobCache.ConfigService = cacheDirConfig(t.TempDir())
+
+	f := CreatePuterFAO(
+		P_PuterFAO{SDK: sdk, BLOBCache: blobCache},
+		D_PuterFAO{
+			EnqueueOperationRequest: operations.EnqueueOperationRequest,
+			EnqueueUpload:           operations.EnqueueUpload,
+		},
+	)
+	f.ReadFAO = f
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	if _, err := f.Write(context.Background(), "/big", []byte("hello"), 0); err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if uploaded != size || !bytes.Equal(head, []byte("hello")) {
+		t.Errorf("expected %d bytes starting with hello, got %d starting with %q", size, uploaded, head)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/8 {
+		t.Errorf("expected the write to stream, but it allocated %d bytes", allocated)
+	}
+}
+
+func TestPuterFAOWriteReleasesDownload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stat":
+			json.NewEncoder(w).Encode(map[string]interface{}{"path": "/file", "size": 5})
+		case "/read":
+			w.Write([]byte("world"))
+		case "/batch":
+			io.Copy(io.Discard, r.Body)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"results": []map[string]interface{}{{"path": "/file"}},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	// the download and the upload share the only write-back slot
+	limits := putersdk.DefaultSchedulerLimits
+	limits.Classes[putersdk.ClassWriteBack] = 1
+	sdk := &putersdk.PuterSDK{
+		Url:            server.URL,
+		PuterAuthToken: "token",
+		Scheduler:      putersdk.CreateScheduler(limits),
+	}
+	sdk.Init()
+	operations := &engine.OperationService{SDK: sdk}
+	operations.Init(nil)
+	blobCache := engine.CreateBLOBCacheService(afero.NewOsFs())
+	blobCache.ConfigService = cacheDirConfig(t.TempDir())
+
+	f := CreatePuterFAO(
+		P_PuterFAO{SDK: sdk, BLOBCache: blobCache},
+		D_PuterFAO{
+			EnqueueOperationRequest: operations.EnqueueOperationRequest,
+			EnqueueUpload:           operations.EnqueueUpload,
+		},
+	)
+	f.ReadFAO = f
+
+	done := make(chan error, 1)
+	go func() {
+		ctx := putersdk.WithClass(context.Background(), putersdk.ClassWriteBack)
+		_, err := f.Write(ctx, "/file", []byte("hello"), 0)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the write to finish while the download's slot is free")
+	}
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"context"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+)
+
+// ReadOnlyFAO rejects every mutating operation with EROFS while it is
+// read-only. It can be switched at runtime, which is how new writes are
+// refused during shutdown while pending ones drain below it.
+type ReadOnlyFAO struct {
+	fao.ProxyFAO
+	readOnly atomic.Bool
+}
+
+func CreateReadOnlyFAO(delegate fao.FAO, readOnly bool) *ReadOnlyFAO {
+	ins := &ReadOnlyFAO{}
+	ins.Delegate = delegate
+	ins.readOnly.Store(readOnly)
+	return ins
+}
+
+func (f *ReadOnlyFAO) SetReadOnly(readOnly bool) {
+	f.readOnly.Store(readOnly)
+}
+
+func (f *ReadOnlyFAO) IsReadOnly() bool {
+	return f.readOnly.Load()
+}
+
+func (f *ReadOnlyFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	if f.IsReadOnly() {
+		return 0, fao.Errorf(syscall.EROFS, "cannot write %s: read-only", path)
+	}
+	return f.Delegate.Write(ctx, path, src, off)
+}
+
+func (f *ReadOnlyFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	if f.IsReadOnly() {
+		return fao.NodeInfo{}, fao.Errorf(syscall.EROFS, "cannot create %s in %s: read-only", name, path)
+	}
+	return f.Delegate.Create(ctx, path, name)
+}
+
+func (f *ReadOnlyFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	if f.IsReadOnly() {
+		return fao.Errorf(syscall.EROFS, "cannot truncate %s: read-only", path)
+	}
+	return f.Delegate.Truncate(ctx, path, size)
+}
+
+func (f *ReadOnlyFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	if f.IsReadOnly() {
+		return fao.NodeInfo{}, fao.Errorf(syscall.EROFS, "cannot create %s in %s: read-only", name, path)
+	}
+	return f.Delegate.MkDir(ctx, path, name)
+}
+
+func (f *ReadOnlyFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
+	if f.IsReadOnly() {
+		return fao.NodeInfo{}, fao.Errorf(syscall.EROFS, "cannot create %s in %s: read-only", name, parent)
+	}
+	return f.Delegate.Symlink(ctx, parent, name, target)
+}
+
+func (f *ReadOnlyFAO) Unlink(ctx context.Context, path string) error {
+	if f.IsReadOnly() {
+		return fao.Errorf(syscall.EROFS, "cannot remove %s: read-only", path)
+	}
+	return f.Delegate.Unlink(ctx, path)
+}
+
+func (f *ReadOnlyFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	if f.IsReadOnly() {
+		return fao.Errorf(syscall.EROFS, "cannot move %s: read-only", source)
+	}
+	return f.Delegate.Move(ctx, source, parent, name)
+}
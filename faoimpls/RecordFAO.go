@@ -0,0 +1,358 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+)
+
+// FAORecord is one call recorded by RecordFAO. Recordings are written as
+// one JSON record per line.
+type FAORecord struct {
+	Seq    int64     `json:"seq"`
+	Layer  string    `json:"layer"`
+	Method string    `json:"method"`
+	Args   FAOArgs   `json:"args"`
+	Result FAOResult `json:"result"`
+	// nanoseconds since the recording began
+	Start    int64 `json:"start"`
+	Duration int64 `json:"dur"`
+}
+
+type FAOArgs struct {
+	Path   string `json:"path,omitempty"`
+	Name   string `json:"name,omitempty"`
+	Parent string `json:"parent,omitempty"`
+	Target string `json:"target,omitempty"`
+	Offset int64  `json:"offset,omitempty"`
+	Size   uint64 `json:"size,omitempty"`
+	// length of the buffer passed to Read
+	Length int `json:"length,omitempty"`
+	FAOData
+}
+
+type FAOResult struct {
+	N      int            `json:"n,omitempty"`
+	Exists bool           `json:"exists,omitempty"`
+	Node   *fao.NodeInfo  `json:"node,omitempty"`
+	Nodes  []fao.NodeInfo `json:"nodes,omitempty"`
+	Errno  syscall.Errno  `json:"errno,omitempty"`
+	Error  string         `json:"error,omitempty"`
+	FAOData
+}
+
+// FAOData is the contents written or read by a call. Unless the
+// recorder keeps contents, only their size and hash are recorded.
+type FAOData struct {
+	Data       []byte `json:"data,omitempty"`
+	DataSize   int    `json:"data_size,omitempty"`
+	DataSHA256 string `json:"data_sha256,omitempty"`
+}
+
+func fullData(data []byte) FAOData {
+	sum := sha256.Sum256(data)
+	return FAOData{
+		Data:       data,
+		DataSize:   len(data),
+		DataSHA256: hex.EncodeToString(sum[:]),
+	}
+}
+
+// Returns whether the contents themselves were recorded.
+func (d FAOData) hasContents() bool {
+	return d.Data != nil || d.DataSHA256 == ""
+}
+
+// Returns the recorded contents, or zeroes of the same size if only
+// their size was recorded.
+func (d FAOData) contents() []byte {
+	if !d.hasContents() {
+		return make([]byte, d.DataSize)
+	}
+	return d.Data
+}
+
+func (r *FAOResult) setError(err error) {
+	if err == nil {
+		return
+	}
+	r.Error = err.Error()
+	var faoErr *fao.FAOError
+	if errors.As(err, &faoErr) {
+		r.Errno = faoErr.Errno
+	}
+}
+
+// Returns the recorded error as the FAO returned it.
+func (r *FAOResult) err() error {
+	if r.Error == "" {
+		return nil
+	}
+	if r.Errno != 0 {
+		return fao.Errorf(r.Errno, "%s", r.Error)
+	}
+	return errors.New(r.Error)
+}
+
+type P_FAORecorder struct {
+	// record file contents instead of only their size and SHA-256
+	Contents bool
+}
+
+// FAORecorder writes the records of one or more RecordFAOs to 'w'.
+type FAORecorder struct {
+	P_FAORecorder
+	w      io.WriteCloser
+	start  time.Time
+	seq    int64
+	lock   sync.Mutex
+	closed bool
+}
+
+func CreateFAORecorder(w io.WriteCloser, params P_FAORecorder) *FAORecorder {
+	return &FAORecorder{
+		P_FAORecorder: params,
+		w:             w,
+		start:         time.Now(),
+	}
+}
+
+func (r *FAORecorder) data(data []byte) FAOData {
+	recorded := fullData(data)
+	if !r.Contents {
+		recorded.Data = nil
+	}
+	return recorded
+}
+
+func (r *FAORecorder) write(record FAORecord) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return
+	}
+
+	r.seq++
+	record.Seq = r.seq
+	data, err := json.Marshal(record)
+	if err != nil {
+		logger.S("record").Error("error encoding %s record: %s", record.Method, err)
+		return
+	}
+	r.w.Write(append(data, '\n'))
+}
+
+func (r *FAORecorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	return r.w.Close()
+}
+
+// Reads a recording written by FAORecorder, which may be gzipped.
+func ReadFAORecording(r io.Reader) ([]FAORecord, error) {
+	buffered := bufio.NewReader(r)
+	if magic, _ := buffered.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		buffered = bufio.NewReader(gz)
+	}
+
+	records := []FAORecord{}
+	decoder := json.NewDecoder(buffered)
+	for {
+		var record FAORecord
+		err := decoder.Decode(&record)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			// a recording cut short by a crash is still useful
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return records, nil
+			}
+			return nil, err
+		}
+		records = append(records, record)
+	}
+}
+
+// RecordFAO records every call into the layer it wraps, with its
+// arguments, results, errors and timing.
+type RecordFAO struct {
+	fao.ProxyFAO
+	Layer    string
+	Recorder *FAORecorder
+}
+
+func CreateRecordFAO(delegate fao.FAO, recorder *FAORecorder, layer string) *RecordFAO {
+	return &RecordFAO{
+		ProxyFAO: fao.ProxyFAO{
+			P_CreateProxyFAO: fao.P_CreateProxyFAO{
+				Delegate: delegate,
+			},
+		},
+		Layer:    layer,
+		Recorder: recorder,
+	}
+}
+
+func (f *RecordFAO) record(method string, args FAOArgs, start time.Time, result FAOResult, err error) {
+	result.setError(err)
+	f.Recorder.write(FAORecord{
+		Layer:    f.Layer,
+		Method:   method,
+		Args:     args,
+		Result:   result,
+		Start:    start.Sub(f.Recorder.start).Nanoseconds(),
+		Duration: time.Since(start).Nanoseconds(),
+	})
+}
+
+func (f *RecordFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	start := time.Now()
+	nodeInfo, exists, err := f.Delegate.Stat(ctx, path)
+	result := FAOResult{Exists: exists}
+	if exists {
+		result.Node = &nodeInfo
+	}
+	f.record("Stat", FAOArgs{Path: path}, start, result, err)
+	return nodeInfo, exists, err
+}
+
+func (f *RecordFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	start := time.Now()
+	nodeInfos, err := f.Delegate.ReadDir(ctx, path)
+	f.record("ReadDir", FAOArgs{Path: path}, start, FAOResult{Nodes: nodeInfos}, err)
+	return nodeInfos, err
+}
+
+func (f *RecordFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.Delegate.Read(ctx, path, dest, off)
+	args := FAOArgs{Path: path, Offset: off, Length: len(dest)}
+	result := FAOResult{N: n, FAOData: f.Recorder.data(dest[:max(n, 0)])}
+	f.record("Read", args, start, result, err)
+	return n, err
+}
+
+func (f *RecordFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	start := time.Now()
+	n, err := f.Delegate.Write(ctx, path, src, off)
+	args := FAOArgs{Path: path, Offset: off, FAOData: f.Recorder.data(src)}
+	f.record("Write", args, start, FAOResult{N: n}, err)
+	return n, err
+}
+
+func (f *RecordFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	start := time.Now()
+	nodeInfo, err := f.Delegate.Create(ctx, path, name)
+	f.record("Create", FAOArgs{Path: path, Name: name}, start, FAOResult{Node: &nodeInfo}, err)
+	return nodeInfo, err
+}
+
+func (f *RecordFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	start := time.Now()
+	err := f.Delegate.Truncate(ctx, path, size)
+	f.record("Truncate", FAOArgs{Path: path, Size: size}, start, FAOResult{}, err)
+	return err
+}
+
+func (f *RecordFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	start := time.Now()
+	nodeInfo, err := f.Delegate.MkDir(ctx, path, name)
+	f.record("MkDir", FAOArgs{Path: path, Name: name}, start, FAOResult{Node: &nodeInfo}, err)
+	return nodeInfo, err
+}
+
+func (f *RecordFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
+	start := time.Now()
+	nodeInfo, err := f.Delegate.Symlink(ctx, parent, name, target)
+	args := FAOArgs{Parent: parent, Name: name, Target: target}
+	f.record("Symlink", args, start, FAOResult{Node: &nodeInfo}, err)
+	return nodeInfo, err
+}
+
+func (f *RecordFAO) Unlink(ctx context.Context, path string) error {
+	start := time.Now()
+	err := f.Delegate.Unlink(ctx, path)
+	f.record("Unlink", FAOArgs{Path: path}, start, FAOResult{}, err)
+	return err
+}
+
+func (f *RecordFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	start := time.Now()
+	err := f.Delegate.Move(ctx, source, parent, name)
+	args := FAOArgs{Path: source, Parent: parent, Name: name}
+	f.record("Move", args, start, FAOResult{}, err)
+	return err
+}
+
+// The stream is read in full so its contents can be hashed or recorded.
+func (f *RecordFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	start := time.Now()
+	reader, err := f.Delegate.ReadAll(ctx, path)
+	var data []byte
+	if err == nil {
+		data, err = io.ReadAll(reader)
+		reader.Close()
+	}
+	result := FAOResult{}
+	if err == nil {
+		result.FAOData = f.Recorder.data(data)
+	}
+	f.record("ReadAll", FAOArgs{Path: path}, start, result, err)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (f *RecordFAO) Fsync(ctx context.Context, path string) error {
+	start := time.Now()
+	err := f.Delegate.Fsync(ctx, path)
+	f.record("Fsync", FAOArgs{Path: path}, start, FAOResult{}, err)
+	return err
+}
+
+func (f *RecordFAO) TakeError(ctx context.Context, path string) error {
+	start := time.Now()
+	err := f.Delegate.TakeError(ctx, path)
+	f.record("TakeError", FAOArgs{Path: path}, start, FAOResult{}, err)
+	return err
+}
@@ -0,0 +1,147 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+)
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Records some calls into a MemFAO at the "top" and "backend" layers.
+func recordCalls(t *testing.T, w io.Writer, params P_FAORecorder) {
+	ctx := context.Background()
+	recorder := CreateFAORecorder(nopWriteCloser{w}, params)
+	var f fao.FAO = CreateRecordFAO(CreateMemFAO(), recorder, "backend")
+	f = CreateRecordFAO(f, recorder, "top")
+
+	f.MkDir(ctx, "/", "dir")
+	f.Create(ctx, "/dir", "file")
+	f.Write(ctx, "/dir/file", []byte("contents"), 0)
+	f.Read(ctx, "/dir/file", make([]byte, 16), 0)
+	f.ReadDir(ctx, "/dir")
+	f.Stat(ctx, "/dir/missing")
+	f.Unlink(ctx, "/dir/missing")
+	recorder.Close()
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	buf := &bytes.Buffer{}
+	recordCalls(t, buf, P_FAORecorder{Contents: true})
+
+	records, err := ReadFAORecording(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 14 {
+		t.Fatalf("expected 14 records; got %d", len(records))
+	}
+
+	testCases := []struct {
+		name       string
+		target     func() fao.FAO
+		mismatches int
+	}{
+		{"recorded backend", func() fao.FAO {
+			return CreateReplayFAO(records, "backend", P_ReplayFAO{})
+		}, 0},
+		{"same stack", func() fao.FAO {
+			return CreateMemFAO()
+		}, 0},
+		{"populated backend", func() fao.FAO {
+			mem := CreateMemFAO()
+			mem.MkDir(context.Background(), "/", "dir")
+			return mem
+		}, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mismatches := ReplayFAORecording(context.Background(), records, "top", tc.target(), false)
+			if len(mismatches) != tc.mismatches {
+				t.Fatalf("expected %d mismatches; got %v", tc.mismatches, mismatches)
+			}
+		})
+	}
+
+	t.Run("mismatch is described", func(t *testing.T) {
+		mem := CreateMemFAO()
+		mem.MkDir(context.Background(), "/", "dir")
+		mismatches := ReplayFAORecording(context.Background(), records, "top", mem, false)
+		expected := "#2 MkDir /: recorded dir(dir 0); replayed error file exists (node dir already exists)"
+		if got := mismatches[0].String(); got != expected {
+			t.Fatalf("expected %q; got %q", expected, got)
+		}
+	})
+
+	t.Run("gzip", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		recordCalls(t, gz, P_FAORecorder{Contents: true})
+		gz.Close()
+
+		records, err := ReadFAORecording(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(records) != 14 {
+			t.Fatalf("expected 14 records; got %d", len(records))
+		}
+	})
+	t.Run("contents are hashed unless asked for", func(t *testing.T) {
+		buf := &bytes.Buffer{}
+		recordCalls(t, buf, P_FAORecorder{})
+		if bytes.Contains(buf.Bytes(), []byte("contents")) {
+			t.Fatalf("expected no file contents in the recording; got %s", buf)
+		}
+
+		records, err := ReadFAORecording(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		targets := map[string]fao.FAO{
+			"recorded backend": CreateReplayFAO(records, "backend", P_ReplayFAO{}),
+			"same stack":       CreateMemFAO(),
+		}
+		for name, target := range targets {
+			mismatches := ReplayFAORecording(context.Background(), records, "top", target, false)
+			if len(mismatches) != 0 {
+				t.Errorf("%s: expected no mismatches; got %v", name, mismatches)
+			}
+		}
+
+		for _, record := range records {
+			if record.Layer != "top" || record.Method != "Read" {
+				continue
+			}
+			shorter := FAOResult{N: 3, FAOData: fullData([]byte("con"))}
+			if resultsMatch("Read", record.Result, shorter) {
+				t.Errorf("expected a read of a different size to mismatch")
+			}
+		}
+	})
+}
@@ -19,6 +19,7 @@
 package faoimpls
 
 import (
+	"context"
 	"github.com/HeyPuter/puter-fuse/engine"
 	"github.com/HeyPuter/puter-fuse/fao"
 	"github.com/HeyPuter/puter-fuse/services"
@@ -39,8 +40,8 @@ func CreateRemoteToLocalUIDFAO(
 	return ins
 }
 
-func (f *RemoteToLocalUIDFAO) Stat(path string) (fao.NodeInfo, bool, error) {
-	nodeInfo, exists, err := f.Delegate.Stat(path)
+func (f *RemoteToLocalUIDFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	nodeInfo, exists, err := f.Delegate.Stat(ctx, path)
 	if err == nil && exists {
 		localUID := f.associationService.GetLocalUIDFromRemote(nodeInfo.RemoteUID)
 		nodeInfo.LocalUID = localUID
@@ -48,8 +49,8 @@ func (f *RemoteToLocalUIDFAO) Stat(path string) (fao.NodeInfo, bool, error) {
 	return nodeInfo, exists, err
 }
 
-func (f *RemoteToLocalUIDFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
-	nodeInfos, err := f.Delegate.ReadDir(path)
+func (f *RemoteToLocalUIDFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	nodeInfos, err := f.Delegate.ReadDir(ctx, path)
 	if err == nil {
 		for i, nodeInfo := range nodeInfos {
 			localUID := f.associationService.GetLocalUIDFromRemote(nodeInfo.RemoteUID)
@@ -60,8 +61,8 @@ func (f *RemoteToLocalUIDFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
 	return nodeInfos, err
 }
 
-func (f *RemoteToLocalUIDFAO) Create(path string, name string) (fao.NodeInfo, error) {
-	nodeInfo, err := f.Delegate.Create(path, name)
+func (f *RemoteToLocalUIDFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	nodeInfo, err := f.Delegate.Create(ctx, path, name)
 	if err == nil {
 		localUID := f.associationService.GetLocalUIDFromRemote(nodeInfo.RemoteUID)
 		nodeInfo.LocalUID = localUID
@@ -69,8 +70,8 @@ func (f *RemoteToLocalUIDFAO) Create(path string, name string) (fao.NodeInfo, er
 	return nodeInfo, err
 }
 
-func (f *RemoteToLocalUIDFAO) MkDir(parent, path string) (fao.NodeInfo, error) {
-	nodeInfo, err := f.Delegate.MkDir(parent, path)
+func (f *RemoteToLocalUIDFAO) MkDir(ctx context.Context, parent, path string) (fao.NodeInfo, error) {
+	nodeInfo, err := f.Delegate.MkDir(ctx, parent, path)
 	if err == nil {
 		localUID := f.associationService.GetLocalUIDFromRemote(nodeInfo.RemoteUID)
 		nodeInfo.LocalUID = localUID
@@ -0,0 +1,399 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+)
+
+type P_ReplayFAO struct {
+	// take as long as the recorded call did
+	Timing bool
+}
+
+// ReplayFAO answers calls with the results recorded for one layer, so
+// the layers above it can be run without the original backend. Calls
+// are matched by method and arguments; each recorded result is used
+// once, then the last one is repeated.
+type ReplayFAO struct {
+	fao.BaseFAO
+	P_ReplayFAO
+
+	queues map[string][]FAORecord
+	last   map[string]FAORecord
+	lock   sync.Mutex
+}
+
+func CreateReplayFAO(records []FAORecord, layer string, params P_ReplayFAO) *ReplayFAO {
+	f := &ReplayFAO{
+		P_ReplayFAO: params,
+		queues:      map[string][]FAORecord{},
+		last:        map[string]FAORecord{},
+	}
+	for _, record := range records {
+		if record.Layer != layer {
+			continue
+		}
+		key := replayKey(record.Method, record.Args)
+		f.queues[key] = append(f.queues[key], record)
+	}
+	return f
+}
+
+// Written data isn't part of the key, so a write is matched even if
+// the layers above split it differently.
+func replayKey(method string, args FAOArgs) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%d|%d|%d",
+		method, args.Path, args.Name, args.Parent, args.Target,
+		args.Offset, args.Size, args.Length)
+}
+
+func (f *ReplayFAO) next(ctx context.Context, method string, args FAOArgs) (FAORecord, error) {
+	key := replayKey(method, args)
+
+	f.lock.Lock()
+	record, ok := f.last[key]
+	if queue := f.queues[key]; len(queue) > 0 {
+		record, ok = queue[0], true
+		f.queues[key] = queue[1:]
+		f.last[key] = record
+	}
+	f.lock.Unlock()
+
+	if !ok {
+		return FAORecord{}, fao.Errorf(syscall.EIO,
+			"no recorded result for %s %s", method, args.Path)
+	}
+	if f.Timing {
+		select {
+		case <-time.After(time.Duration(record.Duration)):
+		case <-ctx.Done():
+			return FAORecord{}, ctx.Err()
+		}
+	}
+	return record, nil
+}
+
+func (f *ReplayFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	record, err := f.next(ctx, "Stat", FAOArgs{Path: path})
+	if err != nil {
+		return fao.NodeInfo{}, false, err
+	}
+	if record.Result.Node == nil {
+		return fao.NodeInfo{}, record.Result.Exists, record.Result.err()
+	}
+	return *record.Result.Node, record.Result.Exists, record.Result.err()
+}
+
+func (f *ReplayFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	record, err := f.next(ctx, "ReadDir", FAOArgs{Path: path})
+	if err != nil {
+		return nil, err
+	}
+	return append([]fao.NodeInfo{}, record.Result.Nodes...), record.Result.err()
+}
+
+func (f *ReplayFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	args := FAOArgs{Path: path, Offset: off, Length: len(dest)}
+	record, err := f.next(ctx, "Read", args)
+	if err != nil {
+		return 0, err
+	}
+	copy(dest, record.Result.contents())
+	return record.Result.N, record.Result.err()
+}
+
+func (f *ReplayFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	record, err := f.next(ctx, "Write", FAOArgs{Path: path, Offset: off})
+	if err != nil {
+		return 0, err
+	}
+	return record.Result.N, record.Result.err()
+}
+
+func (f *ReplayFAO) node(ctx context.Context, method string, args FAOArgs) (fao.NodeInfo, error) {
+	record, err := f.next(ctx, method, args)
+	if err != nil {
+		return fao.NodeInfo{}, err
+	}
+	if record.Result.Node == nil {
+		return fao.NodeInfo{}, record.Result.err()
+	}
+	return *record.Result.Node, record.Result.err()
+}
+
+func (f *ReplayFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	return f.node(ctx, "Create", FAOArgs{Path: path, Name: name})
+}
+
+func (f *ReplayFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	return f.node(ctx, "MkDir", FAOArgs{Path: path, Name: name})
+}
+
+func (f *ReplayFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
+	return f.node(ctx, "Symlink", FAOArgs{Parent: parent, Name: name, Target: target})
+}
+
+func (f *ReplayFAO) errorOnly(ctx context.Context, method string, args FAOArgs) error {
+	record, err := f.next(ctx, method, args)
+	if err != nil {
+		return err
+	}
+	return record.Result.err()
+}
+
+func (f *ReplayFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	return f.errorOnly(ctx, "Truncate", FAOArgs{Path: path, Size: size})
+}
+
+func (f *ReplayFAO) Unlink(ctx context.Context, path string) error {
+	return f.errorOnly(ctx, "Unlink", FAOArgs{Path: path})
+}
+
+func (f *ReplayFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	return f.errorOnly(ctx, "Move", FAOArgs{Path: source, Parent: parent, Name: name})
+}
+
+func (f *ReplayFAO) Fsync(ctx context.Context, path string) error {
+	return f.errorOnly(ctx, "Fsync", FAOArgs{Path: path})
+}
+
+func (f *ReplayFAO) TakeError(ctx context.Context, path string) error {
+	return f.errorOnly(ctx, "TakeError", FAOArgs{Path: path})
+}
+
+func (f *ReplayFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	record, err := f.next(ctx, "ReadAll", FAOArgs{Path: path})
+	if err != nil {
+		return nil, err
+	}
+	if err := record.Result.err(); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(record.Result.contents())), nil
+}
+
+// FAOMismatch is a replayed call whose result differs from the recording.
+type FAOMismatch struct {
+	Record FAORecord
+	Got    FAOResult
+}
+
+func (m FAOMismatch) String() string {
+	path := m.Record.Args.Path
+	if path == "" {
+		path = m.Record.Args.Parent
+	}
+	return fmt.Sprintf("#%d %s %s: recorded %s; replayed %s",
+		m.Record.Seq, m.Record.Method, path,
+		describeResult(m.Record.Method, m.Record.Result),
+		describeResult(m.Record.Method, m.Got))
+}
+
+// Replays the calls recorded for 'layer' against 'target' and returns
+// those whose results differ. Calls are made one at a time in recorded
+// order, or with 'timing' at their recorded times, so overlapping calls
+// overlap again.
+func ReplayFAORecording(
+	ctx context.Context,
+	records []FAORecord,
+	layer string,
+	target fao.FAO,
+	timing bool,
+) []FAOMismatch {
+	mismatches := []FAOMismatch{}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	check := func(record FAORecord) {
+		got := callRecorded(ctx, target, record)
+		if !resultsMatch(record.Method, record.Result, got) {
+			lock.Lock()
+			mismatches = append(mismatches, FAOMismatch{Record: record, Got: got})
+			lock.Unlock()
+		}
+	}
+
+	begin := time.Now()
+	for _, record := range records {
+		if record.Layer != layer {
+			continue
+		}
+		if !timing {
+			check(record)
+			continue
+		}
+		select {
+		case <-time.After(time.Until(begin.Add(time.Duration(record.Start)))):
+		case <-ctx.Done():
+		}
+		wg.Add(1)
+		go func(record FAORecord) {
+			defer wg.Done()
+			check(record)
+		}(record)
+	}
+	wg.Wait()
+
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].Record.Seq < mismatches[j].Record.Seq
+	})
+	return mismatches
+}
+
+func callRecorded(ctx context.Context, target fao.FAO, record FAORecord) FAOResult {
+	args := record.Args
+	result := FAOResult{}
+	var err error
+
+	switch record.Method {
+	case "Stat":
+		var node fao.NodeInfo
+		node, result.Exists, err = target.Stat(ctx, args.Path)
+		if result.Exists {
+			result.Node = &node
+		}
+	case "ReadDir":
+		result.Nodes, err = target.ReadDir(ctx, args.Path)
+	case "Read":
+		dest := make([]byte, args.Length)
+		result.N, err = target.Read(ctx, args.Path, dest, args.Offset)
+		result.FAOData = fullData(dest[:max(result.N, 0)])
+	case "Write":
+		result.N, err = target.Write(ctx, args.Path, args.contents(), args.Offset)
+	case "Create", "MkDir", "Symlink":
+		var node fao.NodeInfo
+		switch record.Method {
+		case "Create":
+			node, err = target.Create(ctx, args.Path, args.Name)
+		case "MkDir":
+			node, err = target.MkDir(ctx, args.Path, args.Name)
+		case "Symlink":
+			node, err = target.Symlink(ctx, args.Parent, args.Name, args.Target)
+		}
+		result.Node = &node
+	case "Truncate":
+		err = target.Truncate(ctx, args.Path, args.Size)
+	case "Unlink":
+		err = target.Unlink(ctx, args.Path)
+	case "Move":
+		err = target.Move(ctx, args.Path, args.Parent, args.Name)
+	case "Fsync":
+		err = target.Fsync(ctx, args.Path)
+	case "TakeError":
+		err = target.TakeError(ctx, args.Path)
+	case "ReadAll":
+		var reader io.ReadCloser
+		reader, err = target.ReadAll(ctx, args.Path)
+		if err == nil {
+			var data []byte
+			data, err = io.ReadAll(reader)
+			reader.Close()
+			result.FAOData = fullData(data)
+		}
+	default:
+		err = fmt.Errorf("unknown method %s", record.Method)
+	}
+
+	result.setError(err)
+	return result
+}
+
+// The parts of a node which FUSE shows. UIDs and times are left out
+// since they differ between backends.
+func describeNode(node fao.NodeInfo) string {
+	kind := "file"
+	if node.IsDir {
+		kind = "dir"
+	} else if node.IsSymlink {
+		kind = "symlink:" + node.SymlinkPath
+	}
+	return fmt.Sprintf("%s(%s %d)", node.Name, kind, node.Size)
+}
+
+func describeResult(method string, result FAOResult) string {
+	if result.Error != "" {
+		if result.Errno != 0 {
+			return fmt.Sprintf("error %s (%s)", result.Errno.Error(), result.Error)
+		}
+		return "error " + result.Error
+	}
+
+	switch method {
+	case "Stat":
+		if !result.Exists {
+			return "not found"
+		}
+		return describeNode(*result.Node)
+	case "ReadDir":
+		names := make([]string, len(result.Nodes))
+		for i, node := range result.Nodes {
+			names[i] = describeNode(node)
+		}
+		sort.Strings(names)
+		return "[" + strings.Join(names, " ") + "]"
+	case "Read", "ReadAll":
+		if !result.hasContents() {
+			return fmt.Sprintf("%d bytes sha256:%s", result.DataSize, truncateString(result.DataSHA256, 12))
+		}
+		return fmt.Sprintf("%d bytes %q", len(result.Data), truncateString(string(result.Data), 32))
+	case "Write":
+		return fmt.Sprintf("%d bytes", result.N)
+	case "Create", "MkDir", "Symlink":
+		if result.Node == nil {
+			return "ok"
+		}
+		return describeNode(*result.Node)
+	}
+	return "ok"
+}
+
+func truncateString(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+func resultsMatch(method string, recorded, replayed FAOResult) bool {
+	// only the errno is compared; messages differ between backends
+	if (recorded.Error == "") != (replayed.Error == "") {
+		return false
+	}
+	if recorded.Error != "" {
+		return recorded.Errno == replayed.Errno
+	}
+	if !recorded.hasContents() {
+		// without the recorded contents, writes were replayed with
+		// zeroes, so only sizes can be compared
+		return recorded.DataSize == replayed.DataSize
+	}
+	return describeResult(method, recorded) == describeResult(method, replayed) &&
+		bytes.Equal(recorded.Data, replayed.Data)
+}
@@ -19,6 +19,8 @@
 package faoimpls
 
 import (
+	"context"
+	"io"
 	"time"
 
 	"github.com/HeyPuter/puter-fuse/fao"
@@ -45,52 +47,67 @@ func CreateSlowFAO(delegate fao.FAO, d time.Duration) *SlowFAO {
 	return fao
 }
 
-func (f *SlowFAO) Stat(path string) (fao.NodeInfo, bool, error) {
+func (f *SlowFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
 	time.Sleep(f.Delay)
-	return f.Delegate.Stat(path)
+	return f.Delegate.Stat(ctx, path)
 }
 
-func (f *SlowFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
+func (f *SlowFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
 	time.Sleep(f.Delay)
-	return f.Delegate.ReadDir(path)
+	return f.Delegate.ReadDir(ctx, path)
 }
 
-func (f *SlowFAO) Create(path string, name string) (fao.NodeInfo, error) {
+func (f *SlowFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
 	time.Sleep(f.Delay)
-	return f.Delegate.Create(path, name)
+	return f.Delegate.Create(ctx, path, name)
 }
 
-func (f *SlowFAO) MkDir(parent, path string) (fao.NodeInfo, error) {
+func (f *SlowFAO) MkDir(ctx context.Context, parent, path string) (fao.NodeInfo, error) {
 	time.Sleep(f.Delay)
-	return f.Delegate.MkDir(parent, path)
+	return f.Delegate.MkDir(ctx, parent, path)
 }
 
-func (f *SlowFAO) Read(path string, dest []byte, off int64) (int, error) {
+func (f *SlowFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
 	time.Sleep(f.Delay)
-	return f.Delegate.Read(path, dest, off)
+	return f.Delegate.Read(ctx, path, dest, off)
 }
 
-func (f *SlowFAO) Write(path string, src []byte, off int64) (int, error) {
+func (f *SlowFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
 	time.Sleep(f.Delay)
-	return f.Delegate.Write(path, src, off)
+	return f.Delegate.Write(ctx, path, src, off)
 }
 
-func (f *SlowFAO) Truncate(path string, size uint64) error {
+func (f *SlowFAO) Truncate(ctx context.Context, path string, size uint64) error {
 	time.Sleep(f.Delay)
-	return f.Delegate.Truncate(path, size)
+	return f.Delegate.Truncate(ctx, path, size)
 }
 
-func (f *SlowFAO) Symlink(parent, name, target string) (fao.NodeInfo, error) {
+func (f *SlowFAO) Symlink(ctx context.Context, parent, name, target string) (fao.NodeInfo, error) {
 	time.Sleep(f.Delay)
-	return f.Delegate.Symlink(parent, name, target)
+	return f.Delegate.Symlink(ctx, parent, name, target)
 }
 
-func (f *SlowFAO) Unlink(path string) error {
+func (f *SlowFAO) Unlink(ctx context.Context, path string) error {
 	time.Sleep(f.Delay)
-	return f.Delegate.Unlink(path)
+	return f.Delegate.Unlink(ctx, path)
 }
 
-func (f *SlowFAO) Move(source, parent, name string) error {
+func (f *SlowFAO) Move(ctx context.Context, source, parent, name string) error {
 	time.Sleep(f.Delay)
-	return f.Delegate.Move(source, parent, name)
+	return f.Delegate.Move(ctx, source, parent, name)
+}
+
+func (f *SlowFAO) Fsync(ctx context.Context, path string) error {
+	time.Sleep(f.Delay)
+	return f.Delegate.Fsync(ctx, path)
+}
+
+// Nothing is sent, so there's no delay.
+func (f *SlowFAO) TakeError(ctx context.Context, path string) error {
+	return f.Delegate.TakeError(ctx, path)
+}
+
+func (f *SlowFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	time.Sleep(f.Delay)
+	return f.Delegate.ReadAll(ctx, path)
 }
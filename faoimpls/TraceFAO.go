@@ -0,0 +1,165 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package faoimpls
+
+import (
+	"context"
+	"io"
+
+	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/trace"
+)
+
+// TraceFAO records a span for each call into the layer it wraps.
+type TraceFAO struct {
+	fao.ProxyFAO
+	Layer string
+}
+
+func CreateTraceFAO(delegate fao.FAO, layer string) *TraceFAO {
+	return &TraceFAO{
+		ProxyFAO: fao.ProxyFAO{
+			P_CreateProxyFAO: fao.P_CreateProxyFAO{
+				Delegate: delegate,
+			},
+		},
+		Layer: layer,
+	}
+}
+
+func (f *TraceFAO) start(ctx context.Context, method string, path string) (context.Context, *trace.Span) {
+	return trace.Start(ctx, "fao."+f.Layer+"."+method, "path", path)
+}
+
+func (f *TraceFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
+	ctx, span := f.start(ctx, "Stat", path)
+	defer span.End()
+	nodeInfo, exists, err := f.Delegate.Stat(ctx, path)
+	span.SetAttr("exists", exists)
+	span.SetError(err)
+	return nodeInfo, exists, err
+}
+
+func (f *TraceFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
+	ctx, span := f.start(ctx, "ReadDir", path)
+	defer span.End()
+	nodeInfos, err := f.Delegate.ReadDir(ctx, path)
+	span.SetAttr("entries", len(nodeInfos))
+	span.SetError(err)
+	return nodeInfos, err
+}
+
+func (f *TraceFAO) Read(ctx context.Context, path string, dest []byte, off int64) (int, error) {
+	ctx, span := f.start(ctx, "Read", path)
+	defer span.End()
+	span.SetAttr("offset", off)
+	n, err := f.Delegate.Read(ctx, path, dest, off)
+	span.SetAttr("bytes", n)
+	span.SetError(err)
+	return n, err
+}
+
+func (f *TraceFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	ctx, span := f.start(ctx, "Write", path)
+	defer span.End()
+	span.SetAttr("offset", off)
+	n, err := f.Delegate.Write(ctx, path, src, off)
+	span.SetAttr("bytes", n)
+	span.SetError(err)
+	return n, err
+}
+
+func (f *TraceFAO) Create(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	ctx, span := f.start(ctx, "Create", path)
+	defer span.End()
+	span.SetAttr("name", name)
+	nodeInfo, err := f.Delegate.Create(ctx, path, name)
+	span.SetError(err)
+	return nodeInfo, err
+}
+
+func (f *TraceFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	ctx, span := f.start(ctx, "Truncate", path)
+	defer span.End()
+	span.SetAttr("size", size)
+	err := f.Delegate.Truncate(ctx, path, size)
+	span.SetError(err)
+	return err
+}
+
+func (f *TraceFAO) MkDir(ctx context.Context, path string, name string) (fao.NodeInfo, error) {
+	ctx, span := f.start(ctx, "MkDir", path)
+	defer span.End()
+	span.SetAttr("name", name)
+	nodeInfo, err := f.Delegate.MkDir(ctx, path, name)
+	span.SetError(err)
+	return nodeInfo, err
+}
+
+func (f *TraceFAO) Symlink(ctx context.Context, parent string, name string, target string) (fao.NodeInfo, error) {
+	ctx, span := f.start(ctx, "Symlink", parent)
+	defer span.End()
+	span.SetAttr("name", name)
+	nodeInfo, err := f.Delegate.Symlink(ctx, parent, name, target)
+	span.SetError(err)
+	return nodeInfo, err
+}
+
+func (f *TraceFAO) Unlink(ctx context.Context, path string) error {
+	ctx, span := f.start(ctx, "Unlink", path)
+	defer span.End()
+	err := f.Delegate.Unlink(ctx, path)
+	span.SetError(err)
+	return err
+}
+
+func (f *TraceFAO) Move(ctx context.Context, source string, parent string, name string) error {
+	ctx, span := f.start(ctx, "Move", source)
+	defer span.End()
+	span.SetAttr("parent", parent)
+	span.SetAttr("name", name)
+	err := f.Delegate.Move(ctx, source, parent, name)
+	span.SetError(err)
+	return err
+}
+
+// Only the time to open the stream is recorded.
+func (f *TraceFAO) ReadAll(ctx context.Context, path string) (io.ReadCloser, error) {
+	ctx, span := f.start(ctx, "ReadAll", path)
+	defer span.End()
+	reader, err := f.Delegate.ReadAll(ctx, path)
+	span.SetError(err)
+	return reader, err
+}
+
+func (f *TraceFAO) Fsync(ctx context.Context, path string) error {
+	ctx, span := f.start(ctx, "Fsync", path)
+	defer span.End()
+	err := f.Delegate.Fsync(ctx, path)
+	span.SetError(err)
+	return err
+}
+
+func (f *TraceFAO) TakeError(ctx context.Context, path string) error {
+	ctx, span := f.start(ctx, "TakeError", path)
+	defer span.End()
+	err := f.Delegate.TakeError(ctx, path)
+	span.SetError(err)
+	return err
+}
@@ -19,6 +19,7 @@
 package faoimpls
 
 import (
+	"context"
 	"fmt"
 	"path/filepath"
 	"time"
@@ -38,6 +39,8 @@ type D_TreeCacheFAO struct {
 	*engine.AssociationService
 }
 
+var treeLog = logger.S("tree-cache")
+
 type TreeCacheFAO struct {
 	fao.ProxyFAO
 	P_TreeCacheFAO
@@ -64,9 +67,8 @@ func CreateTreeCacheFAO(
 
 // === READ CACHING BEHAVIOR ===
 
-func (f *TreeCacheFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
+func (f *TreeCacheFAO) ReadDir(ctx context.Context, path string) ([]fao.NodeInfo, error) {
 	parts := lang.PathSplit(path)
-	fmt.Println("path parts", parts)
 	entry := f.VirtualTreeService.ResolvePath(parts)
 
 	// fmt.Println("The entry in question", entry)
@@ -77,12 +79,14 @@ func (f *TreeCacheFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
 		if entry == nil || entry.LastReaddir.Add(f.TTL).Before(time.Now()) {
 			defer l.Unlock()
 			if entry == nil {
-				fmt.Println("cache miss because entry is nil")
+				treeLog.Debug("miss %s: not cached", path)
 			}
 			if entry != nil {
-				fmt.Println("cache miss because expired", entry.LastReaddir, f.TTL, time.Now())
+				treeLog.Debug("miss %s: expired (read at %s)", path, entry.LastReaddir)
+				engine.CacheEvictions.With("tree").Inc()
 			}
-			return f.readDirAndUpdateCache(path)
+			engine.CacheMisses.With("tree").Inc()
+			return f.readDirAndUpdateCache(ctx, path)
 		}
 		l.Unlock()
 	}
@@ -108,27 +112,31 @@ func (f *TreeCacheFAO) ReadDir(path string) ([]fao.NodeInfo, error) {
 	if !populate_nodeinfos() {
 		l := f.VirtualTreeService.DirectoriesCacheLock.Lock(path)
 		if !populate_nodeinfos() {
-			fmt.Println("cache miss because nodeInfos do not exist")
+			treeLog.Debug("miss %s: missing node info", path)
+			engine.CacheMisses.With("tree").Inc()
 			defer l.Unlock()
-			return f.readDirAndUpdateCache(path)
+			return f.readDirAndUpdateCache(ctx, path)
 		}
 		l.Unlock()
 	}
 
-	fmt.Println("readdir cache hit", path)
+	treeLog.Debug("hit %s", path)
+	engine.CacheHits.With("tree").Inc()
 	// fmt.Println("readdir cache hit", nodeInfos)
 
 	return nodeInfos, nil
 }
 
-func (f *TreeCacheFAO) Stat(path string) (fao.NodeInfo, bool, error) {
+func (f *TreeCacheFAO) Stat(ctx context.Context, path string) (fao.NodeInfo, bool, error) {
 	localUID, exists := f.AssociationService.PathToLocalUID.Get(path)
 	if exists {
+		missed := false
 		nodeInfo, ok, err := f.AssociationService.LocalUIDToNodeInfo.GetOrSet(
 			localUID,
 			f.TTL,
 			func() (fao.NodeInfo, bool, error) {
-				stat, exists, err := f.Delegate.Stat(path)
+				missed = true
+				stat, exists, err := f.Delegate.Stat(ctx, path)
 				if err != nil {
 					return fao.NodeInfo{}, false, err
 				}
@@ -140,6 +148,11 @@ func (f *TreeCacheFAO) Stat(path string) (fao.NodeInfo, bool, error) {
 				return stat, true, nil
 			},
 		)
+		if missed {
+			engine.CacheMisses.With("tree").Inc()
+		} else {
+			engine.CacheHits.With("tree").Inc()
+		}
 		if err != nil {
 			return fao.NodeInfo{}, false, err
 		}
@@ -150,7 +163,8 @@ func (f *TreeCacheFAO) Stat(path string) (fao.NodeInfo, bool, error) {
 		return nodeInfo, true, nil
 	}
 
-	stat, exists, err := f.Delegate.Stat(path)
+	engine.CacheMisses.With("tree").Inc()
+	stat, exists, err := f.Delegate.Stat(ctx, path)
 	if err != nil {
 		return fao.NodeInfo{}, false, err
 	}
@@ -167,9 +181,7 @@ func (f *TreeCacheFAO) Stat(path string) (fao.NodeInfo, bool, error) {
 	return stat, true, nil
 }
 
-func (f *TreeCacheFAO) readDirAndUpdateCache(path string) ([]fao.NodeInfo, error) {
-	fmt.Println("readdir cache miss", path)
-
+func (f *TreeCacheFAO) readDirAndUpdateCache(ctx context.Context, path string) ([]fao.NodeInfo, error) {
 	// Stat the directory (prerequisite to cache the path association)
 	var stat fao.NodeInfo
 	var exists bool
@@ -184,25 +196,23 @@ func (f *TreeCacheFAO) readDirAndUpdateCache(path string) ([]fao.NodeInfo, error
 			},
 		}
 	} else {
-		stat, exists, err = f.Stat(path)
+		stat, exists, err = f.Stat(ctx, path)
 	}
 
 	if err != nil {
-		fmt.Printf("error statting %s: %s\n", path, err)
+		treeLog.Error("error statting %s: %s", path, err)
 		return nil, err
 	}
 
 	if !exists {
-		fmt.Printf("does not exist: %s\n", path)
 		return nil, &fao.ErrDoesNotExist{}
 	}
 
 	if !stat.IsDir {
-		fmt.Printf("not a directory: %s\n", path)
 		return nil, &fao.ErrNotDirectory{}
 	}
 
-	nodeInfos, err := f.Delegate.ReadDir(path)
+	nodeInfos, err := f.Delegate.ReadDir(ctx, path)
 	if err != nil {
 		return nil, err
 	}
@@ -226,8 +236,8 @@ func (f *TreeCacheFAO) readDirAndUpdateCache(path string) ([]fao.NodeInfo, error
 
 // === WRITE-BACK CACHING BEHAVIOR ===
 
-func (f *TreeCacheFAO) MkDir(parent string, path string) (fao.NodeInfo, error) {
-	nodeInfo, err := f.Delegate.MkDir(parent, path)
+func (f *TreeCacheFAO) MkDir(ctx context.Context, parent string, path string) (fao.NodeInfo, error) {
+	nodeInfo, err := f.Delegate.MkDir(ctx, parent, path)
 	if err != nil {
 		return fao.NodeInfo{}, err
 	}
@@ -255,8 +265,50 @@ func (f *TreeCacheFAO) MkDir(parent string, path string) (fao.NodeInfo, error) {
 
 // TODO: Create, Symlink, Unlink, Move
 
-func (f *TreeCacheFAO) Create(parent string, path string) (fao.NodeInfo, error) {
-	nodeInfo, err := f.Delegate.Create(parent, path)
+// Write and Truncate keep the cached size up-to-date so that a stat
+// following a write doesn't report the size from before it.
+
+func (f *TreeCacheFAO) Write(ctx context.Context, path string, src []byte, off int64) (int, error) {
+	n, err := f.Delegate.Write(ctx, path, src, off)
+	if err != nil {
+		return n, err
+	}
+
+	f.updateCachedSize(path, func(size uint64) uint64 {
+		return max(size, uint64(off)+uint64(n))
+	})
+
+	return n, nil
+}
+
+func (f *TreeCacheFAO) Truncate(ctx context.Context, path string, size uint64) error {
+	err := f.Delegate.Truncate(ctx, path, size)
+	if err != nil {
+		return err
+	}
+
+	f.updateCachedSize(path, func(uint64) uint64 {
+		return size
+	})
+
+	return nil
+}
+
+func (f *TreeCacheFAO) updateCachedSize(path string, update func(uint64) uint64) {
+	localUID, ok := f.AssociationService.PathToLocalUID.Get(path)
+	if !ok {
+		return
+	}
+	nodeInfo := f.AssociationService.LocalUIDToNodeInfo.Get(localUID)
+	if nodeInfo == nil {
+		return
+	}
+	nodeInfo.Size = update(nodeInfo.Size)
+	f.AssociationService.LocalUIDToNodeInfo.Set(localUID, *nodeInfo, f.TTL)
+}
+
+func (f *TreeCacheFAO) Create(ctx context.Context, parent string, path string) (fao.NodeInfo, error) {
+	nodeInfo, err := f.Delegate.Create(ctx, parent, path)
 	if err != nil {
 		return fao.NodeInfo{}, err
 	}
@@ -277,8 +329,8 @@ func (f *TreeCacheFAO) Create(parent string, path string) (fao.NodeInfo, error)
 	return nodeInfo, nil
 }
 
-func (f *TreeCacheFAO) Symlink(parent, name, target string) (fao.NodeInfo, error) {
-	nodeInfo, err := f.Delegate.Symlink(parent, name, target)
+func (f *TreeCacheFAO) Symlink(ctx context.Context, parent, name, target string) (fao.NodeInfo, error) {
+	nodeInfo, err := f.Delegate.Symlink(ctx, parent, name, target)
 	if err != nil {
 		return fao.NodeInfo{}, err
 	}
@@ -299,7 +351,7 @@ func (f *TreeCacheFAO) Symlink(parent, name, target string) (fao.NodeInfo, error
 	return nodeInfo, nil
 }
 
-func (f *TreeCacheFAO) Unlink(path string) error {
+func (f *TreeCacheFAO) Unlink(ctx context.Context, path string) error {
 	parentPath := filepath.Dir(path)
 	parentLocalUID, ok := f.AssociationService.PathToLocalUID.Get(parentPath)
 	if !ok {
@@ -312,10 +364,10 @@ func (f *TreeCacheFAO) Unlink(path string) error {
 	f.AssociationService.PathToLocalUID.Del(path)
 	f.VirtualTreeService.Unlink(parentLocalUID, localUID)
 
-	return f.Delegate.Unlink(path)
+	return f.Delegate.Unlink(ctx, path)
 }
 
-func (f *TreeCacheFAO) Move(oldPath, newParentPath, name string) error {
+func (f *TreeCacheFAO) Move(ctx context.Context, oldPath, newParentPath, name string) error {
 	oldParentPath := filepath.Dir(oldPath)
 
 	oldParentLocalUID, ok := f.AssociationService.PathToLocalUID.Get(oldParentPath)
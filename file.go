@@ -20,13 +20,16 @@ package main
 
 import (
 	"context"
-	"fmt"
 	"syscall"
 
+	"github.com/HeyPuter/puter-fuse/debug"
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
 )
 
+// logger for the in-memory example inodes
+var exampleLog = debug.NewLogger("example")
+
 type PuterFSFileInode struct {
 	fs.Inode
 	Contents []byte
@@ -37,14 +40,14 @@ func (n *PuterFSFileInode) GetSize() uint64 {
 }
 
 func (n *PuterFSFileInode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
-	fmt.Printf("open was called\n")
+	exampleLog.Debug("open")
 	return &PuterFSFile{
 		Node: n,
 	}, 0, 0
 }
 
 func (n *PuterFSFileInode) Read(ctx context.Context, f fs.FileHandle, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
-	fmt.Printf("Read was called\n")
+	exampleLog.Debug("read")
 	puterFile := f.(*PuterFSFile)
 	copy(dest, puterFile.GetData())
 	return fuse.ReadResultData(dest), 0
@@ -68,13 +71,11 @@ func (n *PuterFSFileInode) Fsync(ctx context.Context, f fs.FileHandle, flags uin
 }
 
 func (n *PuterFSFileInode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
-	fmt.Println("getattr: puter file handle")
 	out.Mode = 0644
 	out.Size = n.GetSize()
-	fmt.Printf("reporting the size as: %d\n", out.Size)
 	out.Uid = 1000
 	out.Gid = 1000
-	fmt.Printf("Whats ino? [%d]\n", out.Ino)
+	exampleLog.Debug("getattr: size %d, ino %d", out.Size, out.Ino)
 	return 0
 }
 
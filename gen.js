@@ -140,6 +140,10 @@ for ( const model of models ) {
             s += `}\n`
         }
 
+        s += `\nfunc (p *Proxy${model.name}) SetDelegate(delegate ${model.name}) {\n`
+        s += `\tp.Delegate = delegate\n`
+        s += `}\n`
+
         lib.writefile(filename, s);
         console.log(`Wrote ${filename}`);
     }
@@ -0,0 +1,163 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package ignore
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+type pattern struct {
+	source  string
+	regexp  *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// Matcher matches paths against gitignore-style patterns:
+//   - blank lines and lines starting with '#' are skipped
+//   - a leading '!' re-includes paths an earlier pattern matched
+//   - a trailing '/' only matches directories
+//   - a pattern with a '/' anywhere else is relative to the root;
+//     otherwise it matches a name at any depth
+//   - '*', '?' and '[...]' don't match '/'; '**' matches any number of
+//     directories
+//
+// As in git, everything in a matching directory matches too.
+type Matcher struct {
+	patterns []pattern
+}
+
+func Compile(lines []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		p := pattern{source: line}
+		if strings.HasPrefix(line, "!") {
+			p.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		anchored := strings.Contains(line, "/")
+		line = strings.TrimPrefix(line, "/")
+
+		expr := translate(line)
+		if !anchored {
+			expr = "(?:.*/)?" + expr
+		}
+		re, err := regexp.Compile("^" + expr + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %s", p.source, err)
+		}
+		p.regexp = re
+		m.patterns = append(m.patterns, p)
+	}
+	return m, nil
+}
+
+// Translates a glob to a regular expression.
+func translate(glob string) string {
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		ch := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case ch == '*':
+			expr.WriteString("[^/]*")
+		case ch == '?':
+			expr.WriteString("[^/]")
+		case ch == '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				expr.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			expr.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case ch == '\\' && i+1 < len(glob):
+			i++
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(ch)))
+		}
+	}
+	return expr.String()
+}
+
+// Empty reports whether there are no patterns, so nothing matches.
+func (m *Matcher) Empty() bool {
+	return m == nil || len(m.patterns) == 0
+}
+
+// Match reports whether 'path' is ignored. Paths are slash-separated and
+// relative to the root; a leading '/' is allowed.
+func (m *Matcher) Match(path string, isDir bool) bool {
+	if m.Empty() {
+		return false
+	}
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return false
+	}
+
+	// a directory that matches hides everything in it
+	for i, ch := range path {
+		if ch == '/' && m.matchOne(path[:i], true) {
+			return true
+		}
+	}
+	return m.matchOne(path, isDir)
+}
+
+// The last pattern that matches decides.
+func (m *Matcher) matchOne(path string, isDir bool) bool {
+	ignored := false
+	for _, p := range m.patterns {
+		if p.dirOnly && !isDir {
+			continue
+		}
+		if p.regexp.MatchString(path) {
+			ignored = !p.negate
+		}
+	}
+	return ignored
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package ignore
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	patterns := []string{
+		"# editor and desktop files",
+		".*.swp",
+		"*~",
+		".DS_Store",
+		"**/.git/*.lock",
+		"/build/",
+		"logs/**",
+		"!logs/keep.txt",
+		"tmp/",
+		"*.[oa]",
+	}
+	m, err := Compile(patterns)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"/notes.txt", false, false},
+		{"/docs/.notes.txt.swp", false, true},
+		{"/docs/notes.txt~", false, true},
+		{"/.DS_Store", false, true},
+		{"/a/b/.DS_Store", false, true},
+		{"/repo/.git/index.lock", false, true},
+		{"/repo/.git/index", false, false},
+		{"/.git/HEAD.lock", false, true},
+		{"/build", true, true},
+		{"/build", false, false},
+		{"/build/out.bin", false, true},
+		{"/src/build", true, false},
+		{"/logs/today.txt", false, true},
+		{"/logs/keep.txt", false, false},
+		{"/logs", true, false},
+		{"/a/tmp", true, true},
+		{"/a/tmp/file", false, true},
+		{"/a/tmp", false, false},
+		{"/main.o", false, true},
+		{"/main.c", false, false},
+		{"/", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := m.Match(tt.path, tt.isDir); got != tt.ignored {
+				t.Errorf("Match(%q, %v) = %v, expected %v", tt.path, tt.isDir, got, tt.ignored)
+			}
+		})
+	}
+}
+
+func TestCompileEmpty(t *testing.T) {
+	m, err := Compile([]string{"", "  ", "# comment"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !m.Empty() || m.Match("/anything", false) {
+		t.Errorf("expected an empty matcher to match nothing")
+	}
+}
@@ -103,6 +103,18 @@ func (m *KVMap[TKey, TVal]) SetAndLock(key TKey, value TVal, ttl time.Duration)
 	return mutex
 }
 
+func (m *KVMap[TKey, TVal]) Del(key TKey) {
+	mutex := m.getCacheStampedeMutex(key)
+	mutex.Lock()
+	defer mutex.Unlock()
+	m.items.Del(key)
+}
+
+// Returns the number of entries, including expired ones not yet replaced.
+func (m *KVMap[TKey, TVal]) Len() int {
+	return len(m.items.Keys())
+}
+
 func (m *KVMap[TKey, TVal]) Get(key TKey) *TVal {
 	v, exists := m.items.Get(key)
 	if !exists || (v.TTL != 0 && v.Time.Add(v.TTL).Before(time.Now())) {
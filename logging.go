@@ -0,0 +1,77 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/HeyPuter/puter-fuse/debug"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/viper"
+)
+
+var mountLog = debug.NewLogger("mount")
+
+// Applies the logging settings. Logging is shared by every mount of the
+// process, so the settings come from the global configuration. The
+// returned function closes the log file, if there is one.
+func configureLogging() (func(), error) {
+	level, err := debug.ParseLevel(viper.GetString("logLevel"))
+	if err != nil {
+		return nil, err
+	}
+	format, err := debug.ParseFormat(viper.GetString("logFormat"))
+	if err != nil {
+		return nil, err
+	}
+	prefixLevels, err := debug.ParsePrefixLevels(viper.GetStringSlice("logLevels"))
+	if err != nil {
+		return nil, err
+	}
+
+	var writer io.Writer = os.Stdout
+	color := isatty.IsTerminal(os.Stdout.Fd())
+	closeLog := func() {}
+
+	if path := viper.GetString("logFile"); path != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return nil, fmt.Errorf("error creating log directory: %s", err)
+		}
+		file, err := debug.OpenRotatingFile(
+			path, viper.GetInt64("logFileMaxSize"), viper.GetInt("logFileMaxBackups"))
+		if err != nil {
+			return nil, fmt.Errorf("error opening log file: %s", err)
+		}
+		writer = file
+		color = false
+		closeLog = func() { file.Close() }
+	}
+
+	debug.SetLevel(level)
+	debug.Configure(debug.Options{
+		Format:       format,
+		Writer:       writer,
+		Color:        color,
+		PrefixLevels: prefixLevels,
+	})
+	return closeLog, nil
+}
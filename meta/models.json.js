@@ -5,48 +5,48 @@
 
         imports: {
             // base: ['fmt'],
-            interface: ['io'],
+            interface: ['context', 'io'],
         },
 
         methods: {
             Stat: [
-                [ ['path', 'string'] ],
+                [ ['ctx', 'context.Context'], ['path', 'string'] ],
                 ['NodeInfo', 'bool', 'error'],
             ],
             ReadDir: [
-                [ ['path', 'string'] ],
+                [ ['ctx', 'context.Context'], ['path', 'string'] ],
                 ['[]NodeInfo', 'error']
             ],
             Read: [
-                [ ['path', 'string'], ['dest', '[]byte'], ['off', 'int64'] ],
+                [ ['ctx', 'context.Context'], ['path', 'string'], ['dest', '[]byte'], ['off', 'int64'] ],
                 ['int', 'error']
             ],
             Write: [
-                [ ['path', 'string'], ['src', '[]byte'], ['off', 'int64'] ],
+                [ ['ctx', 'context.Context'], ['path', 'string'], ['src', '[]byte'], ['off', 'int64'] ],
                 ['int', 'error']
             ],
             Create: [
-                [ ['path', 'string'], ['name', 'string'] ],
+                [ ['ctx', 'context.Context'], ['path', 'string'], ['name', 'string'] ],
                 ['NodeInfo', 'error']
             ],
             Truncate: [
-                [ ['path', 'string'], ['size', 'uint64'] ],
+                [ ['ctx', 'context.Context'], ['path', 'string'], ['size', 'uint64'] ],
                 ['error']
             ],
             MkDir: [
-                [ ['path', 'string'], ['name', 'string'] ],
+                [ ['ctx', 'context.Context'], ['path', 'string'], ['name', 'string'] ],
                 ['NodeInfo', 'error']
             ],
             Symlink: [
-                [ ['parent', 'string'], ['name', 'string'], ['target', 'string'] ],
+                [ ['ctx', 'context.Context'], ['parent', 'string'], ['name', 'string'], ['target', 'string'] ],
                 ['NodeInfo', 'error']
             ],
             Unlink: [
-                [ ['path', 'string'] ],
+                [ ['ctx', 'context.Context'], ['path', 'string'] ],
                 ['error']
             ],
             Move: [
-                [ ['source', 'string'], ['parent', 'string'], ['name', 'string'] ],
+                [ ['ctx', 'context.Context'], ['source', 'string'], ['parent', 'string'], ['name', 'string'] ],
                 ['error']
             ],
             // Copy: [
@@ -54,8 +54,16 @@
             //     ['NodeInfo', 'error']
             // ],
             ReadAll: [
-                [ ['path', 'string'] ],
+                [ ['ctx', 'context.Context'], ['path', 'string'] ],
                 ['io.ReadCloser', 'error']
+            ],
+            Fsync: [
+                [ ['ctx', 'context.Context'], ['path', 'string'] ],
+                ['error']
+            ],
+            TakeError: [
+                [ ['ctx', 'context.Context'], ['path', 'string'] ],
+                ['error']
             ]
         }
     }
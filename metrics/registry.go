@@ -0,0 +1,190 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+// Package metrics collects counters, gauges and histograms and serves
+// them in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type Collector interface {
+	Name() string
+	// writes HELP, TYPE and every sample
+	WriteText(w io.Writer)
+}
+
+type Registry struct {
+	collectors map[string]Collector
+	lock       sync.RWMutex
+}
+
+func CreateRegistry() *Registry {
+	return &Registry{
+		collectors: map[string]Collector{},
+	}
+}
+
+// Default is the registry the New* functions register with.
+var Default = CreateRegistry()
+
+// Registers a collector; names must be unique within a registry.
+func (r *Registry) Register(c Collector) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, exists := r.collectors[c.Name()]; exists {
+		panic(fmt.Sprintf("metric %s is already registered", c.Name()))
+	}
+	r.collectors[c.Name()] = c
+}
+
+// Writes every metric, sorted by name.
+func (r *Registry) WriteText(w io.Writer) {
+	r.lock.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	r.lock.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		r.lock.RLock()
+		c := r.collectors[name]
+		r.lock.RUnlock()
+		c.WriteText(w)
+	}
+}
+
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buffer := &bytes.Buffer{}
+		r.WriteText(buffer)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(buffer.Bytes())
+	})
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+// Formats a label set as {a="1",b="2"}, or "" if there are no labels.
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = fmt.Sprintf(`%s="%s"`, name, escape.Replace(values[i]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// vec holds one child metric for each combination of label values.
+type vec[T any] struct {
+	name       string
+	help       string
+	labelNames []string
+	create     func() *T
+
+	children map[string]*T
+	values   map[string][]string
+	lock     sync.RWMutex
+}
+
+func (v *vec[T]) with(labelValues []string) *T {
+	if len(labelValues) != len(v.labelNames) {
+		panic(fmt.Sprintf("metric %s takes %d label values; got %d",
+			v.name, len(v.labelNames), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.lock.RLock()
+	child, exists := v.children[key]
+	v.lock.RUnlock()
+	if exists {
+		return child
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if child, exists = v.children[key]; !exists {
+		child = v.create()
+		v.children[key] = child
+		v.values[key] = append([]string{}, labelValues...)
+	}
+	return child
+}
+
+// Calls 'fn' for each child, ordered by label values.
+func (v *vec[T]) each(fn func(labels []string, child *T)) {
+	v.lock.RLock()
+	keys := make([]string, 0, len(v.children))
+	for key := range v.children {
+		keys = append(keys, key)
+	}
+	v.lock.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.lock.RLock()
+		child, labels := v.children[key], v.values[key]
+		v.lock.RUnlock()
+		fn(labels, child)
+	}
+}
+
+func newVec[T any](name, help string, labelNames []string, create func() *T) *vec[T] {
+	v := &vec[T]{
+		name:       name,
+		help:       help,
+		labelNames: labelNames,
+		create:     create,
+		children:   map[string]*T{},
+		values:     map[string][]string{},
+	}
+	// A metric without labels is reported even before it's used
+	if len(labelNames) == 0 {
+		v.with(nil)
+	}
+	return v
+}
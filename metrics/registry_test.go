@@ -0,0 +1,138 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistryWriteText(t *testing.T) {
+	testCases := []struct {
+		name     string
+		populate func(r *Registry)
+		expected string
+	}{
+		{
+			name: "counter",
+			populate: func(r *Registry) {
+				v := CreateCounterVec("calls_total", "Calls made.", "method")
+				r.Register(v)
+				v.With("stat").Inc()
+				v.With("read").Add(3)
+			},
+			expected: `# HELP calls_total Calls made.
+# TYPE calls_total counter
+calls_total{method="read"} 3
+calls_total{method="stat"} 1
+`,
+		},
+		{
+			name: "gauge without labels",
+			populate: func(r *Registry) {
+				v := CreateGaugeVec("queue_depth", "Queued items.")
+				r.Register(v)
+				v.With().Add(5)
+				v.With().Add(-2)
+			},
+			expected: `# HELP queue_depth Queued items.
+# TYPE queue_depth gauge
+queue_depth 3
+`,
+		},
+		{
+			name: "unused counter without labels",
+			populate: func(r *Registry) {
+				r.Register(CreateCounterVec("errors_total", "Errors."))
+			},
+			expected: `# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total 0
+`,
+		},
+		{
+			name: "histogram",
+			populate: func(r *Registry) {
+				v := CreateHistogramVec("size", "Sizes.", []float64{1, 10}, "kind")
+				r.Register(v)
+				h := v.With("a")
+				h.Observe(1)
+				h.Observe(5)
+				h.Observe(50)
+			},
+			expected: `# HELP size Sizes.
+# TYPE size histogram
+size_bucket{kind="a",le="1"} 1
+size_bucket{kind="a",le="10"} 2
+size_bucket{kind="a",le="+Inf"} 3
+size_sum{kind="a"} 56
+size_count{kind="a"} 3
+`,
+		},
+		{
+			name: "escaped label values",
+			populate: func(r *Registry) {
+				v := CreateCounterVec("paths_total", "Paths.", "path")
+				r.Register(v)
+				v.With("a\"b\\c\nd").Inc()
+			},
+			expected: `# HELP paths_total Paths.
+# TYPE paths_total counter
+paths_total{path="a\"b\\c\nd"} 1
+`,
+		},
+		{
+			name: "sorted by name",
+			populate: func(r *Registry) {
+				r.Register(CreateCounterVec("b_total", "B.", "kind"))
+				r.Register(CreateCounterVec("a_total", "A.", "kind"))
+			},
+			expected: `# HELP a_total A.
+# TYPE a_total counter
+# HELP b_total B.
+# TYPE b_total counter
+`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := CreateRegistry()
+			tc.populate(r)
+
+			out := &bytes.Buffer{}
+			r.WriteText(out)
+			if out.String() != tc.expected {
+				t.Fatalf("expected:\n%s\ngot:\n%s", tc.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestRegistryRejectsDuplicates(t *testing.T) {
+	r := CreateRegistry()
+	r.Register(CreateCounterVec("calls_total", "Calls."))
+
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected a panic for a duplicate metric")
+		}
+	}()
+	r.Register(CreateGaugeVec("calls_total", "Calls."))
+}
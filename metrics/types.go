@@ -0,0 +1,218 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// === COUNTER ===
+
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+type CounterVec struct {
+	*vec[Counter]
+}
+
+func CreateCounterVec(name, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{newVec(name, help, labelNames, func() *Counter {
+		return &Counter{}
+	})}
+}
+
+// Creates a counter and registers it with the default registry.
+func NewCounterVec(name, help string, labelNames ...string) *CounterVec {
+	v := CreateCounterVec(name, help, labelNames...)
+	Default.Register(v)
+	return v
+}
+
+func (v *CounterVec) Name() string {
+	return v.name
+}
+
+func (v *CounterVec) With(labelValues ...string) *Counter {
+	return v.with(labelValues)
+}
+
+func (v *CounterVec) WriteText(w io.Writer) {
+	writeHeader(w, v.name, v.help, "counter")
+	v.each(func(labels []string, c *Counter) {
+		fmt.Fprintf(w, "%s%s %d\n", v.name, formatLabels(v.labelNames, labels), c.Value())
+	})
+}
+
+// === GAUGE ===
+
+type Gauge struct {
+	value atomic.Int64
+}
+
+func (g *Gauge) Add(n int64) {
+	g.value.Add(n)
+}
+
+func (g *Gauge) Set(n int64) {
+	g.value.Store(n)
+}
+
+func (g *Gauge) Value() int64 {
+	return g.value.Load()
+}
+
+type GaugeVec struct {
+	*vec[Gauge]
+}
+
+func CreateGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	return &GaugeVec{newVec(name, help, labelNames, func() *Gauge {
+		return &Gauge{}
+	})}
+}
+
+// Creates a gauge and registers it with the default registry.
+func NewGaugeVec(name, help string, labelNames ...string) *GaugeVec {
+	v := CreateGaugeVec(name, help, labelNames...)
+	Default.Register(v)
+	return v
+}
+
+func (v *GaugeVec) Name() string {
+	return v.name
+}
+
+func (v *GaugeVec) With(labelValues ...string) *Gauge {
+	return v.with(labelValues)
+}
+
+func (v *GaugeVec) WriteText(w io.Writer) {
+	writeHeader(w, v.name, v.help, "gauge")
+	v.each(func(labels []string, g *Gauge) {
+		fmt.Fprintf(w, "%s%s %d\n", v.name, formatLabels(v.labelNames, labels), g.Value())
+	})
+}
+
+// === HISTOGRAM ===
+
+// DurationBuckets suit latencies measured in seconds.
+var DurationBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30}
+
+type Histogram struct {
+	buckets []float64
+	// counts[i] is the number of observations in (buckets[i-1], buckets[i]];
+	// the last is for observations above every bucket
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sumBits atomic.Uint64
+}
+
+func (h *Histogram) Observe(value float64) {
+	i := 0
+	for i < len(h.buckets) && value > h.buckets[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.count.Add(1)
+
+	for {
+		old := h.sumBits.Load()
+		sum := math.Float64frombits(old) + value
+		if h.sumBits.CompareAndSwap(old, math.Float64bits(sum)) {
+			return
+		}
+	}
+}
+
+// Observes the time since 'start' in seconds.
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.count.Load()
+}
+
+func (h *Histogram) Sum() float64 {
+	return math.Float64frombits(h.sumBits.Load())
+}
+
+type HistogramVec struct {
+	*vec[Histogram]
+	buckets []float64
+}
+
+func CreateHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	return &HistogramVec{
+		vec: newVec(name, help, labelNames, func() *Histogram {
+			return &Histogram{
+				buckets: buckets,
+				counts:  make([]atomic.Uint64, len(buckets)+1),
+			}
+		}),
+		buckets: buckets,
+	}
+}
+
+// Creates a histogram and registers it with the default registry.
+func NewHistogramVec(name, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	v := CreateHistogramVec(name, help, buckets, labelNames...)
+	Default.Register(v)
+	return v
+}
+
+func (v *HistogramVec) Name() string {
+	return v.name
+}
+
+func (v *HistogramVec) With(labelValues ...string) *Histogram {
+	return v.with(labelValues)
+}
+
+func (v *HistogramVec) WriteText(w io.Writer) {
+	writeHeader(w, v.name, v.help, "histogram")
+	bucketLabels := append(append([]string{}, v.labelNames...), "le")
+	v.each(func(labels []string, h *Histogram) {
+		cumulative := uint64(0)
+		for i, bound := range append(append([]float64{}, h.buckets...), math.Inf(1)) {
+			cumulative += h.counts[i].Load()
+			le := append(append([]string{}, labels...), formatValue(bound))
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, formatLabels(bucketLabels, le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, formatLabels(v.labelNames, labels), formatValue(h.Sum()))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, formatLabels(v.labelNames, labels), h.Count())
+	})
+}
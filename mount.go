@@ -0,0 +1,656 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/credstore"
+	"github.com/HeyPuter/puter-fuse/debug"
+	"github.com/HeyPuter/puter-fuse/engine"
+	faopkg "github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/faoimpls"
+	"github.com/HeyPuter/puter-fuse/ignore"
+	"github.com/HeyPuter/puter-fuse/puterfs"
+	"github.com/HeyPuter/puter-fuse/putersdk"
+	"github.com/HeyPuter/puter-fuse/services"
+	"github.com/HeyPuter/puter-fuse/trace"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+// Mount is one mounted profile, with its own SDK, services and FAO stack.
+type Mount struct {
+	Profile    string
+	Config     *viper.Viper
+	MountPoint string
+
+	SDK         *putersdk.PuterSDK
+	Services    *services.ServicesContainer
+	ReadOnlyFAO *faoimpls.ReadOnlyFAO
+
+	// holds files matching ignorePatterns; "" if there are none
+	ignoredDir string
+
+	server       *fuse.Server
+	unmounted    atomic.Bool
+	shutdownOnce sync.Once
+}
+
+// Services which mounts in the same process can safely share.
+type sharedServices struct {
+	logger *debug.Logger
+
+	// records the backend and top layers of every mount; may be nil
+	recorder *faoimpls.FAORecorder
+
+	// BLOBs are content-addressed, so mounts using the same cache
+	// directory can share one cache
+	blobCaches map[string]*engine.BLOBCacheService
+
+	// bandwidth limits are for the whole process; may be nil
+	throttle *putersdk.Throttle
+
+	// opened once, when it's first needed, so it's only unlocked once
+	// and stays unlocked for re-authentication
+	credentials credstore.Store
+}
+
+func (shared *sharedServices) credentialStore() (credstore.Store, error) {
+	if shared.credentials == nil {
+		store, err := openCredentialStore()
+		if err != nil {
+			return nil, err
+		}
+		shared.credentials = store
+	}
+	return shared.credentials, nil
+}
+
+func (shared *sharedServices) blobCache(cacheDir string) *engine.BLOBCacheService {
+	svc, exists := shared.blobCaches[cacheDir]
+	if !exists {
+		svc = engine.CreateBLOBCacheService(afero.NewOsFs())
+		shared.blobCaches[cacheDir] = svc
+	}
+	return svc
+}
+
+// Returns the profiles to mount and the configuration for each. A single
+// profile uses the global configuration, which it was merged into.
+func mountConfigs() ([]string, []*viper.Viper, error) {
+	names := []string{currentProfile()}
+	if mountAllProfiles {
+		var err error
+		if names, err = configuredProfiles(); err != nil {
+			return nil, nil, err
+		}
+	} else if len(profileNames) > 1 {
+		names = profileNames
+	}
+
+	if len(names) == 1 {
+		return names, []*viper.Viper{viper.GetViper()}, nil
+	}
+
+	if flagChanged("mountPoint") || tokenFile != "" {
+		return nil, nil, &exitError{
+			code: exitUsage,
+			err:  fmt.Errorf("--mountpoint and --token-file apply to a single profile"),
+		}
+	}
+
+	configs := make([]*viper.Viper, len(names))
+	mountPoints := map[string]string{}
+	for i, name := range names {
+		cfg, err := loadProfileConfig(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		mountPoint, _ := filepath.Abs(cfg.GetString("mountPoint"))
+		if other, exists := mountPoints[mountPoint]; exists {
+			return nil, nil, &exitError{
+				code: exitUsage,
+				err:  fmt.Errorf("profiles %q and %q both mount at %s", other, name, mountPoint),
+			}
+		}
+		mountPoints[mountPoint] = name
+		configs[i] = cfg
+	}
+	return names, configs, nil
+}
+
+func mount() error {
+	names, configs, err := mountConfigs()
+	if err != nil {
+		return err
+	}
+
+	closeLog, err := configureLogging()
+	if err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+	defer closeLog()
+
+	if address := viper.GetString("metricsAddress"); address != "" {
+		if err := checkMetricsAddress(address); err != nil {
+			return &exitError{code: exitUsage, err: err}
+		}
+		server, err := startMetricsServer(address)
+		if err != nil {
+			return err
+		}
+		mountLog.Log("metrics: http://%s/metrics", address)
+		defer server.Close()
+	}
+
+	closeTrace, err := configureTracing()
+	if err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+	defer closeTrace()
+
+	programState.cleanupSignal = make(chan os.Signal, 1)
+	signal.Notify(programState.cleanupSignal, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(programState.cleanupSignal)
+	go func() {
+		<-programState.cleanupSignal
+		go func() {
+			<-programState.cleanupSignal
+			mountLog.Warn("second signal; exiting without flushing")
+			os.Exit(1)
+		}()
+		cleanup()
+		os.Exit(0)
+	}()
+
+	// TODO: change this default before release
+	mountLog.Warn("fileReadCacheTTL DEFAULTS TO 30s")
+
+	if viper.GetString("recordFile") != "" && len(names) > 1 {
+		return &exitError{
+			code: exitUsage,
+			err:  fmt.Errorf("only one profile can be mounted while recording"),
+		}
+	}
+	recorder, err := openRecording()
+	if err != nil {
+		return err
+	}
+	if recorder != nil {
+		defer recorder.Close()
+	}
+
+	throttle, err := createThrottle()
+	if err != nil {
+		return &exitError{code: exitUsage, err: err}
+	}
+
+	shared := &sharedServices{
+		logger:     &debug.Logger{},
+		recorder:   recorder,
+		blobCaches: map[string]*engine.BLOBCacheService{},
+		throttle:   throttle,
+	}
+
+	mounts := []*Mount{}
+	for i, name := range names {
+		m, err := createMount(name, configs[i], shared, len(names) > 1)
+		if err != nil {
+			for _, m := range mounts {
+				m.Shutdown()
+			}
+			return err
+		}
+		mounts = append(mounts, m)
+	}
+	passphrasePromptsDone.Store(true)
+
+	programState.cleanupTasks = append(programState.cleanupTasks, func() {
+		mountLog.Log("shutting down; new writes will be refused")
+		var wg sync.WaitGroup
+		for _, m := range mounts {
+			wg.Add(1)
+			go func(m *Mount) {
+				defer wg.Done()
+				m.Shutdown()
+			}(m)
+		}
+		wg.Wait()
+	})
+	// after the mounts, so the final flush is recorded
+	programState.cleanupTasks = append(programState.cleanupTasks, closeTrace)
+	if recorder != nil {
+		programState.cleanupTasks = append(programState.cleanupTasks, func() { recorder.Close() })
+	}
+
+	if viper.GetBool("controlApi") {
+		control, err := startControlServer(controlSocketPath(), mounts)
+		if err != nil {
+			mountLog.Error("control API disabled: %s", err)
+		} else {
+			mountLog.Log("control socket: %s", control.path)
+			defer control.Close()
+			programState.cleanupTasks = append(programState.cleanupTasks, control.Close)
+		}
+	}
+
+	if viper.GetBool("panik") {
+		mountLog.Warn("=== Panik mode is enabled ===")
+	}
+
+	// start serving the file systems
+	var wg sync.WaitGroup
+	for _, m := range mounts {
+		wg.Add(1)
+		go func(m *Mount) {
+			defer wg.Done()
+			m.server.Wait()
+
+			// The filesystem was unmounted externally (e.g. `fusermount
+			// -u`); writes already accepted must still be flushed.
+			m.unmounted.Store(true)
+			m.Shutdown()
+		}(m)
+	}
+	wg.Wait()
+
+	return nil
+}
+
+func createMount(
+	profile string,
+	cfg *viper.Viper,
+	shared *sharedServices,
+	multiple bool,
+) (*Mount, error) {
+	mountPoint, err := filepath.Abs(cfg.GetString("mountPoint"))
+	if err != nil {
+		return nil, err
+	}
+	m := &Mount{
+		Profile:    profile,
+		Config:     cfg,
+		MountPoint: mountPoint,
+	}
+
+	token, fromStore, err := resolveToken(shared.credentialStore, profile)
+	if err != nil {
+		return nil, err
+	}
+	localDir := cfg.GetString("localDir")
+	if token == "" && !cfg.GetBool("testMode") && localDir == "" {
+		return nil, &exitError{
+			code: exitNotLoggedIn,
+			err:  fmt.Errorf("profile %q is not logged in; run `puter-fuse login` first", profile),
+		}
+	}
+
+	if cfg.GetBool("testMode") {
+		cfg.SetDefault("treeCacheTTL", "5s")
+
+		cfg.SetDefault("testDelay", "200ms")
+	}
+
+	cacheDir, err := resolveCacheDir(cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(cacheDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cache directory: %s", err)
+	}
+	cfg.Set("cacheDir", cacheDir)
+
+	limits, err := schedulerLimits(cfg)
+	if err != nil {
+		return nil, &exitError{code: exitUsage, err: err}
+	}
+	var unlocked credstore.Store
+	if fromStore {
+		unlocked = shared.credentials
+	}
+	m.SDK = &putersdk.PuterSDK{
+		Url:            cfg.GetString("url"),
+		PuterAuthToken: token,
+		Reauthenticate: reauthenticator(profile, cfg, unlocked),
+		Throttle:       shared.throttle,
+		Scheduler:      putersdk.CreateScheduler(limits),
+	}
+	m.SDK.Init()
+
+	if !cfg.GetBool("readOnly") && cfg.GetBool("detectReadOnly") &&
+		!cfg.GetBool("testMode") && localDir == "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		canWrite, err := m.SDK.CanWriteHome(ctx)
+		cancel()
+		if err != nil {
+			mountLog.Warn("couldn't check whether the token can write (%s); mounting read-write", err)
+		} else if !canWrite {
+			mountLog.Warn("the token can't write to Puter; mounting read-only")
+			cfg.Set("readOnly", true)
+		}
+	}
+
+	// each mount's log lines are labelled with its profile
+	logger := shared.logger
+	if multiple {
+		logger = logger.S(profile)
+	}
+
+	svcc := createServices(cfg, m.SDK, logger, shared.blobCache(cacheDir))
+	m.Services = svcc
+	m.SDK.Transfers = svcc.Get("transfer").(*engine.TransferService)
+
+	var fao faopkg.FAO
+	var faoBuilder faopkg.FAOBuilder
+	faoBuilder = &faoimpls.NullFAOBuilder{}
+
+	// With metrics or tracing enabled, each layer is wrapped to record
+	// calls into it
+	instrument := func(fao faopkg.FAO, layer string) faopkg.FAO {
+		if viper.GetString("metricsAddress") != "" {
+			fao = faoimpls.CreateMetricsFAO(fao, layer)
+		}
+		if trace.Enabled() {
+			fao = faoimpls.CreateTraceFAO(fao, layer)
+		}
+		return fao
+	}
+
+	if localDir != "" {
+		// a local directory stands in for Puter
+		localDir, err := filepath.Abs(localDir)
+		if err != nil {
+			return nil, err
+		}
+		localFAO, err := faoimpls.CreateLocalDirFAO(afero.NewOsFs(), localDir)
+		if err != nil {
+			return nil, fmt.Errorf("error opening %s: %s", localDir, err)
+		}
+		fao = localFAO
+	} else if cfg.GetBool("testMode") {
+		memFAO := faoimpls.CreateMemFAO()
+		fao = memFAO
+		// Populate with test data
+		{
+			ctx := context.Background()
+			fao.MkDir(ctx, "/", "user")
+			fao.MkDir(ctx, "/user", "one-file")
+			fao.Create(ctx, "/user/one-file", "file")
+			fao.Write(ctx, "/user/one-file/file", []byte("file"), 0)
+			fao.MkDir(ctx, "/user", "three-files")
+			for i := 0; i < 3; i++ {
+				fao.Create(ctx, "/user/three-files", fmt.Sprintf("file-%d", i))
+				fao.Write(ctx, fmt.Sprintf("/user/three-files/file-%d", i),
+					[]byte(fmt.Sprintf("file-%d", i)), 0)
+			}
+			fao.MkDir(ctx, "/user", "fifty-files")
+			for i := 0; i < 50; i++ {
+				fao.Create(ctx, "/user/fifty-files", fmt.Sprintf("file-%d", i))
+				fao.Write(ctx, fmt.Sprintf("/user/fifty-files/file-%d", i),
+					[]byte(fmt.Sprintf("file-%d", i)), 0)
+			}
+		}
+		fao = faoimpls.CreateSlowFAO(fao, cfg.GetDuration("testDelay"))
+
+		chaos, enabled, err := chaosParams(cfg)
+		if err != nil {
+			return nil, &exitError{code: exitUsage, err: err}
+		}
+		if enabled {
+			// logged so a run can be repeated with the same faults
+			mountLog.Warn("injecting faults with seed %d", chaos.Seed)
+			fao = faoimpls.CreateChaosFAO(fao, chaos)
+		}
+		fao = faoimpls.CreateLogFAO(
+			fao,
+			svcc.Get("log").(*debug.LogService).GetLogger("test-storage"),
+		)
+	} else {
+		fao = faoimpls.CreatePuterFAO(
+			faoimpls.P_PuterFAO{
+				SDK:       m.SDK,
+				BLOBCache: svcc.Get("blob-cache").(*engine.BLOBCacheService),
+			},
+			faoimpls.D_PuterFAO{
+				EnqueueOperationRequest: svcc.Get("operation").(*engine.OperationService).EnqueueOperationRequest,
+				EnqueueUpload:           svcc.Get("operation").(*engine.OperationService).EnqueueUpload,
+			},
+		)
+		fao.(*faoimpls.PuterFAO).ReadFAO = fao
+	}
+	fao = instrument(fao, "backend")
+	if shared.recorder != nil {
+		fao = faoimpls.CreateRecordFAO(fao, shared.recorder, "backend")
+	}
+
+	// Only what's sent to Puter is encrypted; the caches above this,
+	// including the write cache's spill files, hold plaintext
+	if roots := cfg.GetStringSlice("encryptedPaths"); len(roots) > 0 {
+		store, err := shared.credentialStore()
+		if err != nil {
+			return nil, err
+		}
+		keys, err := loadEncryptionKeys(store, profile)
+		if err != nil {
+			return nil, err
+		}
+		for i, root := range roots {
+			roots[i] = filepath.Clean("/" + root)
+		}
+		fao = faoimpls.CreateEncryptionFAO(fao, faoimpls.P_EncryptionFAO{
+			Keys:         keys,
+			Roots:        roots,
+			EncryptNames: cfg.GetBool("encryptNames"),
+		})
+		fao = instrument(fao, "encryption")
+	}
+
+	fao = stackCacheLayers(fao, cfg, svcc, instrument)
+
+	if patterns := cfg.GetStringSlice("ignorePatterns"); len(patterns) > 0 {
+		matcher, err := ignore.Compile(patterns)
+		if err != nil {
+			return nil, &exitError{code: exitUsage, err: err}
+		}
+		m.ignoredDir = filepath.Join(cacheDir, "ignored", profile)
+		if !cfg.GetBool("persistIgnored") {
+			// left behind if the last mount didn't exit cleanly
+			os.RemoveAll(m.ignoredDir)
+		}
+		overlay, err := faoimpls.CreateLocalDirFAO(afero.NewOsFs(), m.ignoredDir)
+		if err != nil {
+			return nil, fmt.Errorf("error creating the directory for ignored files: %s", err)
+		}
+		fao = instrument(faoimpls.CreateIgnoreFAO(fao, overlay, matcher), "ignore")
+	}
+
+	// New writes are refused above the write cache during shutdown
+	m.ReadOnlyFAO = faoimpls.CreateReadOnlyFAO(nil, cfg.GetBool("readOnly"))
+
+	// Trying out FAOBuilder with minimal changes
+	faoBuilder.Set(fao)
+	faoBuilder.Add(m.ReadOnlyFAO)
+	faoBuilder.Add(faoimpls.CreateLogFAO(
+		nil,
+		svcc.Get("log").(*debug.LogService).GetLogger("top"),
+	))
+	fao = instrument(faoBuilder.Build(), "top")
+	if shared.recorder != nil {
+		fao = faoimpls.CreateRecordFAO(fao, shared.recorder, "top")
+	}
+
+	puterFS := &puterfs.Filesystem{
+		SDK:      m.SDK,
+		FAO:      fao,
+		Services: svcc,
+	}
+	if name := cfg.GetString("controlDir"); name != "" {
+		puterFS.ControlDir = puterfs.CreateControlDirNode(m.controlFiles())
+		puterFS.ControlDirName = name
+		puterFS.ShowControlDir = cfg.GetBool("showControlDir")
+	}
+	puterFS.Init()
+
+	rootNode := &puterfs.RootNode{}
+	rootNode.Filesystem = puterFS
+	rootNode.Init()
+
+	// Ensure the mountpoint exists
+	err = os.MkdirAll(m.MountPoint, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("error creating mountpoint: %s", err)
+	}
+
+	if mounted, _ := isMounted(m.MountPoint); mounted {
+		return nil, &exitError{
+			code: exitAlreadyMounted,
+			err:  fmt.Errorf("%s is already mounted", m.MountPoint),
+		}
+	}
+
+	options := &fs.Options{}
+	if cfg.GetBool("readOnly") {
+		// the kernel refuses writes before they reach us
+		options.MountOptions.Options = append(options.MountOptions.Options, "ro")
+	}
+	m.server, err = fs.Mount(m.MountPoint, rootNode, options)
+	if err != nil {
+		return nil, fmt.Errorf("error mounting %s: %s", m.MountPoint, err)
+	}
+
+	mountLog.With(
+		"profile", profile,
+		"config", viper.ConfigFileUsed(),
+		"mountpoint", m.MountPoint,
+		"cacheDir", cacheDir,
+	).Log("server started")
+
+	return m, nil
+}
+
+// Deletes the files kept in place of ignored ones unless they're
+// meant to persist.
+func (m *Mount) removeIgnored() {
+	if m.ignoredDir == "" || m.Config.GetBool("persistIgnored") {
+		return
+	}
+	if err := os.RemoveAll(m.ignoredDir); err != nil {
+		mountLog.Error("error removing ignored files: %s", err)
+	}
+}
+
+// Creates the services used by a mount's FAO stack.
+func createServices(
+	cfg *viper.Viper,
+	sdk *putersdk.PuterSDK,
+	logger *debug.Logger,
+	blobCache *engine.BLOBCacheService,
+) *services.ServicesContainer {
+	svcc := &services.ServicesContainer{}
+	svcc.Init()
+
+	svcc.Set("operation", &engine.OperationService{
+		SDK:      sdk,
+		ReadOnly: cfg.GetBool("readOnly"),
+	})
+	svcc.Set("pending-node", &engine.PendingNodeService{})
+	svcc.Set("wfcache", &engine.WholeFileCacheService{})
+	svcc.Set("log", &debug.LogService{Logger: logger})
+	svcc.Set("association", engine.CreateAssociationService())
+	svcc.Set("virtual-tree", engine.CreateVirtualTreeService())
+	svcc.Set("config", &engine.ConfigService{IConfig: cfg})
+	svcc.Set("blob-cache", blobCache)
+	svcc.Set("write-cache", engine.CreateWriteCacheService())
+	svcc.Set("cache-control", engine.CreateCacheControlService())
+	svcc.Set("transfer", engine.CreateTransferService())
+
+	for _, svc := range svcc.All() {
+		svc.Init(svcc)
+	}
+	return svcc
+}
+
+// Adds the UID mapping and cache layers above a backend FAO.
+// 'instrument' wraps each layer as it's added.
+func stackCacheLayers(
+	fao faopkg.FAO,
+	cfg *viper.Viper,
+	svcc services.IServiceContainer,
+	instrument func(fao faopkg.FAO, layer string) faopkg.FAO,
+) faopkg.FAO {
+	fao = faoimpls.CreateRemoteToLocalUIDFAO(fao, svcc)
+	fao = instrument(fao, "remote-to-local-uid")
+
+	if cfg.GetBool("experimental_cache") {
+		fao = faoimpls.CreateFileReadCacheFAO(fao, svcc, faoimpls.P_FileReadCacheFAO{
+			TTL: cfg.GetDuration("fileReadCacheTTL"),
+		})
+		fao = instrument(fao, "file-read-cache")
+	}
+
+	treeCacheFAOTTL := cfg.GetDuration("treeCacheTTL")
+
+	fao = faoimpls.CreateTreeCacheFAO(
+		fao,
+		faoimpls.P_TreeCacheFAO{
+			TTL: treeCacheFAOTTL,
+		},
+		faoimpls.D_TreeCacheFAO{
+			VirtualTreeService: svcc.Get("virtual-tree").(*engine.VirtualTreeService),
+			AssociationService: svcc.Get("association").(*engine.AssociationService),
+		},
+	)
+	fao = instrument(fao, "tree-cache")
+
+	if cfg.GetBool("experimental_cache") {
+		fao = faoimpls.CreateFileWriteCacheFAO(fao, svcc)
+		fao = instrument(fao, "file-write-cache")
+	}
+	return fao
+}
+
+// Refuses new writes, flushes pending ones, then unmounts unless the
+// filesystem was already unmounted. Only the first call has any effect.
+func (m *Mount) Shutdown() {
+	m.shutdownOnce.Do(func() {
+		m.ReadOnlyFAO.SetReadOnly(true)
+		drainPendingWrites(m.Services, m.Config.GetDuration("shutdownTimeout"))
+		defer m.removeIgnored()
+
+		if m.unmounted.Load() {
+			return
+		}
+		mountLog.Log("unmounting %s", m.MountPoint)
+		if err := m.server.Unmount(); err != nil {
+			mountLog.Error("error unmounting %s: %s", m.MountPoint, err)
+		}
+	})
+}
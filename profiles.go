@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// Values at the top level of the configuration file make up the default
+// profile. Named profiles live under "profiles" and override them:
+//
+//	{
+//	  "url": "https://api.puter.com",
+//	  "profiles": {
+//	    "work": { "url": "https://api.puter.example.com", "mountpoint": "/mnt/work" }
+//	  }
+//	}
+const defaultProfile = "default"
+
+// profiles given with --profile
+var profileNames []string
+
+type flagBinding struct {
+	key  string
+	flag *pflag.Flag
+}
+
+// flags which override configuration values, so they can be bound to
+// each profile's configuration as well as the global one
+var flagBindings []flagBinding
+
+func bindFlag(key string, flag *pflag.Flag) {
+	viper.BindPFlag(key, flag)
+	flagBindings = append(flagBindings, flagBinding{key: key, flag: flag})
+}
+
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("url", "https://api.puter.com")
+	v.SetDefault("mountPoint", "/tmp/mnt")
+	v.SetDefault("treeCacheTTL", "5s")
+	v.SetDefault("fileReadCacheTTL", "5s")
+
+	// spill mutation chains larger than this to the cache directory
+	v.SetDefault("writeCacheSpillSize", 16*1024*1024)
+
+	// how long to wait for pending writes when shutting down
+	v.SetDefault("shutdownTimeout", "30s")
+
+	// files matching these gitignore-style patterns are kept locally
+	// and never uploaded
+	v.SetDefault("ignorePatterns", []string{
+		".*.swp", ".*.swx", "*~", ".DS_Store", "._*", "**/.git/*.lock",
+	})
+	// keep ignored files in the cache directory across mounts
+	v.SetDefault("persistIgnored", false)
+
+	// encrypt file contents under these paths, and names if encryptNames
+	// is set
+	v.SetDefault("encryptedPaths", []string{})
+	v.SetDefault("encryptNames", false)
+
+	// bandwidth limits for the whole process, in bytes per second, e.g.
+	// "2MiB"; empty is unlimited. bandwidthSchedule can replace them at
+	// times of day.
+	v.SetDefault("uploadLimit", "")
+	v.SetDefault("downloadLimit", "")
+
+	// mount read-only if the token can't write
+	v.SetDefault("detectReadOnly", true)
+
+	// serve the control API on controlSocket
+	v.SetDefault("controlApi", true)
+
+	// virtual directory at the root of the mount; "" to disable
+	v.SetDefault("controlDir", ".puter-fuse")
+
+	// logging is configured for the whole process; see configureLogging
+	v.SetDefault("logLevel", "info")
+	v.SetDefault("logFormat", "text")
+	v.SetDefault("logFileMaxSize", 10*1024*1024)
+	v.SetDefault("logFileMaxBackups", 3)
+
+	// tracing is off unless traceFile is set; see configureTracing
+	v.SetDefault("traceFormat", "chrome")
+}
+
+// Returns the profile selected for commands which act on one profile.
+func currentProfile() string {
+	if len(profileNames) > 0 {
+		return profileNames[0]
+	}
+	return firstNonEmpty(os.Getenv("PUTER_FUSE_PROFILE"), defaultProfile)
+}
+
+// Returns the names of every profile in the configuration file.
+func configuredProfiles() ([]string, error) {
+	fileConfig, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{defaultProfile}
+	for name := range fileConfig.GetStringMap("profiles") {
+		if name != defaultProfile {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names[1:])
+	return names, nil
+}
+
+// Returns the values the configuration file sets for a named profile.
+func profileSettings(fileConfig *viper.Viper, name string) (map[string]interface{}, error) {
+	if name == defaultProfile {
+		return map[string]interface{}{}, nil
+	}
+	profiles := fileConfig.GetStringMap("profiles")
+	settings, ok := profiles[strings.ToLower(name)].(map[string]interface{})
+	if !ok {
+		return nil, &exitError{code: exitUsage, err: fmt.Errorf("unknown profile %q", name)}
+	}
+	return settings, nil
+}
+
+// Applies the selected profile to the global configuration, for commands
+// which act on a single profile. Unless 'allowNew' is set, the profile
+// must already exist.
+func selectProfile(allowNew bool) error {
+	name := currentProfile()
+	if name == defaultProfile {
+		return nil
+	}
+
+	fileConfig, err := readConfigFile()
+	if err != nil {
+		return err
+	}
+	settings, err := profileSettings(fileConfig, name)
+	if err != nil {
+		if allowNew {
+			return nil
+		}
+		return err
+	}
+	// merged at the config file's precedence, so flags still win
+	return viper.MergeConfigMap(settings)
+}
+
+// Returns a separate configuration for one profile, for running several
+// mounts in one process.
+func loadProfileConfig(name string) (*viper.Viper, error) {
+	fileConfig, err := readConfigFile()
+	if err != nil {
+		return nil, err
+	}
+
+	settings := fileConfig.AllSettings()
+	delete(settings, "profiles")
+
+	overrides, err := profileSettings(fileConfig, name)
+	if err != nil {
+		return nil, err
+	}
+
+	v := viper.New()
+	setDefaults(v)
+	v.MergeConfigMap(settings)
+	v.MergeConfigMap(overrides)
+	for _, binding := range flagBindings {
+		v.BindPFlag(binding.key, binding.flag)
+	}
+	v.BindEnv("url", "PUTER_API_URL")
+	return v, nil
+}
+
+// Reports whether the flag bound to a configuration key was given.
+func flagChanged(key string) bool {
+	for _, binding := range flagBindings {
+		if binding.key == key && binding.flag.Changed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,206 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package puterfs
+
+import (
+	"context"
+	"sync"
+	"syscall"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// ControlFile is a synthetic file in the control directory. What it
+// reads as comes from Contents, and data written to it is passed to
+// Receive when it's closed; either may be nil.
+type ControlFile struct {
+	Name     string
+	Contents func() ([]byte, error)
+	Receive  func(data []byte) error
+}
+
+// Inode numbers for the control directory are well above those handed
+// out for Puter's nodes so they never collide.
+const controlInoBase = 1 << 62
+
+// ControlDirNode is the control directory at the root of the mount. It's
+// provided by the Filesystem and never reaches an FAO.
+type ControlDirNode struct {
+	fs.Inode
+	Files []*ControlFileNode
+}
+
+func CreateControlDirNode(files []ControlFile) *ControlDirNode {
+	dir := &ControlDirNode{}
+	for i, file := range files {
+		dir.Files = append(dir.Files, &ControlFileNode{
+			ControlFile: file,
+			ino:         controlInoBase + 1 + uint64(i),
+		})
+	}
+	return dir
+}
+
+func (n *ControlDirNode) GetIno() uint64 {
+	return controlInoBase
+}
+
+func (n *ControlDirNode) Lookup(
+	ctx context.Context, name string, out *fuse.EntryOut,
+) (*fs.Inode, syscall.Errno) {
+	for _, file := range n.Files {
+		if file.Name != name {
+			continue
+		}
+		file.fillAttr(&out.Attr)
+		return n.NewInode(ctx, file, fs.StableAttr{
+			Mode: syscall.S_IFREG,
+			Ino:  file.ino,
+		}), 0
+	}
+	return nil, syscall.ENOENT
+}
+
+func (n *ControlDirNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
+	entries := []fuse.DirEntry{}
+	for _, file := range n.Files {
+		entries = append(entries, fuse.DirEntry{
+			Mode: syscall.S_IFREG,
+			Name: file.Name,
+			Ino:  file.ino,
+		})
+	}
+	return fs.NewListDirStream(entries), 0
+}
+
+func (n *ControlDirNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	out.Mode = 0755 | syscall.S_IFDIR
+
+	// TODO: load from configuration
+	out.Uid = 1000
+	out.Gid = 1000
+	return 0
+}
+
+type ControlFileNode struct {
+	fs.Inode
+	ControlFile
+	ino uint64
+}
+
+// ControlFileHandler holds what was read when the file was opened, so
+// reads at different offsets see the same snapshot, and what's been
+// written since.
+type ControlFileHandler struct {
+	contents []byte
+	written  []byte
+	lock     sync.Mutex
+}
+
+func (n *ControlFileNode) fillAttr(out *fuse.Attr) {
+	out.Mode = syscall.S_IFREG
+	if n.Contents != nil {
+		out.Mode |= 0444
+	}
+	if n.Receive != nil {
+		out.Mode |= 0200
+	}
+
+	// TODO: load from configuration
+	out.Uid = 1000
+	out.Gid = 1000
+}
+
+func (n *ControlFileNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	n.fillAttr(&out.Attr)
+	return 0
+}
+
+// Truncation is accepted so `echo path > invalidate` works.
+func (n *ControlFileNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	return n.Getattr(ctx, f, out)
+}
+
+func (n *ControlFileNode) Open(ctx context.Context, flags uint32) (fs.FileHandle, uint32, syscall.Errno) {
+	accessMode := flags & syscall.O_ACCMODE
+	if accessMode != syscall.O_WRONLY && n.Contents == nil {
+		return nil, 0, syscall.EACCES
+	}
+	if accessMode != syscall.O_RDONLY && n.Receive == nil {
+		return nil, 0, syscall.EACCES
+	}
+
+	fh := &ControlFileHandler{}
+	if accessMode != syscall.O_WRONLY {
+		contents, err := n.Contents()
+		if err != nil {
+			return nil, 0, toErrno(err)
+		}
+		fh.contents = contents
+	}
+
+	// The size isn't known in advance, so the page cache can't be used.
+	return fh, fuse.FOPEN_DIRECT_IO, 0
+}
+
+func (n *ControlFileNode) Read(
+	ctx context.Context,
+	f fs.FileHandle,
+	dest []byte, off int64,
+) (fuse.ReadResult, syscall.Errno) {
+	fh := f.(*ControlFileHandler)
+	if off >= int64(len(fh.contents)) {
+		return fuse.ReadResultData(nil), 0
+	}
+	end := min(off+int64(len(dest)), int64(len(fh.contents)))
+	return fuse.ReadResultData(fh.contents[off:end]), 0
+}
+
+func (n *ControlFileNode) Write(
+	ctx context.Context,
+	f fs.FileHandle,
+	data []byte, off int64,
+) (uint32, syscall.Errno) {
+	fh := f.(*ControlFileHandler)
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+
+	fh.written = append(fh.written, data...)
+	return uint32(len(data)), 0
+}
+
+// Whatever was written is handled when the file is closed, so an error
+// is reported by close(2).
+func (n *ControlFileNode) Flush(ctx context.Context, f fs.FileHandle) syscall.Errno {
+	fh := f.(*ControlFileHandler)
+	fh.lock.Lock()
+	defer fh.lock.Unlock()
+
+	if len(fh.written) == 0 {
+		return 0
+	}
+	written := fh.written
+	fh.written = nil
+
+	if err := n.Receive(written); err != nil {
+		return toErrno(err)
+	}
+	return 0
+}
@@ -20,7 +20,6 @@ package puterfs
 
 import (
 	"context"
-	"fmt"
 	"path/filepath"
 	"syscall"
 	"time"
@@ -51,12 +50,12 @@ func (n *DirectoryNode) Init() {
 	n.PathLockMap = kvdotgo.CreateKVMap[string, struct{}]()
 }
 
-func (n *DirectoryNode) syncItems() error {
+func (n *DirectoryNode) syncItems(ctx context.Context) error {
 	// TODO: Path -> UID
 	var items []fao.NodeInfo
 	var err error
 
-	items, err = n.FAO.ReadDir(n.CloudItem.Path)
+	items, err = n.FAO.ReadDir(ctx, n.CloudItem.Path)
 	if err != nil {
 		return err
 	}
@@ -70,10 +69,12 @@ func (n *DirectoryNode) syncItems() error {
 		}
 		if iseen, ok := seen[item.Name]; ok {
 			// return fmt.Errorf("duplicate item name: %s", item.Name)
-			fmt.Printf("item named %s has local uid %s\n", item.Name, item.LocalUID)
-			fmt.Printf("item named %s has real uid %s\n", item.Name, item.RemoteUID)
-			fmt.Printf("SEEN named %s has local uid %s\n", iseen.Name, iseen.LocalUID)
-			fmt.Printf("SEEN named %s has real uid %s\n", iseen.Name, iseen.RemoteUID)
+			n.Logger.With(
+				"localUID", item.LocalUID,
+				"remoteUID", item.RemoteUID,
+				"seenLocalUID", iseen.LocalUID,
+				"seenRemoteUID", iseen.RemoteUID,
+			).Warn("duplicate item name: %s", item.Name)
 			// panic(fmt.Errorf("duplicate item name: %s", item.Name))
 		}
 		seen[item.Name] = item
@@ -116,8 +117,10 @@ func (n *DirectoryNode) addOrReplaceCloudItem(
 func (n *DirectoryNode) Lookup(
 	ctx context.Context, name string, out *fuse.EntryOut,
 ) (*fs.Inode, syscall.Errno) {
-	n.Logger.Log("lookup(%s)", name)
-	n.syncItems()
+	ctx, span := startRequest(ctx, "Lookup", filepath.Join(n.CloudItem.Path, name))
+	defer span.End()
+	n.Logger.Debug("lookup(%s)", name)
+	n.syncItems(ctx)
 
 	foundItem, found := n.lookupCloudItem(name)
 
@@ -145,12 +148,14 @@ func (n *DirectoryNode) Symlink(
 	name string,
 	out *fuse.EntryOut,
 ) (*fs.Inode, syscall.Errno) {
-	fmt.Printf("dir::symlink(%s)\n", name)
-	n.syncItems()
+	ctx, span := startRequest(ctx, "Symlink", filepath.Join(n.CloudItem.Path, name))
+	defer span.End()
+	n.Logger.Debug("symlink(%s)", name)
+	n.syncItems(ctx)
 
-	node, err := n.FAO.Symlink(n.CloudItem.Path, name, target)
+	node, err := n.FAO.Symlink(ctx, n.CloudItem.Path, name, target)
 	if err != nil {
-		return nil, syscall.EIO
+		return nil, toErrno(err)
 	}
 
 	cloudItemNode := n.Filesystem.GetNodeFromCloudItem(node)
@@ -167,7 +172,9 @@ func (n *DirectoryNode) Symlink(
 }
 
 func (n *DirectoryNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	n.syncItems()
+	ctx, span := startRequest(ctx, "Readdir", n.CloudItem.Path)
+	defer span.End()
+	n.syncItems(ctx)
 
 	entries := []fuse.DirEntry{}
 	for _, item := range n.Items {
@@ -199,9 +206,11 @@ func (n *DirectoryNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.
 }
 
 func (n *DirectoryNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (node *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
-	n.Logger.Log("create(%s)", name)
+	ctx, span := startRequest(ctx, "Create", filepath.Join(n.CloudItem.Path, name))
+	defer span.End()
+	n.Logger.Debug("create(%s)", name)
 	// check if directory already exists
-	_, exists, err := n.FAO.Stat(filepath.Join(n.CloudItem.Path, name))
+	_, exists, err := n.FAO.Stat(ctx, filepath.Join(n.CloudItem.Path, name))
 	if err != nil {
 		return nil, nil, 0, syscall.EIO
 	}
@@ -209,16 +218,16 @@ func (n *DirectoryNode) Create(ctx context.Context, name string, flags uint32, m
 		return nil, nil, 0, syscall.EEXIST
 	}
 
-	nodeInfo, err := n.FAO.Create(n.CloudItem.Path, name)
+	nodeInfo, err := n.FAO.Create(ctx, n.CloudItem.Path, name)
 	if err != nil {
-		panic(err)
-		// return nil, nil, 0, syscall.EIO
+		n.Logger.Debug("create error: %v", err)
+		return nil, nil, 0, toErrno(err)
 	}
 
 	// log the node info
-	n.Logger.Log("nodeInfo: %+v", nodeInfo)
+	n.Logger.Debug("nodeInfo: %+v", nodeInfo)
 	// log "is dir"
-	n.Logger.Log("is dir: %v", nodeInfo.IsDir)
+	n.Logger.Debug("is dir: %v", nodeInfo.IsDir)
 
 	cloudItemNode := n.Filesystem.GetNodeFromCloudItem(nodeInfo)
 	iface := cloudItemNode.(HasPuterNodeCapabilities)
@@ -234,11 +243,13 @@ func (n *DirectoryNode) Create(ctx context.Context, name string, flags uint32, m
 }
 
 func (n *DirectoryNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	ctx, span := startRequest(ctx, "Mkdir", filepath.Join(n.CloudItem.Path, name))
+	defer span.End()
 	mutex := n.PathLockMap.SetAndLock(name, struct{}{}, 0)
 	defer mutex.Unlock()
 
 	// check if directory already exists
-	_, exists, err := n.FAO.Stat(filepath.Join(n.CloudItem.Path, name))
+	_, exists, err := n.FAO.Stat(ctx, filepath.Join(n.CloudItem.Path, name))
 	if err != nil {
 		return nil, syscall.EIO
 	}
@@ -246,9 +257,9 @@ func (n *DirectoryNode) Mkdir(ctx context.Context, name string, mode uint32, out
 		return nil, syscall.EEXIST
 	}
 
-	nodeInfo, err := n.FAO.MkDir(n.CloudItem.Path, name)
+	nodeInfo, err := n.FAO.MkDir(ctx, n.CloudItem.Path, name)
 	if err != nil {
-		return nil, syscall.EIO
+		return nil, toErrno(err)
 	}
 
 	cloudItemNode := n.Filesystem.GetNodeFromCloudItem(nodeInfo)
@@ -265,8 +276,10 @@ func (n *DirectoryNode) Mkdir(ctx context.Context, name string, mode uint32, out
 }
 
 func (n *DirectoryNode) Unlink(ctx context.Context, name string) syscall.Errno {
+	ctx, span := startRequest(ctx, "Unlink", filepath.Join(n.CloudItem.Path, name))
+	defer span.End()
 	path := filepath.Join(n.CloudItem.Path, name)
-	stat, exists, err := n.FAO.Stat(path)
+	stat, exists, err := n.FAO.Stat(ctx, path)
 	if err != nil {
 		return syscall.EIO
 	}
@@ -277,17 +290,19 @@ func (n *DirectoryNode) Unlink(ctx context.Context, name string) syscall.Errno {
 		return syscall.EISDIR
 	}
 
-	err = n.FAO.Unlink(path)
+	err = n.FAO.Unlink(ctx, path)
 	if err != nil {
-		return syscall.EIO
+		return toErrno(err)
 	}
 
 	return 0
 }
 
 func (n *DirectoryNode) Rmdir(ctx context.Context, name string) syscall.Errno {
+	ctx, span := startRequest(ctx, "Rmdir", filepath.Join(n.CloudItem.Path, name))
+	defer span.End()
 	path := filepath.Join(n.CloudItem.Path, name)
-	stat, exists, err := n.FAO.Stat(path)
+	stat, exists, err := n.FAO.Stat(ctx, path)
 	if err != nil {
 		return syscall.EIO
 	}
@@ -298,9 +313,9 @@ func (n *DirectoryNode) Rmdir(ctx context.Context, name string) syscall.Errno {
 		return syscall.ENOTDIR
 	}
 
-	err = n.FAO.Unlink(path)
+	err = n.FAO.Unlink(ctx, path)
 	if err != nil {
-		return syscall.EIO
+		return toErrno(err)
 	}
 
 	return 0
@@ -313,12 +328,14 @@ func (n *DirectoryNode) Rename(
 	newName string,
 	flags uint32,
 ) syscall.Errno {
+	ctx, span := startRequest(ctx, "Rename", filepath.Join(n.CloudItem.Path, name))
+	defer span.End()
 	sourcePath := filepath.Join(n.CloudItem.Path, name)
 	parentNode := newParent.(*DirectoryNode)
-	err := n.FAO.Move(sourcePath, parentNode.CloudItem.Path, newName)
+	err := n.FAO.Move(ctx, sourcePath, parentNode.CloudItem.Path, newName)
 	if err != nil {
-		n.Logger.Log("rename error: %v", err)
-		return syscall.EIO
+		n.Logger.Error("rename error: %v", err)
+		return toErrno(err)
 	}
 	return 0
 }
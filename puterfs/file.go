@@ -52,15 +52,17 @@ func (n *FileNode) Read(
 	f fs.FileHandle,
 	dest []byte, off int64,
 ) (fuse.ReadResult, syscall.Errno) {
-	n.Logger.Log("read(%s)", n.CloudItem.Path)
+	ctx, span := startRequest(ctx, "Read", n.CloudItem.Path)
+	defer span.End()
+	n.Logger.Debug("read(%s)", n.CloudItem.Path)
 
-	_, err := n.FAO.Read(n.CloudItem.Path, dest, off)
+	amount, err := n.FAO.Read(ctx, n.CloudItem.Path, dest, off)
 	if err != nil {
-		n.Logger.Log("error reading file %s: %s", n.CloudItem.Path, err)
-		return nil, syscall.EIO
+		n.Logger.Error("error reading file %s: %s", n.CloudItem.Path, err)
+		return nil, toErrno(err)
 	}
 
-	return fuse.ReadResultData(dest), 0
+	return fuse.ReadResultData(dest[:amount]), 0
 }
 
 func (n *FileNode) Write(
@@ -68,13 +70,15 @@ func (n *FileNode) Write(
 	f fs.FileHandle,
 	data []byte, off int64,
 ) (uint32, syscall.Errno) {
-	amount, err := n.FAO.Write(n.CloudItem.Path, data, off)
+	ctx, span := startRequest(ctx, "Write", n.CloudItem.Path)
+	defer span.End()
+	amount, err := n.FAO.Write(ctx, n.CloudItem.Path, data, off)
 
 	if err != nil {
 		if viper.GetBool("panik") {
 			panic(fmt.Errorf("error writing file %s: %s", n.CloudItem.Path, err))
 		}
-		return 0, syscall.EIO
+		return 0, toErrno(err)
 	}
 
 	return uint32(amount), 0
@@ -86,11 +90,41 @@ func (n *FileNode) Write(
 
 // )
 
+// Blocks until Puter has acknowledged every write to this file.
 func (n *FileNode) Fsync(ctx context.Context, f fs.FileHandle, flags uint32) syscall.Errno {
+	ctx, span := startRequest(ctx, "Fsync", n.CloudItem.Path)
+	defer span.End()
+	err := n.FAO.Fsync(ctx, n.CloudItem.Path)
+	if err != nil {
+		n.Logger.Error("error syncing file %s: %s", n.CloudItem.Path, err)
+		return toErrno(err)
+	}
+	return 0
+}
+
+// Called on close(2); an upload which has already failed is reported
+// here as well so applications that don't fsync still see the error.
+// Unlike fsync, it doesn't wait for uploads still being sent.
+func (n *FileNode) Flush(ctx context.Context, f fs.FileHandle) syscall.Errno {
+	ctx, span := startRequest(ctx, "Flush", n.CloudItem.Path)
+	defer span.End()
+	err := n.FAO.TakeError(ctx, n.CloudItem.Path)
+	if err != nil {
+		n.Logger.Error("error writing file %s: %s", n.CloudItem.Path, err)
+		return toErrno(err)
+	}
 	return 0
 }
 
 func (n *FileNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	ctx, span := startRequest(ctx, "Getattr", n.CloudItem.Path)
+	defer span.End()
+	// The FAO reports the size with pending mutations applied,
+	// so this may differ from what the last readdir reported.
+	if stat, exists, err := n.FAO.Stat(ctx, n.CloudItem.Path); err == nil && exists {
+		n.CloudItem.Size = stat.Size
+	}
+
 	out.Size = n.CloudItem.Size
 
 	// TODO: load from configuration
@@ -115,13 +149,20 @@ func (n *FileNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrO
 }
 
 func (n *FileNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
+	ctx, span := startRequest(ctx, "Setattr", n.CloudItem.Path)
+	defer span.End()
 
 	// TODO: modify attributes
 	// this NO-OP is here so commands like `touch` exit without error
 	if in.Valid&fuse.FATTR_SIZE != 0 && in.Size != n.CloudItem.Size {
-		n.FAO.Truncate(n.CloudItem.Path, in.Size)
+		err := n.FAO.Truncate(ctx, n.CloudItem.Path, in.Size)
+		if err != nil {
+			n.Logger.Error("error truncating file %s: %s", n.CloudItem.Path, err)
+			return toErrno(err)
+		}
+		n.CloudItem.Size = in.Size
 	}
-	return 0
+	return n.Getattr(ctx, f, out)
 }
 
 func (n *FileNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
@@ -19,16 +19,17 @@
 package puterfs
 
 import (
-	"fmt"
-	"log"
 	"sync"
 
+	"github.com/HeyPuter/puter-fuse/debug"
 	"github.com/HeyPuter/puter-fuse/fao"
 	"github.com/HeyPuter/puter-fuse/putersdk"
 	"github.com/HeyPuter/puter-fuse/services"
 	"github.com/hanwen/go-fuse/v2/fs"
 )
 
+var logger = debug.NewLogger("fs")
+
 type Filesystem struct {
 	// map Puter UIDs to inode numbers
 	UidInoMap  map[string]uint64
@@ -40,6 +41,12 @@ type Filesystem struct {
 	fao.FAO
 	Services *services.ServicesContainer
 
+	// optional; shown at the root under ControlDirName, which is left
+	// out of readdir unless ShowControlDir is set
+	ControlDir     *ControlDirNode
+	ControlDirName string
+	ShowControlDir bool
+
 	NodesMutex     sync.RWMutex
 	UidInoMapMutex sync.RWMutex
 }
@@ -90,7 +97,7 @@ func (fs *Filesystem) GetInoFromUID(uid string) uint64 {
 		if !exists {
 			fs.InoCounter++
 			ino = fs.InoCounter
-			fmt.Println("new ino", ino, "for uid", uid)
+			logger.Debug("new ino %d for uid %s", ino, uid)
 			fs.UidInoMap[uid] = ino
 		}
 		fs.UidInoMapMutex.Unlock()
@@ -100,15 +107,12 @@ func (fs *Filesystem) GetInoFromUID(uid string) uint64 {
 }
 
 func (fs *Filesystem) CreateNodeFromCloudItem(cloudItem fao.NodeInfo) fs.InodeEmbedder {
-	// log info about clouditem
-	log.Printf("cloudItem: %+v\n", cloudItem)
+	logger.Debug("creating node for %s (dir: %t)", cloudItem.Path, cloudItem.IsDir)
 
 	if cloudItem.IsDir {
-		fmt.Println("creating dir node")
 		return fs.CreateDirNodeFromCloudItem(cloudItem)
 	}
 
-	fmt.Println("creating file node")
 	return fs.CreateFileNodeFromCloudItem(cloudItem)
 }
 
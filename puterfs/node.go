@@ -19,9 +19,12 @@
 package puterfs
 
 import (
+	"context"
+	"errors"
 	"syscall"
 
 	"github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/trace"
 )
 
 type HasPuterNodeCapabilities interface {
@@ -59,3 +62,18 @@ func (n *CloudItemNode) GetIno() uint64 {
 func (n *CloudItemNode) SetCloudItem(cloudItem fao.NodeInfo) {
 	n.CloudItem = cloudItem
 }
+
+// Returns the errno carried by an FAOError, or EIO for any other error.
+func toErrno(err error) syscall.Errno {
+	var faoErr *fao.FAOError
+	if errors.As(err, &faoErr) {
+		return faoErr.Errno
+	}
+	return syscall.EIO
+}
+
+// Starts the span for a FUSE request. Its trace ID follows the request
+// through the FAO layers and is sent to Puter as X-Request-Id.
+func startRequest(ctx context.Context, op string, path string) (context.Context, *trace.Span) {
+	return trace.Start(ctx, "fuse."+op, "path", path)
+}
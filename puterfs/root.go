@@ -46,14 +46,14 @@ func (n *RootNode) Init() {
 	n.Logger = svc_log.GetLogger("ROOT")
 }
 
-func (n *RootNode) syncItems() error {
+func (n *RootNode) syncItems(ctx context.Context) error {
 	if time.Now().Compare(n.LastPoll.Add(n.PollDuration)) < 0 {
 		return nil
 	}
 	n.LastPoll = time.Now()
 
 	// TODO: Path -> UID
-	items, err := n.FAO.ReadDir("/")
+	items, err := n.FAO.ReadDir(ctx, "/")
 	if err != nil {
 		return err
 	}
@@ -71,8 +71,19 @@ func (n *RootNode) syncItems() error {
 func (n *RootNode) Lookup(
 	ctx context.Context, name string, out *fuse.EntryOut,
 ) (*fs.Inode, syscall.Errno) {
-	n.Logger.Log("lookup(%s)", name)
-	n.syncItems()
+	ctx, span := startRequest(ctx, "Lookup", "/"+name)
+	defer span.End()
+	n.Logger.Debug("lookup(%s)", name)
+
+	// The control directory shadows anything in Puter with its name.
+	if n.ControlDir != nil && name == n.ControlDirName {
+		return n.NewInode(ctx, n.ControlDir, fs.StableAttr{
+			Mode: syscall.S_IFDIR,
+			Ino:  n.ControlDir.GetIno(),
+		}), 0
+	}
+
+	n.syncItems(ctx)
 
 	var foundItem fao.NodeInfo
 	var found bool
@@ -103,13 +114,18 @@ func (n *RootNode) Lookup(
 }
 
 func (n *RootNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	n.syncItems()
+	ctx, span := startRequest(ctx, "Readdir", "/")
+	defer span.End()
+	n.syncItems(ctx)
 
 	entries := []fuse.DirEntry{}
 	for _, item := range n.Items {
 		if item.Path == "" {
 			panic("item is missing path")
 		}
+		if n.ControlDir != nil && item.Name == n.ControlDirName {
+			continue
+		}
 		node := n.Filesystem.GetNodeFromCloudItem(item)
 		iface := node.(HasPuterNodeCapabilities)
 		entry := fuse.DirEntry{
@@ -119,5 +135,13 @@ func (n *RootNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
 		}
 		entries = append(entries, entry)
 	}
+
+	if n.ControlDir != nil && n.ShowControlDir {
+		entries = append(entries, fuse.DirEntry{
+			Mode: syscall.S_IFDIR,
+			Name: n.ControlDirName,
+			Ino:  n.ControlDir.GetIno(),
+		})
+	}
 	return fs.NewListDirStream(entries), 0
 }
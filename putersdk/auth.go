@@ -0,0 +1,239 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/debug"
+	"github.com/HeyPuter/puter-fuse/trace"
+)
+
+// How often re-authentication is retried while unauthenticated.
+const reauthenticateInterval = 30 * time.Second
+
+type authState struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	// a Reauthenticate call is in progress; requests wait for it
+	reauthenticating bool
+	// the token was rejected and no replacement could be found
+	unauthenticated bool
+}
+
+func (sdk *PuterSDK) getCond() *sync.Cond {
+	if sdk.auth.cond == nil {
+		sdk.auth.cond = sync.NewCond(&sdk.auth.lock)
+	}
+	return sdk.auth.cond
+}
+
+// Returns the current token, waiting while re-authentication is in
+// progress.
+func (sdk *PuterSDK) awaitToken() (string, error) {
+	sdk.auth.lock.Lock()
+	defer sdk.auth.lock.Unlock()
+
+	for sdk.auth.reauthenticating {
+		sdk.getCond().Wait()
+	}
+	if sdk.auth.unauthenticated {
+		return "", ErrUnauthenticated
+	}
+	return sdk.PuterAuthToken, nil
+}
+
+// Authenticated returns false while the SDK has no valid token.
+func (sdk *PuterSDK) Authenticated() bool {
+	sdk.auth.lock.Lock()
+	defer sdk.auth.lock.Unlock()
+
+	return !sdk.auth.unauthenticated
+}
+
+// WaitAuthenticated blocks until the SDK has a valid token.
+func (sdk *PuterSDK) WaitAuthenticated() {
+	sdk.auth.lock.Lock()
+	defer sdk.auth.lock.Unlock()
+
+	for sdk.auth.reauthenticating || sdk.auth.unauthenticated {
+		sdk.getCond().Wait()
+	}
+}
+
+// SetToken replaces the token and resumes any paused requests.
+func (sdk *PuterSDK) SetToken(token string) {
+	sdk.auth.lock.Lock()
+	defer sdk.auth.lock.Unlock()
+
+	debug.AddSecret(token)
+	sdk.PuterAuthToken = token
+	if sdk.auth.unauthenticated {
+		logger.Log("authenticated again; resuming requests")
+	}
+	sdk.auth.unauthenticated = false
+	sdk.getCond().Broadcast()
+}
+
+// Called when Puter rejects 'rejected'. Returns the token to retry with.
+func (sdk *PuterSDK) handleAuthFailure(rejected string) (string, error) {
+	sdk.auth.lock.Lock()
+
+	for sdk.auth.reauthenticating {
+		sdk.getCond().Wait()
+	}
+	if sdk.auth.unauthenticated {
+		sdk.auth.lock.Unlock()
+		return "", ErrUnauthenticated
+	}
+	// another request already replaced the token
+	if sdk.PuterAuthToken != rejected {
+		token := sdk.PuterAuthToken
+		sdk.auth.lock.Unlock()
+		return token, nil
+	}
+
+	logger.Warn("token was rejected; pausing requests to re-authenticate")
+	sdk.auth.reauthenticating = true
+	sdk.auth.lock.Unlock()
+
+	token, err := sdk.reauthenticate(rejected)
+
+	sdk.auth.lock.Lock()
+	defer sdk.auth.lock.Unlock()
+	sdk.auth.reauthenticating = false
+	defer sdk.getCond().Broadcast()
+
+	if err != nil {
+		logger.Error("UNAUTHENTICATED: %s; requests will fail until you log in again", err)
+		sdk.auth.unauthenticated = true
+		go sdk.recoverAuth(rejected)
+		return "", ErrUnauthenticated
+	}
+
+	logger.Log("re-authenticated; resuming requests")
+	debug.AddSecret(token)
+	sdk.PuterAuthToken = token
+	return token, nil
+}
+
+func (sdk *PuterSDK) reauthenticate(rejected string) (string, error) {
+	if sdk.Reauthenticate == nil {
+		return "", fmt.Errorf("no way to re-authenticate is configured")
+	}
+	token, err := sdk.Reauthenticate(rejected)
+	if err != nil {
+		return "", err
+	}
+	if token == "" || token == rejected {
+		return "", fmt.Errorf("no new token is available")
+	}
+	return token, nil
+}
+
+// Retries Reauthenticate periodically until a new token is found, e.g.
+// after the user runs `puter-fuse login` again.
+func (sdk *PuterSDK) recoverAuth(rejected string) {
+	for {
+		time.Sleep(reauthenticateInterval)
+
+		if sdk.Authenticated() {
+			return
+		}
+		token, err := sdk.reauthenticate(rejected)
+		if err == nil {
+			sdk.SetToken(token)
+			return
+		}
+	}
+}
+
+// Do sends 'req' with the current token. If Puter rejects the token,
+// requests are paused while a new one is obtained and 'req' is retried
+// once with it. The trace ID of the request's context is sent as
+// X-Request-Id.
+func (sdk *PuterSDK) Do(req *http.Request) (*http.Response, error) {
+	_, span := trace.Start(req.Context(), "http."+path.Base(req.URL.Path),
+		"method", req.Method)
+	defer span.End()
+
+	if id := trace.RequestID(req.Context()); id != "" {
+		req.Header.Set("X-Request-Id", id)
+	}
+
+	resp, err := sdk.do(req)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttr("status", resp.StatusCode)
+	return resp, nil
+}
+
+func (sdk *PuterSDK) do(req *http.Request) (*http.Response, error) {
+	token, err := sdk.awaitToken()
+	if err != nil {
+		// as the client would, so a streamed body isn't left open
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := sdk.send(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	token, err = sdk.handleAuthFailure(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Body != nil {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("request body can't be replayed after re-authenticating")
+		}
+		req.Body, err = req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	req.Header.Set("Authorization", "Bearer "+token)
+	return sdk.send(req)
+}
+
+// Sends a request, counting the response by status.
+func (sdk *PuterSDK) send(req *http.Request) (*http.Response, error) {
+	resp, err := sdk.Client.Do(req)
+	code := "error"
+	if err == nil {
+		code = strconv.Itoa(resp.StatusCode)
+	}
+	httpResponses.With(req.Method, req.URL.Path, code).Inc()
+	return resp, err
+}
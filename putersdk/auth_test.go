@@ -0,0 +1,106 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func createTestSDK(token string) (*PuterSDK, *httptest.Server) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+
+	sdk := &PuterSDK{
+		Url:            server.URL,
+		PuterAuthToken: token,
+	}
+	sdk.Init()
+	return sdk, server
+}
+
+func TestAuth(t *testing.T) {
+	t.Run("rejected token is replaced and the request retried", func(t *testing.T) {
+		sdk, server := createTestSDK("old")
+		defer server.Close()
+
+		calls := 0
+		sdk.Reauthenticate = func(rejected string) (string, error) {
+			calls++
+			if rejected != "old" {
+				t.Errorf("expected 'old' to be rejected, got '%s'", rejected)
+			}
+			return "new", nil
+		}
+
+		req, _ := http.NewRequest("POST", server.URL, strings.NewReader("body"))
+		resp, err := sdk.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != 200 || string(body) != "body" {
+			t.Errorf("expected the request to be replayed, got %d '%s'", resp.StatusCode, body)
+		}
+		if calls != 1 {
+			t.Errorf("expected 1 call to Reauthenticate, got %d", calls)
+		}
+	})
+
+	t.Run("requests fail while unauthenticated", func(t *testing.T) {
+		sdk, server := createTestSDK("old")
+		defer server.Close()
+
+		sdk.Reauthenticate = func(rejected string) (string, error) {
+			return "", errors.New("no credentials")
+		}
+
+		req, _ := http.NewRequest("GET", server.URL, nil)
+		if _, err := sdk.Do(req); !errors.Is(err, ErrUnauthenticated) {
+			t.Fatalf("expected ErrUnauthenticated, got %v", err)
+		}
+		if sdk.Authenticated() {
+			t.Errorf("expected the SDK to be unauthenticated")
+		}
+
+		req, _ = http.NewRequest("GET", server.URL, nil)
+		if _, err := sdk.Do(req); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("expected ErrUnauthenticated, got %v", err)
+		}
+
+		sdk.SetToken("new")
+		req, _ = http.NewRequest("GET", server.URL, nil)
+		resp, err := sdk.Do(req)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	})
+}
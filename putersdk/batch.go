@@ -19,13 +19,9 @@
 package putersdk
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
-	"mime/multipart"
-	"net/http"
-	"strings"
 )
 
 type Operation map[string]interface{}
@@ -34,53 +30,57 @@ type BatchResoponse struct {
 	Results []map[string]interface{}
 }
 
-func (sdk *PuterSDK) Batch(operations []Operation, blobs [][]byte) (*BatchResoponse, error) {
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
+func (sdk *PuterSDK) Batch(ctx context.Context, operations []Operation, blobs [][]byte) (*BatchResoponse, error) {
+	uploads := make([]Upload, len(blobs))
+	for i, blob := range blobs {
+		uploads[i] = BytesUpload(blob)
+	}
+	return sdk.BatchUploads(ctx, operations, uploads)
+}
 
-	fmt.Printf("batching %d operations with %d files\n", len(operations), len(blobs))
+// BatchUploads sends operations with the files they write, which are
+// streamed rather than held in memory.
+func (sdk *PuterSDK) BatchUploads(ctx context.Context, operations []Operation, uploads []Upload) (_ *BatchResoponse, err error) {
+	body := newMultipartBody()
+
+	logger.Debug("batching %d operations with %d files", len(operations), len(uploads))
 
 	for _, op := range operations {
 		opJson, err := json.Marshal(op)
 		if err != nil {
 			return nil, err
 		}
-
-		fw, _ := writer.CreateFormField("operation")
-		fw.Write(opJson)
+		body.AddField("operation", string(opJson))
 	}
 
-	if blobs != nil {
-		for _, blob := range blobs {
-			fileinfoJson, err := json.Marshal(map[string]interface{}{
-				"name": "untitled",
-				"size": len(blob),
-			})
-			if err != nil {
-				panic(err)
-			}
-			fw, _ := writer.CreateFormField("fileinfo")
-			fw.Write(fileinfoJson)
+	for _, upload := range uploads {
+		fileinfoJson, err := json.Marshal(map[string]interface{}{
+			"name": "untitled",
+			"size": upload.Size,
+		})
+		if err != nil {
+			panic(err)
 		}
-		for _, blob := range blobs {
-			fw, _ := writer.CreateFormFile("file", "untitled")
-			fw.Write(blob)
+		body.AddField("fileinfo", string(fileinfoJson))
+	}
+	transfers := []Transfer{}
+	defer func() {
+		for _, transfer := range transfers {
+			transfer.Finish(err)
 		}
+	}()
+	for _, upload := range uploads {
+		transfer := sdk.startTransfer(TransferUpload, upload.Path, upload.Size)
+		transfers = append(transfers, transfer)
+		body.AddFile("file", "untitled", trackUpload(upload, transfer))
 	}
 
-	writer.Close()
-
-	u := sdk.GetEndpointURL("batch")
-
-	req, err := http.NewRequest("POST", u.String(), body)
+	req, err := sdk.newMultipartRequest(ctx, "batch", body)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+sdk.PuterAuthToken)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-
-	resp, err := sdk.Client.Do(req)
+	resp, err := sdk.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -88,33 +88,28 @@ func (sdk *PuterSDK) Batch(operations []Operation, blobs [][]byte) (*BatchResopo
 
 	if resp.StatusCode != 200 && resp.StatusCode != 218 {
 		respBytes, _ := io.ReadAll(resp.Body)
-		err = fmt.Errorf(
-			"unexpected status: %d"+
-				"\nbody: |%s|",
-			resp.StatusCode,
-			string(respBytes),
-		)
-		return nil, err
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(respBytes),
+		}
 	}
 
-	bgetbody := new(strings.Builder)
-	rgetbody, _ := req.GetBody()
-	io.Copy(bgetbody, rgetbody)
-
 	respBytes, _ := io.ReadAll(resp.Body)
 
-	fmt.Printf("batch response: %s\n", string(respBytes))
-
 	batchResponse := &BatchResoponse{}
 	err = json.Unmarshal(respBytes, batchResponse)
 	if err != nil {
 		return nil, err
 	}
 
-	fmt.Printf("status? [%d] when sending [%s]\n",
-		resp.StatusCode,
-		bgetbody.String(),
-	)
+	// Only sizes are logged; the request carries file contents and the
+	// results carry metadata of the user's files.
+	logger.With(
+		"status", resp.StatusCode,
+		"operations", len(operations),
+		"results", len(batchResponse.Results),
+		"requestBytes", req.ContentLength,
+	).Debug("batch sent")
 
 	return batchResponse, nil
 }
@@ -20,13 +20,13 @@ package putersdk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"net/http"
 )
 
-func (sdk *PuterSDK) Delete(path string) (err error) {
-	fmt.Printf("delete(%s)\n", path)
+func (sdk *PuterSDK) Delete(ctx context.Context, path string) (err error) {
+	logger.Debug("delete(%s)", path)
 	payload := map[string]interface{}{}
 	payload["paths"] = []string{path}
 
@@ -36,7 +36,8 @@ func (sdk *PuterSDK) Delete(path string) (err error) {
 	if err != nil {
 		return
 	}
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
 		u.String(),
 		bytes.NewBuffer(jsonStr),
@@ -44,16 +45,15 @@ func (sdk *PuterSDK) Delete(path string) (err error) {
 	if err != nil {
 		return
 	}
-	req.Header.Set("Authorization", "Bearer "+sdk.PuterAuthToken)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := sdk.Client.Do(req)
+	resp, err := sdk.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		err = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		err = &StatusError{StatusCode: resp.StatusCode}
 	}
 
 	return
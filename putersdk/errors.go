@@ -0,0 +1,46 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Returned instead of sending a request while the SDK has no valid token.
+var ErrUnauthenticated = errors.New("not authenticated with Puter")
+
+// StatusError is returned when Puter responds with an unexpected status.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("unexpected status: %d", e.StatusCode)
+	}
+	return fmt.Sprintf("unexpected status: %d\nbody: |%s|", e.StatusCode, e.Body)
+}
+
+// Returns true if 'err' is a StatusError with the given status code.
+func IsStatus(err error, statusCode int) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.StatusCode == statusCode
+}
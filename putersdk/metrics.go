@@ -0,0 +1,30 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import "github.com/HeyPuter/puter-fuse/metrics"
+
+var httpResponses = metrics.NewCounterVec("puterfuse_http_responses_total",
+	"Responses from Puter by method, endpoint and status code; the code is "+
+		"\"error\" when no response was received.",
+	"method", "path", "code")
+
+var schedulerWait = metrics.NewHistogramVec("puterfuse_http_queue_seconds",
+	"Time requests waited for a place in the scheduler, by class.",
+	metrics.DurationBuckets, "class")
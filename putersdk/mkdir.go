@@ -20,14 +20,14 @@ package putersdk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 )
 
-func (sdk *PuterSDK) Mkdir(path string) (cloudItem CloudItem, err error) {
-	fmt.Printf("mkdir(%s)\n", path)
+func (sdk *PuterSDK) Mkdir(ctx context.Context, path string) (cloudItem CloudItem, err error) {
+	logger.Debug("mkdir(%s)", path)
 	payload := map[string]interface{}{}
 	payload["path"] = path
 
@@ -37,7 +37,8 @@ func (sdk *PuterSDK) Mkdir(path string) (cloudItem CloudItem, err error) {
 	if err != nil {
 		return
 	}
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
 		u.String(),
 		bytes.NewBuffer(jsonStr),
@@ -45,16 +46,15 @@ func (sdk *PuterSDK) Mkdir(path string) (cloudItem CloudItem, err error) {
 	if err != nil {
 		return
 	}
-	req.Header.Set("Authorization", "Bearer "+sdk.PuterAuthToken)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := sdk.Client.Do(req)
+	resp, err := sdk.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		err = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		err = &StatusError{StatusCode: resp.StatusCode}
 		return
 	}
 
@@ -20,14 +20,14 @@ package putersdk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 )
 
-func (sdk *PuterSDK) Move(sourcePath, dstPath, newName string) (cloudItem CloudItem, err error) {
-	fmt.Printf("move(%s,%s,%s)\n", sourcePath, dstPath, newName)
+func (sdk *PuterSDK) Move(ctx context.Context, sourcePath, dstPath, newName string) (cloudItem CloudItem, err error) {
+	logger.Debug("move(%s,%s,%s)", sourcePath, dstPath, newName)
 	payload := map[string]interface{}{}
 	payload["source"] = sourcePath
 	payload["destination"] = dstPath
@@ -39,7 +39,8 @@ func (sdk *PuterSDK) Move(sourcePath, dstPath, newName string) (cloudItem CloudI
 	if err != nil {
 		return
 	}
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
 		u.String(),
 		bytes.NewBuffer(jsonStr),
@@ -47,16 +48,15 @@ func (sdk *PuterSDK) Move(sourcePath, dstPath, newName string) (cloudItem CloudI
 	if err != nil {
 		return
 	}
-	req.Header.Set("Authorization", "Bearer "+sdk.PuterAuthToken)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := sdk.Client.Do(req)
+	resp, err := sdk.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		err = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		err = &StatusError{StatusCode: resp.StatusCode}
 		return
 	}
 
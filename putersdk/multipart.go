@@ -0,0 +1,190 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/spf13/afero"
+)
+
+// Upload is the contents of a file to upload. Open may be called more
+// than once, e.g. to send a request again after re-authenticating, and
+// must return Size bytes each time.
+type Upload struct {
+	// where the contents are going, as shown while they're transferred
+	Path string
+	Size int64
+	Open func() (io.ReadCloser, error)
+}
+
+func BytesUpload(data []byte) Upload {
+	return Upload{
+		Size: int64(len(data)),
+		Open: func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		},
+	}
+}
+
+// FileUpload uploads a local file, such as one in the BLOB cache; it
+// must not change until the upload is finished.
+func FileUpload(fs afero.Fs, path string) (Upload, error) {
+	info, err := fs.Stat(path)
+	if err != nil {
+		return Upload{}, err
+	}
+	return Upload{
+		Size: info.Size(),
+		Open: func() (io.ReadCloser, error) {
+			return fs.Open(path)
+		},
+	}, nil
+}
+
+type multipartPart struct {
+	field    string
+	filename string
+	value    string
+	upload   *Upload
+}
+
+// multipartBody is a multipart/form-data body which is streamed as it's
+// sent rather than built in memory. Its length is known up front, so
+// it's sent with a Content-Length.
+type multipartBody struct {
+	boundary string
+	parts    []multipartPart
+}
+
+func newMultipartBody() *multipartBody {
+	return &multipartBody{boundary: multipart.NewWriter(io.Discard).Boundary()}
+}
+
+func (b *multipartBody) AddField(field, value string) {
+	b.parts = append(b.parts, multipartPart{field: field, value: value})
+}
+
+func (b *multipartBody) AddFile(field, filename string, upload Upload) {
+	b.parts = append(b.parts, multipartPart{field: field, filename: filename, upload: &upload})
+}
+
+func (b *multipartBody) ContentType() string {
+	return "multipart/form-data; boundary=" + b.boundary
+}
+
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+func (b *multipartBody) ContentLength() int64 {
+	counter := &countingWriter{}
+	b.write(counter, false)
+	for _, part := range b.parts {
+		if part.upload != nil {
+			counter.n += part.upload.Size
+		}
+	}
+	return counter.n
+}
+
+// Writes the body, or only the parts other than the files' contents.
+func (b *multipartBody) write(w io.Writer, contents bool) error {
+	writer := multipart.NewWriter(w)
+	if err := writer.SetBoundary(b.boundary); err != nil {
+		return err
+	}
+	for _, part := range b.parts {
+		if part.upload == nil {
+			fw, err := writer.CreateFormField(part.field)
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(fw, part.value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		fw, err := writer.CreateFormFile(part.field, part.filename)
+		if err != nil {
+			return err
+		}
+		if !contents {
+			continue
+		}
+		if err := copyUpload(fw, part.upload); err != nil {
+			return err
+		}
+	}
+	return writer.Close()
+}
+
+func copyUpload(w io.Writer, upload *Upload) error {
+	src, err := upload.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// more or less than Size would break the Content-Length; one byte
+	// over is enough to tell it changed
+	n, err := io.Copy(w, io.LimitReader(src, upload.Size+1))
+	if err != nil {
+		return err
+	}
+	if n != upload.Size {
+		return fmt.Errorf("upload was %d bytes rather than %d", n, upload.Size)
+	}
+	return nil
+}
+
+// Open returns a reader which streams the body; it can be used as a
+// request's GetBody.
+func (b *multipartBody) Open() (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(b.write(writer, true))
+	}()
+	return reader, nil
+}
+
+// Creates a request which streams 'body' to 'endpoint'.
+func (sdk *PuterSDK) newMultipartRequest(ctx context.Context, endpoint string, body *multipartBody) (*http.Request, error) {
+	reader, _ := body.Open()
+	req, err := http.NewRequestWithContext(ctx, "POST", sdk.GetEndpointURL(endpoint).String(), reader)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	req.ContentLength = body.ContentLength()
+	req.GetBody = body.Open
+	req.Header.Set("Content-Type", body.ContentType())
+	return req, nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMultipartBody(t *testing.T) {
+	testCases := []struct {
+		name   string
+		upload Upload
+		err    bool
+	}{
+		{"empty file", BytesUpload(nil), false},
+		{"small file", BytesUpload([]byte("hello")), false},
+		{"large file", BytesUpload(bytes.Repeat([]byte("x"), 1<<20)), false},
+		{"short file", Upload{Size: 10, Open: BytesUpload([]byte("hello")).Open}, true},
+		{"long file", Upload{Size: 2, Open: BytesUpload([]byte("hello")).Open}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			body := newMultipartBody()
+			body.AddField("path", "/dir")
+			body.AddFile("file", "name", tc.upload)
+
+			reader, _ := body.Open()
+			data, err := io.ReadAll(reader)
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if int64(len(data)) != body.ContentLength() {
+				t.Errorf("expected %d bytes, got %d", body.ContentLength(), len(data))
+			}
+
+			req := httptest.NewRequest("POST", "/", bytes.NewReader(data))
+			req.Header.Set("Content-Type", body.ContentType())
+			if err := req.ParseMultipartForm(1 << 10); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.FormValue("path") != "/dir" {
+				t.Errorf("expected path '/dir', got '%s'", req.FormValue("path"))
+			}
+			file, _, err := req.FormFile("file")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			contents, _ := io.ReadAll(file)
+			if int64(len(contents)) != tc.upload.Size {
+				t.Errorf("expected %d bytes of file, got %d", tc.upload.Size, len(contents))
+			}
+		})
+	}
+}
+
+func TestWriteUpload(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer new" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.ContentLength <= 0 {
+			t.Errorf("expected a Content-Length, got %d", r.ContentLength)
+		}
+		file, header, err := r.FormFile("file")
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		contents, _ := io.ReadAll(file)
+		json.NewEncoder(w).Encode(CloudItem{
+			Path: r.FormValue("path") + "/" + header.Filename,
+			Size: uint64(len(contents)),
+		})
+	}))
+	defer server.Close()
+
+	sdk := &PuterSDK{Url: server.URL, PuterAuthToken: "old"}
+	sdk.Init()
+	sdk.Reauthenticate = func(string) (string, error) { return "new", nil }
+
+	opens := 0
+	upload := BytesUpload([]byte(strings.Repeat("data", 1000)))
+	open := upload.Open
+	upload.Open = func() (io.ReadCloser, error) {
+		opens++
+		return open()
+	}
+
+	item, err := sdk.WriteUpload(context.Background(), "/dir/file", upload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if item.Path != "/dir/file" || item.Size != 4000 {
+		t.Errorf("unexpected item: %s, %d bytes", item.Path, item.Size)
+	}
+	// the rejected request is replayed with a fresh body
+	if opens != 2 {
+		t.Errorf("expected the upload to be opened twice, got %d", opens)
+	}
+}
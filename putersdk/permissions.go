@@ -0,0 +1,107 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+type User struct {
+	Username string `json:"username"`
+	UUID     string `json:"uuid"`
+}
+
+// Sends 'payload' as JSON, or a GET without it, and decodes the response.
+func (sdk *PuterSDK) requestJSON(ctx context.Context, endpoint string, payload, dest interface{}) error {
+	method := "GET"
+	var body io.Reader
+	if payload != nil {
+		jsonStr, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		method = "POST"
+		body = bytes.NewBuffer(jsonStr)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, sdk.GetEndpointURL(endpoint).String(), body)
+	if err != nil {
+		return err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := sdk.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return &StatusError{StatusCode: resp.StatusCode}
+	}
+	return json.NewDecoder(resp.Body).Decode(dest)
+}
+
+// Returns the user the token belongs to.
+func (sdk *PuterSDK) Whoami(ctx context.Context) (User, error) {
+	user := User{}
+	err := sdk.requestJSON(ctx, "whoami", nil, &user)
+	return user, err
+}
+
+// Returns whether the token holds each of 'permissions'.
+func (sdk *PuterSDK) CheckPermissions(ctx context.Context, permissions []string) (map[string]bool, error) {
+	response := struct {
+		Permissions map[string]bool `json:"permissions"`
+	}{}
+	err := sdk.requestJSON(ctx, "auth/check-permissions", map[string]interface{}{
+		"permissions": permissions,
+	}, &response)
+	return response.Permissions, err
+}
+
+// Returns whether the token may write to the user's home directory. It
+// fails if Puter doesn't say either way.
+func (sdk *PuterSDK) CanWriteHome(ctx context.Context) (bool, error) {
+	user, err := sdk.Whoami(ctx)
+	if err != nil {
+		return false, err
+	}
+	home, err := sdk.Stat(ctx, "/"+user.Username)
+	if err != nil {
+		return false, err
+	}
+
+	permission := "fs:" + home.RemoteUID + ":write"
+	permissions, err := sdk.CheckPermissions(ctx, []string{permission})
+	if err != nil {
+		return false, err
+	}
+	canWrite, known := permissions[permission]
+	if !known {
+		return false, fmt.Errorf("no answer for permission %s", permission)
+	}
+	return canWrite, nil
+}
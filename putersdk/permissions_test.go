@@ -0,0 +1,91 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanWriteHome(t *testing.T) {
+	tests := []struct {
+		name        string
+		permissions map[string]bool
+		status      int
+		canWrite    bool
+		expectErr   bool
+	}{
+		{"token with write access", map[string]bool{"fs:home-uid:write": true}, 200, true, false},
+		{"token without write access", map[string]bool{"fs:home-uid:write": false}, 200, false, false},
+		{"permission missing from the response", map[string]bool{}, 200, false, true},
+		{"check fails", nil, http.StatusInternalServerError, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/whoami":
+					json.NewEncoder(w).Encode(User{Username: "alice", UUID: "user-uid"})
+				case "/stat":
+					payload := map[string]string{}
+					json.NewDecoder(r.Body).Decode(&payload)
+					if payload["path"] != "/alice" {
+						w.WriteHeader(http.StatusNotFound)
+						return
+					}
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"uid": "home-uid", "name": "alice", "path": "/alice", "is_dir": true,
+					})
+				case "/auth/check-permissions":
+					if tt.status != 200 {
+						w.WriteHeader(tt.status)
+						return
+					}
+					json.NewEncoder(w).Encode(map[string]interface{}{
+						"permissions": tt.permissions,
+					})
+				default:
+					w.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer server.Close()
+
+			sdk := &PuterSDK{Url: server.URL, PuterAuthToken: "token"}
+			sdk.Init()
+
+			canWrite, err := sdk.CanWriteHome(context.Background())
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if canWrite != tt.canWrite {
+				t.Errorf("expected canWrite=%v, got %v", tt.canWrite, canWrite)
+			}
+		})
+	}
+}
@@ -24,16 +24,44 @@ import (
 	"net/url"
 	"path"
 	"time"
+
+	"github.com/HeyPuter/puter-fuse/debug"
 )
 
+var logger = debug.NewLogger("putersdk")
+
 type PuterSDK struct {
 	PuterAuthToken string
 	Client         *http.Client
 	Url            string
+
+	// Called when Puter rejects the token; returns a replacement, e.g.
+	// from stored credentials. Optional.
+	Reauthenticate func(rejected string) (string, error)
+
+	// Follows the progress of uploads and downloads. Optional.
+	Transfers TransferTracker
+
+	// Limits the bandwidth of requests. Optional.
+	Throttle *Throttle
+
+	// Limits how many requests are in flight; DefaultSchedulerLimits
+	// are used if it's nil.
+	Scheduler *Scheduler
+
+	auth authState
 }
 
 func (sdk *PuterSDK) Init() {
-	sdk.Client = &http.Client{}
+	debug.AddSecret(sdk.PuterAuthToken)
+	if sdk.Scheduler == nil {
+		sdk.Scheduler = CreateScheduler(DefaultSchedulerLimits)
+	}
+	var transport http.RoundTripper = sdk.Scheduler.pooledTransport()
+	if sdk.Throttle != nil {
+		transport = sdk.Throttle.Transport(transport)
+	}
+	sdk.Client = &http.Client{Transport: sdk.Scheduler.Transport(transport)}
 	if sdk.Url == "" {
 		sdk.Url = "https://api.puter.local"
 		// sdk.Url = "https://api.puter.com"
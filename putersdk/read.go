@@ -19,12 +19,14 @@
 package putersdk
 
 import (
+	"context"
 	"io"
 	"net/http"
 	"net/url"
 )
 
-func (sdk *PuterSDK) Read(path string) (data []byte, err error) {
+func (sdk *PuterSDK) Read(ctx context.Context, path string) (data []byte, err error) {
+	ctx = withDefaultClass(ctx, ClassRead)
 	u := sdk.GetEndpointURL("read")
 
 	params := url.Values{}
@@ -32,17 +34,26 @@ func (sdk *PuterSDK) Read(path string) (data []byte, err error) {
 
 	u.RawQuery = params.Encode()
 
-	req, err := http.NewRequest("GET", u.String(), nil)
-	req.Header.Set("Authorization", "Bearer "+sdk.PuterAuthToken)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return
+	}
 
-	resp, err := sdk.Client.Do(req)
+	resp, err := sdk.Do(req)
 	if err != nil {
 		return
 	}
 
 	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 {
+		err = &StatusError{StatusCode: resp.StatusCode}
+		return
+	}
+
+	reader := sdk.trackDownload(path, resp.ContentLength, resp.Body)
+	defer reader.Close()
+	body, err := io.ReadAll(reader)
 	if err != nil {
 		return
 	}
@@ -51,7 +62,8 @@ func (sdk *PuterSDK) Read(path string) (data []byte, err error) {
 	return
 }
 
-func (sdk *PuterSDK) ReadStream(path string) (reader io.ReadCloser, err error) {
+func (sdk *PuterSDK) ReadStream(ctx context.Context, path string) (reader io.ReadCloser, err error) {
+	ctx = withDefaultClass(ctx, ClassRead)
 	u := sdk.GetEndpointURL("read")
 
 	params := url.Values{}
@@ -59,17 +71,22 @@ func (sdk *PuterSDK) ReadStream(path string) (reader io.ReadCloser, err error) {
 
 	u.RawQuery = params.Encode()
 
-	req, err := http.NewRequest("GET", u.String(), nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
 		return
 	}
-	req.Header.Set("Authorization", "Bearer "+sdk.PuterAuthToken)
 
-	resp, err := sdk.Client.Do(req)
+	resp, err := sdk.Do(req)
 	if err != nil {
 		return
 	}
 
-	reader = resp.Body
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		err = &StatusError{StatusCode: resp.StatusCode}
+		return
+	}
+
+	reader = sdk.trackDownload(path, resp.ContentLength, resp.Body)
 	return
 }
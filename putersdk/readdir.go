@@ -20,18 +20,18 @@ package putersdk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 
 	"github.com/HeyPuter/puter-fuse/debug"
 )
 
-func (sdk *PuterSDK) Readdir(logger debug.ILogger, path string) (
+func (sdk *PuterSDK) Readdir(ctx context.Context, logger debug.ILogger, path string) (
 	items []CloudItem, err error,
 ) {
-	logger.Log("readdir(%s)", path)
+	logger.Debug("readdir(%s)", path)
 	payload := map[string]interface{}{}
 	payload["path"] = path
 	payload["no_thumbs"] = true
@@ -43,7 +43,8 @@ func (sdk *PuterSDK) Readdir(logger debug.ILogger, path string) (
 	if err != nil {
 		return
 	}
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
 		u.String(),
 		bytes.NewBuffer(jsonStr),
@@ -51,16 +52,15 @@ func (sdk *PuterSDK) Readdir(logger debug.ILogger, path string) (
 	if err != nil {
 		return
 	}
-	req.Header.Set("Authorization", "Bearer "+sdk.PuterAuthToken)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := sdk.Client.Do(req)
+	resp, err := sdk.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		err = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		err = &StatusError{StatusCode: resp.StatusCode}
 		return
 	}
 
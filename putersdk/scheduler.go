@@ -0,0 +1,280 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RequestClass is what a request is for. Each class has its own
+// concurrency limit, and when requests are queued the earlier classes
+// go first.
+type RequestClass int
+
+const (
+	// stat, readdir and the like, which a user is waiting on
+	ClassMetadata RequestClass = iota
+	// contents of files being read
+	ClassRead
+	// contents fetched before they're asked for
+	ClassPrefetch
+	// queued writes being sent
+	ClassWriteBack
+
+	numRequestClasses
+)
+
+func (c RequestClass) String() string {
+	switch c {
+	case ClassMetadata:
+		return "metadata"
+	case ClassRead:
+		return "read"
+	case ClassPrefetch:
+		return "prefetch"
+	case ClassWriteBack:
+		return "write-back"
+	}
+	return "unknown"
+}
+
+// Priority returns whether requests of the class are interactive, which
+// decides who goes first within bandwidth limits.
+func (c RequestClass) Priority() Priority {
+	if c == ClassPrefetch || c == ClassWriteBack {
+		return PriorityBackground
+	}
+	return PriorityInteractive
+}
+
+type classKey struct{}
+
+// WithClass returns a context whose requests are sent as 'class'.
+func WithClass(ctx context.Context, class RequestClass) context.Context {
+	return context.WithValue(ctx, classKey{}, class)
+}
+
+// Returns 'ctx' with 'class' unless it already has one.
+func withDefaultClass(ctx context.Context, class RequestClass) context.Context {
+	if _, exists := ctx.Value(classKey{}).(RequestClass); exists {
+		return ctx
+	}
+	return WithClass(ctx, class)
+}
+
+// Requests without a class are metadata.
+func classOf(ctx context.Context) RequestClass {
+	class, _ := ctx.Value(classKey{}).(RequestClass)
+	return class
+}
+
+// SchedulerLimits are how many requests may be in flight at once.
+type SchedulerLimits struct {
+	// indexed by RequestClass
+	Classes [numRequestClasses]int
+	// across every class; also the number of connections kept alive
+	Total int
+}
+
+var DefaultSchedulerLimits = SchedulerLimits{
+	Classes: [numRequestClasses]int{
+		ClassMetadata:  8,
+		ClassRead:      6,
+		ClassPrefetch:  2,
+		ClassWriteBack: 4,
+	},
+	Total: 12,
+}
+
+// Scheduler limits how many requests of each class are in flight. A
+// request holds its place until its response body has been read to the
+// end, failed or been closed, so a streamed download counts for as long
+// as it's being read.
+type Scheduler struct {
+	limits SchedulerLimits
+
+	lock    sync.Mutex
+	running [numRequestClasses]int
+	total   int
+	waiting [numRequestClasses][]chan struct{}
+}
+
+func CreateScheduler(limits SchedulerLimits) *Scheduler {
+	for class := range limits.Classes {
+		limits.Classes[class] = max(limits.Classes[class], 1)
+	}
+	limits.Total = max(limits.Total, 1)
+	return &Scheduler{limits: limits}
+}
+
+// Returns a transport whose connection pool suits the limits: enough
+// idle connections are kept alive that requests up to the limit don't
+// open new ones.
+func (s *Scheduler) pooledTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          s.limits.Total * 2,
+		MaxIdleConnsPerHost:   s.limits.Total,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// Must be called with the lock held.
+func (s *Scheduler) canRun(class RequestClass) bool {
+	return s.running[class] < s.limits.Classes[class] && s.total < s.limits.Total
+}
+
+// Must be called with the lock held. Starts waiting requests which can
+// now run, earlier classes first.
+func (s *Scheduler) dispatch() {
+	for class := RequestClass(0); class < numRequestClasses; class++ {
+		for len(s.waiting[class]) > 0 && s.canRun(class) {
+			ready := s.waiting[class][0]
+			s.waiting[class] = s.waiting[class][1:]
+			s.running[class]++
+			s.total++
+			close(ready)
+		}
+	}
+}
+
+// Waits for a place for a request of 'class'.
+func (s *Scheduler) acquire(ctx context.Context, class RequestClass) error {
+	ready := make(chan struct{})
+	s.lock.Lock()
+	s.waiting[class] = append(s.waiting[class], ready)
+	s.dispatch()
+	s.lock.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	default:
+	}
+	queueStart := time.Now()
+	defer schedulerWait.With(class.String()).ObserveSince(queueStart)
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for i, waiting := range s.waiting[class] {
+		if waiting == ready {
+			s.waiting[class] = append(s.waiting[class][:i], s.waiting[class][i+1:]...)
+			return ctx.Err()
+		}
+	}
+	// started just as the context ended; the place is given up
+	s.running[class]--
+	s.total--
+	s.dispatch()
+	return ctx.Err()
+}
+
+func (s *Scheduler) release(class RequestClass) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.running[class]--
+	s.total--
+	s.dispatch()
+}
+
+// SchedulerStats are the requests in flight and queued, by class.
+type SchedulerStats struct {
+	Running map[string]int `json:"running"`
+	Queued  map[string]int `json:"queued"`
+}
+
+func (s *Scheduler) Stats() SchedulerStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	stats := SchedulerStats{Running: map[string]int{}, Queued: map[string]int{}}
+	for class := RequestClass(0); class < numRequestClasses; class++ {
+		stats.Running[class.String()] = s.running[class]
+		stats.Queued[class.String()] = len(s.waiting[class])
+	}
+	return stats
+}
+
+// Transport returns 'base' with requests scheduled by their class.
+func (s *Scheduler) Transport(base http.RoundTripper) http.RoundTripper {
+	return &scheduledTransport{base: base, scheduler: s}
+}
+
+type scheduledTransport struct {
+	base      http.RoundTripper
+	scheduler *Scheduler
+}
+
+func (t *scheduledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	class := classOf(req.Context())
+	if err := t.scheduler.acquire(req.Context(), class); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.scheduler.release(class)
+		return nil, err
+	}
+	resp.Body = &releasingBody{ReadCloser: resp.Body, release: func() { t.scheduler.release(class) }}
+	return resp, nil
+}
+
+// releasingBody gives back its request's place at EOF, on a read error
+// or when it's closed, whichever comes first, so a caller who holds on
+// to a finished body doesn't hold up other requests.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (r *releasingBody) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err != nil {
+		r.once.Do(r.release)
+	}
+	return n, err
+}
+
+func (r *releasingBody) Close() error {
+	err := r.ReadCloser.Close()
+	r.once.Do(r.release)
+	return err
+}
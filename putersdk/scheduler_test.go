@@ -0,0 +1,184 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A server which holds each request until 'release' is sent to, and
+// reports the order requests arrived in.
+func createBlockingServer() (*httptest.Server, chan struct{}, chan string) {
+	release := make(chan struct{})
+	arrived := make(chan string, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- r.URL.Query().Get("name")
+		<-release
+	}))
+	return server, release, arrived
+}
+
+func scheduledGet(client *http.Client, ctx context.Context, url string) error {
+	req, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+func TestScheduler(t *testing.T) {
+	t.Run("classes are limited", func(t *testing.T) {
+		server, release, arrived := createBlockingServer()
+		defer server.Close()
+
+		limits := DefaultSchedulerLimits
+		limits.Classes[ClassWriteBack] = 2
+		scheduler := CreateScheduler(limits)
+		client := &http.Client{Transport: scheduler.Transport(http.DefaultTransport)}
+
+		ctx := WithClass(context.Background(), ClassWriteBack)
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				scheduledGet(client, ctx, server.URL)
+			}()
+		}
+
+		<-arrived
+		<-arrived
+		select {
+		case <-arrived:
+			t.Fatalf("expected only 2 requests in flight")
+		case <-time.After(50 * time.Millisecond):
+		}
+		if queued := scheduler.Stats().Queued["write-back"]; queued != 3 {
+			t.Errorf("expected 3 queued requests, got %d", queued)
+		}
+
+		// metadata requests aren't held up by write-back
+		go scheduledGet(client, context.Background(), server.URL)
+		select {
+		case <-arrived:
+		case <-time.After(time.Second):
+			t.Errorf("expected the metadata request to be sent")
+		}
+
+		close(release)
+		wg.Wait()
+	})
+
+	t.Run("earlier classes go first", func(t *testing.T) {
+		server, release, arrived := createBlockingServer()
+		defer server.Close()
+
+		limits := DefaultSchedulerLimits
+		limits.Total = 1
+		scheduler := CreateScheduler(limits)
+		client := &http.Client{Transport: scheduler.Transport(http.DefaultTransport)}
+
+		var wg sync.WaitGroup
+		send := func(class RequestClass, name string) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				scheduledGet(client, WithClass(context.Background(), class), server.URL+"?name="+name)
+			}()
+		}
+
+		send(ClassWriteBack, "first")
+		<-arrived
+		send(ClassWriteBack, "write-back")
+		time.Sleep(20 * time.Millisecond)
+		send(ClassMetadata, "metadata")
+		time.Sleep(20 * time.Millisecond)
+
+		release <- struct{}{}
+		if next := <-arrived; next != "metadata" {
+			t.Errorf("expected the metadata request next, got %s", next)
+		}
+		close(release)
+		wg.Wait()
+	})
+
+	t.Run("queued requests end with their context", func(t *testing.T) {
+		server, release, arrived := createBlockingServer()
+		defer server.Close()
+
+		limits := DefaultSchedulerLimits
+		limits.Total = 1
+		scheduler := CreateScheduler(limits)
+		client := &http.Client{Transport: scheduler.Transport(http.DefaultTransport)}
+
+		go scheduledGet(client, context.Background(), server.URL)
+		<-arrived
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := scheduledGet(client, ctx, server.URL); err == nil {
+			t.Errorf("expected the queued request to fail")
+		}
+
+		close(release)
+		// the place held by the first request is given back
+		if err := scheduledGet(client, context.Background(), server.URL); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		stats := scheduler.Stats()
+		if stats.Running["metadata"] != 0 || stats.Queued["metadata"] != 0 {
+			t.Errorf("expected nothing running or queued, got %+v", stats)
+		}
+	})
+	t.Run("a body read to the end gives back its place", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("contents"))
+		}))
+		defer server.Close()
+
+		limits := DefaultSchedulerLimits
+		limits.Classes[ClassRead] = 1
+		scheduler := CreateScheduler(limits)
+		client := &http.Client{Transport: scheduler.Transport(http.DefaultTransport)}
+		ctx := WithClass(context.Background(), ClassRead)
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// read, but not closed until the test ends
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+
+		timeout, cancel := context.WithTimeout(ctx, time.Second)
+		defer cancel()
+		if err := scheduledGet(client, timeout, server.URL); err != nil {
+			t.Errorf("expected the place to be free, got %v", err)
+		}
+	})
+}
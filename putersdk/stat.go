@@ -20,8 +20,8 @@ package putersdk
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 
@@ -33,8 +33,8 @@ func isValidUUID(u string) bool {
 	return err == nil
 }
 
-func (sdk *PuterSDK) Stat(path string) (cloudItem CloudItem, err error) {
-	fmt.Printf("stat(%s)\n", path)
+func (sdk *PuterSDK) Stat(ctx context.Context, path string) (cloudItem CloudItem, err error) {
+	logger.Debug("stat(%s)", path)
 
 	isUUID := isValidUUID(path)
 
@@ -51,7 +51,8 @@ func (sdk *PuterSDK) Stat(path string) (cloudItem CloudItem, err error) {
 	if err != nil {
 		return
 	}
-	req, err := http.NewRequest(
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"POST",
 		u.String(),
 		bytes.NewBuffer(jsonStr),
@@ -59,16 +60,15 @@ func (sdk *PuterSDK) Stat(path string) (cloudItem CloudItem, err error) {
 	if err != nil {
 		return
 	}
-	req.Header.Set("Authorization", "Bearer "+sdk.PuterAuthToken)
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := sdk.Client.Do(req)
+	resp, err := sdk.Do(req)
 	if err != nil {
 		return
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != 200 {
-		err = fmt.Errorf("unexpected status: %d", resp.StatusCode)
+		err = &StatusError{StatusCode: resp.StatusCode}
 		return
 	}
 
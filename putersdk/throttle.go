@@ -0,0 +1,249 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Priority decides which requests go first when bandwidth is limited;
+// it follows from a request's class.
+type Priority int
+
+const (
+	// a user is waiting on it
+	PriorityInteractive Priority = iota
+	// e.g. write-back uploads; waits while interactive requests do
+	PriorityBackground
+)
+
+func priorityOf(ctx context.Context) Priority {
+	return classOf(ctx).Priority()
+}
+
+// BandwidthLimits are in bytes per second; 0 is unlimited.
+type BandwidthLimits struct {
+	Upload   int64
+	Download int64
+}
+
+// BandwidthWindow replaces the limits during part of each day.
+type BandwidthWindow struct {
+	// times of day, as offsets from midnight; a window which ends before
+	// it starts runs past midnight
+	From, To time.Duration
+	BandwidthLimits
+}
+
+func (w BandwidthWindow) contains(timeOfDay time.Duration) bool {
+	if w.From <= w.To {
+		return timeOfDay >= w.From && timeOfDay < w.To
+	}
+	return timeOfDay >= w.From || timeOfDay < w.To
+}
+
+// Throttle limits the bandwidth of requests made through its transport.
+// It can be shared by several SDKs, which then share the limits.
+type Throttle struct {
+	Limits BandwidthLimits
+	// the first window containing the time of day applies
+	Schedule []BandwidthWindow
+
+	now      func() time.Time
+	upload   tokenBucket
+	download tokenBucket
+}
+
+func CreateThrottle(limits BandwidthLimits, schedule []BandwidthWindow) *Throttle {
+	t := &Throttle{
+		Limits:   limits,
+		Schedule: schedule,
+		now:      time.Now,
+	}
+	t.upload.limit = func() int64 { return t.LimitsAt(t.now()).Upload }
+	t.download.limit = func() int64 { return t.LimitsAt(t.now()).Download }
+	t.upload.now = func() time.Time { return t.now() }
+	t.download.now = t.upload.now
+	return t
+}
+
+// Returns the limits which apply at 'now'.
+func (t *Throttle) LimitsAt(now time.Time) BandwidthLimits {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	timeOfDay := now.Sub(midnight)
+	for _, window := range t.Schedule {
+		if window.contains(timeOfDay) {
+			return window.BandwidthLimits
+		}
+	}
+	return t.Limits
+}
+
+// Transport returns 'base' with request bodies limited to the upload
+// rate and response bodies to the download rate.
+func (t *Throttle) Transport(base http.RoundTripper) http.RoundTripper {
+	return &throttledTransport{base: base, throttle: t}
+}
+
+// the most read at once while throttled, so one read doesn't use the
+// bandwidth for long
+const throttleChunkSize = 16 * 1024
+
+// tokenBucket holds up to a second's worth of bytes at the current
+// limit. Reads take what they read from it, going into debt if need be,
+// and wait until it's paid off before reading again.
+type tokenBucket struct {
+	limit func() int64
+	now   func() time.Time
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+	// interactive reads waiting for tokens, which background reads
+	// give way to
+	interactive int
+	wake        chan struct{}
+}
+
+// Must be called with the lock held. Returns the current limit.
+func (b *tokenBucket) refill() int64 {
+	now := b.now()
+	limit := b.limit()
+	if limit > 0 && !b.last.IsZero() {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*float64(limit), float64(limit))
+	}
+	b.last = now
+	return limit
+}
+
+// Must be called with the lock held.
+func (b *tokenBucket) wakeChan() chan struct{} {
+	if b.wake == nil {
+		b.wake = make(chan struct{})
+	}
+	return b.wake
+}
+
+// Must be called with the lock held.
+func (b *tokenBucket) broadcast() {
+	if b.wake != nil {
+		close(b.wake)
+		b.wake = nil
+	}
+}
+
+// Waits until 'n' bytes may be transferred, and takes them.
+func (b *tokenBucket) take(ctx context.Context, n int, priority Priority) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for {
+		limit := b.refill()
+		if limit <= 0 {
+			return nil
+		}
+
+		var delay time.Duration
+		waitingInteractive := false
+		switch {
+		case priority == PriorityBackground && b.interactive > 0:
+			// until the interactive reads have taken what they need;
+			// checked again in case the limit changes
+			delay = time.Second
+		case b.tokens >= 0:
+			b.tokens -= float64(n)
+			return nil
+		default:
+			delay = time.Duration(-b.tokens / float64(limit) * float64(time.Second))
+			waitingInteractive = priority == PriorityInteractive
+		}
+
+		if waitingInteractive {
+			b.interactive++
+		}
+		wake := b.wakeChan()
+		b.lock.Unlock()
+
+		timer := time.NewTimer(delay)
+		var err error
+		select {
+		case <-timer.C:
+		case <-wake:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		timer.Stop()
+
+		b.lock.Lock()
+		if waitingInteractive {
+			b.interactive--
+			if b.interactive == 0 {
+				b.broadcast()
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+type throttledReader struct {
+	io.ReadCloser
+	ctx      context.Context
+	bucket   *tokenBucket
+	priority Priority
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if r.bucket.limit() > 0 && len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		if waitErr := r.bucket.take(r.ctx, n, r.priority); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+type throttledTransport struct {
+	base     http.RoundTripper
+	throttle *Throttle
+}
+
+func (t *throttledTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	priority := priorityOf(ctx)
+	if req.Body != nil && req.Body != http.NoBody {
+		// the request passed in mustn't be changed
+		req = req.Clone(ctx)
+		req.Body = &throttledReader{req.Body, ctx, &t.throttle.upload, priority}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &throttledReader{resp.Body, ctx, &t.throttle.download, priority}
+	return resp, nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestThrottleLimitsAt(t *testing.T) {
+	throttle := CreateThrottle(BandwidthLimits{Upload: 100, Download: 200}, []BandwidthWindow{
+		{From: 23 * time.Hour, To: 7 * time.Hour},
+		{From: 12 * time.Hour, To: 13 * time.Hour, BandwidthLimits: BandwidthLimits{Upload: 10}},
+	})
+
+	testCases := []struct {
+		name   string
+		time   string
+		limits BandwidthLimits
+	}{
+		{"default", "09:30", BandwidthLimits{Upload: 100, Download: 200}},
+		{"overnight before midnight", "23:15", BandwidthLimits{}},
+		{"overnight after midnight", "03:00", BandwidthLimits{}},
+		{"end is exclusive", "07:00", BandwidthLimits{Upload: 100, Download: 200}},
+		{"daytime window", "12:59", BandwidthLimits{Upload: 10}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			now, _ := time.ParseInLocation("2006-01-02 15:04", "2024-03-01 "+tc.time, time.Local)
+			if limits := throttle.LimitsAt(now); limits != tc.limits {
+				t.Errorf("expected %+v, got %+v", tc.limits, limits)
+			}
+		})
+	}
+}
+
+func TestThrottleTransport(t *testing.T) {
+	const size = 64 * 1024
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Write(make([]byte, size))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name    string
+		limits  BandwidthLimits
+		minimum time.Duration
+	}{
+		{"unlimited", BandwidthLimits{}, 0},
+		// the first read goes straight through; the rest wait
+		{"upload", BandwidthLimits{Upload: 256 * 1024}, 150 * time.Millisecond},
+		{"download", BandwidthLimits{Download: 256 * 1024}, 150 * time.Millisecond},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{Transport: CreateThrottle(tc.limits, nil).Transport(http.DefaultTransport)}
+			start := time.Now()
+			resp, err := client.Post(server.URL, "application/octet-stream", bytes.NewReader(make([]byte, size)))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			elapsed := time.Since(start)
+
+			if len(body) != size {
+				t.Errorf("expected %d bytes, got %d", size, len(body))
+			}
+			if elapsed < tc.minimum || elapsed > tc.minimum+2*time.Second {
+				t.Errorf("expected it to take about %s, took %s", tc.minimum, elapsed)
+			}
+		})
+	}
+}
+
+func TestThrottlePriority(t *testing.T) {
+	throttle := CreateThrottle(BandwidthLimits{Upload: 100 * 1000}, nil)
+	bucket := &throttle.upload
+	ctx := context.Background()
+
+	// 200ms of debt
+	bucket.take(ctx, 20*1000, PriorityBackground)
+
+	done := make(chan Priority, 2)
+	go func() {
+		bucket.take(ctx, 10*1000, PriorityInteractive)
+		done <- PriorityInteractive
+	}()
+	time.Sleep(20 * time.Millisecond)
+	go func() {
+		bucket.take(ctx, 10*1000, PriorityBackground)
+		done <- PriorityBackground
+	}()
+
+	if first := <-done; first != PriorityInteractive {
+		t.Errorf("expected the interactive read to go first")
+	}
+	<-done
+
+	t.Run("waits end with the context", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		bucket.take(ctx, 1000*1000, PriorityInteractive)
+		if err := bucket.take(ctx, 1, PriorityInteractive); err == nil {
+			t.Errorf("expected the wait to be cut short")
+		}
+	})
+}
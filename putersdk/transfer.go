@@ -0,0 +1,109 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"io"
+	"sync"
+)
+
+type TransferDirection string
+
+const (
+	TransferUpload   TransferDirection = "upload"
+	TransferDownload TransferDirection = "download"
+)
+
+// TransferTracker follows the progress of uploads and downloads as their
+// bodies are read, and can hold or stop them by blocking or failing
+// those reads.
+type TransferTracker interface {
+	// Start begins tracking a transfer of 'size' bytes, or -1 if the
+	// size isn't known.
+	Start(direction TransferDirection, path string, size int64) Transfer
+}
+
+type Transfer interface {
+	// Reader returns 'body' counting what's read from it. An upload's
+	// body is read again from the start if its request is retried.
+	Reader(body io.ReadCloser) io.ReadCloser
+	// Finish stops tracking the transfer; 'err' is why it failed.
+	Finish(err error)
+}
+
+type nopTransfer struct{}
+
+func (nopTransfer) Reader(body io.ReadCloser) io.ReadCloser { return body }
+func (nopTransfer) Finish(err error)                        {}
+
+func (sdk *PuterSDK) startTransfer(direction TransferDirection, path string, size int64) Transfer {
+	if sdk.Transfers == nil {
+		return nopTransfer{}
+	}
+	return sdk.Transfers.Start(direction, path, size)
+}
+
+// Returns 'upload' with its contents read through 'transfer'.
+func trackUpload(upload Upload, transfer Transfer) Upload {
+	open := upload.Open
+	upload.Open = func() (io.ReadCloser, error) {
+		body, err := open()
+		if err != nil {
+			return nil, err
+		}
+		return transfer.Reader(body), nil
+	}
+	return upload
+}
+
+// finishOnClose finishes a download once its body is read to the end,
+// fails, or is closed.
+type finishOnClose struct {
+	io.ReadCloser
+	transfer Transfer
+	once     sync.Once
+}
+
+func (r *finishOnClose) finish(err error) {
+	r.once.Do(func() { r.transfer.Finish(err) })
+}
+
+func (r *finishOnClose) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if err == io.EOF {
+		r.finish(nil)
+	} else if err != nil {
+		r.finish(err)
+	}
+	return n, err
+}
+
+func (r *finishOnClose) Close() error {
+	r.finish(nil)
+	return r.ReadCloser.Close()
+}
+
+// Returns the body of a download from 'path', tracked as a transfer.
+func (sdk *PuterSDK) trackDownload(path string, size int64, body io.ReadCloser) io.ReadCloser {
+	if sdk.Transfers == nil {
+		return body
+	}
+	transfer := sdk.Transfers.Start(TransferDownload, path, size)
+	return &finishOnClose{ReadCloser: transfer.Reader(body), transfer: transfer}
+}
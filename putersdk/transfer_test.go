@@ -0,0 +1,139 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package putersdk
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+type testTransfer struct {
+	tracker   *testTracker
+	direction TransferDirection
+	path      string
+	size      int64
+	read      int64
+	finished  bool
+}
+
+type testTracker struct {
+	lock      sync.Mutex
+	transfers []*testTransfer
+}
+
+func (tr *testTracker) Start(direction TransferDirection, path string, size int64) Transfer {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	transfer := &testTransfer{tracker: tr, direction: direction, path: path, size: size}
+	tr.transfers = append(tr.transfers, transfer)
+	return transfer
+}
+
+func (t *testTransfer) Reader(body io.ReadCloser) io.ReadCloser {
+	return &testTransferReader{body, t}
+}
+
+func (t *testTransfer) Finish(err error) {
+	t.tracker.lock.Lock()
+	defer t.tracker.lock.Unlock()
+	t.finished = true
+}
+
+type testTransferReader struct {
+	io.ReadCloser
+	transfer *testTransfer
+}
+
+func (r *testTransferReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.transfer.tracker.lock.Lock()
+	r.transfer.read += int64(n)
+	r.transfer.tracker.lock.Unlock()
+	return n, err
+}
+
+func TestTransfers(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/read" {
+			w.Write([]byte("contents"))
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		w.Write([]byte(`{"results": [{}]}`))
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name      string
+		call      func(sdk *PuterSDK) error
+		direction TransferDirection
+		path      string
+		size      int64
+	}{
+		{"write", func(sdk *PuterSDK) error {
+			_, err := sdk.Write(context.Background(), "/dir/file", []byte("data"))
+			return err
+		}, TransferUpload, "/dir/file", 4},
+		{"batch", func(sdk *PuterSDK) error {
+			upload := BytesUpload([]byte("data"))
+			upload.Path = "/dir/file"
+			_, err := sdk.BatchUploads(context.Background(), []Operation{{"op": "write"}}, []Upload{upload})
+			return err
+		}, TransferUpload, "/dir/file", 4},
+		{"read", func(sdk *PuterSDK) error {
+			_, err := sdk.Read(context.Background(), "/dir/file")
+			return err
+		}, TransferDownload, "/dir/file", 8},
+		{"read stream", func(sdk *PuterSDK) error {
+			reader, err := sdk.ReadStream(context.Background(), "/dir/file")
+			if err != nil {
+				return err
+			}
+			io.ReadAll(reader)
+			return nil
+		}, TransferDownload, "/dir/file", 8},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tracker := &testTracker{}
+			sdk := &PuterSDK{Url: server.URL, Transfers: tracker}
+			sdk.Init()
+
+			if err := tc.call(sdk); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(tracker.transfers) != 1 {
+				t.Fatalf("expected 1 transfer, got %d", len(tracker.transfers))
+			}
+			transfer := tracker.transfers[0]
+			if transfer.direction != tc.direction || transfer.path != tc.path || transfer.size != tc.size {
+				t.Errorf("unexpected transfer: %s %s %d", transfer.direction, transfer.path, transfer.size)
+			}
+			if transfer.read != tc.size || !transfer.finished {
+				t.Errorf("expected %d bytes read and the transfer finished, got %d and %v",
+					tc.size, transfer.read, transfer.finished)
+			}
+		})
+	}
+}
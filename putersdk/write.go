@@ -19,28 +19,30 @@
 package putersdk
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"mime/multipart"
-	"net/http"
 	"path/filepath"
-	"strings"
 )
 
-func (sdk *PuterSDK) Write(path string, data []byte) (*CloudItem, error) {
-	cloudItem, err := sdk.write(path, data, "")
+func (sdk *PuterSDK) Write(ctx context.Context, path string, data []byte) (*CloudItem, error) {
+	return sdk.WriteUpload(ctx, path, BytesUpload(data))
+}
+
+// WriteUpload writes a file whose contents are streamed from 'upload'.
+func (sdk *PuterSDK) WriteUpload(ctx context.Context, path string, upload Upload) (*CloudItem, error) {
+	cloudItem, err := sdk.write(ctx, path, upload, "")
 	if err != nil {
-		fmt.Printf("error: %s\n", err)
+		logger.With("path", path, "error", err).Error("write failed")
 	}
 	return cloudItem, err
 }
 
-func (sdk *PuterSDK) Symlink(path, target string) (*CloudItem, error) {
+func (sdk *PuterSDK) Symlink(ctx context.Context, path, target string) (*CloudItem, error) {
 	parent := filepath.Dir(path)
 	name := filepath.Base(path)
-	batchResponse, err := sdk.Batch([]Operation{
+	batchResponse, err := sdk.Batch(ctx, []Operation{
 		{
 			"op":     "symlink",
 			"path":   parent,
@@ -67,48 +69,29 @@ func (sdk *PuterSDK) Symlink(path, target string) (*CloudItem, error) {
 	return cloudItem, nil
 }
 
-func (sdk *PuterSDK) write(path string, data []byte, target string) (*CloudItem, error) {
-	fmt.Printf("write(%s)\n", path)
+func (sdk *PuterSDK) write(ctx context.Context, path string, upload Upload, target string) (_ *CloudItem, err error) {
+	logger.Debug("write(%s)", path)
+	transfer := sdk.startTransfer(TransferUpload, path, upload.Size)
+	defer func() { transfer.Finish(err) }()
+	upload = trackUpload(upload, transfer)
+
 	filename := filepath.Base(path)
 	path = filepath.Dir(path)
-	body := &bytes.Buffer{}
-	writer := multipart.NewWriter(body)
-	{
-		fw, _ := writer.CreateFormField("path")
-		io.Copy(fw, strings.NewReader(path))
-	}
-	{
-		fw, _ := writer.CreateFormField("overwrite")
-		io.Copy(fw, strings.NewReader("true"))
-	}
-	{
-		fw, _ := writer.CreateFormField("size")
-		io.Copy(fw, strings.NewReader(fmt.Sprintf("%d", len(data))))
-	}
+	body := newMultipartBody()
+	body.AddField("path", path)
+	body.AddField("overwrite", "true")
+	body.AddField("size", fmt.Sprintf("%d", upload.Size))
 	if target != "" {
-		fw, _ := writer.CreateFormField("symlink_path")
-		io.Copy(fw, strings.NewReader(target))
+		body.AddField("symlink_path", target)
 	}
-	{
-		fw, _ := writer.CreateFormFile("file", filename)
-		fw.Write(data)
-	}
-	writer.Close()
-
-	// fmt.Printf("Did it work? [%s]\n", body.String())
-
-	u := sdk.GetEndpointURL("write")
+	body.AddFile("file", filename, upload)
 
-	req, err := http.NewRequest("POST", u.String(), body)
+	req, err := sdk.newMultipartRequest(ctx, "write", body)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+sdk.PuterAuthToken)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
 
-	fmt.Printf("Content-Type: %s\n", writer.FormDataContentType())
-
-	resp, err := sdk.Client.Do(req)
+	resp, err := sdk.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -116,19 +99,12 @@ func (sdk *PuterSDK) write(path string, data []byte, target string) (*CloudItem,
 
 	if resp.StatusCode != 200 {
 		respBytes, _ := io.ReadAll(resp.Body)
-		err = fmt.Errorf(
-			"unexpected status: %d"+
-				"\nbody: |%s|",
-			resp.StatusCode,
-			string(respBytes),
-		)
-		return nil, err
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(respBytes),
+		}
 	}
 
-	bgetbody := new(strings.Builder)
-	rgetbody, _ := req.GetBody()
-	io.Copy(bgetbody, rgetbody)
-
 	respBytes, _ := io.ReadAll(resp.Body)
 
 	cloudItem := &CloudItem{}
@@ -137,10 +113,10 @@ func (sdk *PuterSDK) write(path string, data []byte, target string) (*CloudItem,
 		return nil, err
 	}
 
-	fmt.Printf("status? [%d] when sending [%s]\n",
-		resp.StatusCode,
-		bgetbody.String(),
-	)
+	logger.With(
+		"status", resp.StatusCode,
+		"requestBytes", req.ContentLength,
+	).Debug("wrote %s", cloudItem.Path)
 
 	return cloudItem, nil
 }
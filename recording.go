@@ -0,0 +1,152 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/HeyPuter/puter-fuse/debug"
+	"github.com/HeyPuter/puter-fuse/engine"
+	faopkg "github.com/HeyPuter/puter-fuse/fao"
+	"github.com/HeyPuter/puter-fuse/faoimpls"
+	"github.com/spf13/afero"
+	"github.com/spf13/viper"
+)
+
+var replayOpts struct {
+	against           string
+	timing            bool
+	experimentalCache bool
+}
+
+// gzipFile closes the gzip stream before the file under it.
+type gzipFile struct {
+	*gzip.Writer
+	file *os.File
+}
+
+func (f gzipFile) Close() error {
+	if err := f.Writer.Close(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// Opens recordFile for RecordFAO, if it's set. Recordings ending in .gz
+// are compressed. File contents are only recorded with recordContents;
+// otherwise their size and hash stand in for them.
+func openRecording() (*faoimpls.FAORecorder, error) {
+	path := viper.GetString("recordFile")
+	if path == "" {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating recording directory: %s", err)
+	}
+	// recordings hold file paths, and maybe contents, so they're kept
+	// private
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recording: %s", err)
+	}
+	params := faoimpls.P_FAORecorder{
+		Contents: viper.GetBool("recordContents"),
+	}
+	if params.Contents {
+		mountLog.Warn("recording filesystem calls, including file contents, to %s", path)
+	} else {
+		mountLog.Warn("recording filesystem calls to %s", path)
+	}
+
+	if strings.HasSuffix(path, ".gz") {
+		return faoimpls.CreateFAORecorder(gzipFile{gzip.NewWriter(file), file}, params), nil
+	}
+	return faoimpls.CreateFAORecorder(file, params), nil
+}
+
+// Replays the calls FUSE made in a recording against a fresh FAO stack
+// and prints each call whose result differs.
+func replay(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	records, err := faoimpls.ReadFAORecording(file)
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("error reading recording: %s", err)
+	}
+
+	var backend faopkg.FAO
+	switch replayOpts.against {
+	case "recorded":
+		backend = faoimpls.CreateReplayFAO(records, "backend", faoimpls.P_ReplayFAO{
+			Timing: replayOpts.timing,
+		})
+	case "mem":
+		backend = faoimpls.CreateMemFAO()
+	default:
+		return &exitError{
+			code: exitUsage,
+			err:  fmt.Errorf("unknown backend %q; expected recorded or mem", replayOpts.against),
+		}
+	}
+
+	cacheDir, err := os.MkdirTemp("", "puter-fuse-replay-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(cacheDir)
+
+	cfg := viper.New()
+	setDefaults(cfg)
+	cfg.Set("cacheDir", cacheDir)
+	cfg.Set("experimental_cache", replayOpts.experimentalCache)
+
+	svcc := createServices(cfg, nil, debug.NewLogger("replay"),
+		engine.CreateBLOBCacheService(afero.NewOsFs()))
+	noop := func(fao faopkg.FAO, layer string) faopkg.FAO { return fao }
+	fao := stackCacheLayers(backend, cfg, svcc, noop)
+
+	calls := 0
+	for _, record := range records {
+		if record.Layer == "top" {
+			calls++
+		}
+	}
+
+	mismatches := faoimpls.ReplayFAORecording(
+		context.Background(), records, "top", fao, replayOpts.timing)
+	for _, mismatch := range mismatches {
+		fmt.Println(mismatch)
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%d of %d calls differ from the recording", len(mismatches), calls)
+	}
+	fmt.Printf("all %d calls match the recording\n", calls)
+	return nil
+}
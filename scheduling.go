@@ -0,0 +1,66 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+
+	"github.com/HeyPuter/puter-fuse/putersdk"
+	"github.com/spf13/viper"
+)
+
+// requestConcurrency in the configuration; see the README
+type requestConcurrencyConfig struct {
+	Metadata  int
+	Read      int
+	Prefetch  int
+	WriteBack int
+	Total     int
+}
+
+// Returns the scheduler limits from requestConcurrency; anything not set
+// keeps its default.
+func schedulerLimits(cfg *viper.Viper) (putersdk.SchedulerLimits, error) {
+	limits := putersdk.DefaultSchedulerLimits
+	conf := requestConcurrencyConfig{}
+	if err := cfg.UnmarshalKey("requestConcurrency", &conf); err != nil {
+		return limits, fmt.Errorf("invalid requestConcurrency: %s", err)
+	}
+
+	values := []struct {
+		name  string
+		value int
+		limit *int
+	}{
+		{"metadata", conf.Metadata, &limits.Classes[putersdk.ClassMetadata]},
+		{"read", conf.Read, &limits.Classes[putersdk.ClassRead]},
+		{"prefetch", conf.Prefetch, &limits.Classes[putersdk.ClassPrefetch]},
+		{"writeBack", conf.WriteBack, &limits.Classes[putersdk.ClassWriteBack]},
+		{"total", conf.Total, &limits.Total},
+	}
+	for _, v := range values {
+		if v.value < 0 {
+			return limits, fmt.Errorf("invalid requestConcurrency.%s: must be at least 1", v.name)
+		}
+		if v.value > 0 {
+			*v.limit = v.value
+		}
+	}
+	return limits, nil
+}
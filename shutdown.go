@@ -0,0 +1,78 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/engine"
+	"github.com/HeyPuter/puter-fuse/putersdk"
+	"github.com/HeyPuter/puter-fuse/services"
+)
+
+// Waits for the write cache and the batch queue to empty, up to
+// 'timeout', then reports anything that couldn't be sent to Puter.
+func drainPendingWrites(svcc services.IServiceContainer, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	svc_writeCache := svcc.Get("write-cache").(*engine.WriteCacheService)
+	svc_op := svcc.Get("operation").(*engine.OperationService)
+	svc_association := svcc.Get("association").(*engine.AssociationService)
+
+	log := mountLog.S("shutdown")
+	log.Log("flushing pending writes (timeout: %s)", timeout)
+
+	// The write cache is drained first since it feeds the batch queue.
+	files := svc_writeCache.Drain(ctx)
+	requests := svc_op.Drain(ctx)
+
+	if len(files) == 0 && len(requests) == 0 {
+		log.Log("all pending writes were flushed")
+		return
+	}
+
+	log.Error("could not flush all pending writes")
+
+	if len(files) > 0 {
+		pending := map[string]bool{}
+		for _, localUID := range files {
+			pending[localUID] = true
+		}
+		paths := []string{}
+		for _, path := range svc_association.PathToLocalUID.Keys() {
+			localUID, _ := svc_association.PathToLocalUID.Get(path)
+			if pending[localUID] {
+				paths = append(paths, path)
+			}
+		}
+		log.With("paths", paths).Error(
+			"%d file(s) have writes which were not acknowledged", len(files))
+	}
+
+	if len(requests) > 0 {
+		operations := []putersdk.Operation{}
+		for _, req := range requests {
+			operations = append(operations, req.Operation)
+		}
+		log.With("operations", operations).Error(
+			"%d operation(s) were not sent", len(requests))
+	}
+}
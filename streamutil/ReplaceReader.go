@@ -21,8 +21,13 @@ package streamutil
 import (
 	"fmt"
 	"io"
+
+	"github.com/HeyPuter/puter-fuse/debug"
 )
 
+// only used when a ReplaceReader is verbose
+var logger = debug.NewLogger("streamutil").S("replace-reader")
+
 type ReplaceReader struct {
 	source          io.ReadCloser // The original reader
 	replacement     []byte        // The replacement to be inserted
@@ -58,9 +63,8 @@ func (replaceReader *ReplaceReader) Read(p []byte) (int, error) {
 
 		replaceReader.debug("iter")
 		if replaceReader.verbose {
-			fmt.Printf("amountWrittenSoFar: %d\n", amountWrittenSoFar)
-			fmt.Printf("offset: %d\n", replaceReader.offset)
-			fmt.Printf("replaceEnd: %d\n", replaceEnd)
+			logger.Debug("amountWrittenSoFar: %d, offset: %d, replaceEnd: %d",
+				amountWrittenSoFar, replaceReader.offset, replaceEnd)
 		}
 		if replaceReader.readPos >= replaceEnd && replaceReader.forLaterPos < replaceReader.forLaterLen {
 			replaceReader.debug("writing from forLater")
@@ -93,12 +97,12 @@ func (replaceReader *ReplaceReader) Read(p []byte) (int, error) {
 				replaceReader.debugPrint("EOF")
 				return amountWrittenSoFar, io.EOF
 			}
-			replaceReader.debugPrint("read |" + string(p[:n]) + "|")
+			replaceReader.debugPrint(fmt.Sprintf("read %d bytes", n))
 			if replaceReader.readPos+uint64(n) > replaceReader.offset+uint64(len(replaceReader.replacement)) && !replaceReader.skippingStarted {
 				// We've read past the offset, so put the extra bytes in the forLater slice
 				// extra := replaceReader.readPos + uint64(n) - replaceReader.offset
 				extra := replaceReader.offset + uint64(len(replaceReader.replacement)) - replaceReader.readPos
-				replaceReader.debugPrint("extra: |" + string(p[int(extra):n]) + "|")
+				replaceReader.debugPrint(fmt.Sprintf("extra: %d bytes", n-int(extra)))
 				forLaterCopyLen := copy(replaceReader.forLater, p[int(extra):n])
 				replaceReader.forLaterLen += uint64(forLaterCopyLen)
 			}
@@ -110,8 +114,7 @@ func (replaceReader *ReplaceReader) Read(p []byte) (int, error) {
 			replaceReader.readPos += uint64(n)
 			amountWrittenSoFar += n
 			if replaceReader.verbose {
-				fmt.Println("amountWrittenSoFar: ", amountWrittenSoFar)
-				fmt.Printf("p: %s\n", p)
+				logger.Debug("amountWrittenSoFar: %d", amountWrittenSoFar)
 			}
 			continue
 		}
@@ -125,13 +128,9 @@ func (replaceReader *ReplaceReader) Read(p []byte) (int, error) {
 			// Insert the replacement into p
 			copyLen := copy(p[amountWrittenSoFar:], replaceReader.replacement[replaceReader.readPos-replaceReader.offset:])
 			if replaceReader.verbose {
-				fmt.Printf("copyLen: %d\n", copyLen)
-				fmt.Printf("replaceReader.readPos: %d\n", replaceReader.readPos)
-				fmt.Printf("replaceReader.offset: %d\n", replaceReader.offset)
-				fmt.Printf("replaceReader.readPos-replaceReader.offset: %d\n", replaceReader.readPos-replaceReader.offset)
-				fmt.Printf("replaceReader.replacement: %s\n", replaceReader.replacement)
-				fmt.Printf("p: %s\n", p)
-				fmt.Printf("amountWrittenSoFar: %d\n", amountWrittenSoFar)
+				logger.Debug("copyLen: %d, readPos: %d, offset: %d, replacement: %d bytes, amountWrittenSoFar: %d",
+					copyLen, replaceReader.readPos, replaceReader.offset,
+					len(replaceReader.replacement), amountWrittenSoFar)
 			}
 			amountWrittenSoFar += copyLen
 			replaceReader.readPos += uint64(copyLen)
@@ -139,7 +138,7 @@ func (replaceReader *ReplaceReader) Read(p []byte) (int, error) {
 		}
 	}
 
-	replaceReader.debugPrint("result: |" + string(p[:amountWrittenSoFar]) + "|")
+	replaceReader.debugPrint(fmt.Sprintf("result: %d bytes", amountWrittenSoFar))
 
 	return amountWrittenSoFar, nil
 }
@@ -149,7 +148,7 @@ func (replaceReader *ReplaceReader) startSkipping() {
 	amountToSkip := len(replaceReader.replacement) - int(replaceReader.overreadAmount)
 	go func() {
 		if replaceReader.verbose {
-			fmt.Printf("--> skipping %d bytes\n", amountToSkip)
+			logger.Debug("skipping %d bytes", amountToSkip)
 		}
 		_, err := io.CopyN(io.Discard, replaceReader.source, int64(amountToSkip))
 		replaceReader.skipped <- err
@@ -161,25 +160,27 @@ func (replaceReader *ReplaceReader) printState() {
 		return
 	}
 
-	fmt.Println("readPos:", replaceReader.readPos)
-	fmt.Println("offset:", replaceReader.offset)
-	fmt.Println("forLaterPos:", replaceReader.forLaterPos)
-	fmt.Println("forLaterLen:", replaceReader.forLaterLen)
-	fmt.Println("skippingStarted:", replaceReader.skippingStarted)
-	fmt.Println("skippingDone:", replaceReader.skippingDone)
-	fmt.Println("overreadAmount:", replaceReader.overreadAmount)
+	logger.With(
+		"readPos", replaceReader.readPos,
+		"offset", replaceReader.offset,
+		"forLaterPos", replaceReader.forLaterPos,
+		"forLaterLen", replaceReader.forLaterLen,
+		"skippingStarted", replaceReader.skippingStarted,
+		"skippingDone", replaceReader.skippingDone,
+		"overreadAmount", replaceReader.overreadAmount,
+	).Debug("state")
 }
 
 func (replaceReader *ReplaceReader) debug(s string) {
 	if replaceReader.verbose {
-		fmt.Println("===" + s + "===")
+		logger.Debug("=== %s ===", s)
 		replaceReader.printState()
 	}
 }
 
 func (replaceReader *ReplaceReader) debugPrint(s string) {
 	if replaceReader.verbose {
-		fmt.Println(s)
+		logger.Debug("%s", s)
 	}
 }
 
@@ -0,0 +1,81 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package streamutil
+
+import "io"
+
+// TruncateReader emits exactly 'size' bytes: the contents of the source
+// reader up to 'size', followed by zero bytes if the source ends early.
+// This matches the behaviour of truncate(2) for both shrinking and growing.
+type TruncateReader struct {
+	source    io.ReadCloser
+	size      uint64
+	readPos   uint64
+	sourceEOF bool
+}
+
+func NewTruncateReader(source io.ReadCloser, size uint64) io.ReadCloser {
+	return &TruncateReader{
+		source: source,
+		size:   size,
+	}
+}
+
+// Read implements the io.Reader interface for TruncateReader.
+func (r *TruncateReader) Read(p []byte) (int, error) {
+	if r.readPos >= r.size {
+		return 0, io.EOF
+	}
+
+	remaining := r.size - r.readPos
+	if uint64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+
+	n := 0
+	if !r.sourceEOF {
+		var err error
+		n, err = r.source.Read(p)
+		if err == io.EOF {
+			r.sourceEOF = true
+		} else if err != nil {
+			r.readPos += uint64(n)
+			return n, err
+		}
+		if n == 0 && !r.sourceEOF {
+			return 0, nil
+		}
+	}
+
+	if r.sourceEOF {
+		// zero-fill the rest of this read
+		for i := n; i < len(p); i++ {
+			p[i] = 0
+		}
+		n = len(p)
+	}
+
+	r.readPos += uint64(n)
+	return n, nil
+}
+
+// Close implements the io.Closer interface, ensuring the source is closed.
+func (r *TruncateReader) Close() error {
+	return r.source.Close()
+}
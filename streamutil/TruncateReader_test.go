@@ -0,0 +1,62 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package streamutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTruncateReader(t *testing.T) {
+	type testCase struct {
+		source   string
+		size     uint64
+		expected []byte
+	}
+
+	testCases := map[string]testCase{
+		"shrink": {"hello, world!", 5, []byte("hello")},
+		"equal":  {"hello", 5, []byte("hello")},
+		"grow":   {"hi", 5, []byte("hi\x00\x00\x00")},
+		"empty":  {"", 3, []byte{0, 0, 0}},
+		"zero":   {"hello", 0, []byte{}},
+	}
+
+	for label, tc := range testCases {
+		for _, bufSize := range []int{1, 2, 3, 7, 100} {
+			t.Run(fmt.Sprintf("%s with %d char buffer", label, bufSize), func(t *testing.T) {
+				reader := NewTruncateReader(
+					NewReaderReadCloser(strings.NewReader(tc.source), nil),
+					tc.size,
+				)
+
+				out, err := io.ReadAll(NewSmallBufferReader(reader, bufSize))
+				if err != nil && err != io.EOF {
+					t.Errorf("unexpected error: %v", err)
+				}
+				if !bytes.Equal(out, tc.expected) {
+					t.Errorf("expected %q, got %q", tc.expected, out)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sync"
+)
+
+// ChromeExporter writes spans in the Chrome trace-event format, which
+// chrome://tracing and https://ui.perfetto.dev open. Each trace is shown
+// as its own thread.
+type ChromeExporter struct {
+	w      io.WriteCloser
+	lock   sync.Mutex
+	closed bool
+}
+
+type chromeEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Phase string                 `json:"ph"`
+	Ts    int64                  `json:"ts"`
+	Dur   int64                  `json:"dur"`
+	Pid   int                    `json:"pid"`
+	Tid   uint32                 `json:"tid"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+func CreateChromeExporter(w io.WriteCloser) *ChromeExporter {
+	// The closing bracket is optional in this format, so a trace is
+	// readable even if the process doesn't exit cleanly.
+	io.WriteString(w, "[\n")
+	return &ChromeExporter{w: w}
+}
+
+func threadID(id TraceID) uint32 {
+	h := fnv.New32a()
+	h.Write(id[:])
+	return h.Sum32() & 0x7fffffff
+}
+
+func (e *ChromeExporter) ExportSpan(span *Span) {
+	args := map[string]interface{}{
+		"trace_id": span.TraceID.String(),
+		"span_id":  span.SpanID.String(),
+	}
+	if !span.ParentID.IsZero() {
+		args["parent_id"] = span.ParentID.String()
+	}
+	for _, attr := range span.Attrs {
+		args[attr.Key] = attrValue(attr.Value)
+	}
+	if len(span.Links) > 0 {
+		links := make([]string, len(span.Links))
+		for i, link := range span.Links {
+			links[i] = link.TraceID.String()
+		}
+		args["links"] = links
+	}
+	if span.Err != nil {
+		args["error"] = span.Err.Error()
+	}
+
+	data, err := json.Marshal(chromeEvent{
+		Name:  span.Name,
+		Cat:   category(span.Name),
+		Phase: "X",
+		Ts:    span.StartTime.UnixMicro(),
+		Dur:   span.EndTime.Sub(span.StartTime).Microseconds(),
+		Pid:   1,
+		Tid:   threadID(span.TraceID),
+		Args:  args,
+	})
+	if err != nil {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return
+	}
+	e.w.Write(data)
+	io.WriteString(e.w, ",\n")
+}
+
+func (e *ChromeExporter) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	// a trailing comma isn't valid JSON, so end with an empty event
+	io.WriteString(e.w, "{}]\n")
+	return e.w.Close()
+}
+
+// Span names are "<category>.<name>", e.g. "fao.tree-cache.ReadDir".
+func category(name string) string {
+	for i, ch := range name {
+		if ch == '.' {
+			return name[:i]
+		}
+	}
+	return ""
+}
+
+// Attribute values are written as strings unless they're numbers or
+// booleans.
+func attrValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case string, bool, int, int32, int64, uint, uint32, uint64, float32, float64:
+		return v
+	case error:
+		return v.Error()
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,176 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package trace
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"sync"
+)
+
+// OTLPExporter writes spans as OTLP-JSON, one ExportTraceServiceRequest
+// per line, as the OpenTelemetry collector's file exporter does.
+type OTLPExporter struct {
+	w      io.WriteCloser
+	lock   sync.Mutex
+	closed bool
+}
+
+func CreateOTLPExporter(w io.WriteCloser) *OTLPExporter {
+	return &OTLPExporter{w: w}
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpLink struct {
+	TraceID string `json:"traceId"`
+	SpanID  string `json:"spanId"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []otlpAttr `json:"attributes,omitempty"`
+	Links             []otlpLink `json:"links,omitempty"`
+	Status            otlpStatus `json:"status"`
+}
+
+const (
+	otlpKindInternal = 1
+	otlpStatusOK     = 1
+	otlpStatusError  = 2
+)
+
+func toOTLPValue(value interface{}) otlpValue {
+	switch v := attrValue(value).(type) {
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case int:
+		s := strconv.FormatInt(int64(v), 10)
+		return otlpValue{IntValue: &s}
+	case int32:
+		s := strconv.FormatInt(int64(v), 10)
+		return otlpValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return otlpValue{IntValue: &s}
+	case uint:
+		s := strconv.FormatUint(uint64(v), 10)
+		return otlpValue{IntValue: &s}
+	case uint32:
+		s := strconv.FormatUint(uint64(v), 10)
+		return otlpValue{IntValue: &s}
+	case uint64:
+		s := strconv.FormatUint(v, 10)
+		return otlpValue{IntValue: &s}
+	case float32:
+		f := float64(v)
+		return otlpValue{DoubleValue: &f}
+	case float64:
+		return otlpValue{DoubleValue: &v}
+	case string:
+		return otlpValue{StringValue: &v}
+	}
+	return otlpValue{}
+}
+
+func (e *OTLPExporter) ExportSpan(span *Span) {
+	s := otlpSpan{
+		TraceID:           span.TraceID.String(),
+		SpanID:            span.SpanID.String(),
+		Name:              span.Name,
+		Kind:              otlpKindInternal,
+		StartTimeUnixNano: strconv.FormatInt(span.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(span.EndTime.UnixNano(), 10),
+		Status:            otlpStatus{Code: otlpStatusOK},
+	}
+	if !span.ParentID.IsZero() {
+		s.ParentSpanID = span.ParentID.String()
+	}
+	for _, attr := range span.Attrs {
+		s.Attributes = append(s.Attributes, otlpAttr{Key: attr.Key, Value: toOTLPValue(attr.Value)})
+	}
+	for _, link := range span.Links {
+		s.Links = append(s.Links, otlpLink{TraceID: link.TraceID.String(), SpanID: link.SpanID.String()})
+	}
+	if span.Err != nil {
+		s.Status = otlpStatus{Code: otlpStatusError, Message: span.Err.Error()}
+	}
+
+	request := map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []otlpAttr{
+						{Key: "service.name", Value: toOTLPValue("puter-fuse")},
+					},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "puter-fuse"},
+						"spans": []otlpSpan{s},
+					},
+				},
+			},
+		},
+	}
+	data, err := json.Marshal(request)
+	if err != nil {
+		return
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return
+	}
+	e.w.Write(data)
+	io.WriteString(e.w, "\n")
+}
+
+func (e *OTLPExporter) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if e.closed {
+		return nil
+	}
+	e.closed = true
+	return e.w.Close()
+}
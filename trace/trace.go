@@ -0,0 +1,212 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) IsZero() bool {
+	return id == SpanID{}
+}
+
+func newTraceID() (id TraceID) {
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() (id SpanID) {
+	rand.Read(id[:])
+	return id
+}
+
+// Link refers to a span in another trace.
+type Link struct {
+	TraceID TraceID
+	SpanID  SpanID
+}
+
+type Attr struct {
+	Key   string
+	Value interface{}
+}
+
+// Span is a timed operation within a trace. Spans are always created so
+// the trace ID can be sent with requests, but are only recorded while an
+// exporter is set.
+type Span struct {
+	TraceID   TraceID
+	SpanID    SpanID
+	ParentID  SpanID
+	Name      string
+	StartTime time.Time
+	EndTime   time.Time
+	Attrs     []Attr
+	// spans in other traces this span did work for, e.g. the operations
+	// sent in a batch
+	Links []Link
+	Err   error
+
+	lock  sync.Mutex
+	ended bool
+}
+
+func (s *Span) SetAttr(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Attrs = append(s.Attrs, Attr{Key: key, Value: value})
+}
+
+func (s *Span) AddLink(other *Span) {
+	if s == nil || other == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Links = append(s.Links, Link{TraceID: other.TraceID, SpanID: other.SpanID})
+}
+
+// SetError marks the span as failed; nil errors are ignored.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.Err = err
+}
+
+// End ends the span and hands it to the exporter. Only the first call
+// has any effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.lock.Unlock()
+
+	if e := getExporter(); e != nil {
+		e.ExportSpan(s)
+	}
+}
+
+type spanKey struct{}
+
+// FromContext returns the current span, or nil.
+func FromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// RequestID returns the trace ID of 'ctx' for the X-Request-Id header,
+// or "" if there is no trace.
+func RequestID(ctx context.Context) string {
+	span := FromContext(ctx)
+	if span == nil {
+		return ""
+	}
+	return span.TraceID.String()
+}
+
+// Start begins a span as a child of the span in 'ctx', or a new trace if
+// there is none. Attributes are given as key-value pairs.
+func Start(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, *Span) {
+	span := &Span{
+		SpanID:    newSpanID(),
+		Name:      name,
+		StartTime: time.Now(),
+	}
+	if parent := FromContext(ctx); parent != nil {
+		span.TraceID = parent.TraceID
+		span.ParentID = parent.SpanID
+	} else {
+		span.TraceID = newTraceID()
+	}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		span.Attrs = append(span.Attrs, Attr{
+			Key:   fmt.Sprint(keysAndValues[i]),
+			Value: keysAndValues[i+1],
+		})
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// StartRoot begins a new trace even if 'ctx' has one, e.g. for work done
+// on behalf of several traces.
+func StartRoot(ctx context.Context, name string, keysAndValues ...interface{}) (context.Context, *Span) {
+	return Start(context.WithValue(ctx, spanKey{}, (*Span)(nil)), name, keysAndValues...)
+}
+
+// Exporter receives finished spans.
+type Exporter interface {
+	ExportSpan(span *Span)
+	Close() error
+}
+
+var exporter atomic.Pointer[Exporter]
+
+// SetExporter starts recording spans to 'e'; nil stops recording.
+func SetExporter(e Exporter) {
+	if e == nil {
+		exporter.Store(nil)
+		return
+	}
+	exporter.Store(&e)
+}
+
+func getExporter() Exporter {
+	if e := exporter.Load(); e != nil {
+		return *e
+	}
+	return nil
+}
+
+// Enabled reports whether spans are being recorded.
+func Enabled() bool {
+	return exporter.Load() != nil
+}
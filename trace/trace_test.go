@@ -0,0 +1,171 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package trace
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type nopCloser struct{ *bytes.Buffer }
+
+func (nopCloser) Close() error { return nil }
+
+// Records spans for the duration of a test.
+type recorder struct{ spans []*Span }
+
+func (r *recorder) ExportSpan(span *Span) { r.spans = append(r.spans, span) }
+func (r *recorder) Close() error          { return nil }
+
+func record(t *testing.T) *recorder {
+	r := &recorder{}
+	SetExporter(r)
+	t.Cleanup(func() { SetExporter(nil) })
+	return r
+}
+
+func TestSpans(t *testing.T) {
+	testCases := []struct {
+		name       string
+		start      func(ctx context.Context) (context.Context, *Span)
+		sameTrace  bool
+		wantParent bool
+	}{
+		{"child", func(ctx context.Context) (context.Context, *Span) {
+			return Start(ctx, "child")
+		}, true, true},
+		{"root", func(ctx context.Context) (context.Context, *Span) {
+			return StartRoot(ctx, "root")
+		}, false, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, parent := Start(context.Background(), "parent")
+			ctx, span := tc.start(ctx)
+
+			if FromContext(ctx) != span {
+				t.Fatalf("expected the span to be in its context")
+			}
+			if (span.TraceID == parent.TraceID) != tc.sameTrace {
+				t.Fatalf("expected same trace: %v", tc.sameTrace)
+			}
+			if (span.ParentID == parent.SpanID) != tc.wantParent {
+				t.Fatalf("expected parent: %v", tc.wantParent)
+			}
+			if RequestID(ctx) != span.TraceID.String() {
+				t.Fatalf("expected request ID %s; got %s", span.TraceID, RequestID(ctx))
+			}
+		})
+	}
+
+	t.Run("no trace", func(t *testing.T) {
+		if id := RequestID(context.Background()); id != "" {
+			t.Fatalf("expected no request ID; got %q", id)
+		}
+	})
+}
+
+func TestEnd(t *testing.T) {
+	r := record(t)
+
+	_, span := Start(context.Background(), "fao.test.Read", "path", "/a")
+	span.SetError(errors.New("failed"))
+	span.End()
+	span.End()
+
+	if len(r.spans) != 1 {
+		t.Fatalf("expected 1 exported span; got %d", len(r.spans))
+	}
+	if span.EndTime.Before(span.StartTime) {
+		t.Fatalf("expected the span to end after it started")
+	}
+
+	// nil spans are safe to use when a caller has none
+	var none *Span
+	none.SetAttr("a", 1)
+	none.End()
+}
+
+func TestExporters(t *testing.T) {
+	testCases := []struct {
+		name   string
+		create func(w nopCloser) Exporter
+		check  func(t *testing.T, output string)
+	}{
+		{"chrome", func(w nopCloser) Exporter { return CreateChromeExporter(w) },
+			func(t *testing.T, output string) {
+				var events []map[string]interface{}
+				if err := json.Unmarshal([]byte(output), &events); err != nil {
+					t.Fatalf("expected a JSON array: %s", err)
+				}
+				event := events[0]
+				if event["name"] != "fao.test.Read" || event["cat"] != "fao" || event["ph"] != "X" {
+					t.Fatalf("unexpected event: %v", event)
+				}
+				args := event["args"].(map[string]interface{})
+				if args["path"] != "/a" || args["error"] != "failed" {
+					t.Fatalf("unexpected args: %v", args)
+				}
+			}},
+		{"otlp", func(w nopCloser) Exporter { return CreateOTLPExporter(w) },
+			func(t *testing.T, output string) {
+				lines := strings.Split(strings.TrimSpace(output), "\n")
+				if len(lines) != 1 {
+					t.Fatalf("expected 1 line; got %d", len(lines))
+				}
+				var request struct {
+					ResourceSpans []struct {
+						ScopeSpans []struct {
+							Spans []struct {
+								Name   string
+								Status struct{ Code int }
+							}
+						}
+					}
+				}
+				if err := json.Unmarshal([]byte(lines[0]), &request); err != nil {
+					t.Fatal(err)
+				}
+				span := request.ResourceSpans[0].ScopeSpans[0].Spans[0]
+				if span.Name != "fao.test.Read" || span.Status.Code != otlpStatusError {
+					t.Fatalf("unexpected span: %+v", span)
+				}
+			}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := nopCloser{&bytes.Buffer{}}
+			exporter := tc.create(buf)
+
+			_, span := Start(context.Background(), "fao.test.Read", "path", "/a")
+			span.SetError(errors.New("failed"))
+			span.EndTime = span.StartTime
+			exporter.ExportSpan(span)
+			exporter.Close()
+
+			tc.check(t, buf.String())
+		})
+	}
+}
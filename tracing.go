@@ -0,0 +1,69 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/HeyPuter/puter-fuse/trace"
+	"github.com/spf13/viper"
+)
+
+// Starts exporting spans to traceFile, if it's set. Like logging, tracing
+// is configured for the whole process. The returned function flushes and
+// closes the trace file.
+func configureTracing() (func(), error) {
+	path := viper.GetString("traceFile")
+	if path == "" {
+		return func() {}, nil
+	}
+
+	format := viper.GetString("traceFormat")
+	if format != "chrome" && format != "otlp" {
+		return nil, fmt.Errorf("unknown trace format %q; expected chrome or otlp", format)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("error creating trace directory: %s", err)
+	}
+	// traces include paths, so they're kept private like the logs
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error opening trace file: %s", err)
+	}
+
+	var exporter trace.Exporter
+	switch format {
+	case "chrome":
+		exporter = trace.CreateChromeExporter(file)
+	case "otlp":
+		exporter = trace.CreateOTLPExporter(file)
+	}
+	trace.SetExporter(exporter)
+	mountLog.Log("tracing to %s (%s)", path, format)
+
+	return func() {
+		trace.SetExporter(nil)
+		if err := exporter.Close(); err != nil {
+			mountLog.Error("error closing trace file: %s", err)
+		}
+	}, nil
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright (C) 2024  Puter Technologies Inc.
+ *
+ * This file is part of puter-fuse.
+ *
+ * puter-fuse is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Affero General Public License as published
+ * by the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU Affero General Public License for more details.
+ *
+ * You should have received a copy of the GNU Affero General Public License
+ * along with this program.  If not, see <https://www.gnu.org/licenses/>.
+ */
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/HeyPuter/puter-fuse/engine"
+)
+
+var transfersOpts struct {
+	json  bool
+	watch time.Duration
+}
+
+// Formats a number of bytes, e.g. 1.5 MiB.
+func formatBytes(n float64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	unit := 0
+	for n >= 1024 && unit < len(units)-1 {
+		n /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%.0f %s", n, units[unit])
+	}
+	return fmt.Sprintf("%.1f %s", n, units[unit])
+}
+
+func formatTransfer(t engine.TransferInfo) (progress, rate, eta string) {
+	progress = formatBytes(float64(t.Done))
+	if t.Total >= 0 {
+		percent := 100.0
+		if t.Total > 0 {
+			percent = float64(t.Done) * 100 / float64(t.Total)
+		}
+		progress = fmt.Sprintf("%s / %s (%.0f%%)", progress, formatBytes(float64(t.Total)), percent)
+	}
+	rate = formatBytes(t.Rate) + "/s"
+	eta = "-"
+	if t.ETA >= 0 {
+		eta = (time.Duration(t.ETA) * time.Second).String()
+	}
+	return
+}
+
+func printTransfers(results []mountTransfers) {
+	profile := controlProfile()
+	rows := []string{}
+	for _, result := range results {
+		if profile != "" && !strings.EqualFold(result.Profile, profile) {
+			continue
+		}
+		for _, t := range result.Transfers {
+			progress, rate, eta := formatTransfer(t)
+			rows = append(rows, fmt.Sprintf("%d\t%s\t%s\t%s\t%s\t%s\t%s\t%s",
+				t.ID, result.Profile, t.Direction, t.State, progress, rate, eta, t.Path))
+		}
+	}
+	if len(rows) == 0 {
+		fmt.Println("No transfers in flight")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tPROFILE\tDIRECTION\tSTATE\tPROGRESS\tRATE\tETA\tPATH")
+	for _, row := range rows {
+		fmt.Fprintln(w, row)
+	}
+	w.Flush()
+}
+
+func transfersCommand() error {
+	for {
+		results := []mountTransfers{}
+		if err := callControl(http.MethodGet, "/v1/transfers", nil, &results); err != nil {
+			return err
+		}
+
+		if transfersOpts.json {
+			out, _ := json.MarshalIndent(results, "", "  ")
+			fmt.Println(string(out))
+		} else {
+			if transfersOpts.watch > 0 {
+				// clear the screen
+				fmt.Print("\033[H\033[2J")
+			}
+			printTransfers(results)
+		}
+
+		if transfersOpts.watch <= 0 {
+			return nil
+		}
+		time.Sleep(transfersOpts.watch)
+	}
+}
+
+// Sends 'action' (pause, resume or cancel) for the transfer 'id'.
+func transferActionCommand(action string, id string) error {
+	parsed, err := strconv.ParseInt(id, 10, 64)
+	if err != nil {
+		return &exitError{code: exitUsage, err: fmt.Errorf("invalid transfer ID %q", id)}
+	}
+	return callControl(http.MethodPost, "/v1/transfers/"+action, &controlRequest{ID: parsed}, nil)
+}